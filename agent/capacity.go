@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/djavorszky/ddn/common/logger"
+	"github.com/djavorszky/ddn/common/model"
+)
+
+// capacity collects the free space of the agent's folders and the usage of the
+// database server. Parts that can't be determined are logged and left out, so
+// that the rest can still be reported.
+func capacity() model.Capacity {
+	c := model.Capacity{
+		Disks:     make([]model.DiskUsage, 0),
+		Databases: make([]model.DatabaseSize, 0),
+		Reported:  time.Now(),
+	}
+
+	for _, disk := range capacityDirs() {
+		var err error
+
+		disk.Total, disk.Free, err = diskUsage(disk.Path)
+		if err != nil {
+			logger.Warn("capacity: checking disk of %s failed: %v", disk.Path, err)
+			continue
+		}
+
+		c.Disks = append(c.Disks, disk)
+	}
+
+	sizes, err := db.DatabaseSizes()
+	if err != nil {
+		logger.Warn("capacity: %v", err)
+	}
+
+	for name, size := range sizes {
+		c.Databases = append(c.Databases, model.DatabaseSize{Name: name, Size: size})
+	}
+
+	sort.Slice(c.Databases, func(i, j int) bool { return c.Databases[i].Name < c.Databases[j].Name })
+
+	c.Connections, err = db.Connections()
+	if err != nil {
+		logger.Warn("capacity: %v", err)
+	}
+
+	c.ServerVersion, err = db.Version()
+	if err != nil {
+		logger.Warn("capacity: %v", err)
+	}
+
+	return c
+}
+
+// capacityDirs returns the folders whose disks are reported: the ones dumps are
+// imported from and exported to, and the datafile folder if one is configured.
+func capacityDirs() []model.DiskUsage {
+	dirs := []model.DiskUsage{
+		{Label: "dumps", Path: filepath.Join(workdir, "dumps")},
+		{Label: "exports", Path: filepath.Join(workdir, "exports")},
+	}
+
+	switch {
+	case conf.Vendor == "oracle" && conf.DatafileDir != "":
+		dirs = append(dirs, model.DiskUsage{Label: "datafiles", Path: conf.DatafileDir})
+	case conf.Vendor == "mssql" && conf.MSSQLDataDir != "":
+		dirs = append(dirs, model.DiskUsage{Label: "datafiles", Path: conf.MSSQLDataDir})
+	}
+
+	return dirs
+}
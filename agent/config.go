@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"runtime"
 
+	"github.com/djavorszky/ddn/common/discovery"
+	"github.com/djavorszky/ddn/common/inet"
 	"github.com/djavorszky/ddn/common/logger"
 )
 
@@ -16,6 +18,7 @@ type Config struct {
 	Password      string `toml:"db-userpass"`
 	SID           string `toml:"oracle-sid"`
 	DatafileDir   string `toml:"oracle-datafiles-path"`
+	MSSQLDataDir  string `toml:"mssql-data-path"`
 	LocalDBAddr   string `toml:"db-local-addr"`
 	LocalDBPort   string `toml:"db-local-port"`
 	AgentDBHost   string `toml:"db-remote-addr"`
@@ -25,6 +28,61 @@ type Config struct {
 	ShortName     string `toml:"agent-shortname"`
 	AgentName     string `toml:"agent-longname"`
 	MasterAddress string `toml:"server-address"`
+
+	// Multicast group to wait for the server's announcement on if no
+	// server-address is given. Defaults to discovery.DefaultGroup.
+	DiscoveryGroup string `toml:"discovery-group"`
+
+	// Token the server signs its announcements with. If given, unsigned
+	// announcements are ignored, otherwise any host on the network can
+	// announce itself as the server.
+	DiscoveryToken string `toml:"discovery-token"`
+
+	// Location of pg_restore, used for importing non-plain Postgres dumps.
+	// If left empty, it is looked for next to the db-executable.
+	PgRestoreExec string `toml:"pg-restore-executable"`
+
+	// Keep the owners and grantees of Postgres dumps if the roles exist on
+	// the server, instead of remapping everything to the database's user.
+	PgKeepRoles bool `toml:"pg-keep-roles"`
+
+	// Rules for rewriting MySQL dumps. The definer is either "strip" (default),
+	// "keep", or the account to use instead, e.g. "CURRENT_USER". If no collations
+	// are given, the ones introduced by MySQL 8 are mapped to 5.x ones.
+	MySQLDefiner    string            `toml:"mysql-definer"`
+	MySQLCollations map[string]string `toml:"mysql-collations"`
+	MySQLEngines    map[string]string `toml:"mysql-engines"`
+
+	// Limits for extracting uploaded archives. If left empty,
+	// sensible defaults are used.
+	MaxExtractSize    int64 `toml:"max-extract-size-mb"`
+	MaxExtractEntries int   `toml:"max-extract-entries"`
+
+	// Certificates to serve HTTPS with and to present to the server. If a
+	// client CA is given, the server has to present a certificate signed by it.
+	TLSCert     string `toml:"tls-cert"`
+	TLSKey      string `toml:"tls-key"`
+	TLSRootCA   string `toml:"tls-root-ca"`
+	TLSClientCA string `toml:"tls-client-ca"`
+}
+
+// TLS returns the TLS configuration of the agent.
+func (c Config) TLS() inet.TLSConfig {
+	return inet.TLSConfig{
+		CertFile:     c.TLSCert,
+		KeyFile:      c.TLSKey,
+		RootCAFile:   c.TLSRootCA,
+		ClientCAFile: c.TLSClientCA,
+	}
+}
+
+// discoveryGroup returns the multicast group the server's announcement is waited for on.
+func (c Config) discoveryGroup() string {
+	if c.DiscoveryGroup == "" {
+		return discovery.DefaultGroup
+	}
+
+	return c.DiscoveryGroup
 }
 
 // Print prints the Config object to the log.
@@ -41,6 +99,10 @@ func (c Config) Print() {
 		logger.Info("DatafileDir:\t\t%s", conf.DatafileDir)
 	}
 
+	if conf.Vendor == "mssql" && conf.MSSQLDataDir != "" {
+		logger.Info("Data dir:\t\t%s", conf.MSSQLDataDir)
+	}
+
 	logger.Info("Local DB addr:\t%s", conf.LocalDBAddr)
 	logger.Info("Local DB port:\t%s", conf.LocalDBPort)
 
@@ -53,7 +115,45 @@ func (c Config) Print() {
 	logger.Info("Short name:\t\t%s", conf.ShortName)
 	logger.Info("Agent name:\t%s", conf.AgentName)
 
-	logger.Info("Master address:\t%s", conf.MasterAddress)
+	if conf.MasterAddress != "" {
+		logger.Info("Master address:\t%s", conf.MasterAddress)
+	} else {
+		logger.Info("Master address:\tdiscovered on %s", conf.discoveryGroup())
+
+		if conf.DiscoveryToken == "" {
+			logger.Warn("No discovery-token given, the first announcement on %s is trusted", conf.discoveryGroup())
+		}
+	}
+
+	if conf.TLS().Mutual() {
+		logger.Info("TLS:\t\t\tenabled, server needs a client certificate")
+	} else if conf.TLS().Enabled() {
+		logger.Info("TLS:\t\t\tenabled")
+	}
+
+	if conf.Vendor == "postgres" {
+		logger.Info("pg_restore:\t\t%s", pgRestoreExec())
+		logger.Info("Keep roles:\t\t%t", conf.PgKeepRoles)
+	}
+
+	if conf.Vendor == "mysql" {
+		rw := newMySQLRewriter()
+
+		logger.Info("Definer:\t\t%s", rw.definer)
+		logger.Info("Collations:\t\t%v", rw.collations)
+
+		if len(rw.engines) > 0 {
+			logger.Info("Engines:\t\t%v", rw.engines)
+		}
+	}
+
+	if conf.MaxExtractSize > 0 {
+		logger.Info("Max extract size:\t%d MB", conf.MaxExtractSize)
+	}
+
+	if conf.MaxExtractEntries > 0 {
+		logger.Info("Max extract entries:\t%d", conf.MaxExtractEntries)
+	}
 }
 
 // NewConfig returns a configuration file based on the vendor
@@ -134,6 +234,27 @@ func NewConfig(vendor string) Config {
 		default:
 			conf.Exec = "/path/to/sqlplus"
 		}
+	case "mssql":
+		conf = Config{
+			Vendor:        "mssql",
+			Version:       "14.0",
+			ShortName:     "mssql-2017",
+			LocalDBPort:   "1433",
+			LocalDBAddr:   "localhost",
+			AgentPort:     "7000",
+			AgentAddr:     "http://localhost",
+			AgentDBPort:   "1433",
+			AgentDBHost:   "localhost",
+			User:          "sa",
+			Password:      "password",
+			MasterAddress: "http://localhost:7010",
+		}
+		switch runtime.GOOS {
+		case "windows":
+			conf.Exec = "C:\\path\\to\\sqlcmd.exe"
+		default:
+			conf.Exec = "/opt/mssql-tools/bin/sqlcmd"
+		}
 	}
 
 	conf.AgentName = fmt.Sprintf("%s-%s", hostname, conf.ShortName)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"strings"
 
@@ -50,6 +51,13 @@ type Database interface {
 	// Version returns the database server's version.
 	Version() (string, error)
 
+	// DatabaseSizes returns the size of each database in the server in bytes, as
+	// known by the vendor's catalog. System databases are omitted like in ListDatabase.
+	DatabaseSizes() (map[string]int64, error)
+
+	// Connections returns the number of connections currently open to the server.
+	Connections() (int, error)
+
 	// RequiredFields returns the fields that are required to be present in an API call, specific
 	// to the database vendor
 	RequiredFields(dbRequest model.DBRequest, reqType int) []string
@@ -59,6 +67,14 @@ type Database interface {
 	ValidateDump(path string) (string, error)
 }
 
+// progressReporter is implemented by databases that can tell how far along
+// long running imports and exports are. Progress messages are passed to the
+// given func while the operation runs.
+type progressReporter interface {
+	ImportDatabaseProgress(dbRequest model.DBRequest, progress func(msg string)) error
+	ExportDatabaseProgress(dbRequest model.DBRequest, progress func(msg string)) (string, error)
+}
+
 // VendorSupported returns an error if the specified vendor is not supported.
 func VendorSupported(vendor string) error {
 	vendor = strings.ToLower(vendor)
@@ -93,3 +109,39 @@ func GetDB(vendor string) (Database, error) {
 
 	return db, nil
 }
+
+// querySizes runs a query returning database names and sizes, and collects them
+// into a map. Databases for which skip returns true are left out.
+func querySizes(conn *sql.DB, query string, skip func(name string) bool) (map[string]int64, error) {
+	rows, err := conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("querying database sizes failed: %s", err.Error())
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var (
+			name string
+			size sql.NullInt64
+		)
+
+		err = rows.Scan(&name, &size)
+		if err != nil {
+			return nil, fmt.Errorf("scanning size rows failed: %s", err.Error())
+		}
+
+		if skip != nil && skip(name) {
+			continue
+		}
+
+		sizes[name] = size.Int64
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error encountered when reading rows: %s", err.Error())
+	}
+
+	return sizes, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/djavorszky/ddn/common/discovery"
+	"github.com/djavorszky/ddn/common/logger"
+)
+
+// discoverServer waits until the server announces itself on the discovery
+// group, and registers with the address it announced from then on. If a
+// discovery-token is given, only the announcements signed with it are trusted.
+func discoverServer() {
+	group := conf.discoveryGroup()
+
+	logger.Info("No server-address given, waiting for the server to announce itself on %s", group)
+
+	a, err := discovery.Discover(group, conf.DiscoveryToken, 0)
+	if err != nil {
+		logger.Fatal("discovery: %v", err)
+	}
+
+	if !a.TLS {
+		logger.Warn("Discovered server is not served over https, database passwords are sent in plaintext")
+	}
+
+	if a.ClientCert && !conf.TLS().Enabled() {
+		logger.Warn("Server requires a client certificate, but no tls-cert and tls-key are given")
+	}
+
+	conf.MasterAddress = a.ServerAddress()
+
+	logger.Info("Discovered server at %s, version %q", conf.MasterAddress, a.Version)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// diskUsage returns the size and the space available to the agent on the
+// filesystem the path is on.
+func diskUsage(path string) (total, free uint64, err error) {
+	var stat syscall.Statfs_t
+
+	err = syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return stat.Blocks * uint64(stat.Bsize), stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -31,33 +31,39 @@ func createDatabase(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error("couldn't decode json request: %v", err)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.ErrorJSONResponse(err))
+		inet.ErrorJSONResponse(err).Send(w)
 		return
 	}
 
+	reqLog := logger.With("request_id", dbreq.RequestID, "id", dbreq.ID)
+
 	if ok := sutils.Present(db.RequiredFields(dbreq, createDB)...); !ok {
-		logger.Error("createDatabase: missing fields: dbreq: %v", dbreq)
+		reqLog.Error("createDatabase: missing fields: dbreq: %v", dbreq)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.InvalidResponse())
+		inet.InvalidResponse().Send(w)
 		return
 	}
 
-	httpStatus := http.StatusOK
 	err = db.CreateDatabase(dbreq)
 	if err != nil {
-		httpStatus = http.StatusInternalServerError
-		msg.Status = status.CreateDatabaseFailed
-		msg.Message = fmt.Sprintf("creating database %q failed: %v", dbreq.DatabaseName, err)
+		failure := inet.Failure{
+			HTTPStatus: http.StatusInternalServerError,
+			Status:     status.CreateDatabaseFailed,
+			Message:    fmt.Sprintf("creating database %q failed: %v", dbreq.DatabaseName, err),
+		}
 
-		logger.Error(msg.Message)
-	} else {
-		msg.Status = status.Success
-		msg.Message = "Successfully created the database and user!"
+		reqLog.Error(failure.Message)
 
-		logger.Debug("Successfully created database %q", dbreq.DatabaseName)
+		failure.Send(w)
+		return
 	}
 
-	inet.SendResponse(w, httpStatus, msg)
+	msg.Status = status.Success
+	msg.Message = "Successfully created the database and user!"
+
+	reqLog.Debug("Successfully created database %q", dbreq.DatabaseName)
+
+	inet.SendResponse(w, http.StatusOK, msg)
 }
 
 // listDatabase lists the supervised databases in a JSON format
@@ -70,14 +76,15 @@ func listDatabases(w http.ResponseWriter, r *http.Request) {
 	msg.Status = status.Success
 	msg.Message, err = db.ListDatabase()
 	if err != nil {
-		var errMsg inet.Message
-
-		errMsg.Status = status.ListDatabaseFailed
-		errMsg.Message = fmt.Sprintf("list databases: %v", err)
+		failure := inet.Failure{
+			HTTPStatus: http.StatusInternalServerError,
+			Status:     status.ListDatabaseFailed,
+			Message:    fmt.Sprintf("list databases: %v", err),
+		}
 
-		logger.Error(errMsg.Message)
+		logger.Error(failure.Message)
 
-		inet.SendResponse(w, http.StatusInternalServerError, errMsg)
+		failure.Send(w)
 		return
 	}
 
@@ -92,7 +99,7 @@ func echo(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error("couldn't decode json request: %v", err)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.ErrorJSONResponse(err))
+		inet.ErrorJSONResponse(err).Send(w)
 		return
 	}
 
@@ -110,34 +117,39 @@ func dropDatabase(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error("couldn't drop database: %v", err)
 
-		inet.SendResponse(w, http.StatusInternalServerError, inet.ErrorJSONResponse(err))
+		inet.ErrorJSONResponse(err).Send(w)
 		return
 	}
 
+	reqLog := logger.With("request_id", dbreq.RequestID, "id", dbreq.ID)
+
 	if ok := sutils.Present(db.RequiredFields(dbreq, dropDB)...); !ok {
-		logger.Error("dropDatabase: missing fields: dbreq: %v", dbreq)
+		reqLog.Error("dropDatabase: missing fields: dbreq: %v", dbreq)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.InvalidResponse())
+		inet.InvalidResponse().Send(w)
 		return
 	}
 
-	httpStatus := http.StatusOK
-
 	err = db.DropDatabase(dbreq)
 	if err != nil {
-		httpStatus = http.StatusInternalServerError
-		msg.Status = status.DropDatabaseFailed
-		msg.Message = fmt.Sprintf("dropping database failed: %v", err)
+		failure := inet.Failure{
+			HTTPStatus: http.StatusInternalServerError,
+			Status:     status.DropDatabaseFailed,
+			Message:    fmt.Sprintf("dropping database failed: %v", err),
+		}
 
-		logger.Error(msg.Message)
-	} else {
-		msg.Status = status.Success
-		msg.Message = "Successfully dropped the database and user!"
+		reqLog.Error(failure.Message)
 
-		logger.Debug(msg.Message)
+		failure.Send(w)
+		return
 	}
 
-	inet.SendResponse(w, httpStatus, msg)
+	msg.Status = status.Success
+	msg.Message = "Successfully dropped the database and user!"
+
+	reqLog.Debug(msg.Message)
+
+	inet.SendResponse(w, http.StatusOK, msg)
 }
 
 // importDatabase will import the specified dumpfile to the database
@@ -152,39 +164,47 @@ func importDatabase(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error("couldn't decode json request: %v", err)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.ErrorJSONResponse(err))
+		inet.ErrorJSONResponse(err).Send(w)
 		return
 	}
 
+	reqLog := logger.With("request_id", dbreq.RequestID, "id", dbreq.ID)
+
 	if ok := sutils.Present(db.RequiredFields(dbreq, importDB)...); !ok {
-		logger.Error("importDatabase: missing fields: dbreq: %v", dbreq)
+		reqLog.Error("importDatabase: missing fields: dbreq: %v", dbreq)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.InvalidResponse())
+		inet.InvalidResponse().Send(w)
 		return
 	}
 
 	if exists := inet.AddrExists(dbreq.DumpLocation); !exists {
-		msg.Status = status.NotFound
-		msg.Message = fmt.Sprintf("Specified file doesn't exist or is not reachable at location %q.", dbreq.DumpLocation)
+		failure := inet.Failure{
+			HTTPStatus: http.StatusNotFound,
+			Status:     status.NotFound,
+			Message:    fmt.Sprintf("Specified file doesn't exist or is not reachable at location %q.", dbreq.DumpLocation),
+		}
 
-		logger.Error(msg.Message)
+		reqLog.Error(failure.Message)
 
-		inet.SendResponse(w, http.StatusNotFound, msg)
+		failure.Send(w)
 		return
 	}
 
 	err = db.CreateDatabase(dbreq)
 	if err != nil {
-		msg.Status = status.CreateDatabaseFailed
-		msg.Message = fmt.Sprintf("creating database failed: %v", err)
+		failure := inet.Failure{
+			HTTPStatus: http.StatusInternalServerError,
+			Status:     status.CreateDatabaseFailed,
+			Message:    fmt.Sprintf("creating database failed: %v", err),
+		}
 
-		logger.Error(msg.Message)
+		reqLog.Error(failure.Message)
 
-		inet.SendResponse(w, http.StatusInternalServerError, msg)
+		failure.Send(w)
 		return
 	}
 
-	logger.Debug("Starting import process for database %q", dbreq.DatabaseName)
+	reqLog.Debug("Starting import process for database %q", dbreq.DatabaseName)
 
 	msg.Status = status.Accepted
 	msg.Message = "Understood request, starting import process."
@@ -205,11 +225,13 @@ func exportDatabase(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error("couldn't decode json request: %v", err)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.ErrorJSONResponse(err))
+		inet.ErrorJSONResponse(err).Send(w)
 		return
 	}
 
-	logger.Debug("Starting export process for database %q", dbreq.DatabaseName)
+	reqLog := logger.With("request_id", dbreq.RequestID, "id", dbreq.ID)
+
+	reqLog.Debug("Starting export process for database %q", dbreq.DatabaseName)
 
 	msg.Status = status.Accepted
 	msg.Message = "Understood request, starting export process."
@@ -237,9 +259,7 @@ func apiSetLogLevel(w http.ResponseWriter, r *http.Request) {
 	case "debug":
 		lvl = logger.DEBUG
 	default:
-		msg := inet.Message{Status: http.StatusBadRequest, Message: "ERR_UNRECOGNIZED_LOGLEVEL"}
-
-		inet.SendResponse(w, http.StatusBadRequest, msg)
+		inet.SendFailure(w, http.StatusBadRequest, "ERR_UNRECOGNIZED_LOGLEVEL", level)
 		return
 	}
 
@@ -291,7 +311,14 @@ func heartbeat(w http.ResponseWriter, r *http.Request) {
 	err := db.Alive()
 	if err != nil {
 		logger.Error("database dead: %v", err)
-		msg = inet.ErrorResponse()
+
+		// The server takes the agent down until its database is back
+		inet.Failure{
+			HTTPStatus: http.StatusServiceUnavailable,
+			Status:     status.ServiceUnavailable,
+			Message:    fmt.Sprintf("database dead: %v", err),
+		}.Send(w)
+		return
 	}
 
 	inet.SendResponse(w, http.StatusOK, msg)
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/djavorszky/ddn/common/inet"
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/common/status"
+)
+
+// failingDB fails every call the handlers make.
+type failingDB struct {
+	Database
+}
+
+func (failingDB) Alive() error                         { return fmt.Errorf("connection refused") }
+func (failingDB) CreateDatabase(model.DBRequest) error { return fmt.Errorf("database exists") }
+func (failingDB) DropDatabase(model.DBRequest) error   { return fmt.Errorf("database in use") }
+func (failingDB) ListDatabase() ([]string, error)      { return nil, fmt.Errorf("connection refused") }
+
+func (failingDB) RequiredFields(dbreq model.DBRequest, reqType int) []string {
+	return []string{dbreq.DatabaseName}
+}
+
+func TestHandlerFailures(t *testing.T) {
+	defer func(old Database) { db = old }(db)
+	db = failingDB{}
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		body       string
+		httpStatus int
+		status     int
+	}{
+		{"invalid json", createDatabase, "{", http.StatusBadRequest, status.InvalidJSON},
+		{"missing fields", createDatabase, "{}", http.StatusBadRequest, status.MissingParameters},
+		{"create failed", createDatabase, `{"database_name": "db"}`, http.StatusInternalServerError, status.CreateDatabaseFailed},
+		{"drop invalid json", dropDatabase, "{", http.StatusBadRequest, status.InvalidJSON},
+		{"drop failed", dropDatabase, `{"database_name": "db"}`, http.StatusInternalServerError, status.DropDatabaseFailed},
+		{"list failed", listDatabases, "", http.StatusInternalServerError, status.ListDatabaseFailed},
+		{"database dead", heartbeat, "", http.StatusServiceUnavailable, status.ServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.handler(w, httptest.NewRequest("POST", "/", strings.NewReader(tt.body)))
+
+		var f inet.Failure
+
+		err := json.Unmarshal(w.Body.Bytes(), &f)
+		if err != nil {
+			t.Errorf("%s: couldn't decode failure %s: %v", tt.name, w.Body, err)
+			continue
+		}
+
+		if w.Code != tt.httpStatus || f.HTTPStatus != w.Code {
+			t.Errorf("%s: expected status %d in the header and the body, got %d and %d", tt.name, tt.httpStatus, w.Code, f.HTTPStatus)
+		}
+
+		if f.Status != tt.status || f.Message == "" {
+			t.Errorf("%s: expected status %d with a message, got %s", tt.name, tt.status, w.Body)
+		}
+	}
+}
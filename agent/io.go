@@ -9,9 +9,191 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/djavorszky/ddn/common/logger"
+)
+
+// Default limits applied to archive extraction if the configuration
+// does not override them.
+const (
+	defaultMaxExtractSize    int64 = 50 * 1024 // in megabytes
+	defaultMaxExtractEntries       = 1000
 )
 
-func unzip(path string) ([]string, error) {
+// rejectedArchiveError is returned when an archive is refused for safety reasons,
+// e.g. it contains entries escaping the extraction directory, symlinks, or
+// expands to more than the configured limits.
+type rejectedArchiveError struct {
+	reason string
+}
+
+func (e rejectedArchiveError) Error() string {
+	return fmt.Sprintf("archive rejected: %s", e.reason)
+}
+
+func rejectArchive(format string, args ...interface{}) error {
+	return rejectedArchiveError{reason: fmt.Sprintf(format, args...)}
+}
+
+// isRejectedArchive returns true if the error was caused by an archive that
+// was refused for safety reasons.
+func isRejectedArchive(err error) bool {
+	_, ok := err.(rejectedArchiveError)
+	return ok
+}
+
+type unsupportedArchiveError struct {
+	ext string
+}
+
+func (e unsupportedArchiveError) Error() string {
+	return fmt.Sprintf("archive not supported: %s", e.ext)
+}
+
+// isUnsupportedArchive returns true if the error was caused by an archive of
+// a format that can't be extracted.
+func isUnsupportedArchive(err error) bool {
+	_, ok := err.(unsupportedArchiveError)
+	return ok
+}
+
+// extractor extracts archives into a single directory, refusing entries
+// that would end up outside of it and keeping track of the number of
+// entries and bytes written so far.
+type extractor struct {
+	dir        string
+	maxSize    int64
+	maxEntries int
+
+	written int64
+	entries int
+}
+
+// newExtractor returns an extractor that places all files into dir, using
+// the limits set in the configuration.
+func newExtractor(dir string) *extractor {
+	ex := &extractor{
+		dir:        dir,
+		maxSize:    conf.MaxExtractSize * 1024 * 1024,
+		maxEntries: conf.MaxExtractEntries,
+	}
+
+	if ex.maxSize <= 0 {
+		ex.maxSize = defaultMaxExtractSize * 1024 * 1024
+	}
+
+	if ex.maxEntries <= 0 {
+		ex.maxEntries = defaultMaxExtractEntries
+	}
+
+	return ex
+}
+
+// extract extracts the archive at path based on its extension, and returns the
+// list of files that were extracted.
+func (ex *extractor) extract(path string) ([]string, error) {
+	switch filepath.Ext(path) {
+	case ".zip":
+		return ex.unzip(path)
+	case ".gz":
+		return ex.ungzip(path)
+	case ".bz2":
+		return ex.unbzip2(path)
+	case ".tar":
+		return ex.untar(path)
+	}
+
+	return nil, unsupportedArchiveError{ext: filepath.Ext(path)}
+}
+
+// target returns the location the archive entry called name should be extracted
+// to, or an error if it would end up outside of the extraction directory.
+func (ex *extractor) target(name string) (string, error) {
+	if name == "" {
+		return "", rejectArchive("entry with empty name")
+	}
+
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.HasPrefix(name, string(os.PathSeparator)) {
+		return "", rejectArchive("entry %q has an absolute path", name)
+	}
+
+	clean := filepath.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(os.PathSeparator)) {
+		return "", rejectArchive("entry %q points outside of the extraction directory", name)
+	}
+
+	dst := filepath.Join(ex.dir, clean)
+
+	rel, err := filepath.Rel(ex.dir, dst)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", rejectArchive("entry %q points outside of the extraction directory", name)
+	}
+
+	return dst, nil
+}
+
+// count registers a new entry, and errors if there are too many of them.
+func (ex *extractor) count() error {
+	ex.entries++
+	if ex.entries > ex.maxEntries {
+		return rejectArchive("more than %d entries", ex.maxEntries)
+	}
+
+	return nil
+}
+
+func (ex *extractor) mkdir(name string) error {
+	dst, err := ex.target(name)
+	if err != nil {
+		return err
+	}
+
+	if err = ex.count(); err != nil {
+		return err
+	}
+
+	return os.MkdirAll(dst, 0755)
+}
+
+// write copies src into the file called name inside the extraction directory,
+// stopping once the total extracted size would exceed the limit.
+func (ex *extractor) write(name string, src io.Reader, mode os.FileMode) (string, error) {
+	dst, err := ex.target(name)
+	if err != nil {
+		return "", err
+	}
+
+	if err = ex.count(); err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return "", fmt.Errorf("could not create directory: %s", err.Error())
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0600)
+	if err != nil {
+		return "", fmt.Errorf("could not create output file: %s", err.Error())
+	}
+	defer out.Close()
+
+	n, err := io.Copy(out, io.LimitReader(src, ex.maxSize-ex.written+1))
+	ex.written += n
+	if err != nil {
+		return "", fmt.Errorf("extracting %q failed: %s", name, err.Error())
+	}
+
+	if ex.written > ex.maxSize {
+		return "", rejectArchive("expands to more than %d bytes", ex.maxSize)
+	}
+
+	return dst, nil
+}
+
+func (ex *extractor) unzip(path string) ([]string, error) {
 	defer os.Remove(path)
 
 	r, err := zip.OpenReader(path)
@@ -22,9 +204,26 @@ func unzip(path string) ([]string, error) {
 
 	var files []string
 	for _, f := range r.File {
-		name, err := unzipFile(f)
+		if f.Mode()&os.ModeSymlink != 0 {
+			return nil, rejectArchive("entry %q is a symlink", f.Name)
+		}
+
+		if f.FileInfo().IsDir() {
+			err = ex.mkdir(f.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if f.UncompressedSize64 > uint64(ex.maxSize-ex.written) {
+			return nil, rejectArchive("expands to more than %d bytes", ex.maxSize)
+		}
+
+		name, err := ex.unzipFile(f)
 		if err != nil {
-			return nil, fmt.Errorf("extracting zip file failed: %s", err.Error())
+			return nil, err
 		}
 
 		files = append(files, name)
@@ -33,28 +232,17 @@ func unzip(path string) ([]string, error) {
 	return files, nil
 }
 
-func unzipFile(f *zip.File) (string, error) {
+func (ex *extractor) unzipFile(f *zip.File) (string, error) {
 	src, err := f.Open()
 	if err != nil {
 		return "", fmt.Errorf("opening zipfile failed: %s", err.Error())
 	}
 	defer src.Close()
 
-	dst, err := os.OpenFile(f.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-	if err != nil {
-		return "", fmt.Errorf("opening destination file failed: %s", err.Error())
-	}
-	defer dst.Close()
-
-	_, err = io.Copy(dst, src)
-	if err != nil {
-		return "", fmt.Errorf("copying from archive failed: %s", err.Error())
-	}
-
-	return f.Name, nil
+	return ex.write(f.Name, src, f.Mode())
 }
 
-func ungzip(path string) ([]string, error) {
+func (ex *extractor) ungzip(path string) ([]string, error) {
 	defer os.Remove(path)
 
 	reader, err := os.Open(path)
@@ -69,34 +257,26 @@ func ungzip(path string) ([]string, error) {
 	}
 	defer archive.Close()
 
-	ext := filepath.Ext(path)
-	name := archive.Header.Name
-	if name == "" {
+	name := filepath.Base(archive.Header.Name)
+	if archive.Header.Name == "" {
 		dstName := filepath.Base(path)
 
-		name = dstName[:len(dstName)-len(ext)]
+		name = dstName[:len(dstName)-len(filepath.Ext(path))]
 	}
 
-	dst, err := os.Create(name)
-	if err != nil {
-		return nil, fmt.Errorf("could not create output file: %s", err.Error())
+	if filepath.Ext(name) == ".tar" {
+		return ex.untarFrom(archive)
 	}
-	defer dst.Close()
 
-	_, err = io.Copy(dst, archive)
+	dst, err := ex.write(name, archive, 0644)
 	if err != nil {
-		return nil, fmt.Errorf("uncompressing gzip failed: %s", err.Error())
-	}
-
-	if filepath.Ext(dst.Name()) == ".tar" {
-		dst.Close()
-		return untar(fmt.Sprintf("%s/%s", filepath.Dir(dst.Name()), dst.Name()))
+		return nil, err
 	}
 
-	return []string{dst.Name()}, nil
+	return []string{dst}, nil
 }
 
-func unbzip2(path string) ([]string, error) {
+func (ex *extractor) unbzip2(path string) ([]string, error) {
 	defer os.Remove(path)
 
 	reader, err := os.Open(path)
@@ -107,43 +287,37 @@ func unbzip2(path string) ([]string, error) {
 
 	archive := bzip2.NewReader(reader)
 
-	ext := filepath.Ext(path)
 	dstName := filepath.Base(path)
+	name := dstName[:len(dstName)-len(filepath.Ext(path))]
 
-	name := dstName[:len(dstName)-len(ext)]
-
-	dst, err := os.Create(name)
-	if err != nil {
-		return nil, fmt.Errorf("could not create output file: %s", err.Error())
+	if filepath.Ext(name) == ".tar" {
+		return ex.untarFrom(archive)
 	}
-	defer dst.Close()
 
-	_, err = io.Copy(dst, archive)
+	dst, err := ex.write(name, archive, 0644)
 	if err != nil {
-		return nil, fmt.Errorf("uncompressing bzip2 failed: %s", err.Error())
+		return nil, err
 	}
 
-	if filepath.Ext(dst.Name()) == ".tar" {
-		dst.Close()
-		return untar(fmt.Sprintf("%s/%s", filepath.Dir(dst.Name()), dst.Name()))
-	}
-
-	return []string{dst.Name()}, nil
+	return []string{dst}, nil
 }
 
-func untar(path string) ([]string, error) {
+func (ex *extractor) untar(path string) ([]string, error) {
 	defer os.Remove(path)
 
 	file, err := os.Open(path)
-
 	if err != nil {
 		return nil, fmt.Errorf("opening tarball failed: %s", err.Error())
 	}
 	defer file.Close()
 
+	return ex.untarFrom(file)
+}
+
+func (ex *extractor) untarFrom(r io.Reader) ([]string, error) {
 	var files []string
 
-	tarBallReader := tar.NewReader(file)
+	tarBallReader := tar.NewReader(r)
 
 	for {
 		header, err := tarBallReader.Next()
@@ -155,28 +329,27 @@ func untar(path string) ([]string, error) {
 			return nil, fmt.Errorf("encountered error while reading tarball: %s", err.Error())
 		}
 
-		filename := header.Name
-
 		switch header.Typeflag {
 		case tar.TypeDir:
+			err = ex.mkdir(header.Name)
 			if err != nil {
-				return nil, fmt.Errorf("tarball contains folder, stopping")
+				return nil, err
 			}
 		case tar.TypeReg:
-			writer, err := os.Create(filename)
-			if err != nil {
-				return nil, fmt.Errorf("could not create output file: %s", err.Error())
+			if header.Size > ex.maxSize-ex.written {
+				return nil, rejectArchive("expands to more than %d bytes", ex.maxSize)
 			}
-			defer writer.Close()
 
-			_, err = io.Copy(writer, tarBallReader)
+			name, err := ex.write(header.Name, tarBallReader, os.FileMode(header.Mode))
 			if err != nil {
-				return nil, fmt.Errorf("uncompressing tarball failed: %s", err.Error())
+				return nil, err
 			}
 
-			files = append(files, writer.Name())
+			files = append(files, name)
+		case tar.TypeSymlink, tar.TypeLink:
+			return nil, rejectArchive("entry %q is a link", header.Name)
 		default:
-			fmt.Printf("Unable to untar type : %c in file %s", header.Typeflag, filename)
+			logger.Warn("Skipping tarball entry %q of unsupported type %c", header.Name, header.Typeflag)
 		}
 	}
 
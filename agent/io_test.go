@@ -0,0 +1,167 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testEntry struct {
+	name    string
+	content string
+	link    bool
+}
+
+func writeTestZip(t *testing.T, dir string, entries []testEntry) string {
+	path := filepath.Join(dir, "test.zip")
+
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("could not create zip: %v", err)
+	}
+	defer out.Close()
+
+	w := zip.NewWriter(out)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		header.SetMode(0644)
+
+		if e.link {
+			header.SetMode(os.ModeSymlink | 0777)
+		}
+
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("could not add %q to zip: %v", e.name, err)
+		}
+
+		f.Write([]byte(e.content))
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close zip: %v", err)
+	}
+
+	return path
+}
+
+func writeTestTar(t *testing.T, dir string, entries []testEntry) string {
+	var buf bytes.Buffer
+
+	w := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if e.link {
+			header = &tar.Header{Name: e.name, Linkname: e.content, Typeflag: tar.TypeSymlink}
+		}
+
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatalf("could not add %q to tar: %v", e.name, err)
+		}
+
+		if !e.link {
+			w.Write([]byte(e.content))
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close tar: %v", err)
+	}
+
+	path := filepath.Join(dir, "test.tar")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("could not write tar: %v", err)
+	}
+
+	return path
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name     string
+		tarball  bool
+		entries  []testEntry
+		maxSize  int64
+		maxFiles int
+		rejected bool
+	}{
+		{name: "zip ok", entries: []testEntry{{name: "dump.sql", content: "select 1;"}}},
+		{name: "zip nested ok", entries: []testEntry{{name: "dir/dump.sql", content: "select 1;"}}},
+		{name: "zip slip", entries: []testEntry{{name: "../evil.sql", content: "drop"}}, rejected: true},
+		{name: "zip slip nested", entries: []testEntry{{name: "dir/../../evil.sql", content: "drop"}}, rejected: true},
+		{name: "zip absolute", entries: []testEntry{{name: "/tmp/evil.sql", content: "drop"}}, rejected: true},
+		{name: "zip symlink", entries: []testEntry{{name: "link", content: "/etc/passwd", link: true}}, rejected: true},
+		{name: "zip too large", entries: []testEntry{{name: "dump.sql", content: "0123456789"}}, maxSize: 5, rejected: true},
+		{name: "zip too many", entries: []testEntry{{name: "a", content: "a"}, {name: "b", content: "b"}}, maxFiles: 1, rejected: true},
+		{name: "tar ok", tarball: true, entries: []testEntry{{name: "dump.sql", content: "select 1;"}}},
+		{name: "tar slip", tarball: true, entries: []testEntry{{name: "../evil.sql", content: "drop"}}, rejected: true},
+		{name: "tar symlink", tarball: true, entries: []testEntry{{name: "link", content: "/etc/passwd", link: true}}, rejected: true},
+		{name: "tar too large", tarball: true, entries: []testEntry{{name: "dump.sql", content: "0123456789"}}, maxSize: 5, rejected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "ddnc-test")
+			if err != nil {
+				t.Fatalf("could not create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tmp)
+
+			jobDir := filepath.Join(tmp, "job")
+			os.Mkdir(jobDir, 0755)
+
+			var path string
+			if tt.tarball {
+				path = writeTestTar(t, tmp, tt.entries)
+			} else {
+				path = writeTestZip(t, tmp, tt.entries)
+			}
+
+			ex := newExtractor(jobDir)
+			if tt.maxSize != 0 {
+				ex.maxSize = tt.maxSize
+			}
+			if tt.maxFiles != 0 {
+				ex.maxEntries = tt.maxFiles
+			}
+
+			files, err := ex.extract(path)
+			if tt.rejected {
+				if !isRejectedArchive(err) {
+					t.Fatalf("expected archive to be rejected, got: %v", err)
+				}
+
+				if _, err := os.Stat(filepath.Join(tmp, "evil.sql")); err == nil {
+					t.Errorf("file was written outside of the extraction directory")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("extract failed: %v", err)
+			}
+
+			if len(files) != len(tt.entries) {
+				t.Fatalf("expected %d files, got %d", len(tt.entries), len(files))
+			}
+
+			for _, f := range files {
+				rel, err := filepath.Rel(jobDir, f)
+				if err != nil || filepath.IsAbs(rel) || rel[0] == '.' {
+					t.Errorf("file %q extracted outside of %q", f, jobDir)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractUnsupported(t *testing.T) {
+	_, err := newExtractor(os.TempDir()).extract("dump.rar")
+	if !isUnsupportedArchive(err) || isRejectedArchive(err) {
+		t.Errorf("expected the archive to be unsupported, got: %v", err)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -51,9 +50,20 @@ func main() {
 	var err error
 	filename := flag.String("p", "ddnc.conf", "Specify the configuration file's name")
 	logname := flag.String("l", "std", "Specify the log's filename. If set to std, logs to the terminal.")
+	logformat := flag.String("f", "text", "Specify the log's format: text, json or logfmt.")
+	logMaxSize := flag.Int64("log-max-size", 0, "Rotate the log file when it grows above this many megabytes. 0 disables it.")
+	logDaily := flag.Bool("log-daily", false, "Rotate the log file at the start of every day.")
+	logCompress := flag.Bool("log-compress", false, "Compress rotated log files with gzip.")
+	logKeep := flag.Int("log-keep", 0, "Number of rotated log files to keep. 0 keeps all of them.")
 
 	flag.Parse()
 
+	format, err := logger.ParseFormat(*logformat)
+	if err != nil {
+		logger.Fatal("%v", err)
+	}
+	logger.SetFormat(format)
+
 	loadProperties(*filename)
 
 	if _, err := os.Stat(conf.Exec); os.IsNotExist(err) {
@@ -61,21 +71,19 @@ func main() {
 	}
 
 	if *logname != "std" {
-		if _, err := os.Stat(*logname); err == nil {
-			rotated := fmt.Sprintf("%s.%s", *logname, time.Now().Format("2006-01-02_03:04"))
-			logger.Debug("Rotating logfile to %s", rotated)
-
-			os.Rename(*logname, rotated)
-		}
-
-		logOut, err := os.OpenFile(*logname, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		logOut, err := logger.OpenRotatingFile(*logname, logger.RotateOptions{
+			MaxSize:    *logMaxSize * 1024 * 1024,
+			Daily:      *logDaily,
+			Compress:   *logCompress,
+			MaxBackups: *logKeep,
+		})
 		if err != nil {
-			fmt.Printf("error opening file %s, will continue logging to stderr: %s", *logname, err.Error())
-			logOut = os.Stderr
-		}
-		defer logOut.Close()
+			logger.Warn("error opening file %s, will continue logging to stderr: %v", *logname, err)
+		} else {
+			defer logOut.Close()
 
-		log.SetOutput(logOut)
+			log.SetOutput(logOut)
+		}
 	}
 
 	hostname, err = os.Hostname()
@@ -153,6 +161,15 @@ func main() {
 		}
 	}
 
+	err = inet.UseTLS(conf.TLS())
+	if err != nil {
+		logger.Fatal("tls: %v", err)
+	}
+
+	if conf.MasterAddress == "" {
+		discoverServer()
+	}
+
 	err = registerAgent()
 	if err != nil {
 		logger.Error("Could not register agent, will keep trying: %s", err.Error())
@@ -169,7 +186,7 @@ func main() {
 
 	logger.Debug("Started up at %s", startup.Round(time.Millisecond))
 
-	logger.Fatal("server: %v", http.ListenAndServe(port, Router()))
+	logger.Fatal("server: %v", inet.ListenAndServe(port, Router(), conf.TLS(), true))
 }
 
 func loadProperties(filename string) {
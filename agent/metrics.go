@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/djavorszky/notif"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Imports and exports can take anything from seconds to hours.
+var jobBuckets = prometheus.ExponentialBuckets(1, 3, 10)
+
+var (
+	importDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ddn",
+		Subsystem: "agent",
+		Name:      "import_duration_seconds",
+		Help:      "Time imports took from download to completion, by vendor and outcome.",
+		Buckets:   jobBuckets,
+	}, []string{"vendor", "outcome"})
+
+	exportDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ddn",
+		Subsystem: "agent",
+		Name:      "export_duration_seconds",
+		Help:      "Time exports took until the dump was zipped, by vendor and outcome.",
+		Buckets:   jobBuckets,
+	}, []string{"vendor", "outcome"})
+
+	jobsInProgress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ddn",
+		Subsystem: "agent",
+		Name:      "jobs_in_progress",
+		Help:      "Number of imports and exports currently running.",
+	}, []string{"operation"})
+
+	downloadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "ddn",
+		Subsystem: "agent",
+		Name:      "download_bytes_total",
+		Help:      "Bytes of dumps downloaded for importing.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(importDuration, exportDuration, jobsInProgress, downloadBytes)
+}
+
+// observeJob returns a channel that passes the statuses on to ch. Once it is
+// closed, the duration of the job is recorded, with the last status sent
+// deciding the outcome, and ch is closed as well.
+func observeJob(operation string, durations *prometheus.HistogramVec, ch chan notif.Y) chan notif.Y {
+	proxy := make(chan notif.Y)
+	start := time.Now()
+	vendor := conf.Vendor
+
+	jobsInProgress.WithLabelValues(operation).Inc()
+
+	go func() {
+		var last int
+		for y := range proxy {
+			last = y.StatusCode
+			ch <- y
+		}
+
+		jobsInProgress.WithLabelValues(operation).Dec()
+		durations.WithLabelValues(vendor, outcome(last)).Observe(time.Since(start).Seconds())
+
+		close(ch)
+	}()
+
+	return proxy
+}
+
+// outcome returns the label of the status code's category.
+func outcome(statusCode int) string {
+	switch {
+	case statusCode > 99 && statusCode < 200:
+		return "success"
+	case statusCode > 199 && statusCode < 300:
+		return "client_error"
+	case statusCode > 299 && statusCode < 400:
+		return "server_error"
+	default:
+		return "unknown"
+	}
+}
+
+// countDownload adds the size of the downloaded file to the downloaded bytes.
+func countDownload(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	downloadBytes.Add(float64(fi.Size()))
+}
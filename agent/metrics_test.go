@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/djavorszky/ddn/common/status"
+	"github.com/djavorszky/notif"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestObserveJob(t *testing.T) {
+	defer func(old Config) { conf = old }(conf)
+	conf.Vendor = "mysql"
+
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_duration_seconds"}, []string{"vendor", "outcome"})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(durations)
+
+	ch := make(chan notif.Y)
+	proxy := observeJob("import", durations, ch)
+
+	go func() {
+		proxy <- notif.Y{StatusCode: status.ImportInProgress, Msg: "Importing"}
+		proxy <- notif.Y{StatusCode: status.Success, Msg: "Completed"}
+		close(proxy)
+	}()
+
+	var got []int
+	for y := range ch {
+		got = append(got, y.StatusCode)
+	}
+
+	if len(got) != 2 || got[0] != status.ImportInProgress || got[1] != status.Success {
+		t.Errorf("statuses not passed on in order: %v", got)
+	}
+
+	// The duration is recorded by the time ch is closed
+	families, err := reg.Gather()
+	if err != nil || len(families) != 1 || len(families[0].GetMetric()) != 1 {
+		t.Fatalf("expected a single duration, got %v: %v", families, err)
+	}
+
+	m := families[0].GetMetric()[0]
+	if m.GetHistogram().GetSampleCount() != 1 {
+		t.Errorf("expected one observation, got %d", m.GetHistogram().GetSampleCount())
+	}
+
+	labels := make(map[string]string)
+	for _, l := range m.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+
+	if labels["vendor"] != "mysql" || labels["outcome"] != "success" {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	tests := map[int]string{
+		status.Success:          "success",
+		status.DownloadFailed:   "client_error",
+		status.ImportFailed:     "server_error",
+		status.ImportInProgress: "unknown",
+		0:                       "unknown",
+	}
+
+	for code, want := range tests {
+		if got := outcome(code); got != want {
+			t.Errorf("outcome(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
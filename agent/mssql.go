@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 
 	"github.com/djavorszky/ddn/common/logger"
 	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/sutils"
+
+	_ "github.com/denisenkom/go-mssqldb"
 )
 
 type mssql struct {
@@ -16,77 +23,226 @@ type mssql struct {
 }
 
 func (db *mssql) Connect(c Config) error {
-	return fmt.Errorf("operation not supported: Connect")
+	var err error
+
+	if ok := sutils.Present(c.User, c.Password, c.LocalDBAddr, c.LocalDBPort); !ok {
+		return fmt.Errorf("missing parameters. Need-Got: {user: %s}, {password: %s}, {dbAddress: %s}, {dbPort: %s}", c.User, c.Password, c.LocalDBAddr, c.LocalDBPort)
+	}
+
+	query := url.Values{}
+	query.Add("database", "master")
+
+	datasource := &url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     fmt.Sprintf("%s:%s", c.LocalDBAddr, c.LocalDBPort),
+		RawQuery: query.Encode(),
+	}
+
+	db.conn, err = sql.Open("sqlserver", datasource.String())
+	if err != nil {
+		return fmt.Errorf("creating connection pool failed: %s", err.Error())
+	}
+
+	err = db.conn.Ping()
+	if err != nil {
+		db.conn.Close()
+		return fmt.Errorf("database ping failed: %s", err.Error())
+	}
+
+	return nil
 }
 
 func (db *mssql) Close() {
-	// Not needed
+	db.conn.Close()
 }
 
 func (db *mssql) Alive() error {
-	return fmt.Errorf("operation not supported: Alive")
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error("Panic Attack! Database seems to be down.")
+		}
+	}()
+
+	_, err := db.conn.Exec("SELECT 1")
+	if err != nil {
+		return fmt.Errorf("executing stayalive query failed: %s", err.Error())
+	}
+
+	return nil
 }
 
+// CreateDatabase creates a database along with a login of the same name as the
+// user, which is made the owner of the database. Fails if the database or the
+// login already exists.
 func (db *mssql) CreateDatabase(dbRequest model.DBRequest) error {
+	err := db.Alive()
+	if err != nil {
+		return fmt.Errorf("alive check failed: %s", err.Error())
+	}
 
-	args := []string{"-b", "-U", conf.User, "-P", conf.Password, "-Q", fmt.Sprintf("CREATE DATABASE %s", dbRequest.DatabaseName)}
+	if isMSSQLSystemDatabase(dbRequest.DatabaseName) {
+		return fmt.Errorf("trying to create system databases not allowed")
+	}
 
-	res := RunCommand(conf.Exec, args...)
+	switch strings.ToLower(dbRequest.Username) {
+	case "sa", strings.ToLower(conf.User):
+		return fmt.Errorf("trying to create admin login not allowed")
+	}
 
-	if res.exitCode != 0 {
-		if strings.Contains(res.stderr, "already exists") {
-			return fmt.Errorf("database %q already exists", dbRequest.DatabaseName)
-		}
-		logger.Error("unable to create database:\n> stdout:\n%q\n> stderr:\n%q\n> exitCode: %d", res.stdout, res.stderr, res.exitCode)
+	exists, err := db.dbExists(dbRequest.DatabaseName)
+	if err != nil {
+		return fmt.Errorf("checking if database exists failed: %s", err.Error())
+	}
+	if exists {
+		return fmt.Errorf("database %q already exists", dbRequest.DatabaseName)
+	}
 
-		return fmt.Errorf("create database failed with exitcode '%d'", res.exitCode)
+	exists, err = db.loginExists(dbRequest.Username)
+	if err != nil {
+		return fmt.Errorf("checking if login exists failed: %s", err.Error())
+	}
+	if exists {
+		return fmt.Errorf("login %q already exists", dbRequest.Username)
+	}
+
+	// SQL Server does not allow creating databases in transactions, so
+	// everything is cleaned up by hand if something goes wrong. Until the
+	// login is created, only the database is dropped, in case someone else
+	// created the login in the meantime.
+	dbOnly := dbRequest
+	dbOnly.Username = ""
+
+	_, err = db.conn.Exec(fmt.Sprintf("CREATE DATABASE %s", mssqlIdent(dbRequest.DatabaseName)))
+	if err != nil {
+		return fmt.Errorf("executing create database query failed: %s", err.Error())
+	}
+
+	_, err = db.conn.Exec(fmt.Sprintf("CREATE LOGIN %s WITH PASSWORD = %s, CHECK_POLICY = OFF, DEFAULT_DATABASE = %s",
+		mssqlIdent(dbRequest.Username), mssqlString(dbRequest.Password), mssqlIdent(dbRequest.DatabaseName)))
+	if err != nil {
+		db.DropDatabase(dbOnly)
+		return fmt.Errorf("executing create login %q failed: %s", dbRequest.Username, err.Error())
+	}
+
+	err = db.mapUser(dbRequest)
+	if err != nil {
+		db.DropDatabase(dbRequest)
+		return err
 	}
 
 	return nil
 }
 
+// DropDatabase drops a database and its login, unless the login is still the
+// user of other databases. Always succeeds, even if droppable database or
+// login does not exist
 func (db *mssql) DropDatabase(dbRequest model.DBRequest) error {
+	err := db.Alive()
+	if err != nil {
+		return fmt.Errorf("alive check failed: %s", err.Error())
+	}
 
-	args := []string{"-b", "-U", conf.User, "-P", conf.Password, "-Q", fmt.Sprintf("DROP DATABASE %s", dbRequest.DatabaseName)}
+	if isMSSQLSystemDatabase(dbRequest.DatabaseName) {
+		return fmt.Errorf("dropping system databases not allowed")
+	}
 
-	res := RunCommand(conf.Exec, args...)
+	switch strings.ToLower(dbRequest.Username) {
+	case "sa", strings.ToLower(conf.User):
+		return fmt.Errorf("dropping admin login not allowed")
+	}
+
+	exists, err := db.dbExists(dbRequest.DatabaseName)
+	if err != nil {
+		return fmt.Errorf("checking if database exists failed: %s", err.Error())
+	}
 
-	if res.exitCode != 0 {
-		if !(strings.Contains(res.stderr, "it does not exist")) {
-			logger.Error("Unable to drop database:\n> stdout:\n'%s'\n> stderr:\n'%s'\n> exitCode: %d", res.stdout, res.stderr, res.exitCode)
+	if exists {
+		// Kick out anyone still connected, otherwise the drop fails
+		name := mssqlIdent(dbRequest.DatabaseName)
 
-			return fmt.Errorf("drop database failed with exitcode '%d'", res.exitCode)
+		_, err = db.conn.Exec(fmt.Sprintf("ALTER DATABASE %s SET SINGLE_USER WITH ROLLBACK IMMEDIATE; DROP DATABASE %s", name, name))
+		if err != nil {
+			return fmt.Errorf("dropping database %q failed: %s", dbRequest.DatabaseName, err.Error())
 		}
 	}
 
+	if dbRequest.Username == "" {
+		return nil
+	}
+
+	exists, err = db.loginExists(dbRequest.Username)
+	if err != nil {
+		return fmt.Errorf("checking if login exists failed: %s", err.Error())
+	}
+
+	if !exists {
+		return nil
+	}
+
+	// Logins are server level objects, so only drop it if no other database uses it.
+	dbs, err := db.ListDatabase()
+	if err != nil {
+		return fmt.Errorf("listing databases failed: %s", err.Error())
+	}
+
+	for _, name := range dbs {
+		var count int
+
+		err = db.conn.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s.sys.database_principals dp JOIN sys.server_principals sp ON dp.sid = sp.sid WHERE sp.name = @p1",
+			mssqlIdent(name)), dbRequest.Username).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("checking users of login failed: %s", err.Error())
+		}
+
+		if count != 0 {
+			return nil
+		}
+	}
+
+	_, err = db.conn.Exec(fmt.Sprintf("DROP LOGIN %s", mssqlIdent(dbRequest.Username)))
+	if err != nil {
+		return fmt.Errorf("dropping login %q failed: %s", dbRequest.Username, err.Error())
+	}
+
 	return nil
 }
 
+// ImportDatabase restores a .bak file over the database created for the import. The
+// data and log files are moved to the data directory of the agent, named after the database.
 func (db *mssql) ImportDatabase(dbRequest model.DBRequest) error {
-	curDir, err := os.Getwd()
+	dataDir, err := db.dataDir()
 	if err != nil {
-		return fmt.Errorf("could not determine current directory")
+		db.DropDatabase(dbRequest)
+		return fmt.Errorf("could not determine data directory: %s", err.Error())
 	}
 
-	s := strings.Split(dbRequest.DumpLocation, ":")
+	files, err := db.backupFiles(dbRequest.DumpLocation)
+	if err != nil {
+		db.DropDatabase(dbRequest)
+		return fmt.Errorf("could not read file list of backup: %s", err.Error())
+	}
 
-	driveLetter, dumpPath := s[0], s[1]
+	stmt := fmt.Sprintf("RESTORE DATABASE %s FROM DISK = %s WITH REPLACE",
+		mssqlIdent(dbRequest.DatabaseName), mssqlString(dbRequest.DumpLocation))
 
-	args := []string{
-		"-b",
-		"-U", conf.User,
-		"-P", conf.Password,
-		"-v", "driveLetter=" + driveLetter,
-		"-v", "dumpPath=" + dumpPath,
-		"-v", "targetDatabaseName=" + dbRequest.DatabaseName,
-		"-i", curDir + "\\sql\\mssql\\import_dump.sql"}
+	for i, f := range files {
+		stmt += fmt.Sprintf(", MOVE %s TO %s", mssqlString(f.logicalName), mssqlString(f.target(dataDir, dbRequest.DatabaseName, i)))
+	}
 
-	res := RunCommand(conf.Exec, args...)
+	logger.Debug("Restoring backup: %s", stmt)
 
-	if res.exitCode != 0 {
-		logger.Error("Dump import seems to have failed:\n> stdout:\n'%s'\n> stderr:\n'%s'\n> exitCode: %d", res.stdout, res.stderr, res.exitCode)
+	_, err = db.conn.Exec(stmt)
+	if err != nil {
+		db.DropDatabase(dbRequest)
+		return fmt.Errorf("restoring backup failed: %s", err.Error())
+	}
 
-		return fmt.Errorf("import failed with exitcode '%q'", res.exitCode)
+	// The restore replaced the user created along with the database
+	err = db.mapUser(dbRequest)
+	if err != nil {
+		db.DropDatabase(dbRequest)
+		return err
 	}
 
 	return nil
@@ -98,24 +254,78 @@ func (db *mssql) ExportDatabase(dbRequest model.DBRequest) (string, error) {
 	return "", fmt.Errorf("export not yet implemented for MSSQL")
 }
 
+// ListDatabase returns a list of strings - the names of the databases in the server
+// All system tables are omitted from the returned list. If there's an error, it is returned.
 func (db *mssql) ListDatabase() ([]string, error) {
-	return nil, fmt.Errorf("operation not supported: ListDatabase")
+	err := db.Alive()
+	if err != nil {
+		return nil, fmt.Errorf("alive check failed: %s", err.Error())
+	}
+
+	rows, err := db.conn.Query("SELECT name FROM sys.databases WHERE database_id > 4")
+	if err != nil {
+		return nil, fmt.Errorf("listing databases failed: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var list []string
+	for rows.Next() {
+		var database string
+
+		err = rows.Scan(&database)
+		if err != nil {
+			return nil, fmt.Errorf("scanning db rows failed: %s", err.Error())
+		}
+
+		if isMSSQLSystemDatabase(database) {
+			continue
+		}
+
+		list = append(list, database)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error encountered when reading rows: %s", err.Error())
+	}
+
+	return list, nil
 }
 
 func (db *mssql) Version() (string, error) {
+	var version string
 
-	args := []string{"-b", "-h", "-1", "-W", "-U", conf.User, "-P", conf.Password, "-Q",
-		"SET NOCOUNT ON; SELECT (CAST(SERVERPROPERTY('productversion') AS nvarchar(128)) + SPACE(1) + CAST(SERVERPROPERTY('productlevel') AS nvarchar(128)) + SPACE(1) + CAST(SERVERPROPERTY('edition') AS nvarchar(128)))"}
+	err := db.conn.QueryRow("SELECT CAST(SERVERPROPERTY('productversion') AS nvarchar(128)) + SPACE(1) + CAST(SERVERPROPERTY('productlevel') AS nvarchar(128)) + SPACE(1) + CAST(SERVERPROPERTY('edition') AS nvarchar(128))").Scan(&version)
+	if err != nil {
+		return "", fmt.Errorf("getting version failed: %s", err.Error())
+	}
 
-	res := RunCommand(conf.Exec, args...)
+	return strings.TrimSpace(version), nil
+}
+
+// DatabaseSizes returns the size of the data and log files of each database.
+func (db *mssql) DatabaseSizes() (map[string]int64, error) {
+	err := db.Alive()
+	if err != nil {
+		return nil, fmt.Errorf("alive check failed: %s", err.Error())
+	}
+
+	// size is in 8 KB pages
+	return querySizes(db.conn, `SELECT d.name, SUM(CAST(f.size AS bigint)) * 8192 FROM sys.databases d
+		JOIN sys.master_files f ON f.database_id = d.database_id
+		WHERE d.database_id > 4 GROUP BY d.name`, isMSSQLSystemDatabase)
+}
 
-	if res.exitCode != 0 {
-		logger.Error("Unable to get SQL Server version:\n> stdout:\n'%s'\n> stderr:\n'%s'\n> exitCode: %d", res.stdout, res.stderr, res.exitCode)
+// Connections returns the number of user sessions on the server.
+func (db *mssql) Connections() (int, error) {
+	var count int
 
-		return "", fmt.Errorf("getting version failed with exitcode '%d'", res.exitCode)
+	err := db.conn.QueryRow("SELECT count(*) FROM sys.dm_exec_sessions WHERE is_user_process = 1").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("counting sessions failed: %s", err.Error())
 	}
 
-	return strings.TrimSpace(res.stdout), nil
+	return count, nil
 }
 
 func (db *mssql) RequiredFields(dbreq model.DBRequest, reqType int) []string {
@@ -123,14 +333,190 @@ func (db *mssql) RequiredFields(dbreq model.DBRequest, reqType int) []string {
 
 	switch reqType {
 	case createDB:
-		req = append(req, dbreq.Password)
+		req = append(req, dbreq.Username, dbreq.Password)
 	case importDB:
-		req = append(req, strconv.Itoa(dbreq.ID), dbreq.Password, dbreq.DumpLocation)
+		req = append(req, strconv.Itoa(dbreq.ID), dbreq.Username, dbreq.Password, dbreq.DumpLocation)
 	}
 
 	return req
 }
 
+// ValidateDump checks that the dump is a SQL Server backup. These are in
+// Microsoft Tape Format, and start with a "TAPE" block.
 func (db *mssql) ValidateDump(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("could not open dumpfile '%s': %s", path, err.Error())
+	}
+	defer file.Close()
+
+	header := make([]byte, 4)
+	_, err = io.ReadFull(file, header)
+	if err != nil || !bytes.Equal(header, []byte("TAPE")) {
+		return path, fmt.Errorf("dump is not a SQL Server backup (.bak) file")
+	}
+
 	return path, nil
 }
+
+// mapUser creates the user for the login in the database, or reattaches it if
+// the database already has a user with that name, and makes it the database's owner.
+func (db *mssql) mapUser(dbRequest model.DBRequest) error {
+	user := mssqlIdent(dbRequest.Username)
+
+	batch := fmt.Sprintf(`IF USER_ID(%s) IS NULL CREATE USER %s FOR LOGIN %s ELSE ALTER USER %s WITH LOGIN = %s;
+EXEC sp_addrolemember N'db_owner', %s;`, mssqlString(dbRequest.Username), user, user, user, user, mssqlString(dbRequest.Username))
+
+	_, err := db.conn.Exec(fmt.Sprintf("EXEC %s.sys.sp_executesql %s", mssqlIdent(dbRequest.DatabaseName), mssqlString(batch)))
+	if err != nil {
+		return fmt.Errorf("creating user %q in database %q failed: %s", dbRequest.Username, dbRequest.DatabaseName, err.Error())
+	}
+
+	return nil
+}
+
+// dataDir returns the directory the restored databases' files should be put in.
+// Unless configured, it is the directory the server keeps the master database in.
+func (db *mssql) dataDir() (string, error) {
+	if conf.MSSQLDataDir != "" {
+		return conf.MSSQLDataDir, nil
+	}
+
+	var master string
+
+	err := db.conn.QueryRow("SELECT physical_name FROM sys.master_files WHERE database_id = 1 AND type = 0").Scan(&master)
+	if err != nil {
+		return "", err
+	}
+
+	i := strings.LastIndexAny(master, `\/`)
+	if i == -1 {
+		return "", fmt.Errorf("unexpected location of master database: %q", master)
+	}
+
+	return master[:i], nil
+}
+
+// mssqlBackupFile is a file contained in a backup, as returned by RESTORE FILELISTONLY
+type mssqlBackupFile struct {
+	logicalName  string
+	physicalName string
+	fileType     string
+}
+
+// target returns where the i-th file of the backup should be restored to.
+func (f mssqlBackupFile) target(dir, database string, i int) string {
+	sep := `\`
+	if strings.HasPrefix(dir, "/") {
+		sep = "/"
+	}
+
+	ext := path.Ext(strings.Replace(f.physicalName, `\`, "/", -1))
+
+	switch f.fileType {
+	case "L":
+		ext = ".ldf"
+	case "D":
+		if i == 0 {
+			ext = ".mdf"
+		} else if ext == "" {
+			ext = ".ndf"
+		}
+	}
+
+	return fmt.Sprintf("%s%s%s_%d%s", strings.TrimRight(dir, `\/`), sep, database, i, ext)
+}
+
+// backupFiles returns the files in the backup at path.
+func (db *mssql) backupFiles(path string) ([]mssqlBackupFile, error) {
+	rows, err := db.conn.Query(fmt.Sprintf("RESTORE FILELISTONLY FROM DISK = %s", mssqlString(path)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// The number of columns differs between server versions
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []mssqlBackupFile
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+
+		var f mssqlBackupFile
+		for i, col := range cols {
+			switch col {
+			case "LogicalName":
+				f.logicalName = values[i].String
+			case "PhysicalName":
+				f.physicalName = values[i].String
+			case "Type":
+				f.fileType = values[i].String
+			}
+		}
+
+		files = append(files, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("backup does not contain any files")
+	}
+
+	return files, nil
+}
+
+func (db *mssql) dbExists(database string) (bool, error) {
+	var count int
+
+	err := db.conn.QueryRow("SELECT count(*) FROM sys.databases WHERE name = @p1", database).Scan(&count)
+	if err != nil {
+		return true, fmt.Errorf("executing query failed: %s", err.Error())
+	}
+
+	return count != 0, nil
+}
+
+func (db *mssql) loginExists(login string) (bool, error) {
+	var count int
+
+	err := db.conn.QueryRow("SELECT count(*) FROM sys.server_principals WHERE name = @p1", login).Scan(&count)
+	if err != nil {
+		return true, fmt.Errorf("executing query failed: %s", err.Error())
+	}
+
+	return count != 0, nil
+}
+
+func isMSSQLSystemDatabase(database string) bool {
+	switch strings.ToLower(database) {
+	case "master", "tempdb", "model", "msdb", "distribution":
+		return true
+	}
+
+	return false
+}
+
+// mssqlIdent quotes name to be used as an identifier.
+func mssqlIdent(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
+
+// mssqlString quotes s to be used as a unicode string literal.
+func mssqlString(s string) string {
+	return "N'" + strings.Replace(s, "'", "''", -1) + "'"
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestMSSQLQuoting(t *testing.T) {
+	idents := []struct {
+		name string
+		want string
+	}{
+		{"lportal", "[lportal]"},
+		{"my db", "[my db]"},
+		{"evil]; DROP DATABASE master; --", "[evil]]; DROP DATABASE master; --]"},
+		{"]]", "[]]]]]"},
+	}
+
+	for _, tt := range idents {
+		if got := mssqlIdent(tt.name); got != tt.want {
+			t.Errorf("mssqlIdent(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	strs := []struct {
+		s    string
+		want string
+	}{
+		{"secret", "N'secret'"},
+		{"", "N''"},
+		{"it's", "N'it''s'"},
+		{"'; DROP LOGIN sa; --", "N'''; DROP LOGIN sa; --'"},
+		{"árvíztűrő", "N'árvíztűrő'"},
+	}
+
+	for _, tt := range strs {
+		if got := mssqlString(tt.s); got != tt.want {
+			t.Errorf("mssqlString(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMSSQLBackupFileTarget(t *testing.T) {
+	tests := []struct {
+		file mssqlBackupFile
+		dir  string
+		i    int
+		want string
+	}{
+		{mssqlBackupFile{physicalName: `C:\data\lportal.mdf`, fileType: "D"}, `C:\data\`, 0, `C:\data\mydb_0.mdf`},
+		{mssqlBackupFile{physicalName: `C:\data\lportal_log.ldf`, fileType: "L"}, `C:\data`, 1, `C:\data\mydb_1.ldf`},
+		{mssqlBackupFile{physicalName: `C:\data\extra.ndf`, fileType: "D"}, `C:\data`, 2, `C:\data\mydb_2.ndf`},
+		{mssqlBackupFile{physicalName: `C:\data\extra`, fileType: "D"}, `C:\data`, 2, `C:\data\mydb_2.ndf`},
+		{mssqlBackupFile{physicalName: `C:\data\lportal.dat`, fileType: "D"}, `C:\data`, 0, `C:\data\mydb_0.mdf`},
+		{mssqlBackupFile{physicalName: `C:\data\fulltext`, fileType: "F"}, `C:\data`, 3, `C:\data\mydb_3`},
+		{mssqlBackupFile{physicalName: "/var/opt/mssql/data/lportal.mdf", fileType: "D"}, "/var/opt/mssql/data/", 0, "/var/opt/mssql/data/mydb_0.mdf"},
+		{mssqlBackupFile{physicalName: `C:\data\lportal_log.ldf`, fileType: "L"}, "/var/opt/mssql/data", 1, "/var/opt/mssql/data/mydb_1.ldf"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.file.target(tt.dir, "mydb", tt.i); got != tt.want {
+			t.Errorf("target(%q, %q, %d) of %s = %q, want %q", tt.dir, "mydb", tt.i, tt.file.physicalName, got, tt.want)
+		}
+	}
+}
@@ -93,8 +93,7 @@ func (db *mysql) ListDatabase() ([]string, error) {
 			return nil, fmt.Errorf("reading row failed: %s", err.Error())
 		}
 
-		switch database {
-		case "information_schema", "performance_schema", "mysql", "nbinfo", "sys":
+		if isMySQLSystemDatabase(database) {
 			continue
 		}
 
@@ -328,6 +327,29 @@ func (db *mysql) Version() (string, error) {
 	return re.FindString(buf.String()), nil
 }
 
+// DatabaseSizes returns the size of the data and indexes of each database, based
+// on information_schema. Databases without tables are not listed.
+func (db *mysql) DatabaseSizes() (map[string]int64, error) {
+	err := db.Alive()
+	if err != nil {
+		return nil, fmt.Errorf("alive check failed: %s", err.Error())
+	}
+
+	return querySizes(db.conn, "SELECT table_schema, SUM(data_length + index_length) FROM information_schema.tables GROUP BY table_schema", isMySQLSystemDatabase)
+}
+
+// Connections returns the number of threads connected to the server.
+func (db *mysql) Connections() (int, error) {
+	var count int
+
+	err := db.conn.QueryRow("SELECT count(*) FROM information_schema.processlist").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("counting connections failed: %s", strip(err.Error()))
+	}
+
+	return count, nil
+}
+
 func (db *mysql) RequiredFields(dbreq model.DBRequest, reqType int) []string {
 	req := []string{dbreq.DatabaseName, dbreq.Username}
 
@@ -369,46 +391,49 @@ func (db *mysql) userExists(username string) (bool, error) {
 	return false, nil
 }
 
+// isMySQLSystemDatabase returns true for the databases that come with the server.
+func isMySQLSystemDatabase(name string) bool {
+	switch name {
+	case "information_schema", "performance_schema", "mysql", "nbinfo", "sys":
+		return true
+	}
+
+	return false
+}
+
 func strip(test string) string {
 	return strings.TrimSuffix(test, "\n")
 }
 
+// ValidateDump rewrites the dump using the rules configured for the agent,
+// see mysqlRewriter.
 func (db *mysql) ValidateDump(path string) (string, error) {
-	toRemove := []string{"create database", "drop database", "/*!50013 definer=", "use ",
-		"CREATE DATABASE", "DROP DATABASE", "/*!50013 DEFINER=", "USE "}
-
 	file, err := os.Open(path)
 	if err != nil {
 		return "", fmt.Errorf("could not open dumpfile '%s': %s", path, err.Error())
 	}
 	defer file.Close()
 
-	lines, err := sutils.OccursWith(strings.HasPrefix, file, toRemove)
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "ddnc")
 	if err != nil {
-		return path, fmt.Errorf("couldn't find occurrences: %v", err)
+		return path, fmt.Errorf("could not create tempfile: %s", err.Error())
 	}
+	defer tmpFile.Close()
 
-	// Rewind file
-	file.Seek(0, 0)
-
-	if len(lines) > 0 {
-		tmpFile, err := ioutil.TempFile(os.TempDir(), "ddnc")
-		if err != nil {
-			return path, fmt.Errorf("could not create tempfile: %s", err.Error())
-		}
-
-		err = sutils.CopyWithoutLines(file, lines, tmpFile)
-		if err != nil {
-			return path, fmt.Errorf("removing extra lines from dump failed: %s", err.Error())
-		}
+	err = newMySQLRewriter().rewrite(file, tmpFile)
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return path, fmt.Errorf("rewriting dump failed: %s", err.Error())
+	}
 
-		tmpFilePath, _ := filepath.Abs(tmpFile.Name())
+	file.Close()
+	tmpFile.Close()
 
-		os.Rename(tmpFilePath, path)
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return path, fmt.Errorf("replacing dump failed: %s", err.Error())
 	}
 
-	// Rewind file
-	file.Seek(0, 0)
-
 	return path, nil
 }
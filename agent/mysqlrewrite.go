@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultMySQLCollations maps the collations introduced in MySQL 8 to ones
+// understood by 5.x servers.
+var defaultMySQLCollations = map[string]string{
+	"utf8mb4_0900_ai_ci": "utf8mb4_unicode_ci",
+	"utf8mb4_0900_as_ci": "utf8mb4_unicode_ci",
+	"utf8mb4_0900_as_cs": "utf8mb4_bin",
+	"utf8mb4_0900_bin":   "utf8mb4_bin",
+}
+
+// Quoted or bare MySQL account name parts, e.g. `root`, 'root' or root
+const mysqlAccountPart = "`(?:[^`]|``)*`" + `|'(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*"|[\w.$%-]+`
+
+var (
+	mysqlDefiner  = regexp.MustCompile(`(?i)\s*\bDEFINER\s*=\s*(?:CURRENT_USER(?:\s*\(\s*\))?|(?:` + mysqlAccountPart + `)(?:\s*@\s*(?:` + mysqlAccountPart + `))?)`)
+	mysqlEngine   = regexp.MustCompile(`(?i)(\bENGINE\s*=\s*)(\w+)`)
+	mysqlDropped  = regexp.MustCompile(`(?is)^(?:/\*!\d*\s*)?(?:(?:CREATE|DROP)\s+(?:DATABASE|SCHEMA)\b|USE\s)`)
+	mysqlDataStmt = regexp.MustCompile(`(?is)^(?:INSERT|REPLACE)\s`)
+)
+
+// mysqlRewriter rewrites MySQL dumps statement by statement so that they can be
+// imported into the agent's server: statements switching or creating databases
+// are dropped, and DEFINER clauses, collations and storage engines in the DDL
+// are rewritten according to the agent's configuration.
+type mysqlRewriter struct {
+	// definer is either "strip", "keep", or what DEFINER should be set to
+	definer    string
+	collations map[string]string
+	engines    map[string]string
+
+	collation *regexp.Regexp
+}
+
+// newMySQLRewriter returns a mysqlRewriter configured from conf.
+func newMySQLRewriter() *mysqlRewriter {
+	rw := &mysqlRewriter{
+		definer:    conf.MySQLDefiner,
+		collations: make(map[string]string),
+		engines:    make(map[string]string),
+	}
+
+	if rw.definer == "" {
+		rw.definer = "strip"
+	}
+
+	collations := conf.MySQLCollations
+	if collations == nil {
+		collations = defaultMySQLCollations
+	}
+
+	var names []string
+	for from, to := range collations {
+		rw.collations[strings.ToLower(from)] = to
+		names = append(names, regexp.QuoteMeta(from))
+	}
+
+	for from, to := range conf.MySQLEngines {
+		rw.engines[strings.ToLower(from)] = to
+	}
+
+	if len(names) > 0 {
+		// Longest first, so that prefixes of other names don't match early
+		sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+		rw.collation = regexp.MustCompile(`(?i)\b(` + strings.Join(names, "|") + `)\b`)
+	}
+
+	return rw
+}
+
+// rewrite copies the dump from src to dst, rewriting it one statement at a time.
+func (rw *mysqlRewriter) rewrite(src io.Reader, dst io.Writer) error {
+	w := bufio.NewWriter(dst)
+
+	err := splitMySQLStatements(src, func(stmt string) error {
+		_, err := w.WriteString(rw.statement(stmt))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// statement returns the rewritten form of stmt. Leading comments are kept even
+// if the statement itself is dropped.
+func (rw *mysqlRewriter) statement(stmt string) string {
+	n := mysqlCommentPrefix(stmt)
+	prefix, body := stmt[:n], stmt[n:]
+
+	if mysqlDropped.MatchString(body) {
+		return prefix
+	}
+
+	// Data does not need rewriting, and could contain anything in its strings.
+	if mysqlDataStmt.MatchString(body) {
+		return stmt
+	}
+
+	switch rw.definer {
+	case "keep":
+	case "strip":
+		body = mysqlDefiner.ReplaceAllString(body, "")
+	default:
+		body = mysqlDefiner.ReplaceAllLiteralString(body, " DEFINER="+rw.definer)
+	}
+
+	if rw.collation != nil {
+		body = rw.collation.ReplaceAllStringFunc(body, func(name string) string {
+			return rw.collations[strings.ToLower(name)]
+		})
+	}
+
+	if len(rw.engines) > 0 {
+		body = mysqlEngine.ReplaceAllStringFunc(body, func(match string) string {
+			p := mysqlEngine.FindStringSubmatch(match)
+
+			if to, ok := rw.engines[strings.ToLower(p[2])]; ok {
+				return p[1] + to
+			}
+
+			return match
+		})
+	}
+
+	return prefix + body
+}
+
+// mysqlCommentPrefix returns the length of the whitespace and comments that
+// precede the statement. Conditional comments (/*! ... */) are part of the statement.
+func mysqlCommentPrefix(stmt string) int {
+	i := 0
+	for i < len(stmt) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(stmt[i])):
+			i++
+		case stmt[i] == '#' || isMySQLDashComment(stmt[i:]):
+			end := strings.IndexByte(stmt[i:], '\n')
+			if end == -1 {
+				return len(stmt)
+			}
+			i += end + 1
+		case strings.HasPrefix(stmt[i:], "/*") && !strings.HasPrefix(stmt[i:], "/*!"):
+			end := strings.Index(stmt[i+2:], "*/")
+			if end == -1 {
+				return len(stmt)
+			}
+			i += end + 4
+		default:
+			return i
+		}
+	}
+
+	return i
+}
+
+// isMySQLDashComment returns whether s starts with a "-- " comment. MySQL needs
+// the dashes to be followed by whitespace or the end of the line.
+func isMySQLDashComment(s string) bool {
+	if !strings.HasPrefix(s, "--") {
+		return false
+	}
+
+	return len(s) == 2 || strings.ContainsRune(" \t\r\n", rune(s[2]))
+}
+
+// States of the statement splitter
+const (
+	inCode = iota
+	inSingleQuote
+	inDoubleQuote
+	inBacktick
+	inLineComment
+	inBlockComment
+)
+
+// splitMySQLStatements reads SQL from src and calls fn with each statement,
+// including the comments and whitespace before it and its delimiter. Delimiters
+// inside strings, identifiers and comments are ignored, and DELIMITER commands
+// are followed. Concatenating the statements gives back the original input.
+func splitMySQLStatements(src io.Reader, fn func(string) error) error {
+	r := bufio.NewReader(src)
+
+	var stmt bytes.Buffer
+	delim := ";"
+	state := inCode
+	escaped := false
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		// DELIMITER is a client command, so it can only be the first thing
+		// on a line, outside of any statement.
+		if state == inCode && mysqlCommentPrefix(stmt.String()) == stmt.Len() {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && strings.EqualFold(fields[0], "DELIMITER") {
+				delim = fields[1]
+
+				stmt.WriteString(line)
+				if ferr := fn(stmt.String()); ferr != nil {
+					return ferr
+				}
+				stmt.Reset()
+
+				line = ""
+			}
+		}
+
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+
+			switch state {
+			case inCode:
+				switch {
+				case strings.HasPrefix(line[i:], delim):
+					stmt.WriteString(delim)
+					i += len(delim) - 1
+
+					if ferr := fn(stmt.String()); ferr != nil {
+						return ferr
+					}
+					stmt.Reset()
+
+					continue
+				case c == '\'':
+					state = inSingleQuote
+				case c == '"':
+					state = inDoubleQuote
+				case c == '`':
+					state = inBacktick
+				case c == '#' || isMySQLDashComment(line[i:]):
+					state = inLineComment
+				case strings.HasPrefix(line[i:], "/*"):
+					state = inBlockComment
+					stmt.WriteString("/*")
+					i++
+
+					continue
+				}
+			case inSingleQuote, inDoubleQuote:
+				quote := byte('\'')
+				if state == inDoubleQuote {
+					quote = '"'
+				}
+
+				switch {
+				case escaped:
+					escaped = false
+				case c == '\\':
+					escaped = true
+				case c == quote:
+					state = inCode
+				}
+			case inBacktick:
+				if c == '`' {
+					state = inCode
+				}
+			case inLineComment:
+				if c == '\n' {
+					state = inCode
+				}
+			case inBlockComment:
+				if strings.HasPrefix(line[i:], "*/") {
+					state = inCode
+					stmt.WriteString("*/")
+					i++
+
+					continue
+				}
+			}
+
+			stmt.WriteByte(c)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if stmt.Len() > 0 {
+		return fn(stmt.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSplitMySQLStatements(t *testing.T) {
+	dump := strings.Join([]string{
+		"-- comment; with a semicolon",
+		"INSERT INTO `a;b` VALUES ('x;y','it\\'s;', \"q;\");",
+		"/* block; comment */ SELECT 1;",
+		"DELIMITER ;;",
+		"CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET @x = 1; END ;;",
+		"DELIMITER ;",
+		"SELECT 2;",
+		"",
+	}, "\n")
+
+	var stmts []string
+	err := splitMySQLStatements(strings.NewReader(dump), func(stmt string) error {
+		stmts = append(stmts, stmt)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("splitMySQLStatements failed: %v", err)
+	}
+
+	if got := strings.Join(stmts, ""); got != dump {
+		t.Errorf("statements do not add up to the input:\n%s", got)
+	}
+
+	want := []string{
+		"SELECT 1;",
+		"DELIMITER ;;\n",
+		"END ;;",
+		"DELIMITER ;\n",
+		"SELECT 2;",
+		"\n",
+	}
+
+	if len(stmts) != 7 {
+		t.Fatalf("expected 7 statements, got %d: %q", len(stmts), stmts)
+	}
+
+	for i, w := range want {
+		if !strings.HasSuffix(stmts[i+1], w) {
+			t.Errorf("statement %d = %q, expected it to end with %q", i+1, stmts[i+1], w)
+		}
+	}
+}
+
+func TestMySQLRewriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		definer string
+		engines map[string]string
+		in      string
+		want    string
+	}{
+		{
+			name: "database statements dropped",
+			in:   "-- Current Database: `x`\nCREATE DATABASE /*!32312 IF NOT EXISTS*/ `x`;\n/*!40000 DROP DATABASE IF EXISTS `x`*/;\nUSE `x`;\n",
+			want: "-- Current Database: `x`\n\n\n\n",
+		},
+		{
+			name: "definer stripped",
+			in:   "/*!50003 CREATE*/ /*!50017 DEFINER=`root`@`%`*/ /*!50003 TRIGGER t BEFORE INSERT ON a FOR EACH ROW SET @x = 1 */;\nCREATE DEFINER='admin'@'localhost' PROCEDURE p() SQL SECURITY DEFINER SELECT 1;\n",
+			want: "/*!50003 CREATE*/ /*!50017*/ /*!50003 TRIGGER t BEFORE INSERT ON a FOR EACH ROW SET @x = 1 */;\nCREATE PROCEDURE p() SQL SECURITY DEFINER SELECT 1;\n",
+		},
+		{
+			name:    "definer replaced",
+			definer: "CURRENT_USER",
+			in:      "/*!50013 DEFINER=`root`@`localhost` SQL SECURITY DEFINER */\n/*!50001 VIEW `v` AS select 1 */;\n",
+			want:    "/*!50013 DEFINER=CURRENT_USER SQL SECURITY DEFINER */\n/*!50001 VIEW `v` AS select 1 */;\n",
+		},
+		{
+			name:    "definer kept",
+			definer: "keep",
+			in:      "CREATE DEFINER=`root`@`%` VIEW v AS SELECT 1;\n",
+			want:    "CREATE DEFINER=`root`@`%` VIEW v AS SELECT 1;\n",
+		},
+		{
+			name:    "collations and engines mapped",
+			engines: map[string]string{"myisam": "InnoDB"},
+			in:      "CREATE TABLE `a` (\n  `b` varchar(10) COLLATE utf8mb4_0900_ai_ci\n) ENGINE=MyISAM DEFAULT CHARSET=utf8mb4 COLLATE=UTF8MB4_0900_AI_CI;\n",
+			want:    "CREATE TABLE `a` (\n  `b` varchar(10) COLLATE utf8mb4_unicode_ci\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;\n",
+		},
+		{
+			name: "data untouched",
+			in:   "INSERT INTO `a` VALUES ('DEFINER=`root`@`%` utf8mb4_0900_ai_ci');\n",
+			want: "INSERT INTO `a` VALUES ('DEFINER=`root`@`%` utf8mb4_0900_ai_ci');\n",
+		},
+	}
+
+	defer func(old Config) { conf = old }(conf)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf = Config{MySQLDefiner: tt.definer, MySQLEngines: tt.engines}
+
+			var out bytes.Buffer
+			if err := newMySQLRewriter().rewrite(strings.NewReader(tt.in), &out); err != nil {
+				t.Fatalf("rewrite failed: %v", err)
+			}
+
+			if out.String() != tt.want {
+				t.Errorf("unexpected result:\n%q\nwant:\n%q", out.String(), tt.want)
+			}
+		})
+	}
+}
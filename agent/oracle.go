@@ -1,23 +1,67 @@
 package main
 
 import (
+	"bufio"
 	"database/sql"
 	"fmt"
+	"io"
+	"net/url"
+	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/djavorszky/ddn/common/logger"
 	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/sutils"
+
+	_ "github.com/sijms/go-ora/v2"
 )
 
+// dataPumpPollInterval is how often the state of running Data Pump jobs is checked.
+var dataPumpPollInterval = 5 * time.Second
+
+// Number of lines shown from the end of the Data Pump log if a job fails
+const dataPumpLogTail = 20
+
+// Schema names are used as identifiers in statements, so only plain ones are accepted.
+var oracleIdent = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_$#]{0,29}$`)
+
 type oracle struct {
 	conn *sql.DB
 }
 
 func (db *oracle) Connect(c Config) error {
+	var err error
+
+	if ok := sutils.Present(c.User, c.Password, c.LocalDBAddr, c.LocalDBPort, c.SID); !ok {
+		return fmt.Errorf("missing parameters. Need-Got: {user: %s}, {password: %s}, {dbAddress: %s}, {dbPort: %s}, {sid: %s}", c.User, c.Password, c.LocalDBAddr, c.LocalDBPort, c.SID)
+	}
+
+	query := url.Values{}
+	query.Add("SID", c.SID)
+
+	datasource := &url.URL{
+		Scheme:   "oracle",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     fmt.Sprintf("%s:%s", c.LocalDBAddr, c.LocalDBPort),
+		RawQuery: query.Encode(),
+	}
+
+	db.conn, err = sql.Open("oracle", datasource.String())
+	if err != nil {
+		return fmt.Errorf("creating connection pool failed: %s", err.Error())
+	}
+
+	err = db.conn.Ping()
+	if err != nil {
+		db.conn.Close()
+		return fmt.Errorf("database ping failed: %s", err.Error())
+	}
+
 	return nil
 }
 
@@ -26,61 +70,117 @@ func (db *oracle) Close() {
 }
 
 func (db *oracle) Alive() error {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error("Panic Attack! Database seems to be down.")
+		}
+	}()
+
+	_, err := db.conn.Exec("SELECT 1 FROM dual")
+	if err != nil {
+		return fmt.Errorf("executing stayalive query failed: %s", err.Error())
+	}
+
 	return nil
 }
 
+// CreateDatabase creates a schema along with a tablespace of the same name.
 func (db *oracle) CreateDatabase(dbRequest model.DBRequest) error {
 	err := db.Alive()
 	if err != nil {
 		return fmt.Errorf("alive check failed: %s", err.Error())
 	}
 
-	args := []string{
-		"-L",
-		"-S",
-		fmt.Sprintf("%s/%s", conf.User, conf.Password),
-		"@./sql/oracle/create_schema.sql",
-		dbRequest.Username,
-		dbRequest.Password,
-		conf.DatafileDir,
+	if !oracleIdent.MatchString(dbRequest.Username) {
+		return fmt.Errorf("invalid schema name %q", dbRequest.Username)
 	}
 
-	res := RunCommand(conf.Exec, args...)
+	if strings.EqualFold(dbRequest.Username, conf.User) {
+		return fmt.Errorf("trying to create admin schema not allowed")
+	}
+
+	err = checkOraclePassword(dbRequest.Password)
+	if err != nil {
+		return err
+	}
+
+	schema := strings.ToUpper(dbRequest.Username)
 
-	if res.exitCode == 1920 {
+	exists, err := db.schemaExists(schema)
+	if err != nil {
+		return fmt.Errorf("checking if schema exists failed: %s", err.Error())
+	}
+	if exists {
 		return fmt.Errorf("user/schema %s already exists", dbRequest.Username)
 	}
 
-	if res.exitCode != 0 {
-		return fmt.Errorf("unable to create database: %v", res)
+	statements := []string{
+		fmt.Sprintf("CREATE SMALLFILE TABLESPACE %s DATAFILE '%s%s_01.dbf' SIZE 32M AUTOEXTEND ON MAXSIZE UNLIMITED", schema, conf.DatafileDir, schema),
+		fmt.Sprintf("ALTER TABLESPACE %s ADD DATAFILE '%s%s_02.dbf' SIZE 1M AUTOEXTEND ON MAXSIZE UNLIMITED", schema, conf.DatafileDir, schema),
+		fmt.Sprintf(`CREATE USER %s IDENTIFIED BY "%s" DEFAULT TABLESPACE %s QUOTA UNLIMITED ON %s`, schema, dbRequest.Password, schema, schema),
+		fmt.Sprintf("GRANT CONNECT, RESOURCE TO %s", schema),
+	}
+
+	for _, stmt := range statements {
+		_, err = db.conn.Exec(stmt)
+		if err != nil {
+			db.DropDatabase(dbRequest)
+			return fmt.Errorf("creating schema failed: %s", err.Error())
+		}
 	}
 
 	return nil
 }
 
+// checkOraclePassword returns an error if the password can't be used as is in
+// the quoted IDENTIFIED BY clause.
+func checkOraclePassword(pass string) error {
+	if strings.Contains(pass, `"`) {
+		return fmt.Errorf("password can't contain double quotes")
+	}
+
+	return nil
+}
+
+// DropDatabase drops the schema and its tablespace. Always succeeds, even if
+// droppable schema or tablespace does not exist
 func (db *oracle) DropDatabase(dbRequest model.DBRequest) error {
-	args := []string{
-		"-L",
-		"-S",
-		fmt.Sprintf("%s/%s", conf.User, conf.Password),
-		"@./sql/oracle/drop_schema.sql",
-		dbRequest.Username,
+	err := db.Alive()
+	if err != nil {
+		return fmt.Errorf("alive check failed: %s", err.Error())
 	}
 
-	res := RunCommand(conf.Exec, args...)
+	if !oracleIdent.MatchString(dbRequest.Username) {
+		return fmt.Errorf("invalid schema name %q", dbRequest.Username)
+	}
 
-	if res.exitCode == 1918 { // ORA-01918: user xxx does not exist ---> return with success
-		return nil
+	if strings.EqualFold(dbRequest.Username, conf.User) {
+		return fmt.Errorf("dropping admin schema not allowed")
 	}
 
-	if res.exitCode != 0 {
-		return fmt.Errorf("unable to drop database: %v", res)
+	schema := strings.ToUpper(dbRequest.Username)
+
+	_, err = db.conn.Exec(fmt.Sprintf("DROP USER %s CASCADE", schema))
+	if err != nil && !strings.Contains(err.Error(), "ORA-01918") { // ORA-01918: user does not exist
+		return fmt.Errorf("dropping schema failed: %s", err.Error())
+	}
+
+	_, err = db.conn.Exec(fmt.Sprintf("DROP TABLESPACE %s INCLUDING CONTENTS AND DATAFILES", schema))
+	if err != nil && !strings.Contains(err.Error(), "ORA-00959") { // ORA-00959: tablespace does not exist
+		return fmt.Errorf("dropping tablespace failed: %s", err.Error())
 	}
 
 	return nil
 }
 
 func (db *oracle) ImportDatabase(dbRequest model.DBRequest) error {
+	return db.ImportDatabaseProgress(dbRequest, func(string) {})
+}
+
+// ImportDatabaseProgress imports the dump using the import_dump stored procedure, while
+// reporting the state of its Data Pump job. If the import fails, the end of the job's
+// log is returned as part of the error.
+func (db *oracle) ImportDatabaseProgress(dbRequest model.DBRequest, progress func(msg string)) error {
 	dumpDir, fileName := filepath.Split(dbRequest.DumpLocation)
 
 	args := []string{
@@ -95,54 +195,130 @@ func (db *oracle) ImportDatabase(dbRequest model.DBRequest) error {
 		conf.DatafileDir,
 	}
 
+	// The procedure names the job and its log after the schema
+	job := strings.ToUpper(dbRequest.Username)
+	logFile := filepath.Join(dumpDir, job+".LOG")
+
+	stop := watchDataPump(db.dataPumpState, job, logFile, progress)
+
 	res := RunCommand(conf.Exec, args...)
+	stop()
 
 	if res.exitCode != 0 {
-		return fmt.Errorf("dump import seems to have failed: %v", res)
+		return fmt.Errorf("dump import seems to have failed: %v%s", res, dataPumpLog(logFile))
 	}
 
 	return nil
 }
 
 func (db *oracle) ExportDatabase(dbRequest model.DBRequest) (string, error) {
+	return db.ExportDatabaseProgress(dbRequest, func(string) {})
+}
+
+// ExportDatabaseProgress exports the schema with expdp, reporting the state of the
+// Data Pump job while it runs.
+func (db *oracle) ExportDatabaseProgress(dbRequest model.DBRequest, progress func(msg string)) (string, error) {
 	fullDumpFilename := fmt.Sprintf("%s_%s.dmp", dbRequest.DatabaseName, time.Now().Format("20060102150405"))
+	logFilename := fmt.Sprintf("%s.log", strings.TrimSuffix(fullDumpFilename, path.Ext(fullDumpFilename)))
+
+	// Job names can be at most 30 characters long
+	job := strings.ToUpper("EXP_" + dbRequest.DatabaseName)
+	if len(job) > 30 {
+		job = job[:30]
+	}
+
 	// Start the export
 	args := []string{
 		fmt.Sprintf("%s/%s", conf.User, conf.Password),
 		fmt.Sprintf("schemas=%s", dbRequest.DatabaseName),
 		"directory=EXP_DIR",
 		fmt.Sprintf("dumpfile=%s", fullDumpFilename),
-		fmt.Sprintf("logfile=%s.log", strings.TrimSuffix(fullDumpFilename, path.Ext(fullDumpFilename))),
+		fmt.Sprintf("logfile=%s", logFilename),
+		fmt.Sprintf("job_name=%s", job),
 	}
 
+	logFile := filepath.Join(workdir, "exports", logFilename)
+
+	stop := watchDataPump(db.dataPumpState, job, logFile, progress)
+
 	res := RunCommand("expdp", args...)
+	stop()
 
 	if res.exitCode != 0 {
-		return "", fmt.Errorf("schema export seems to have failed: %v", res)
+		return "", fmt.Errorf("schema export seems to have failed: %v%s", res, dataPumpLog(logFile))
 	}
 
 	return fullDumpFilename, nil
 }
 
+// ListDatabase returns the schemas created by the agent, which are the ones that
+// have a default tablespace of the same name.
 func (db *oracle) ListDatabase() ([]string, error) {
-	return nil, nil
+	err := db.Alive()
+	if err != nil {
+		return nil, fmt.Errorf("alive check failed: %s", err.Error())
+	}
+
+	rows, err := db.conn.Query("SELECT username FROM dba_users WHERE default_tablespace = username ORDER BY username")
+	if err != nil {
+		return nil, fmt.Errorf("listing schemas failed: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var list []string
+	for rows.Next() {
+		var schema string
+
+		err = rows.Scan(&schema)
+		if err != nil {
+			return nil, fmt.Errorf("scanning schema rows failed: %s", err.Error())
+		}
+
+		list = append(list, schema)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error encountered when reading rows: %s", err.Error())
+	}
+
+	return list, nil
 }
 
 func (db *oracle) Version() (string, error) {
-	args := []string{
-		"-L",
-		"-S",
-		fmt.Sprintf("%s/%s", conf.User, conf.Password),
-		"@./sql/oracle/get_db_version.sql",
+	var version string
+
+	err := db.conn.QueryRow("SELECT version FROM v$instance").Scan(&version)
+	if err != nil {
+		return "", fmt.Errorf("unable to get Oracle version: %s", err.Error())
 	}
 
-	res := RunCommand(conf.Exec, args...)
+	return strings.TrimSpace(version), nil
+}
 
-	if res.exitCode != 0 {
-		return "", fmt.Errorf("unable to get Oracle version: %v", res)
+// DatabaseSizes returns the size of the segments owned by each schema created by
+// the agent.
+func (db *oracle) DatabaseSizes() (map[string]int64, error) {
+	err := db.Alive()
+	if err != nil {
+		return nil, fmt.Errorf("alive check failed: %s", err.Error())
 	}
 
-	return strings.TrimSpace(res.stdout), nil
+	return querySizes(db.conn, `SELECT u.username, SUM(s.bytes) FROM dba_users u
+		LEFT JOIN dba_segments s ON s.owner = u.username
+		WHERE u.default_tablespace = u.username GROUP BY u.username`, nil)
+}
+
+// Connections returns the number of user sessions on the instance.
+func (db *oracle) Connections() (int, error) {
+	var count int
+
+	err := db.conn.QueryRow("SELECT count(*) FROM v$session WHERE type = 'USER'").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("counting sessions failed: %s", err.Error())
+	}
+
+	return count, nil
 }
 
 func (db *oracle) RequiredFields(dbreq model.DBRequest, reqType int) []string {
@@ -174,9 +350,10 @@ func (db *oracle) RefreshImportStoredProcedure() error {
 
 	if res.exitCode != 0 {
 		logger.Error("Missing grants from SYS perhaps?")
-		logger.Error("sgrant select on dba_datapump_jobs to %s;", conf.User)
-		logger.Error("%sgrant create any directory to %s;\n", conf.User)
-		logger.Error("%sgrant create external job to %s;\n", conf.User)
+		logger.Error("grant select on dba_datapump_jobs to %s;", conf.User)
+		logger.Error("grant select on v_$session_longops to %s;", conf.User)
+		logger.Error("grant create any directory to %s;", conf.User)
+		logger.Error("grant create external job to %s;", conf.User)
 
 		return fmt.Errorf("creating import procedure failed: %v", res)
 	}
@@ -185,13 +362,168 @@ func (db *oracle) RefreshImportStoredProcedure() error {
 }
 
 func (db *oracle) CreateExpDir(expDirPath string) error {
-	args := []string{"-L", "-S", fmt.Sprintf("%s/%s", conf.User, conf.Password), "@./sql/oracle/create_exp_dir.sql", expDirPath}
+	_, err := db.conn.Exec(fmt.Sprintf("CREATE OR REPLACE DIRECTORY EXP_DIR AS '%s'", strings.Replace(expDirPath, "'", "''", -1)))
+	if err != nil {
+		return fmt.Errorf("creating EXP_DIR directory failed: %s", err.Error())
+	}
 
-	res := RunCommand(conf.Exec, args...)
+	return nil
+}
 
-	if res.exitCode != 0 {
-		return fmt.Errorf("creating EXP_DIR directory failed: %v", res)
+func (db *oracle) schemaExists(schema string) (bool, error) {
+	var count int
+
+	err := db.conn.QueryRow("SELECT count(*) FROM dba_users WHERE username = :1", schema).Scan(&count)
+	if err != nil {
+		return true, fmt.Errorf("executing query failed: %s", err.Error())
 	}
 
-	return nil
+	return count != 0, nil
+}
+
+// watchDataPump reports the state of the Data Pump job, as returned by state, and
+// the errors written to its log through progress. The returned function stops the
+// watch, and only returns once progress is not going to be called anymore.
+func watchDataPump(state func(job string) (string, error), job, logFile string, progress func(msg string)) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(dataPumpPollInterval)
+		defer ticker.Stop()
+
+		var (
+			last   string
+			offset int64
+		)
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			msg, err := state(job)
+			if err != nil {
+				logger.Warn("could not check state of Data Pump job %s: %v", job, err)
+			}
+
+			// The job may have ended while its state was queried
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if msg != "" && msg != last {
+				progress(msg)
+				last = msg
+			}
+
+			var errs []string
+			errs, offset = dataPumpErrors(logFile, offset)
+
+			for _, e := range errs {
+				progress("Data Pump: " + e)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// dataPumpState returns the state of the Data Pump job owned by the agent's user,
+// along with its progress if known. Returns an empty string if there's no such job.
+func (db *oracle) dataPumpState(job string) (string, error) {
+	var (
+		operation, state string
+		sofar, total     sql.NullFloat64
+	)
+
+	err := db.conn.QueryRow(`SELECT j.operation, j.state, l.sofar, l.totalwork
+		FROM dba_datapump_jobs j
+		LEFT JOIN (SELECT opname, MAX(sofar) sofar, MAX(totalwork) totalwork FROM v$session_longops GROUP BY opname) l ON l.opname = j.job_name
+		WHERE j.owner_name = :1 AND j.job_name = :2`, strings.ToUpper(conf.User), job).Scan(&operation, &state, &sofar, &total)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	msg := fmt.Sprintf("%s %s", strings.Title(strings.ToLower(operation)), strings.ToLower(state))
+
+	if total.Valid && total.Float64 > 0 {
+		msg = fmt.Sprintf("%s (%d%%)", msg, int(sofar.Float64*100/total.Float64))
+	}
+
+	return msg, nil
+}
+
+// dataPumpErrors returns the ORA- errors written to the log since offset, and the
+// offset to continue reading from next time.
+func dataPumpErrors(logFile string, offset int64) ([]string, int64) {
+	file, err := os.Open(logFile)
+	if err != nil {
+		return nil, offset
+	}
+	defer file.Close()
+
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, offset
+	}
+
+	var errs []string
+
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadString('\n')
+
+		// Only complete lines are processed, the rest is read next time
+		if err != nil {
+			break
+		}
+
+		offset += int64(len(line))
+
+		if strings.Contains(line, "ORA-") {
+			errs = append(errs, strings.TrimSpace(line))
+		}
+	}
+
+	return errs, offset
+}
+
+// dataPumpLog returns the last lines of the Data Pump log, to be appended to
+// error messages. Returns an empty string if the log could not be read.
+func dataPumpLog(logFile string) string {
+	file, err := os.Open(logFile)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	var lines []string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+
+		if len(lines) > dataPumpLogTail {
+			lines = lines[1:]
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("\nData Pump log (%s):\n%s", filepath.Base(logFile), strings.Join(lines, "\n"))
 }
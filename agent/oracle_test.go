@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDataPumpErrors(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ddnc-test")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	logFile := filepath.Join(tmp, "SCHEMA.LOG")
+
+	errs, offset := dataPumpErrors(logFile, 0)
+	if len(errs) != 0 || offset != 0 {
+		t.Fatalf("missing log: got %v, %d", errs, offset)
+	}
+
+	ioutil.WriteFile(logFile, []byte("Starting job\nORA-39083: Object type failed\nProcessing\nORA-0000"), 0644)
+
+	errs, offset = dataPumpErrors(logFile, 0)
+	if len(errs) != 1 || errs[0] != "ORA-39083: Object type failed" {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	// The incomplete last line is left for the next read
+	f, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("1: table exists\n")
+	f.Close()
+
+	errs, _ = dataPumpErrors(logFile, offset)
+	if len(errs) != 1 || errs[0] != "ORA-00001: table exists" {
+		t.Errorf("unexpected errors after append: %v", errs)
+	}
+
+	tail := dataPumpLog(logFile)
+	if !strings.Contains(tail, "SCHEMA.LOG") || !strings.HasSuffix(tail, "ORA-00001: table exists") {
+		t.Errorf("unexpected log tail: %q", tail)
+	}
+}
+
+func TestWatchDataPumpStop(t *testing.T) {
+	defer func(old time.Duration) { dataPumpPollInterval = old }(dataPumpPollInterval)
+	dataPumpPollInterval = time.Millisecond
+
+	var stopped int32
+
+	querying := make(chan struct{}, 1)
+
+	// The query of the state is still running when the watch is stopped
+	slowState := func(job string) (string, error) {
+		select {
+		case querying <- struct{}{}:
+		default:
+		}
+
+		time.Sleep(50 * time.Millisecond)
+
+		return "Import executing", nil
+	}
+
+	stop := watchDataPump(slowState, "SCHEMA", "", func(msg string) {
+		if atomic.LoadInt32(&stopped) == 1 {
+			t.Errorf("progress %q reported after the watch was stopped", msg)
+		}
+	})
+
+	<-querying
+	stop()
+	atomic.StoreInt32(&stopped, 1)
+
+	// Give a watcher that is still running the time to report
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestCheckOraclePassword(t *testing.T) {
+	for _, pass := range []string{"secret", "pa'ss", "p@ss word"} {
+		if err := checkOraclePassword(pass); err != nil {
+			t.Errorf("expected %q to be accepted, got %v", pass, err)
+		}
+	}
+
+	if err := checkOraclePassword(`pa"ss`); err == nil {
+		t.Errorf("expected a password with double quotes to be rejected")
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"database/sql"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -200,33 +202,146 @@ func (db *postgres) DropDatabase(dbRequest model.DBRequest) error {
 }
 
 // ImportDatabase imports the dumpfile to the database or returns an error
-// if it failed for some reason.
+// if it failed for some reason. Roles referenced by the dump are remapped to
+// the database's user, see pgRoleMapper.
 func (db *postgres) ImportDatabase(dbreq model.DBRequest) error {
-	userArg := fmt.Sprintf("-U%s", dbreq.Username)
+	format, err := pgDumpFormat(dbreq.DumpLocation)
+	if err != nil {
+		db.DropDatabase(dbreq)
+		return fmt.Errorf("could not determine dump format: %s", err.Error())
+	}
 
-	cmd := exec.Command(conf.Exec, userArg, dbreq.DatabaseName)
+	mapper, err := db.newRoleMapper(dbreq.Username)
+	if err != nil {
+		db.DropDatabase(dbreq)
+		return fmt.Errorf("could not prepare role remapping: %s", err.Error())
+	}
+
+	if format != pgPlain {
+		err = db.restoreAsScript(dbreq, format, mapper)
+	} else {
+		err = db.importPlain(dbreq, mapper)
+	}
 
-	file, err := os.Open(dbreq.DumpLocation)
 	if err != nil {
 		db.DropDatabase(dbreq)
+		return err
+	}
+
+	return nil
+}
+
+// importPlain feeds a plain SQL dump to psql, remapping the roles on the way.
+func (db *postgres) importPlain(dbreq model.DBRequest, mapper *pgRoleMapper) error {
+	file, err := os.Open(dbreq.DumpLocation)
+	if err != nil {
 		return fmt.Errorf("could not open dumpfile '%s': %s", dbreq.DumpLocation, err.Error())
 	}
 	defer file.Close()
 
-	cmd.Stdin = file
+	return db.psql(dbreq, file, mapper)
+}
+
+// restoreAsScript has pg_restore convert a custom, directory or tar format dump
+// to SQL, which is then fed to psql with its roles remapped, same as plain dumps
+// are. Restoring the dump with pg_restore directly would either keep the roles
+// as they are, or drop the ownership and privileges altogether.
+func (db *postgres) restoreAsScript(dbreq model.DBRequest, format int, mapper *pgRoleMapper) error {
+	// Without --dbname, pg_restore writes the script to stdout
+	cmd := exec.Command(pgRestoreExec(), fmt.Sprintf("--format=%s", pgFormatFlags[format]), dbreq.DumpLocation)
 
 	var errBuf bytes.Buffer
 	cmd.Stderr = &errBuf
 
-	err = cmd.Run()
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("could not read pg_restore output: %s", err.Error())
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("could not start pg_restore command: %s", err.Error())
+	}
+
+	importErr := db.psql(dbreq, out, mapper)
+
+	// Drain whatever psql did not read so that pg_restore can exit
+	io.Copy(ioutil.Discard, out)
+
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("could not execute pg_restore command: %s", strip(errBuf.String()))
+	}
+
+	return importErr
+}
+
+// psql runs the SQL read from src against the database, passing each line
+// through the role mapper first.
+func (db *postgres) psql(dbreq model.DBRequest, src io.Reader, mapper *pgRoleMapper) error {
+	// Changing ownership to roles other than the database's own user needs
+	// the privileges of the agent's user.
+	user := dbreq.Username
+	if conf.PgKeepRoles {
+		user = conf.User
+	}
+
+	cmd := exec.Command(conf.Exec, fmt.Sprintf("-U%s", user), dbreq.DatabaseName)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		pw.CloseWithError(rewritePgDump(src, pw, mapper.remap))
+		close(done)
+	}()
+
+	cmd.Stdin = pr
+
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+
+	err := cmd.Run()
+
+	// Unblock the rewriting if psql stopped reading early
+	pr.Close()
+	<-done
+
 	if err != nil {
-		db.DropDatabase(dbreq)
 		return fmt.Errorf("could not execute import command: %s", errBuf.String())
 	}
 
 	return nil
 }
 
+// newRoleMapper returns a pgRoleMapper that maps roles to user. If the original
+// roles should be kept, the ones existing on the server are looked up.
+func (db *postgres) newRoleMapper(user string) (*pgRoleMapper, error) {
+	mapper := &pgRoleMapper{user: user, keep: make(map[string]bool)}
+
+	if !conf.PgKeepRoles {
+		return mapper, nil
+	}
+
+	rows, err := db.conn.Query("SELECT rolname FROM pg_roles")
+	if err != nil {
+		return nil, fmt.Errorf("listing roles failed: %s", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role string
+
+		err = rows.Scan(&role)
+		if err != nil {
+			return nil, fmt.Errorf("reading role failed: %s", err.Error())
+		}
+
+		mapper.keep[role] = true
+	}
+
+	return mapper, rows.Err()
+}
+
 func (db *postgres) ExportDatabase(dbRequest model.DBRequest) (string, error) {
 	//fullDumpFilename := fmt.Sprintf("%s_%s.dmp", dbRequest.DatabaseName, time.Now().Format("20060102150405"))
 
@@ -249,6 +364,30 @@ func (db *postgres) Version() (string, error) {
 	return re.FindString(buf.String()), nil
 }
 
+// DatabaseSizes returns the disk space used by each database.
+func (db *postgres) DatabaseSizes() (map[string]int64, error) {
+	err := db.Alive()
+	if err != nil {
+		return nil, fmt.Errorf("alive check failed: %s", err.Error())
+	}
+
+	return querySizes(db.conn, "SELECT datname, pg_database_size(datname) FROM pg_database WHERE datistemplate = false", func(name string) bool {
+		return name == "postgres"
+	})
+}
+
+// Connections returns the number of backends connected to the server.
+func (db *postgres) Connections() (int, error) {
+	var count int
+
+	err := db.conn.QueryRow("SELECT count(*) FROM pg_stat_activity").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("counting connections failed: %s", err.Error())
+	}
+
+	return count, nil
+}
+
 func (db *postgres) RequiredFields(dbreq model.DBRequest, reqType int) []string {
 	req := []string{dbreq.DatabaseName, dbreq.Username}
 
@@ -262,42 +401,15 @@ func (db *postgres) RequiredFields(dbreq model.DBRequest, reqType int) []string
 	return req
 }
 
+// ValidateDump checks that the dump is in a format that can be imported. Ownership
+// is taken care of while importing, as the user the roles are mapped to is not known
+// at this point.
 func (db *postgres) ValidateDump(path string) (string, error) {
-	toRemove := []string{"ALTER TABLE", "alter table"}
-
-	file, err := os.Open(path)
-	if err != nil {
-		return "", fmt.Errorf("could not open dumpfile '%s': %s", path, err.Error())
-	}
-	defer file.Close()
-
-	lines, err := sutils.OccursWith(strings.HasPrefix, file, toRemove)
+	_, err := pgDumpFormat(path)
 	if err != nil {
-		return path, fmt.Errorf("couldn't find occurrences: %v", err)
+		return path, fmt.Errorf("could not determine dump format: %v", err)
 	}
 
-	// Rewind file
-	file.Seek(0, 0)
-
-	if len(lines) > 0 {
-		tmpFile, err := ioutil.TempFile(os.TempDir(), "ddnc")
-		if err != nil {
-			return path, fmt.Errorf("could not create tempfile: %s", err.Error())
-		}
-
-		err = sutils.CopyWithoutLines(file, lines, tmpFile)
-		if err != nil {
-			return path, fmt.Errorf("removing extra lines from dump failed: %s", err.Error())
-		}
-
-		tmpFilePath, _ := filepath.Abs(tmpFile.Name())
-
-		os.Rename(tmpFilePath, path)
-	}
-
-	// Rewind file
-	file.Seek(0, 0)
-
 	return path, nil
 }
 
@@ -332,3 +444,199 @@ func (db *postgres) userExists(user string) (bool, error) {
 
 	return false, nil
 }
+
+// Formats of dumps created by pg_dump
+const (
+	pgPlain int = iota
+	pgCustom
+	pgDirectory
+	pgTar
+)
+
+// pgFormatFlags maps the dump formats to pg_restore's --format values
+var pgFormatFlags = map[int]string{
+	pgCustom:    "custom",
+	pgDirectory: "directory",
+	pgTar:       "tar",
+}
+
+// pgDumpFormat detects the format of the dump at path: directory format dumps are
+// folders containing a toc.dat, custom format ones start with "PGDMP", and tar
+// format ones are tarballs starting with a toc.dat. Everything else is considered
+// to be plain SQL.
+func pgDumpFormat(path string) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return pgPlain, err
+	}
+
+	if info.IsDir() {
+		if _, err := os.Stat(filepath.Join(path, "toc.dat")); err != nil {
+			return pgPlain, fmt.Errorf("directory %q does not contain a toc.dat", path)
+		}
+
+		return pgDirectory, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return pgPlain, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return pgPlain, err
+	}
+	header = header[:n]
+
+	if bytes.HasPrefix(header, []byte("PGDMP")) {
+		return pgCustom, nil
+	}
+
+	if len(header) >= 262 && string(header[257:262]) == "ustar" && string(bytes.TrimRight(header[:100], "\x00")) == "toc.dat" {
+		return pgTar, nil
+	}
+
+	return pgPlain, nil
+}
+
+// isPgTarDump returns true if the agent is a postgres one and the file at path is a
+// tar format dump, which should be handed to pg_restore as is instead of being extracted.
+func isPgTarDump(path string) bool {
+	if conf.Vendor != "postgres" {
+		return false
+	}
+
+	format, err := pgDumpFormat(path)
+
+	return err == nil && format == pgTar
+}
+
+// pgDumpDir returns the directory of a directory format dump if the
+// files contain its table of contents.
+func pgDumpDir(files []string) (string, bool) {
+	for _, f := range files {
+		if filepath.Base(f) == "toc.dat" {
+			return filepath.Dir(f), true
+		}
+	}
+
+	return "", false
+}
+
+// pgRestoreExec returns the location of the pg_restore executable. Unless configured
+// otherwise, it is expected to be next to psql.
+func pgRestoreExec() string {
+	if conf.PgRestoreExec != "" {
+		return conf.PgRestoreExec
+	}
+
+	name := "pg_restore"
+	if strings.HasSuffix(strings.ToLower(conf.Exec), ".exe") {
+		name = "pg_restore.exe"
+	}
+
+	return filepath.Join(filepath.Dir(conf.Exec), name)
+}
+
+var (
+	pgOwnerTo = regexp.MustCompile(`(?i)^(\s*ALTER\s+.+\s+OWNER\s+TO\s+)("[^"]+"|[^\s;]+)(\s*;\s*)$`)
+	pgGrantTo = regexp.MustCompile(`(?i)^(\s*GRANT\s+.+\s+TO\s+)(.+?)(\s+WITH\s+(?:GRANT|ADMIN)\s+OPTION)?(\s*;\s*)$`)
+	pgSetRole = regexp.MustCompile(`(?i)^(\s*SET\s+(?:SESSION\s+|LOCAL\s+)?ROLE\s+)("[^"]+"|'[^']+'|[^\s;]+)(\s*;\s*)$`)
+)
+
+// pgRoleMapper rewrites the roles in "ALTER ... OWNER TO", "GRANT ... TO" and
+// "SET ROLE" statements to user, unless they are in keep.
+type pgRoleMapper struct {
+	user string
+	keep map[string]bool
+}
+
+// remap rewrites the roles in line. Lines are never dropped.
+func (m *pgRoleMapper) remap(line string) (string, bool) {
+	if p := pgOwnerTo.FindStringSubmatch(line); p != nil {
+		return p[1] + m.role(p[2]) + p[3], true
+	}
+
+	if p := pgSetRole.FindStringSubmatch(line); p != nil {
+		return p[1] + m.role(p[2]) + p[3], true
+	}
+
+	if p := pgGrantTo.FindStringSubmatch(line); p != nil {
+		grantees := strings.Split(p[2], ",")
+		for i, g := range grantees {
+			grantees[i] = m.role(strings.TrimSpace(g))
+		}
+
+		return p[1] + strings.Join(grantees, ", ") + p[3] + p[4], true
+	}
+
+	return line, true
+}
+
+// role returns the role that should be used in place of role.
+func (m *pgRoleMapper) role(role string) string {
+	switch strings.ToUpper(role) {
+	case "PUBLIC", "CURRENT_USER", "SESSION_USER", "NONE":
+		return role
+	}
+
+	name := strings.Trim(role, `"'`)
+	if !strings.HasPrefix(role, `"`) {
+		name = strings.ToLower(name)
+	}
+
+	if m.keep[name] {
+		return role
+	}
+
+	return m.user
+}
+
+// rewritePgDump copies a plain SQL dump from src to dst, passing each line through
+// rewrite, which returns the line to be written, or false if it should be dropped.
+// The data sections of COPY statements are copied verbatim.
+func rewritePgDump(src io.Reader, dst io.Writer, rewrite func(string) (string, bool)) error {
+	r := bufio.NewReader(src)
+	w := bufio.NewWriter(dst)
+
+	inCopy := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if line != "" {
+			out, keep := line, true
+
+			if inCopy {
+				if strings.TrimRight(line, "\r\n") == "\\." {
+					inCopy = false
+				}
+			} else {
+				out, keep = rewrite(line)
+
+				if keep && pgCopyFromStdin.MatchString(line) {
+					inCopy = true
+				}
+			}
+
+			if keep {
+				if _, werr := w.WriteString(out); werr != nil {
+					return werr
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return w.Flush()
+}
+
+var pgCopyFromStdin = regexp.MustCompile(`(?i)^COPY\s+.+\s+FROM\s+stdin`)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPgDumpFormat(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ddnc-test")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	plain := filepath.Join(tmp, "plain.sql")
+	ioutil.WriteFile(plain, []byte("CREATE TABLE foo (id integer);\n"), 0644)
+
+	custom := filepath.Join(tmp, "custom.dump")
+	ioutil.WriteFile(custom, []byte("PGDMP\x01\x0c\x00"), 0644)
+
+	dir := filepath.Join(tmp, "directory")
+	os.Mkdir(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "toc.dat"), []byte("PGDMP"), 0644)
+
+	noToc := filepath.Join(tmp, "notoc")
+	os.Mkdir(noToc, 0755)
+
+	tarDump := filepath.Join(tmp, "dump.tar")
+	os.Rename(writeTestTar(t, tmp, []testEntry{{name: "toc.dat", content: "PGDMP"}, {name: "3000.dat", content: "1\n"}}), tarDump)
+
+	sqlTar := filepath.Join(tmp, "sql.tar")
+	os.Rename(writeTestTar(t, tmp, []testEntry{{name: "dump.sql", content: "select 1;"}}), sqlTar)
+
+	tests := []struct {
+		path    string
+		want    int
+		wantErr bool
+	}{
+		{path: plain, want: pgPlain},
+		{path: custom, want: pgCustom},
+		{path: dir, want: pgDirectory},
+		{path: tarDump, want: pgTar},
+		{path: sqlTar, want: pgPlain},
+		{path: noToc, wantErr: true},
+		{path: filepath.Join(tmp, "missing"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := pgDumpFormat(tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pgDumpFormat(%q) error = %v, wantErr %v", filepath.Base(tt.path), err, tt.wantErr)
+			continue
+		}
+
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("pgDumpFormat(%q) = %d, want %d", filepath.Base(tt.path), got, tt.want)
+		}
+	}
+}
+
+func TestPgRoleMapper(t *testing.T) {
+	dump := strings.Join([]string{
+		"CREATE TABLE public.foo (id integer);",
+		"ALTER TABLE public.foo OWNER TO someone;",
+		"ALTER TABLE ONLY public.foo",
+		"    ADD CONSTRAINT foo_pkey PRIMARY KEY (id);",
+		"alter sequence public.foo_seq owner to \"Someone\";",
+		"ALTER SCHEMA app OWNER TO existing;",
+		"GRANT ALL ON TABLE public.foo TO someone, PUBLIC, existing;",
+		"GRANT SELECT ON TABLE public.foo TO reader WITH GRANT OPTION;",
+		"SET ROLE someone;",
+		"SET SESSION ROLE existing;",
+		"COPY public.foo (id) FROM stdin;",
+		"ALTER TABLE public.foo OWNER TO someone;",
+		"\\.",
+		"",
+	}, "\n")
+
+	want := strings.Join([]string{
+		"CREATE TABLE public.foo (id integer);",
+		"ALTER TABLE public.foo OWNER TO dbuser;",
+		"ALTER TABLE ONLY public.foo",
+		"    ADD CONSTRAINT foo_pkey PRIMARY KEY (id);",
+		"alter sequence public.foo_seq owner to dbuser;",
+		"ALTER SCHEMA app OWNER TO existing;",
+		"GRANT ALL ON TABLE public.foo TO dbuser, PUBLIC, existing;",
+		"GRANT SELECT ON TABLE public.foo TO dbuser WITH GRANT OPTION;",
+		"SET ROLE dbuser;",
+		"SET SESSION ROLE existing;",
+		"COPY public.foo (id) FROM stdin;",
+		"ALTER TABLE public.foo OWNER TO someone;",
+		"\\.",
+		"",
+	}, "\n")
+
+	mapper := &pgRoleMapper{user: "dbuser", keep: map[string]bool{"existing": true}}
+
+	var out bytes.Buffer
+	if err := rewritePgDump(strings.NewReader(dump), &out, mapper.remap); err != nil {
+		t.Fatalf("rewritePgDump failed: %v", err)
+	}
+
+	if out.String() != want {
+		t.Errorf("unexpected result:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
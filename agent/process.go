@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -18,80 +22,96 @@ import (
 )
 
 func startImport(dbreq model.DBRequest) {
-	upd8Path := fmt.Sprintf("%s/%s", conf.MasterAddress, "upd8")
+	upd8Path := upd8Endpoint(dbreq)
 
-	ch := notif.New(dbreq.ID, upd8Path)
+	ch := observeJob("import", importDuration, notif.New(dbreq.ID, upd8Path))
 	defer close(ch)
 
+	jobLog := logger.With("request_id", dbreq.RequestID, "id", dbreq.ID, "database", dbreq.DatabaseName)
+
 	ch <- notif.Y{StatusCode: status.DownloadInProgress, Msg: "Downloading dump"}
-	logger.Debug("Downloading dump from %q", dbreq.DumpLocation)
+	jobLog.Debug("Downloading dump from %q", dbreq.DumpLocation)
 
 	path, err := inet.DownloadFile("dumps", dbreq.DumpLocation)
 	if err != nil {
 		db.DropDatabase(dbreq)
-		logger.Error("could not download file: %v", err)
+		jobLog.Error("could not download file: %v", err)
 
 		ch <- notif.Y{StatusCode: status.DownloadFailed, Msg: "Downloading file failed: " + err.Error()}
 		return
 	}
 	defer os.Remove(path)
 
-	if isArchive(path) {
+	countDownload(path)
+
+	if isArchive(path) && !isPgTarDump(path) {
 		ch <- notif.Y{StatusCode: status.ExtractingArchive, Msg: "Extracting archive"}
 
-		logger.Debug("Extracting archive: %v", path)
-
-		var (
-			files []string
-			err   error
-		)
-
-		switch filepath.Ext(path) {
-		case ".zip":
-			files, err = unzip(path)
-		case ".gz":
-			files, err = ungzip(path)
-		case ".bz2":
-			files, err = unbzip2(path)
-		case ".tar":
-			files, err = untar(path)
-		default:
+		jobLog.Debug("Extracting archive: %v", path)
+
+		// Extract into a directory of its own so that the archive can't
+		// overwrite anything else, and so that cleaning up is easy.
+		jobDir, err := ioutil.TempDir("dumps", fmt.Sprintf("import-%d-", dbreq.ID))
+		if err != nil {
 			db.DropDatabase(dbreq)
-			logger.Error("import process stopped; encountered unsupported archive")
+			jobLog.Error("could not create extraction directory: %v", err)
 
-			ch <- notif.Y{StatusCode: status.ArchiveNotSupported, Msg: "archive not supported"}
+			ch <- notif.Y{StatusCode: status.ExtractingArchiveFailed, Msg: "Extracting file failed: " + err.Error()}
 			return
 		}
-		for _, f := range files {
-			defer os.Remove(f)
-		}
+		defer os.RemoveAll(jobDir)
 
+		files, err := newExtractor(jobDir).extract(path)
 		if err != nil {
 			db.DropDatabase(dbreq)
-			logger.Error("could not extract archive: %v", err)
+			jobLog.Error("could not extract archive: %v", err)
+
+			if isUnsupportedArchive(err) {
+				ch <- notif.Y{StatusCode: status.ArchiveNotSupported, Msg: "archive not supported"}
+				return
+			}
+
+			if isRejectedArchive(err) {
+				ch <- notif.Y{StatusCode: status.ArchiveRejected, Msg: "Extracting file failed: " + err.Error()}
+				return
+			}
 
 			ch <- notif.Y{StatusCode: status.ExtractingArchiveFailed, Msg: "Extracting file failed: " + err.Error()}
 			return
 		}
 
+		// Directory format Postgres dumps consist of several files, which
+		// are imported together.
+		if dir, ok := pgDumpDir(files); ok && conf.Vendor == "postgres" {
+			files = []string{dir}
+		}
+
 		if len(files) > 1 {
 			db.DropDatabase(dbreq)
-			logger.Error("import process stopped; more than one file found in archive")
+			jobLog.Error("import process stopped; more than one file found in archive")
 
 			ch <- notif.Y{StatusCode: status.MultipleFilesInArchive, Msg: "Archive contains more than one file, import stopped"}
 			return
 		}
 
+		if len(files) == 0 {
+			db.DropDatabase(dbreq)
+			jobLog.Error("import process stopped; no files found in archive")
+
+			ch <- notif.Y{StatusCode: status.ExtractingArchiveFailed, Msg: "Archive does not contain any files, import stopped"}
+			return
+		}
+
 		path = files[0]
 	}
 
-	logger.Debug("Validating dump: %s", path)
+	jobLog.Debug("Validating dump: %s", path)
 
 	ch <- notif.Y{StatusCode: status.ValidatingDump, Msg: "Validating dump"}
 	path, err = db.ValidateDump(path)
 	if err != nil {
 		db.DropDatabase(dbreq)
-		logger.Error("database validation failed: %v", err)
+		jobLog.Error("database validation failed: %v", err)
 
 		ch <- notif.Y{StatusCode: status.ValidationFailed, Msg: "Validating dump failed: " + err.Error()}
 		return
@@ -105,41 +125,74 @@ func startImport(dbreq model.DBRequest) {
 	}
 
 	path, _ = filepath.Abs(path)
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
 
 	dbreq.DumpLocation = path
 
-	logger.Debug("Importing dump: %v", path)
+	jobLog.Debug("Importing dump: %v", path)
 	ch <- notif.Y{StatusCode: status.ImportInProgress, Msg: "Importing"}
 
 	start := time.Now()
 
-	err = db.ImportDatabase(dbreq)
+	if pr, ok := db.(progressReporter); ok {
+		err = pr.ImportDatabaseProgress(dbreq, func(msg string) {
+			ch <- notif.Y{StatusCode: status.ImportInProgress, Msg: msg}
+		})
+	} else {
+		err = db.ImportDatabase(dbreq)
+	}
+
 	if err != nil {
-		logger.Error("could not import database: %v", err)
+		jobLog.Error("could not import database: %v", err)
 
 		ch <- notif.Y{StatusCode: status.ImportFailed, Msg: "Importing dump failed: " + err.Error()}
 		return
 	}
 
-	logger.Debug("Import succeded in %v", time.Since(start))
+	jobLog.Debug("Import succeded in %v", time.Since(start))
 	ch <- notif.Y{StatusCode: status.Success, Msg: "Completed"}
 }
 
+// upd8Endpoint returns the server's endpoint for status updates. The request ID
+// is sent along so that the server can log the updates with it.
+func upd8Endpoint(dbreq model.DBRequest) string {
+	endpoint := fmt.Sprintf("%s/%s", conf.MasterAddress, "upd8")
+
+	if dbreq.RequestID != "" {
+		endpoint += "?request_id=" + url.QueryEscape(dbreq.RequestID)
+	}
+
+	return endpoint
+}
+
 func startExport(dbreq model.DBRequest) {
-	upd8Path := fmt.Sprintf("%s/%s", conf.MasterAddress, "upd8")
+	upd8Path := upd8Endpoint(dbreq)
 
-	ch := notif.New(dbreq.ID, upd8Path)
+	ch := observeJob("export", exportDuration, notif.New(dbreq.ID, upd8Path))
 	defer close(ch)
 
-	logger.Debug("Exporting database: %v", dbreq.DatabaseName)
+	jobLog := logger.With("request_id", dbreq.RequestID, "id", dbreq.ID, "database", dbreq.DatabaseName)
+
+	jobLog.Debug("Exporting database: %v", dbreq.DatabaseName)
 	ch <- notif.Y{StatusCode: status.ExportInProgress, Msg: "Exporting"}
 
 	start := time.Now()
 
-	fullDumpFilename, err := db.ExportDatabase(dbreq)
+	var (
+		fullDumpFilename string
+		err              error
+	)
+
+	if pr, ok := db.(progressReporter); ok {
+		fullDumpFilename, err = pr.ExportDatabaseProgress(dbreq, func(msg string) {
+			ch <- notif.Y{StatusCode: status.ExportInProgress, Msg: msg}
+		})
+	} else {
+		fullDumpFilename, err = db.ExportDatabase(dbreq)
+	}
+
 	if err != nil {
-		logger.Error("could not export database: %v", err)
+		jobLog.Error("could not export database: %v", err)
 
 		ch <- notif.Y{StatusCode: status.ExportFailed, Msg: "Exporting database failed: " + err.Error()}
 		return
@@ -147,7 +200,7 @@ func startExport(dbreq model.DBRequest) {
 
 	// Archive (zip) the created dump file
 	ch <- notif.Y{StatusCode: status.ArchivingDump, Msg: "Zipping dump"}
-	logger.Debug("Zipping dump file: %v", fullDumpFilename)
+	jobLog.Debug("Zipping dump file: %v", fullDumpFilename)
 
 	inputFiles := []string{filepath.Join(".", "exports", fullDumpFilename)}
 	outputZipFilename := fmt.Sprintf("%s.zip", strings.TrimSuffix(fullDumpFilename, path.Ext(fullDumpFilename)))
@@ -155,7 +208,7 @@ func startExport(dbreq model.DBRequest) {
 	err = zipFiles(filepath.Join(".", "exports", outputZipFilename), inputFiles)
 
 	if err != nil {
-		logger.Error("could not zip dump file: %v", err)
+		jobLog.Error("could not zip dump file: %v", err)
 
 		ch <- notif.Y{StatusCode: status.ZippingDumpFailed, Msg: "Zipping dump failed: " + err.Error()}
 		os.Remove(filepath.Join(".", "exports", outputZipFilename))
@@ -165,16 +218,21 @@ func startExport(dbreq model.DBRequest) {
 
 	os.Remove(inputFiles[0])
 
-	logger.Debug("Export succeeded in %v", time.Since(start))
-	ch <- notif.Y{StatusCode: status.Success, Msg: "Export completed:" + outputZipFilename}
+	jobLog.Debug("Export succeeded in %v", time.Since(start))
+	ch <- notif.Y{StatusCode: status.Success, Msg: model.ExportCompleted + outputZipFilename}
 }
 
+// capacityEvery is the number of heartbeats after which the capacity is reported.
+const capacityEvery = 6
+
 // This method should always be called asynchronously
 func keepAlive() {
 	endpoint := fmt.Sprintf("%s/%s/%s", conf.MasterAddress, "alive", conf.ShortName)
 
 	ticker := time.NewTicker(10 * time.Second)
-	for range ticker.C {
+	for tick := 0; ; tick++ {
+		<-ticker.C
+
 		// Check if the endpoint is up
 		if !inet.AddrExists(fmt.Sprintf("%s/%s", conf.MasterAddress, "heartbeat")) {
 			if registered {
@@ -198,7 +256,15 @@ func keepAlive() {
 			registered = true
 		}
 
-		respCode := inet.GetResponseCode(endpoint)
+		// Capacity is sent every minute, the rest of the time it's enough to
+		// check whether the server still knows about us.
+		var respCode int
+		if tick%capacityEvery == 0 {
+			respCode = reportCapacity(endpoint)
+		} else {
+			respCode = inet.GetResponseCode(endpoint)
+		}
+
 		if respCode == http.StatusOK {
 			continue
 		}
@@ -235,3 +301,22 @@ func checkExports() {
 		}
 	}
 }
+
+// reportCapacity posts the agent's capacity to the endpoint and returns the
+// status code of the response, or 0 if the request failed.
+func reportCapacity(endpoint string) int {
+	b, err := json.Marshal(capacity())
+	if err != nil {
+		logger.Error("capacity: encoding failed: %v", err)
+		return 0
+	}
+
+	resp, err := http.Post(endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		logger.Error("capacity: reporting failed: %v", err)
+		return 0
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/djavorszky/ddn/common/srv"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Router creates a new router that registers all routes.
@@ -32,6 +33,8 @@ func Router() *mux.Router {
 
 	attachProfiler(router)
 
+	router.Handle("/metrics", promhttp.Handler())
+
 	return router
 }
 
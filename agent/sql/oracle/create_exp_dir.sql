@@ -1,8 +0,0 @@
-WHENEVER OSERROR EXIT FAILURE
-WHENEVER SQLERROR EXIT SQL.SQLCODE
-SET VERIFY OFF
-SET FEEDBACK OFF
-
-CREATE OR REPLACE DIRECTORY EXP_DIR AS '&1';
-
-EXIT
\ No newline at end of file
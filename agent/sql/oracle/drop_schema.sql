@@ -1,8 +0,0 @@
-WHENEVER OSERROR EXIT FAILURE
-WHENEVER SQLERROR EXIT SQL.SQLCODE
-SET VERIFY OFF
-
-DROP USER &1 CASCADE;
-DROP TABLESPACE &1 INCLUDING CONTENTS AND DATAFILES;
-
-EXIT;
\ No newline at end of file
@@ -1,5 +0,0 @@
-WHENEVER OSERROR EXIT FAILURE
-WHENEVER SQLERROR EXIT SQL.SQLCODE
-SET HEADING OFF
-SET NEWPAGE NONE
-SELECT version FROM V$INSTANCE;
\ No newline at end of file
@@ -3,6 +3,7 @@
 -- grant select on dba_datapump_jobs to system;
 -- grant create any directory to system;
 -- grant create external job to system;
+-- grant select on v_$session_longops to system; -- used by the agent to report Data Pump progress
 
 WHENEVER OSERROR EXIT FAILURE
 WHENEVER SQLERROR EXIT SQL.SQLCODE
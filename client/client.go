@@ -0,0 +1,425 @@
+// Package client calls the v2 API of the server, as described in
+// server/apiv2.md. Every call is made on behalf of the user the client was
+// created with, and fails with an *Error if the server responds with one.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn/common/inet"
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/common/status"
+	"github.com/djavorszky/ddn/server/database/data"
+)
+
+// Client calls the API of a single server.
+type Client struct {
+	// Address is the base URL of the server, e.g. http://localhost:7010
+	Address string
+
+	// User is the email address sent in the Authorization header.
+	User string
+
+	// HTTPClient is used to send the requests, http.DefaultClient if nil. Set
+	// it to a client with the transport built from inet.TLSConfig if the server
+	// requires client certificates.
+	HTTPClient *http.Client
+}
+
+// New returns a client calling the server at address as user.
+func New(address, user string) *Client {
+	return &Client{
+		Address: strings.TrimSuffix(inet.WithScheme(address), "/"),
+		User:    user,
+	}
+}
+
+// Error is returned if the server responds with a failure.
+type Error struct {
+	StatusCode int
+
+	// Status is the status of common/status the server sent along, e.g.
+	// status.NotFound, which stays the same for the same cause of failure.
+	Status int
+
+	// Errors holds the error code of the server, followed by its parameters,
+	// e.g. ["ERR_DATABASE_NOT_FOUND", "12"]
+	Errors []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server responded with %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+// Database is returned when creating or importing a database, along with the
+// agent the database ended up on.
+type Database struct {
+	data.Row
+	Agent model.Agent `json:"agent_info"`
+}
+
+// Access holds the details needed to connect to a database.
+type Access struct {
+	JDBCDriver  string `json:"jdbc_driver"`
+	JDBCUrl     string `json:"jdbc_url"`
+	JDBCUrl6210 string `json:"jdbc_url_6210,omitempty"`
+	User        string `json:"user"`
+	Password    string `json:"password"`
+	Database    string `json:"database,omitempty"`
+	URL         string `json:"url"`
+}
+
+// DatabaseQuery filters, sorts and pages the listed databases. Fields left
+// empty are not filtered on.
+type DatabaseQuery struct {
+	Vendor  string
+	Agent   string
+	Creator string
+
+	// Name matches databases whose name contains it.
+	Name   string
+	Status []int
+
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	ExpiresFrom time.Time
+	ExpiresTo   time.Time
+
+	// Sort is the field to sort by, prefixed with - to sort descending.
+	Sort string
+
+	// Limit is the size of a page, and Cursor the one returned with the
+	// previous page.
+	Limit  int
+	Cursor string
+}
+
+// values returns the query as query parameters of GET /api/databases.
+func (q DatabaseQuery) values() url.Values {
+	v := url.Values{}
+
+	set := func(name, value string) {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+
+	setTime := func(name string, t time.Time) {
+		if !t.IsZero() {
+			v.Set(name, t.Format(time.RFC3339))
+		}
+	}
+
+	set("vendor", q.Vendor)
+	set("agent", q.Agent)
+	set("creator", q.Creator)
+	set("name", q.Name)
+	set("sort", q.Sort)
+	set("cursor", q.Cursor)
+
+	setTime("created_from", q.CreatedFrom)
+	setTime("created_to", q.CreatedTo)
+	setTime("expires_from", q.ExpiresFrom)
+	setTime("expires_to", q.ExpiresTo)
+
+	if len(q.Status) > 0 {
+		codes := make([]string, len(q.Status))
+		for i, s := range q.Status {
+			codes[i] = strconv.Itoa(s)
+		}
+
+		v.Set("status", strings.Join(codes, ","))
+	}
+
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	return v
+}
+
+// Agents returns all agents registered with the server.
+func (c *Client) Agents(ctx context.Context) ([]model.Agent, error) {
+	var agents []model.Agent
+
+	_, err := c.do(ctx, http.MethodGet, "/api/agents", nil, nil, &agents)
+
+	return agents, err
+}
+
+// ActiveAgents returns the agents that are up.
+func (c *Client) ActiveAgents(ctx context.Context) ([]model.Agent, error) {
+	var agents []model.Agent
+
+	_, err := c.do(ctx, http.MethodGet, "/api/agents/active", nil, nil, &agents)
+
+	return agents, err
+}
+
+// Agent returns the agent by its short name.
+func (c *Client) Agent(ctx context.Context, name string) (model.Agent, error) {
+	var agent model.Agent
+
+	_, err := c.do(ctx, http.MethodGet, "/api/agents/"+url.PathEscape(name), nil, nil, &agent)
+
+	return agent, err
+}
+
+// Databases returns a page of the databases visible to the user, and the
+// cursor of the next page, which is empty on the last one.
+func (c *Client) Databases(ctx context.Context, q DatabaseQuery) ([]data.Row, string, error) {
+	var rows []data.Row
+
+	next, err := c.do(ctx, http.MethodGet, "/api/databases", q.values(), nil, &rows)
+
+	return rows, next, err
+}
+
+// Database returns a database by its id.
+func (c *Client) Database(ctx context.Context, id int) (data.Row, error) {
+	var row data.Row
+
+	_, err := c.do(ctx, http.MethodGet, databasePath(id), nil, nil, &row)
+
+	return row, err
+}
+
+// DatabaseByName returns a database by the agent it's on and its name.
+func (c *Client) DatabaseByName(ctx context.Context, agent, dbname string) (data.Row, error) {
+	var row data.Row
+
+	_, err := c.do(ctx, http.MethodGet, "/api/databases/"+url.PathEscape(agent)+"/"+url.PathEscape(dbname), nil, nil, &row)
+
+	return row, err
+}
+
+// Create creates an empty database. If the request names no agent, the server
+// chooses one based on its vendor, which can contain a version constraint.
+func (c *Client) Create(ctx context.Context, req model.ClientRequest) (Database, error) {
+	var db Database
+
+	_, err := c.do(ctx, http.MethodPost, "/api/databases/create", nil, req, &db)
+
+	return db, err
+}
+
+// Import starts importing the dump at the DumpLocation of the request into a
+// new database. The import runs in the background; its progress shows in the
+// status of the returned database.
+func (c *Client) Import(ctx context.Context, req model.ClientRequest) (Database, error) {
+	var db Database
+
+	_, err := c.do(ctx, http.MethodPost, "/api/databases/import", nil, req, &db)
+
+	return db, err
+}
+
+// Export starts exporting the database on its agent.
+func (c *Client) Export(ctx context.Context, id int) (string, error) {
+	var msg string
+
+	_, err := c.do(ctx, http.MethodPut, databasePath(id)+"/export", nil, nil, &msg)
+
+	return msg, err
+}
+
+// Recreate drops the database and creates it again, empty.
+func (c *Client) Recreate(ctx context.Context, id int) (data.Row, error) {
+	var row data.Row
+
+	_, err := c.do(ctx, http.MethodPut, databasePath(id)+"/recreate", nil, nil, &row)
+
+	return row, err
+}
+
+// Drop starts dropping the database. It is removed from the server once the
+// agent dropped it.
+func (c *Client) Drop(ctx context.Context, id int) error {
+	_, err := c.do(ctx, http.MethodDelete, databasePath(id), nil, nil, nil)
+
+	return err
+}
+
+// SetVisibility makes the database visible to everyone if public, only to its
+// creator otherwise.
+func (c *Client) SetVisibility(ctx context.Context, id int, public bool) error {
+	visibility := "private"
+	if public {
+		visibility = "public"
+	}
+
+	_, err := c.do(ctx, http.MethodPut, databasePath(id)+"/visibility/"+visibility, nil, nil, nil)
+
+	return err
+}
+
+// ExtendExpiry postpones the expiry of the database by amount of unit, which
+// is one of days, months or years, and returns the new expiry.
+func (c *Client) ExtendExpiry(ctx context.Context, id, amount int, unit string) (time.Time, error) {
+	var expiry time.Time
+
+	path := fmt.Sprintf("%s/expiry/extend/%d/%s", databasePath(id), amount, url.PathEscape(unit))
+	_, err := c.do(ctx, http.MethodPut, path, nil, nil, &expiry)
+
+	return expiry, err
+}
+
+// AccessInfo returns the details needed to connect to the database.
+func (c *Client) AccessInfo(ctx context.Context, id int) (Access, error) {
+	var access Access
+
+	_, err := c.do(ctx, http.MethodGet, databasePath(id)+"/accessinfo", nil, nil, &access)
+
+	return access, err
+}
+
+// AccessInfoByName returns the details needed to connect to the database
+// by the agent it's on and its name.
+func (c *Client) AccessInfoByName(ctx context.Context, agent, dbname string) (Access, error) {
+	var access Access
+
+	path := "/api/databases/" + url.PathEscape(agent) + "/" + url.PathEscape(dbname) + "/accessinfo"
+	_, err := c.do(ctx, http.MethodGet, path, nil, nil, &access)
+
+	return access, err
+}
+
+// Upload sends the dump read from r to the server, and returns the location
+// to import it from, to be used as the DumpLocation of Import.
+func (c *Client) Upload(ctx context.Context, filename string, r io.Reader) (string, error) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+
+	// The form is written while it's being sent, so that the dump isn't held in memory
+	go func() {
+		part, err := form.CreateFormFile("dumpfile", filepath.Base(filename))
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+
+		if err == nil {
+			err = form.Close()
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	var location string
+
+	_, err := c.send(ctx, http.MethodPost, "/api/dumps", nil, form.FormDataContentType(), pr, &location)
+	pr.Close()
+
+	return location, err
+}
+
+// Wait polls the database every interval until the action started on it is
+// over. It returns the database once its status is Completed, or an error
+// holding its message if it failed. Waiting on a dropped database returns an
+// *Error with http.StatusNotFound once it's gone.
+func (c *Client) Wait(ctx context.Context, id int, interval time.Duration) (data.Row, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		row, err := c.Database(ctx, id)
+		if err != nil {
+			return row, err
+		}
+
+		switch {
+		case row.Status == status.Success:
+			return row, nil
+		case row.IsErr():
+			return row, fmt.Errorf("%s: %s", row.StatusLabel(), row.Message)
+		}
+
+		select {
+		case <-ctx.Done():
+			return row, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func databasePath(id int) string {
+	return "/api/databases/" + strconv.Itoa(id)
+}
+
+// do sends the request with body as JSON payload, and decodes the data of the
+// response into out. It returns the cursor of the next page, if there's any.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) (string, error) {
+	if body == nil {
+		return c.send(ctx, method, path, query, "", nil, out)
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", fmt.Errorf("encoding request failed: %v", err)
+	}
+
+	return c.send(ctx, method, path, query, "application/json", bytes.NewReader(b), out)
+}
+
+// send sends the request with the payload of contentType, and decodes the
+// data of the response into out.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, contentType string, payload io.Reader, out interface{}) (string, error) {
+	dest := c.Address + path
+	if len(query) > 0 {
+		dest += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, dest, payload)
+	if err != nil {
+		return "", fmt.Errorf("creating request failed: %v", err)
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", c.User)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %v", method, path, err)
+	}
+	defer res.Body.Close()
+
+	// Failures come with the fields of both
+	var resp struct {
+		inet.Response
+		inet.Failure
+	}
+	resp.Data = out
+
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		if res.StatusCode >= http.StatusBadRequest {
+			return "", &Error{StatusCode: res.StatusCode, Errors: []string{http.StatusText(res.StatusCode)}}
+		}
+
+		return "", fmt.Errorf("decoding response of %s %s failed: %v", method, path, err)
+	}
+
+	if !resp.Success {
+		return "", &Error{StatusCode: res.StatusCode, Status: resp.Status, Errors: resp.Error}
+	}
+
+	return resp.Next, nil
+}
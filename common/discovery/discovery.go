@@ -0,0 +1,190 @@
+// Package discovery lets agents find the server without configuring its
+// address. The server periodically announces itself to a multicast group,
+// and agents that have no server-address listen on the same group until they
+// hear an announcement.
+//
+// Anyone on the network can send to the group, so the announcements can be
+// signed with a token shared by the server and the agents. Agents with a token
+// ignore the announcements that are not signed with it.
+package discovery
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/djavorszky/ddn/common/logger"
+)
+
+const (
+	// DefaultGroup is the multicast group the server announces itself on if
+	// none is configured.
+	DefaultGroup = "224.0.0.1:9999"
+
+	// DefaultInterval is how often the server announces itself.
+	DefaultInterval = 5 * time.Second
+
+	// service identifies the announcements of ddn among other traffic on the group.
+	service = "ddn"
+
+	maxPacketSize = 8192
+)
+
+// Announcement holds the information an agent needs to enroll with the server.
+type Announcement struct {
+	Service string `json:"service"`
+
+	// Address is the base URL the agents register on, including the scheme,
+	// e.g. https://ddn.example.com:7010
+	Address string `json:"address"`
+	Version string `json:"version"`
+
+	// TLS is true if the server is served over https, and ClientCert if it
+	// requires the agents to present a certificate.
+	TLS        bool `json:"tls"`
+	ClientCert bool `json:"clientCert"`
+
+	// Signature is the HMAC of the fields above with the shared token, if the
+	// server has one.
+	Signature string `json:"signature,omitempty"`
+
+	// Source is the IP the announcement was received from.
+	Source net.IP `json:"-"`
+}
+
+// ServerAddress returns the address to register with. If the server announced
+// itself with a loopback host, e.g. because server-host is left on localhost,
+// the host is replaced with the IP the announcement came from.
+func (a Announcement) ServerAddress() string {
+	u, err := url.Parse(a.Address)
+	if err != nil || a.Source == nil {
+		return a.Address
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return a.Address
+	}
+
+	port := u.Port()
+
+	u.Host = a.Source.String()
+	if port != "" {
+		u.Host = net.JoinHostPort(a.Source.String(), port)
+	}
+
+	return u.String()
+}
+
+// sign returns the signature of the announcement with the token.
+func (a Announcement) sign(token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%t\n%t", a.Service, a.Address, a.Version, a.TLS, a.ClientCert)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns true if the announcement is signed with the token. Every
+// announcement is accepted if the token is empty.
+func (a Announcement) verify(token string) bool {
+	if token == "" {
+		return true
+	}
+
+	return hmac.Equal([]byte(a.Signature), []byte(a.sign(token)))
+}
+
+// Announce sends the announcement to the group every interval until stop is
+// closed, signed with the token unless it's empty. It only returns an error if
+// the group can't be used; failing sends are logged and retried at the next
+// interval.
+func Announce(group, token string, a Announcement, interval time.Duration, stop <-chan struct{}) error {
+	addr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return fmt.Errorf("resolve group %q: %v", group, err)
+	}
+
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return fmt.Errorf("dial group %q: %v", group, err)
+	}
+	defer conn.Close()
+
+	a.Service = service
+	if token != "" {
+		a.Signature = a.sign(token)
+	}
+
+	msg, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("marshal announcement: %v", err)
+	}
+
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := conn.Write(msg); err != nil {
+			logger.Debug("announce on %s failed: %v", group, err)
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Discover listens on the group until it receives an announcement, or the
+// timeout passes. A timeout of zero waits indefinitely. Packets that are not
+// announcements of ddn are ignored, as well as the announcements not signed
+// with the token if it's not empty.
+func Discover(group, token string, timeout time.Duration) (Announcement, error) {
+	addr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return Announcement{}, fmt.Errorf("resolve group %q: %v", group, err)
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, addr)
+	if err != nil {
+		return Announcement{}, fmt.Errorf("listen on group %q: %v", group, err)
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return Announcement{}, fmt.Errorf("no announcement received on %q: %v", group, err)
+		}
+
+		var a Announcement
+		if err := json.Unmarshal(buf[:n], &a); err != nil || a.Service != service || a.Address == "" {
+			logger.Debug("ignoring packet from %s on %s", src, group)
+			continue
+		}
+
+		if !a.verify(token) {
+			logger.Warn("ignoring announcement of %s from %s: not signed with the discovery-token", a.Address, src)
+			continue
+		}
+
+		a.Source = src.IP
+
+		return a, nil
+	}
+}
@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// testGroup is not the default one, so that the tests don't pick up a server
+// running on the same network.
+const testGroup = "224.0.0.1:19999"
+
+func TestAnnounceDiscover(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp4", testGroup)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		// Unrelated traffic on the group is ignored
+		conn, err := net.DialUDP("udp4", nil, addr)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for i := 0; i < 5; i++ {
+			conn.Write([]byte("not an announcement"))
+			conn.Write([]byte(`{"service":"other","address":"http://example.com"}`))
+
+			// As are the announcements not signed with the token
+			conn.Write([]byte(`{"service":"ddn","address":"http://attacker.example.com","signature":"00"}`))
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	sent := Announcement{Address: "https://ddn.example.com:7010", Version: "5", TLS: true, ClientCert: true}
+	go Announce(testGroup, "secret", sent, 100*time.Millisecond, stop)
+
+	got, err := Discover(testGroup, "secret", 3*time.Second)
+	if err != nil {
+		// Multicast depends on the network the tests run in; sending to the
+		// group is looped back to the host if it has a route for it.
+		t.Skipf("multicast is not available: %v", err)
+	}
+
+	if got.Service != service {
+		t.Errorf("Service = %q, want %q", got.Service, service)
+	}
+
+	if got.Address != sent.Address || got.Version != sent.Version || got.TLS != sent.TLS || got.ClientCert != sent.ClientCert {
+		t.Errorf("Discover() = %+v, want %+v", got, sent)
+	}
+
+	if got.Source == nil {
+		t.Errorf("Source not set")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	a := Announcement{Service: service, Address: "https://ddn.example.com:7010", Version: "5", TLS: true}
+
+	if !a.verify("") {
+		t.Errorf("expected an unsigned announcement to be accepted without a token")
+	}
+
+	if a.verify("secret") {
+		t.Errorf("expected an unsigned announcement to be rejected with a token")
+	}
+
+	a.Signature = a.sign("secret")
+	if !a.verify("secret") {
+		t.Errorf("expected the signed announcement to be accepted")
+	}
+
+	if a.verify("other") {
+		t.Errorf("expected the announcement to be rejected with another token")
+	}
+
+	forged := a
+	forged.Address = "http://attacker.example.com:7010"
+	if forged.verify("secret") {
+		t.Errorf("expected a changed address to be rejected")
+	}
+
+	forged = a
+	forged.TLS = false
+	if forged.verify("secret") {
+		t.Errorf("expected a changed TLS flag to be rejected")
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	src := net.ParseIP("10.0.0.5")
+
+	tests := []struct {
+		address string
+		source  net.IP
+		want    string
+	}{
+		{"http://ddn.example.com:7010", src, "http://ddn.example.com:7010"},
+		{"https://192.168.1.10:7010", src, "https://192.168.1.10:7010"},
+		{"http://localhost:7010", src, "http://10.0.0.5:7010"},
+		{"https://127.0.0.1:7010", src, "https://10.0.0.5:7010"},
+		{"http://localhost", src, "http://10.0.0.5"},
+		{"http://localhost:7010", nil, "http://localhost:7010"},
+	}
+
+	for _, tt := range tests {
+		a := Announcement{Address: tt.address, Source: tt.source}
+
+		if got := a.ServerAddress(); got != tt.want {
+			t.Errorf("ServerAddress(%q, %v) = %q, want %q", tt.address, tt.source, got, tt.want)
+		}
+	}
+}
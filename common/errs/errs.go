@@ -3,18 +3,23 @@ package errs
 // Constants for errors.
 const (
 	// Service related
-	JSONDecodeFailed       = "ERR_JSON_DECODE_FAILED"
-	JSONEncodeFailed       = "ERR_JSON_ENCODE_FAILED"
-	MissingUserCookie      = "ERR_MISSING_USER_COOKIE"
-	MissingParameters      = "ERR_MISSING_PARAMETERS"
-	AccessDenied           = "ERR_ACCESS_DENIED"
-	InvalidURL             = "ERR_INVALID_URL"
-	UnknownParameter       = "ERR_UNKNOWN_PARAMETER"
-	AgentNotFound          = "ERR_AGENT_NOT_FOUND"
-	NoAgentsAvailable      = "ERR_NO_AGENTS_AVAILABLE"
-	FailedListingDirectory = "ERR_DIR_LIST_FAILED"
-	NoFoldersMounted       = "ERR_NO_FOLDER_MOUNTED"
-	FileIOFailed           = "ERR_FILE_IO_FAILED"
+	JSONDecodeFailed        = "ERR_JSON_DECODE_FAILED"
+	JSONEncodeFailed        = "ERR_JSON_ENCODE_FAILED"
+	MissingUserCookie       = "ERR_MISSING_USER_COOKIE"
+	MissingParameters       = "ERR_MISSING_PARAMETERS"
+	AccessDenied            = "ERR_ACCESS_DENIED"
+	InvalidURL              = "ERR_INVALID_URL"
+	UnknownParameter        = "ERR_UNKNOWN_PARAMETER"
+	AgentNotFound           = "ERR_AGENT_NOT_FOUND"
+	NoAgentsAvailable       = "ERR_NO_AGENTS_AVAILABLE"
+	FailedListingDirectory  = "ERR_DIR_LIST_FAILED"
+	NoFoldersMounted        = "ERR_NO_FOLDER_MOUNTED"
+	FileIOFailed            = "ERR_FILE_IO_FAILED"
+	InvalidVendorConstraint = "ERR_INVALID_VENDOR_CONSTRAINT"
+	APIRemoved              = "ERR_API_REMOVED"
+	TooManyRequests         = "ERR_TOO_MANY_REQUESTS"
+	RequestTooLarge         = "ERR_REQUEST_TOO_LARGE"
+	InvalidPassword         = "ERR_INVALID_PASSWORD"
 
 	// Database related
 	PersistFailed  = "ERR_DATABASE_PERSIST_FAILED"
@@ -0,0 +1,112 @@
+package inet
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/djavorszky/ddn/common/errs"
+	"github.com/djavorszky/ddn/common/status"
+)
+
+// Failure is the body of every failed call to the server's or the agents'
+// APIs. It is understood by clients of all of them: "status" and "message"
+// are where clients of v1 and of the agents look, "success" and "error" are
+// where v2 clients do.
+type Failure struct {
+	// HTTPStatus is the status the response was sent with.
+	HTTPStatus int `json:"http_status"`
+
+	// Status is one of the client or server errors of common/status, which
+	// stays the same as long as the cause of the failure does.
+	Status int `json:"status"`
+
+	// Message is one of the codes in common/errs, or a description of what
+	// went wrong for the failures not covered by one.
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+// errStatus holds the statuses of the common/errs codes that have a more
+// specific one than what their HTTP status maps to.
+var errStatus = map[string]int{
+	errs.JSONDecodeFailed:  status.InvalidJSON,
+	errs.MissingParameters: status.MissingParameters,
+	errs.MissingUserCookie: status.MissingParameters,
+	errs.AccessDenied:      status.AccessDenied,
+	errs.AgentNotFound:     status.NotFound,
+	errs.QueryNoResults:    status.NotFound,
+	errs.APIRemoved:        status.Gone,
+	errs.TooManyRequests:   status.TooManyRequests,
+	errs.RequestTooLarge:   status.RequestTooLarge,
+	errs.NoAgentsAvailable: status.ServiceUnavailable,
+	errs.CreateFailed:      status.CreateDatabaseFailed,
+	errs.ImportFailed:      status.ImportFailed,
+	errs.DropFailed:        status.DropDatabaseFailed,
+	errs.ExportFailed:      status.ExportFailed,
+}
+
+// NewFailure returns the failure with the common/errs code in message, and
+// the status matching it and httpStatus.
+func NewFailure(httpStatus int, message string, details ...string) Failure {
+	return Failure{
+		HTTPStatus: httpStatus,
+		Status:     statusOf(httpStatus, message),
+		Message:    message,
+		Details:    details,
+	}
+}
+
+// statusOf returns the status of the message if it has one and it's of the
+// same kind as httpStatus: a client error for 4xx, a server error for 5xx.
+// Otherwise, it's the one matching httpStatus.
+func statusOf(httpStatus int, message string) int {
+	clientErr := httpStatus < http.StatusInternalServerError
+
+	if s, ok := errStatus[message]; ok && clientErr == (s < status.ServerError) {
+		return s
+	}
+
+	switch httpStatus {
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return status.AccessDenied
+	case http.StatusNotFound:
+		return status.NotFound
+	case http.StatusGone:
+		return status.Gone
+	case http.StatusTooManyRequests:
+		return status.TooManyRequests
+	case http.StatusRequestEntityTooLarge:
+		return status.RequestTooLarge
+	case http.StatusServiceUnavailable:
+		return status.ServiceUnavailable
+	}
+
+	if clientErr {
+		return status.ClientError
+	}
+
+	return status.ServerError
+}
+
+// Marshal marshals the failure into json, along with the fields v2 clients
+// expect: "success" is false, and "error" holds the message followed by the
+// details.
+func (f Failure) Marshal() []byte {
+	b, _ := json.Marshal(struct {
+		Failure
+		Success bool     `json:"success"`
+		Error   []string `json:"error"`
+	}{
+		Failure: f,
+		Error:   append([]string{f.Message}, f.Details...),
+	})
+
+	return b
+}
+
+// Send writes the failure to w with its HTTP status.
+func (f Failure) Send(w http.ResponseWriter) {
+	WriteHeader(w, f.HTTPStatus)
+
+	w.Write(f.Marshal())
+}
@@ -0,0 +1,95 @@
+package inet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/djavorszky/ddn/common/errs"
+	"github.com/djavorszky/ddn/common/status"
+)
+
+func TestNewFailure(t *testing.T) {
+	tests := []struct {
+		httpStatus int
+		message    string
+		want       int
+	}{
+		{http.StatusBadRequest, errs.MissingParameters, status.MissingParameters},
+		{http.StatusBadRequest, errs.JSONDecodeFailed, status.InvalidJSON},
+		{http.StatusForbidden, errs.AccessDenied, status.AccessDenied},
+		{http.StatusNotFound, errs.QueryNoResults, status.NotFound},
+		{http.StatusBadRequest, errs.AgentNotFound, status.NotFound},
+		{http.StatusGone, errs.APIRemoved, status.Gone},
+		{http.StatusTooManyRequests, errs.TooManyRequests, status.TooManyRequests},
+		{http.StatusRequestEntityTooLarge, "ERR_SOMETHING_NEW", status.RequestTooLarge},
+		{http.StatusServiceUnavailable, errs.NoAgentsAvailable, status.ServiceUnavailable},
+		{http.StatusInternalServerError, errs.CreateFailed, status.CreateDatabaseFailed},
+		{http.StatusInternalServerError, errs.QueryFailed, status.ServerError},
+		{http.StatusBadRequest, errs.UnknownParameter, status.ClientError},
+		{http.StatusForbidden, "ERR_SOMETHING_NEW", status.AccessDenied},
+
+		// The status of the code is only used if it's the same kind of error
+		{http.StatusBadRequest, errs.ImportFailed, status.ClientError},
+		{http.StatusServiceUnavailable, errs.AgentNotFound, status.ServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		f := NewFailure(tt.httpStatus, tt.message)
+
+		if f.Status != tt.want {
+			t.Errorf("NewFailure(%d, %q): expected status %d, got %d", tt.httpStatus, tt.message, tt.want, f.Status)
+		}
+	}
+}
+
+func TestSendFailure(t *testing.T) {
+	w := httptest.NewRecorder()
+	SendFailure(w, http.StatusNotFound, errs.QueryNoResults, "12")
+
+	var body struct {
+		Success    bool     `json:"success"`
+		Error      []string `json:"error"`
+		HTTPStatus int      `json:"http_status"`
+		Status     int      `json:"status"`
+		Message    string   `json:"message"`
+		Details    []string `json:"details"`
+	}
+
+	err := json.Unmarshal(w.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatalf("couldn't decode failure %s: %v", w.Body, err)
+	}
+
+	if w.Code != http.StatusNotFound || body.HTTPStatus != w.Code {
+		t.Errorf("expected the header and the body to have status 404, got %d and %d", w.Code, body.HTTPStatus)
+	}
+
+	if body.Success || body.Status != status.NotFound || body.Message != errs.QueryNoResults {
+		t.Errorf("unexpected failure %s", w.Body)
+	}
+
+	if !reflect.DeepEqual(body.Details, []string{"12"}) || !reflect.DeepEqual(body.Error, []string{errs.QueryNoResults, "12"}) {
+		t.Errorf("unexpected details in %s", w.Body)
+	}
+
+	// The failure is still understood by clients of the older APIs
+	var msg Message
+	json.Unmarshal(w.Body.Bytes(), &msg)
+
+	if msg.Status != status.NotFound || msg.Message != errs.QueryNoResults {
+		t.Errorf("expected the failure to decode as a Message, got %+v", msg)
+	}
+
+	w = httptest.NewRecorder()
+	SendFailure(w, http.StatusServiceUnavailable)
+
+	msg = Message{}
+	json.Unmarshal(w.Body.Bytes(), &msg)
+
+	if w.Code != http.StatusServiceUnavailable || msg.Message != http.StatusText(http.StatusServiceUnavailable) {
+		t.Errorf("expected a failure without a code to be sent, got %d: %s", w.Code, w.Body)
+	}
+}
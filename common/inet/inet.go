@@ -71,11 +71,7 @@ func GetResponseCode(url string) int {
 		}
 	}()
 
-	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-		url = "http://" + url
-	}
-
-	resp, err := http.Get(url)
+	resp, err := http.Get(WithScheme(url))
 	if err != nil {
 		return 0
 	}
@@ -99,6 +95,9 @@ type Response struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   []string    `json:"error,omitempty"`
+
+	// Next is the cursor of the next page, if the data is a list that has more
+	Next string `json:"next,omitempty"`
 }
 
 // Marshal marshals the response into json
@@ -120,14 +119,26 @@ func SendSuccess(w http.ResponseWriter, status int, data interface{}) {
 	w.Write(r.Marshal())
 }
 
-// SendFailure creates a JSON response of a failed API call
-func SendFailure(w http.ResponseWriter, status int, errs ...string) {
+// SendPage creates a JSON response of a successful API call returning a page of
+// a list. If there are more pages, next is the cursor to get the following one with.
+func SendPage(w http.ResponseWriter, status int, data interface{}, next string) {
 	WriteHeader(w, status)
 
 	r := Response{
-		Success: false,
-		Error:   errs,
+		Success: true,
+		Data:    data,
+		Next:    next,
 	}
 
 	w.Write(r.Marshal())
 }
+
+// SendFailure creates a JSON response of a failed API call, with the
+// common/errs code in the first of errs, and the details of it in the rest.
+func SendFailure(w http.ResponseWriter, status int, errs ...string) {
+	if len(errs) == 0 {
+		errs = []string{http.StatusText(status)}
+	}
+
+	NewFailure(status, errs[0], errs[1:]...).Send(w)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 
 	"github.com/djavorszky/ddn/common/logger"
 	"github.com/djavorszky/ddn/common/status"
@@ -82,39 +83,36 @@ func (msg StructMessage) Compose() []byte {
 	return b
 }
 
-// ErrorResponse composes a Message with an 500 response code. It should be used
+// ErrorResponse composes a Failure with a 500 response code. It should be used
 // for situations where something went wrong on the server's side.
-func ErrorResponse() Message {
-	var errMsg Message
-
-	errMsg.Status = status.ServerError
-	errMsg.Message = "Something went wrong on the server."
-
-	return errMsg
+func ErrorResponse() Failure {
+	return Failure{
+		HTTPStatus: http.StatusInternalServerError,
+		Status:     status.ServerError,
+		Message:    "Something went wrong on the server.",
+	}
 }
 
-// ErrorJSONResponse composes a Message with a 400 response code. It should be used
+// ErrorJSONResponse composes a Failure with a 400 response code. It should be used
 // for situations where something was wrong with the JSON request
-func ErrorJSONResponse(err error) Message {
-	var msg Message
-
+func ErrorJSONResponse(err error) Failure {
 	logger.Error("json decode: %v", err)
 
-	msg.Status = status.InvalidJSON
-	msg.Message = fmt.Sprintf("Invalid JSON request, received error: %v", err)
-
-	return msg
+	return Failure{
+		HTTPStatus: http.StatusBadRequest,
+		Status:     status.InvalidJSON,
+		Message:    fmt.Sprintf("Invalid JSON request, received error: %v", err),
+	}
 }
 
-// InvalidResponse composes a Message with a 400 response code. It should be used
+// InvalidResponse composes a Failure with a 400 response code. It should be used
 // for situations where the request was invalid.
-func InvalidResponse() Message {
-	var msg Message
-
-	msg.Status = status.MissingParameters
-	msg.Message = "One or more required fields are missing from the call"
-
-	return msg
+func InvalidResponse() Failure {
+	return Failure{
+		HTTPStatus: http.StatusBadRequest,
+		Status:     status.MissingParameters,
+		Message:    "One or more required fields are missing from the call",
+	}
 }
 
 // Fireable is an empty interface. This way, custom structs can also be used. There
@@ -0,0 +1,181 @@
+package inet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DefaultScheme is prepended to addresses that don't specify one. It is
+// changed to https by UseTLS.
+var DefaultScheme = "http"
+
+// TLSConfig holds the certificates the server and the agents use to secure
+// the traffic between each other.
+type TLSConfig struct {
+	// CertFile and KeyFile are served to the clients, and presented to the
+	// other side when making requests, in case it asks for a certificate.
+	CertFile string
+	KeyFile  string
+
+	// RootCAFile is used to verify the certificate of the other side when
+	// making requests. If empty, the system's roots are used.
+	RootCAFile string
+
+	// ClientCAFile turns on mutual TLS: clients are asked for a certificate
+	// that is signed by one of its CAs.
+	ClientCAFile string
+}
+
+// Enabled returns whether TLS is configured.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// Mutual returns whether clients have to present a certificate.
+func (c TLSConfig) Mutual() bool {
+	return c.Enabled() && c.ClientCAFile != ""
+}
+
+// ServerConfig returns the configuration used to listen. If required is false,
+// clients without a certificate are allowed in, and it's left up to the
+// handlers to check the ones that need it.
+func (c TLSConfig) ServerConfig(required bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate failed: %s", err.Error())
+	}
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		conf.ClientCAs, err = loadCertPool(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+		if required {
+			conf.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return conf, nil
+}
+
+// ClientConfig returns the configuration used to make requests.
+func (c TLSConfig) ClientConfig() (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.Enabled() {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate failed: %s", err.Error())
+		}
+
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.RootCAFile != "" {
+		pool, err := loadCertPool(c.RootCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		conf.RootCAs = pool
+	}
+
+	return conf, nil
+}
+
+// UseTLS makes the default HTTP client, which is used by the helpers of this
+// package and for sending notifications, use the configuration for its requests,
+// and addresses without a scheme default to https.
+func UseTLS(c TLSConfig) error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	conf, err := c.ClientConfig()
+	if err != nil {
+		return err
+	}
+
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("default transport is not an *http.Transport")
+	}
+
+	transport.TLSClientConfig = conf
+	DefaultScheme = "https"
+
+	return nil
+}
+
+// ListenAndServe serves the handler on the address, over TLS if it is
+// configured. Client certificates are required if required is true and
+// a client CA is set.
+func ListenAndServe(addr string, handler http.Handler, c TLSConfig, required bool) error {
+	if !c.Enabled() {
+		return http.ListenAndServe(addr, handler)
+	}
+
+	conf, err := c.ServerConfig(required)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: conf,
+	}
+
+	return server.ListenAndServeTLS("", "")
+}
+
+// WithScheme prepends the DefaultScheme to the address if it doesn't have one.
+func WithScheme(addr string) string {
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		return addr
+	}
+
+	return DefaultScheme + "://" + addr
+}
+
+// PeerNames returns the common name and the DNS names of the verified
+// certificate the client presented, or nil if it didn't present one.
+func PeerNames(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+
+	names := make([]string, 0, len(cert.DNSNames)+1)
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+
+	return append(names, cert.DNSNames...)
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA file failed: %s", err.Error())
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,147 @@
+package inet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCert creates a certificate signed by the parent, or a self-signed CA if
+// parent is nil, and writes it and its key to dir.
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key failed: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("creating certificate failed: %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshalling key failed: %v", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	cert, _ := x509.ParseCertificate(der)
+
+	return cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddn-tls")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "server", ca, caKey)
+	writeCert(t, dir, "mysql-57", ca, caKey)
+
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	serverConf := TLSConfig{CertFile: file("server.crt"), KeyFile: file("server.key"), ClientCAFile: file("ca.crt")}
+
+	tlsConf, err := serverConf.ServerConfig(true)
+	if err != nil {
+		t.Fatalf("ServerConfig failed: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Join(PeerNames(r), ",")))
+	}))
+	srv.TLS = tlsConf
+	srv.StartTLS()
+	defer srv.Close()
+
+	agentConf := TLSConfig{CertFile: file("mysql-57.crt"), KeyFile: file("mysql-57.key"), RootCAFile: file("ca.crt")}
+
+	clientConf, err := agentConf.ClientConfig()
+	if err != nil {
+		t.Fatalf("ClientConfig failed: %v", err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConf}}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request with client certificate failed: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "mysql-57,localhost" {
+		t.Errorf("unexpected peer names: %q", body)
+	}
+
+	// Without a client certificate, the handshake fails
+	clientConf, _ = TLSConfig{RootCAFile: file("ca.crt")}.ClientConfig()
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: clientConf}}
+
+	_, err = client.Get(srv.URL)
+	if err == nil {
+		t.Errorf("expected request without client certificate to fail")
+	}
+
+	// Without the CA, the server's certificate is not trusted
+	clientConf, _ = TLSConfig{}.ClientConfig()
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: clientConf}}
+
+	_, err = client.Get(srv.URL)
+	if err == nil {
+		t.Errorf("expected request to an untrusted server to fail")
+	}
+}
+
+func TestWithScheme(t *testing.T) {
+	defer func(old string) { DefaultScheme = old }(DefaultScheme)
+
+	if got := WithScheme("localhost:7010"); got != "http://localhost:7010" {
+		t.Errorf("WithScheme = %q", got)
+	}
+
+	DefaultScheme = "https"
+
+	if got := WithScheme("localhost:7010"); got != "https://localhost:7010" {
+		t.Errorf("WithScheme = %q", got)
+	}
+
+	if got := WithScheme("http://localhost:7010"); got != "http://localhost:7010" {
+		t.Errorf("WithScheme changed the explicit scheme: %q", got)
+	}
+}
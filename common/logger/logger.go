@@ -1,9 +1,14 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // LogLevel is used to determine what to log.
@@ -38,41 +43,234 @@ const (
 // Level is to be used to control the log level of the application.
 var Level = INFO
 
+// Format is used to determine how the log lines are written.
+type Format int
+
+// The available formats. Text is the human readable one, with the fields
+// appended to the message. JSON and Logfmt write one object or line per
+// entry, with the time, level and message as fields.
+const (
+	Text Format = iota
+	JSON
+	Logfmt
+)
+
+func (f Format) String() string {
+	switch f {
+	case JSON:
+		return "json"
+	case Logfmt:
+		return "logfmt"
+	default:
+		return "text"
+	}
+}
+
+var format = Text
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "text":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	case "logfmt":
+		return Logfmt, nil
+	default:
+		return Text, fmt.Errorf("unknown log format: %s", name)
+	}
+}
+
+// SetFormat changes the format of the log lines. The structured formats add
+// the time themselves, so the flags of the standard logger are cleared for them.
+func SetFormat(f Format) {
+	format = f
+
+	if f == Text {
+		log.SetFlags(log.LstdFlags)
+	} else {
+		log.SetFlags(0)
+	}
+}
+
+// Entry holds key/value pairs that are added to every line logged with it.
+type Entry struct {
+	fields []interface{}
+}
+
+// With returns an Entry with the given key/value pairs, e.g.
+// logger.With("request_id", id, "vendor", vendor).Info("import started")
+func With(keyvals ...interface{}) Entry {
+	return Entry{}.With(keyvals...)
+}
+
+// With returns a copy of the Entry with the key/value pairs added.
+func (e Entry) With(keyvals ...interface{}) Entry {
+	fields := make([]interface{}, 0, len(e.fields)+len(keyvals)+1)
+	fields = append(fields, e.fields...)
+	fields = append(fields, keyvals...)
+
+	if len(keyvals)%2 != 0 {
+		// The last value has no key, so it gets one that stands out.
+		fields = append(fields[:len(fields)-1], "!BADKEY", fields[len(fields)-1])
+	}
+
+	return Entry{fields: fields}
+}
+
 // Fatal should be used to log a critical incident and exit the application
-func Fatal(msg string, args ...interface{}) {
+func (e Entry) Fatal(msg string, args ...interface{}) {
 	defer os.Exit(1)
 
-	log.Printf("[%s] %s", FATAL, fmt.Sprintf(msg, args...))
+	e.output(FATAL, fmt.Sprintf(msg, args...))
 }
 
 // Error should be used for application errors that should be resolved
-func Error(msg string, args ...interface{}) {
+func (e Entry) Error(msg string, args ...interface{}) {
 	if shouldLog(ERROR) {
-		log.Printf("[%s] %s", ERROR, fmt.Sprintf(msg, args...))
+		e.output(ERROR, fmt.Sprintf(msg, args...))
 	}
 }
 
 // Warn should be used for events that can be dangerous
-func Warn(msg string, args ...interface{}) {
+func (e Entry) Warn(msg string, args ...interface{}) {
 	if shouldLog(WARN) {
-		log.Printf("[%s]  %s", WARN, fmt.Sprintf(msg, args...))
+		e.output(WARN, fmt.Sprintf(msg, args...))
 	}
 }
 
 // Info should be used to share data.
-func Info(msg string, args ...interface{}) {
+func (e Entry) Info(msg string, args ...interface{}) {
 	if shouldLog(INFO) {
-		log.Printf("[%s]  %s", INFO, fmt.Sprintf(msg, args...))
+		e.output(INFO, fmt.Sprintf(msg, args...))
 	}
 }
 
 // Debug should be used for debugging purposes only.
-func Debug(msg string, args ...interface{}) {
+func (e Entry) Debug(msg string, args ...interface{}) {
 	if shouldLog(DEBUG) {
-		log.Printf("[%s] %s", DEBUG, fmt.Sprintf(msg, args...))
+		e.output(DEBUG, fmt.Sprintf(msg, args...))
 	}
 }
 
+// Fatal should be used to log a critical incident and exit the application
+func Fatal(msg string, args ...interface{}) {
+	defer os.Exit(1)
+
+	Entry{}.output(FATAL, fmt.Sprintf(msg, args...))
+}
+
+// Error should be used for application errors that should be resolved
+func Error(msg string, args ...interface{}) {
+	Entry{}.Error(msg, args...)
+}
+
+// Warn should be used for events that can be dangerous
+func Warn(msg string, args ...interface{}) {
+	Entry{}.Warn(msg, args...)
+}
+
+// Info should be used to share data.
+func Info(msg string, args ...interface{}) {
+	Entry{}.Info(msg, args...)
+}
+
+// Debug should be used for debugging purposes only.
+func Debug(msg string, args ...interface{}) {
+	Entry{}.Debug(msg, args...)
+}
+
+func (e Entry) output(lvl LogLevel, msg string) {
+	log.Print(e.format(lvl, msg, time.Now()))
+}
+
+// format returns the line to be logged in the current format.
+func (e Entry) format(lvl LogLevel, msg string, now time.Time) string {
+	var b bytes.Buffer
+
+	switch format {
+	case JSON:
+		b.WriteString(`{"time":`)
+		b.WriteString(jsonValue(now.Format(time.RFC3339Nano)))
+		b.WriteString(`,"level":`)
+		b.WriteString(jsonValue(lvl.String()))
+		b.WriteString(`,"msg":`)
+		b.WriteString(jsonValue(msg))
+
+		for i := 0; i < len(e.fields); i += 2 {
+			b.WriteString(",")
+			b.WriteString(jsonValue(fmt.Sprint(e.fields[i])))
+			b.WriteString(":")
+			b.WriteString(jsonValue(fieldValue(e.fields[i+1])))
+		}
+
+		b.WriteString("}")
+	case Logfmt:
+		b.WriteString("time=")
+		b.WriteString(now.Format(time.RFC3339Nano))
+		b.WriteString(" level=")
+		b.WriteString(lvl.String())
+		b.WriteString(" msg=")
+		b.WriteString(logfmtValue(msg))
+		e.writeLogfmt(&b)
+	default:
+		// The level is padded so that the messages line up
+		fmt.Fprintf(&b, "%-7s %s", "["+lvl.String()+"]", msg)
+		e.writeLogfmt(&b)
+	}
+
+	return b.String()
+}
+
+func (e Entry) writeLogfmt(b *bytes.Buffer) {
+	for i := 0; i < len(e.fields); i += 2 {
+		b.WriteString(" ")
+		b.WriteString(logfmtKey(fmt.Sprint(e.fields[i])))
+		b.WriteString("=")
+		b.WriteString(logfmtValue(fmt.Sprint(fieldValue(e.fields[i+1]))))
+	}
+}
+
+// fieldValue returns errors and Stringers as strings, and everything else as is.
+func fieldValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case fmt.Stringer:
+		return t.String()
+	default:
+		return v
+	}
+}
+
+func jsonValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+
+	return string(b)
+}
+
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+
+		return r
+	}, key)
+}
+
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\\\t\r\n") {
+		return strconv.Quote(value)
+	}
+
+	return value
+}
+
 func shouldLog(lvl LogLevel) bool {
 	if Level&lvl == Level {
 		return true
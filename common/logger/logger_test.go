@@ -1,7 +1,9 @@
 package logger
 
 import (
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestShouldLog(t *testing.T) {
@@ -26,3 +28,40 @@ func TestShouldLog(t *testing.T) {
 		}
 	}
 }
+
+func TestFormat(t *testing.T) {
+	defer SetFormat(Text)
+
+	now := time.Date(2018, 3, 5, 10, 15, 0, 0, time.UTC)
+	e := With("request_id", "abc123", "id", 5).With("err", errors.New("it failed"), "odd")
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{Text, `[warn]  import failed request_id=abc123 id=5 err="it failed" !BADKEY=odd`},
+		{Logfmt, `time=2018-03-05T10:15:00Z level=warn msg="import failed" request_id=abc123 id=5 err="it failed" !BADKEY=odd`},
+		{JSON, `{"time":"2018-03-05T10:15:00Z","level":"warn","msg":"import failed","request_id":"abc123","id":5,"err":"it failed","!BADKEY":"odd"}`},
+	}
+
+	for _, test := range tests {
+		SetFormat(test.format)
+
+		if got := e.format(WARN, "import failed", now); got != test.want {
+			t.Errorf("%s:\n got %s\nwant %s", test.format, got, test.want)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"": Text, "text": Text, "JSON": JSON, "logfmt": Logfmt} {
+		got, err := ParseFormat(name)
+		if err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %s, %v, want %s", name, got, err, want)
+		}
+	}
+
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("ParseFormat should fail for unknown formats")
+	}
+}
@@ -0,0 +1,308 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is used to name the rotated files. It sorts the same way
+// alphabetically as it does chronologically.
+const backupTimeFormat = "2006-01-02_15-04-05"
+
+// RotateOptions control when a RotatingFile is rotated and how long the
+// rotated files are kept.
+type RotateOptions struct {
+	// MaxSize is the size in bytes above which the file is rotated. 0 means no limit.
+	MaxSize int64
+
+	// Daily rotates the file when the first line of a new day is written.
+	Daily bool
+
+	// Compress gzips the rotated files.
+	Compress bool
+
+	// MaxBackups is the number of rotated files to keep. 0 keeps all of them.
+	MaxBackups int
+}
+
+// RotatingFile is a log file that rotates itself. Writes are serialized, and
+// the file is swapped between two writes, so no lines are lost or split while
+// rotating. Rotated files are compressed and cleaned up in the background.
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// now is replaced in tests
+	now func() time.Time
+
+	// cleanup is used to wait for the background work on Close, and
+	// cleanupMu to run one of them at a time
+	cleanup   sync.WaitGroup
+	cleanupMu sync.Mutex
+}
+
+// OpenRotatingFile opens the file at path for appending, and rotates it
+// according to the options as lines are written.
+func OpenRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, opts: opts, now: time.Now}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("opening log file failed: %s", err.Error())
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("checking log file failed: %s", err.Error())
+	}
+
+	f.file = file
+	f.size = fi.Size()
+	f.opened = f.now()
+
+	// A file left over from a previous run belongs to the day it was last written.
+	if f.size > 0 {
+		f.opened = fi.ModTime()
+	}
+
+	return nil
+}
+
+// Write writes the line to the file, rotating it first if it's due.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, fmt.Errorf("log file %s is closed", f.path)
+	}
+
+	if f.due(int64(len(p))) {
+		err := f.rotate()
+		if err != nil {
+			// Keep logging to the current file rather than losing the line
+			fmt.Fprintf(os.Stderr, "rotating log file failed: %v\n", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// due returns whether the file has to be rotated before writing n more bytes.
+func (f *RotatingFile) due(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+
+	if f.opts.MaxSize > 0 && f.size+n > f.opts.MaxSize {
+		return true
+	}
+
+	if f.opts.Daily {
+		y1, m1, d1 := f.opened.Date()
+		y2, m2, d2 := f.now().Date()
+
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+
+	return false
+}
+
+// Rotate rotates the file regardless of its size or age.
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.rotate()
+}
+
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return fmt.Errorf("closing log file failed: %s", err.Error())
+	}
+
+	backup := f.backupName()
+
+	renameErr := os.Rename(f.path, backup)
+
+	// The file has to be reopened even if renaming failed, otherwise nothing
+	// could be logged anymore.
+	err = f.open()
+	if err != nil {
+		f.file = nil
+		return err
+	}
+
+	if renameErr != nil {
+		return fmt.Errorf("renaming log file failed: %s", renameErr.Error())
+	}
+
+	f.cleanup.Add(1)
+	go func() {
+		defer f.cleanup.Done()
+
+		f.compressAndPrune(backup)
+	}()
+
+	return nil
+}
+
+// backupName returns a name for the rotated file that isn't taken yet.
+func (f *RotatingFile) backupName() string {
+	name := fmt.Sprintf("%s.%s", f.path, f.now().Format(backupTimeFormat))
+
+	backup := name
+	for i := 1; exists(backup) || exists(backup+".gz"); i++ {
+		backup = fmt.Sprintf("%s.%d", name, i)
+	}
+
+	return backup
+}
+
+func (f *RotatingFile) compressAndPrune(backup string) {
+	f.cleanupMu.Lock()
+	defer f.cleanupMu.Unlock()
+
+	if f.opts.Compress {
+		err := gzipFile(backup)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "compressing rotated log file failed: %v\n", err)
+		}
+	}
+
+	if f.opts.MaxBackups > 0 {
+		err := f.prune()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "removing old log files failed: %v\n", err)
+		}
+	}
+}
+
+// prune removes the oldest rotated files above MaxBackups.
+func (f *RotatingFile) prune() error {
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > f.opts.MaxBackups {
+		err = os.Remove(backups[0])
+		if err != nil {
+			return err
+		}
+
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// backups returns the rotated files from the oldest to the newest.
+func (f *RotatingFile) backups() ([]string, error) {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := f.path + "."
+
+	var backups []string
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, prefix), ".gz")
+		if len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+
+		_, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)])
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, m)
+	}
+
+	sort.Strings(backups)
+
+	return backups, nil
+}
+
+// Close closes the file, after waiting for the rotated ones to be compressed.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.cleanup.Wait()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	src.Close()
+
+	return os.Remove(path)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,155 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileSize(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ddn-rotate")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "ddn.log")
+
+	f, err := OpenRotatingFile(path, RotateOptions{MaxSize: 100, Compress: true})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+
+	now := time.Date(2018, 3, 1, 10, 0, 0, 0, time.Local)
+	f.now = func() time.Time { return now }
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < 50; i++ {
+				fmt.Fprintf(f, "writer %d line %d\n", w, i)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatalf("listing backups failed: %v", err)
+	}
+
+	if len(backups) == 0 {
+		t.Fatalf("expected the file to be rotated")
+	}
+
+	lines := readLines(t, path)
+	for _, b := range backups {
+		if !strings.HasSuffix(b, ".gz") {
+			t.Errorf("expected %s to be compressed", b)
+		}
+
+		lines = append(lines, readLines(t, b)...)
+	}
+
+	if len(lines) != 200 {
+		t.Errorf("expected 200 lines, got %d", len(lines))
+	}
+
+	seen := make(map[string]bool)
+	for _, l := range lines {
+		if !strings.HasPrefix(l, "writer ") || seen[l] {
+			t.Errorf("unexpected or duplicated line %q", l)
+		}
+		seen[l] = true
+	}
+}
+
+func TestRotatingFileDaily(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ddn-rotate")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, "ddn.log")
+
+	f, err := OpenRotatingFile(path, RotateOptions{Daily: true, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+
+	now := time.Date(2018, 3, 1, 23, 59, 0, 0, time.Local)
+	f.now = func() time.Time { return now }
+	f.opened = now
+
+	for day := 0; day < 4; day++ {
+		fmt.Fprintf(f, "day %d\n", day)
+		fmt.Fprintf(f, "day %d again\n", day)
+
+		now = now.Add(24 * time.Hour)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatalf("listing backups failed: %v", err)
+	}
+
+	want := []string{path + ".2018-03-03_23-59-00", path + ".2018-03-04_23-59-00"}
+	if strings.Join(backups, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected backups %v, got %v", want, backups)
+	}
+
+	if got := readLines(t, backups[1]); len(got) != 2 || got[0] != "day 2" {
+		t.Errorf("unexpected content of %s: %q", backups[1], got)
+	}
+
+	if got := readLines(t, path); len(got) != 2 || got[0] != "day 3" {
+		t.Errorf("unexpected content of %s: %q", path, got)
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening %s failed: %v", path, err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("opening %s failed: %v", path, err)
+		}
+		r = zr
+	}
+
+	var lines []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+
+	return lines
+}
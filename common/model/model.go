@@ -3,7 +3,8 @@ package model
 import (
 	"encoding/json"
 	"fmt"
-	"strings"
+	"net/http"
+	"time"
 
 	"github.com/djavorszky/ddn/common/inet"
 	"github.com/djavorszky/ddn/common/status"
@@ -12,6 +13,10 @@ import (
 	webpush "github.com/sherclockholmes/webpush-go"
 )
 
+// ExportCompleted starts the message an agent reports once the export of a
+// database completed, followed by the name of the dump it can be downloaded as.
+const ExportCompleted = "Export completed:"
+
 // DBRequest is used to represent JSON call about creating, dropping or importing databases
 type DBRequest struct {
 	ID           int    `json:"id"`
@@ -19,12 +24,20 @@ type DBRequest struct {
 	DumpLocation string `json:"dumpfile_location"`
 	Username     string `json:"username"`
 	Password     string `json:"password"`
+
+	// RequestID identifies the request that started the job in the logs of
+	// both the server and the agent.
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // ClientRequest is used to represent a JSON call between a client and the server
 type ClientRequest struct {
 	AgentIdentifier string `json:"agent_identifier"`
 	RequesterEmail  string `json:"requester_email"`
+
+	// Vendor is used to let the server choose the agent if no identifier is
+	// given. It can contain a version constraint, e.g. mysql>=5.7
+	Vendor string `json:"vendor"`
 	DBRequest
 }
 
@@ -66,6 +79,80 @@ type Agent struct {
 	Address    string `json:"agent_address"`
 	Token      string `json:"agent_token"`
 	Up         bool   `json:"agent_up"`
+
+	// Capacity is the latest capacity report of the agent, if it sent any.
+	Capacity *Capacity `json:"capacity,omitempty"`
+
+	// requestID is sent along with the requests to the agent, see WithRequestID
+	requestID string
+}
+
+// Capacity is sent by the agent along with its heartbeat to tell the server
+// how much room it has left.
+type Capacity struct {
+	Disks         []DiskUsage    `json:"disks"`
+	Databases     []DatabaseSize `json:"databases"`
+	Connections   int            `json:"connections"`
+	ServerVersion string         `json:"server_version"`
+	Reported      time.Time      `json:"reported"`
+}
+
+// DiskUsage holds the size and free space of the filesystem a directory of the agent is on.
+type DiskUsage struct {
+	Label string `json:"label"`
+	Path  string `json:"path"`
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+}
+
+// UsedPercent returns how full the disk is, in percents.
+func (d DiskUsage) UsedPercent() float64 {
+	if d.Total == 0 {
+		return 0
+	}
+
+	return float64(d.Total-d.Free) / float64(d.Total) * 100
+}
+
+// HumanFree returns the free space in a human readable format.
+func (d DiskUsage) HumanFree() string {
+	return humanBytes(d.Free)
+}
+
+// HumanTotal returns the size of the disk in a human readable format.
+func (d DiskUsage) HumanTotal() string {
+	return humanBytes(d.Total)
+}
+
+// DatabaseSize holds the size of a database on the agent in bytes.
+type DatabaseSize struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// HumanSize returns the size of the database in a human readable format.
+func (d DatabaseSize) HumanSize() string {
+	if d.Size < 0 {
+		return humanBytes(0)
+	}
+
+	return humanBytes(uint64(d.Size))
+}
+
+// humanBytes formats a size in bytes using binary units, e.g. 1.5 GiB.
+func humanBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
 }
 
 // PushSubscription is used to represent a subscription for web push notifications
@@ -135,13 +222,51 @@ func (a Agent) DropDatabase(id int, dbname, dbuser string) (string, error) {
 	return a.executeAction(dbreq, "drop-database")
 }
 
-func (a Agent) executeAction(dbreq DBRequest, endpoint string) (string, error) {
-	dest := fmt.Sprintf("%s:%s/%s", a.Address, a.AgentPort, endpoint)
+// listClient is used to list the databases of agents. Callers may hold locks
+// while waiting for it, so an agent that doesn't answer must not hang them.
+var listClient = &http.Client{Timeout: time.Minute}
+
+// ListDatabases asks the agent for the databases that exist on its server.
+func (a Agent) ListDatabases() ([]string, error) {
+	dest := a.endpoint("list-databases")
+
+	resp, err := listClient.Get(dest)
+	if err != nil {
+		return nil, fmt.Errorf("listing databases failed: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	var msg inet.ListMessage
 
-	if !strings.HasPrefix(dest, "http://") && !strings.HasPrefix(dest, "https://") {
-		dest = fmt.Sprintf("http://%s", dest)
+	err = json.NewDecoder(resp.Body).Decode(&msg)
+	if err != nil {
+		return nil, fmt.Errorf("decoding list of databases failed: %s", err.Error())
 	}
 
+	if msg.Status != status.Success {
+		return nil, fmt.Errorf("agent issue: listing databases failed with status %d", msg.Status)
+	}
+
+	return msg.Message, nil
+}
+
+// WithRequestID returns a copy of the agent that sends the request ID along
+// with the requests, so that the agent logs them with the same ID.
+func (a Agent) WithRequestID(id string) Agent {
+	a.requestID = id
+	return a
+}
+
+// endpoint returns the address of the agent's endpoint.
+func (a Agent) endpoint(endpoint string) string {
+	return inet.WithScheme(fmt.Sprintf("%s:%s/%s", a.Address, a.AgentPort, endpoint))
+}
+
+func (a Agent) executeAction(dbreq DBRequest, endpoint string) (string, error) {
+	dest := a.endpoint(endpoint)
+
+	dbreq.RequestID = a.requestID
+
 	resp, err := notif.SndLoc(dbreq, dest)
 	if err != nil && resp == "" {
 		return "", fmt.Errorf("sending json message failed: %s", err.Error())
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListDatabasesTimeout(t *testing.T) {
+	defer func(old time.Duration) { listClient.Timeout = old }(listClient.Timeout)
+	listClient.Timeout = 50 * time.Millisecond
+
+	release := make(chan struct{})
+
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+
+	// Closing the server waits for the handler, so it's released first
+	defer close(release)
+
+	i := strings.LastIndex(hung.URL, ":")
+	agent := Agent{Address: hung.URL[:i], AgentPort: hung.URL[i+1:]}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := agent.ListDatabases()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expected listing the databases of a hung agent to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("listing the databases of a hung agent didn't time out")
+	}
+}
@@ -1,26 +1,107 @@
 package srv
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/djavorszky/ddn/common/logger"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// Logger logs queries to the log with some extra information
+// RequestIDHeader is the header the request ID is read from and written to.
+// Agents reporting back through upd8 send it in the request_id parameter instead.
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+var requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "ddn",
+	Name:      "http_request_duration_seconds",
+	Help:      "Time it took to serve HTTP requests, by route, method and status code.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"handler", "method", "code"})
+
+func init() {
+	prometheus.MustRegister(requestDuration)
+}
+
+// Logger logs queries to the log with some extra information, and
+// records how long they took to serve. Each request gets an ID, which
+// is returned in the X-Request-ID header and can be retrieved with RequestID.
 func Logger(inner http.Handler, handler string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		inner.ServeHTTP(w, r)
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = r.URL.Query().Get("request_id")
+		}
+		if id == "" {
+			id = NewRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		inner.ServeHTTP(rec, r)
+
+		elapsed := time.Since(start)
+
+		requestDuration.WithLabelValues(handler, r.Method, strconv.Itoa(rec.status)).Observe(elapsed.Seconds())
 
 		if strings.HasPrefix(r.RequestURI, "/alive") ||
 			r.RequestURI == "/heartbeat" {
 			return
 		}
 
-		logger.Debug("[%s]\t%s\t%s\t%s\t",
-			r.RemoteAddr, r.Method, r.RequestURI, time.Since(start))
+		logger.With(
+			"request_id", id,
+			"remote", r.RemoteAddr,
+			"method", r.Method,
+			"uri", r.RequestURI,
+			"handler", handler,
+			"status", rec.status,
+			"duration_ms", float64(elapsed)/float64(time.Millisecond),
+		).Debug("request served")
 	})
 }
+
+// RequestID returns the ID of the request set by Logger, or an empty string
+// if the request didn't go through it.
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// NewRequestID returns a random ID to be used for requests or jobs that are
+// not started by an HTTP request.
+func NewRequestID() string {
+	b := make([]byte, 8)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder remembers the status code written to the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
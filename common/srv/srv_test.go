@@ -0,0 +1,48 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoggerRequestID(t *testing.T) {
+	var seen string
+	handler := Logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r)
+		w.WriteHeader(http.StatusTeapot)
+	}), "test")
+
+	tests := []struct {
+		name   string
+		header string
+		url    string
+		want   string
+	}{
+		{"header", "from-header", "/test", "from-header"},
+		{"query", "", "/upd8?request_id=from-query", "from-query"},
+		{"generated", "", "/test", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.header != "" {
+			req.Header.Set(RequestIDHeader, tt.header)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusTeapot {
+			t.Errorf("%s: status not passed on: %d", tt.name, rec.Code)
+		}
+
+		if seen == "" || rec.Header().Get(RequestIDHeader) != seen {
+			t.Errorf("%s: handler saw %q, response has %q", tt.name, seen, rec.Header().Get(RequestIDHeader))
+		}
+
+		if tt.want != "" && seen != tt.want {
+			t.Errorf("%s: got request ID %q, want %q", tt.name, seen, tt.want)
+		}
+	}
+}
@@ -34,6 +34,11 @@ func init() {
 	Labels[MultipleFilesInArchive] = "Archive contains multiple files"
 	Labels[MissingParameters] = "Missing Parameters"
 	Labels[InvalidJSON] = "Invalid JSON Request"
+	Labels[ArchiveRejected] = "Archive rejected"
+	Labels[AccessDenied] = "Access denied"
+	Labels[Gone] = "Gone"
+	Labels[TooManyRequests] = "Too many requests"
+	Labels[RequestTooLarge] = "Request too large"
 
 	// Server Error
 	Labels[ServerError] = "Server Error"
@@ -45,6 +50,7 @@ func init() {
 	Labels[ListDatabaseFailed] = "Listing databases failed"
 	Labels[DropDatabaseFailed] = "Dropping database failed"
 	Labels[ZippingDumpFailed] = "Zipping dump failed"
+	Labels[ServiceUnavailable] = "Service unavailable"
 
 	// Warnings
 	Labels[DropInProgress] = "Drop in progress"
@@ -87,6 +93,11 @@ const (
 	MultipleFilesInArchive int = 204 // status.MultipleFilesInArchive
 	MissingParameters      int = 205 // status.MissingParameters
 	InvalidJSON            int = 206 // status.InvalidJSON
+	ArchiveRejected        int = 207 // status.ArchiveRejected
+	AccessDenied           int = 208 // status.AccessDenied
+	Gone                   int = 209 // status.Gone
+	TooManyRequests        int = 210 // status.TooManyRequests
+	RequestTooLarge        int = 211 // status.RequestTooLarge
 )
 
 // Server errors are used to convey that something went wrong
@@ -103,6 +114,7 @@ const (
 	DeleteSubscriptionFailed int = 309 // status.DeleteSubscriptionFailed
 	ExportFailed             int = 310 // status.ExportFailed
 	ZippingDumpFailed        int = 311 // status.ZippingDumpFailed
+	ServiceUnavailable       int = 312 // status.ServiceUnavailable
 )
 
 // Warnings are for issuing warnings.
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/djavorszky/ddn/client"
+	"github.com/djavorszky/ddn/common/inet"
+	"github.com/djavorszky/ddn/common/model"
+	vis "github.com/djavorszky/ddn/common/visibility"
+	"github.com/djavorszky/ddn/server/database/data"
+)
+
+// waitInterval is how often the status of the database is checked while waiting.
+const waitInterval = 3 * time.Second
+
+func runAgents(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error {
+	active := flags.Bool("active", false, "Only list the agents that are up.")
+	flags.Parse(args)
+
+	list := c.Agents
+	if *active {
+		list = c.ActiveAgents
+	}
+
+	agents, err := list(ctx)
+	if err != nil {
+		return err
+	}
+
+	return output(agents, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tVENDOR\tVERSION\tDATABASE\tUP")
+
+		for _, a := range agents {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s:%s\t%v\n", a.ShortName, a.DBVendor, a.Version, a.DBAddr, a.DBPort, a.Up)
+		}
+
+		tw.Flush()
+	})
+}
+
+func runList(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error {
+	var q client.DatabaseQuery
+
+	flags.StringVar(&q.Vendor, "vendor", "", "Only databases of this vendor.")
+	flags.StringVar(&q.Agent, "agent", "", "Only databases on this agent.")
+	flags.StringVar(&q.Creator, "creator", "", "Only databases created by this user.")
+	flags.StringVar(&q.Name, "name", "", "Only databases whose name contains this.")
+	flags.StringVar(&q.Sort, "sort", "", "Field to sort by, prefixed with - for descending, e.g. -expirydate.")
+	flags.IntVar(&q.Limit, "limit", 0, "Only list this many, and print the cursor of the rest.")
+	flags.StringVar(&q.Cursor, "cursor", "", "Continue listing from where the previous -limit stopped.")
+	statuses := flags.String("status", "", "Comma separated list of status codes.")
+	flags.Parse(args)
+
+	if *statuses != "" {
+		for _, s := range strings.Split(*statuses, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("-status: %q is not a status code", s)
+			}
+
+			q.Status = append(q.Status, code)
+		}
+	}
+
+	var rows []data.Row
+	for {
+		page, next, err := c.Databases(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		rows = append(rows, page...)
+
+		if next == "" {
+			break
+		}
+
+		if q.Limit > 0 {
+			progress("More databases: -cursor %s", next)
+			break
+		}
+
+		q.Cursor = next
+	}
+
+	return output(rows, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tVENDOR\tAGENT\tSTATUS\tCREATOR\tEXPIRES")
+
+		for _, row := range rows {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", row.ID, row.DBName, row.DBVendor, row.AgentName, row.StatusLabel(), row.Creator, row.ExpiryDate.Format("2006-01-02"))
+		}
+
+		tw.Flush()
+	})
+}
+
+func runShow(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error {
+	flags.Parse(args)
+
+	row, err := databaseArg(ctx, c, flags)
+	if err != nil {
+		return err
+	}
+
+	return output(row, func(w io.Writer) { printRow(w, row) })
+}
+
+// createFlags registers the flags shared by create and import.
+func createFlags(flags *flag.FlagSet) (*model.ClientRequest, *bool) {
+	var req model.ClientRequest
+
+	flags.StringVar(&req.AgentIdentifier, "agent", "", "Agent to create the database on.")
+	flags.StringVar(&req.Vendor, "vendor", "", "Let the server choose an agent of this vendor, e.g. mysql or \"mysql>=5.7\".")
+	flags.StringVar(&req.DatabaseName, "name", "", "Name of the database. Generated if empty.")
+	flags.StringVar(&req.Username, "user", "", "User of the database. Generated if empty.")
+	flags.StringVar(&req.Password, "password", "", "Password of the user. Generated if empty.")
+	public := flags.Bool("public", false, "Make the database visible to everyone.")
+
+	return &req, public
+}
+
+func runCreate(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error {
+	req, public := createFlags(flags)
+	flags.Parse(args)
+
+	db, err := c.Create(ctx, *req)
+	if err != nil {
+		return err
+	}
+
+	if *public {
+		err = setPublic(ctx, c, &db.Row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return output(db, func(w io.Writer) { printRow(w, db.Row) })
+}
+
+func runImport(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error {
+	req, public := createFlags(flags)
+	url := flags.String("url", "", "URL of the dump to import.")
+	file := flags.String("file", "", "Local dump to upload and import.")
+	wait := flags.Bool("wait", false, "Wait until the import finishes.")
+	flags.Parse(args)
+
+	if (*url == "") == (*file == "") {
+		return fmt.Errorf("either -url or -file is required")
+	}
+
+	req.DumpLocation = *url
+
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("opening dump failed: %v", err)
+		}
+		defer f.Close()
+
+		progress("Uploading %s", *file)
+
+		req.DumpLocation, err = c.Upload(ctx, *file, f)
+		if err != nil {
+			return fmt.Errorf("uploading dump failed: %v", err)
+		}
+	}
+
+	db, err := c.Import(ctx, *req)
+	if err != nil {
+		return err
+	}
+
+	if *public {
+		err = setPublic(ctx, c, &db.Row)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *wait {
+		progress("Importing %s on %s", db.DBName, db.AgentName)
+
+		db.Row, err = c.Wait(ctx, db.ID, waitInterval)
+		if err != nil {
+			return fmt.Errorf("importing %s failed: %v", db.DBName, err)
+		}
+	}
+
+	return output(db, func(w io.Writer) { printRow(w, db.Row) })
+}
+
+func runWait(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error {
+	flags.Parse(args)
+
+	row, err := databaseArg(ctx, c, flags)
+	if err != nil {
+		return err
+	}
+
+	row, err = c.Wait(ctx, row.ID, waitInterval)
+	if err != nil {
+		return err
+	}
+
+	return output(row, func(w io.Writer) { printRow(w, row) })
+}
+
+func runAccess(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error {
+	flags.Parse(args)
+
+	row, err := databaseArg(ctx, c, flags)
+	if err != nil {
+		return err
+	}
+
+	access, err := c.AccessInfo(ctx, row.ID)
+	if err != nil {
+		return err
+	}
+
+	return output(access, func(w io.Writer) { fmt.Fprint(w, portalExt(access)) })
+}
+
+func runExtend(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error {
+	flags.Parse(args)
+
+	if flags.NArg() != 3 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	id, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("%q is not an id", flags.Arg(0))
+	}
+
+	amount, err := strconv.Atoi(flags.Arg(1))
+	if err != nil || amount <= 0 {
+		return fmt.Errorf("%q is not a positive number", flags.Arg(1))
+	}
+
+	expiry, err := c.ExtendExpiry(ctx, id, amount, flags.Arg(2))
+	if err != nil {
+		return err
+	}
+
+	return output(expiry, func(w io.Writer) { fmt.Fprintf(w, "Expires on %s\n", expiry.Format("2006-01-02 15:04")) })
+}
+
+// exportResult is printed once an export that was waited on completed.
+type exportResult struct {
+	Database data.Row `json:"database"`
+	Download string   `json:"download"`
+	Saved    string   `json:"saved,omitempty"`
+}
+
+func runExport(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error {
+	wait := flags.Bool("wait", false, "Wait until the export finishes, and print where to download it from.")
+	out := flags.String("o", "", "Wait until the export finishes, and download the dump to this file or folder.")
+	flags.Parse(args)
+
+	row, err := databaseArg(ctx, c, flags)
+	if err != nil {
+		return err
+	}
+
+	msg, err := c.Export(ctx, row.ID)
+	if err != nil {
+		return err
+	}
+
+	if !*wait && *out == "" {
+		return output(msg, func(w io.Writer) { fmt.Fprintln(w, msg) })
+	}
+
+	progress("Exporting %s on %s", row.DBName, row.AgentName)
+
+	row, err = c.Wait(ctx, row.ID, waitInterval)
+	if err != nil {
+		return fmt.Errorf("exporting %s failed: %v", row.DBName, err)
+	}
+
+	file, ok := exportFile(row)
+	if !ok {
+		return fmt.Errorf("export of %s finished without a dump: %s", row.DBName, row.Message)
+	}
+
+	agent, err := c.Agent(ctx, row.AgentName)
+	if err != nil {
+		return err
+	}
+
+	result := exportResult{
+		Database: row,
+		Download: inet.WithScheme(fmt.Sprintf("%s:%s/exports/%s", agent.Address, agent.AgentPort, file)),
+	}
+
+	if *out != "" {
+		result.Saved = *out
+		if info, err := os.Stat(*out); err == nil && info.IsDir() {
+			result.Saved = filepath.Join(*out, file)
+		}
+
+		progress("Downloading %s", result.Download)
+
+		err = download(ctx, c, result.Download, result.Saved)
+		if err != nil {
+			return err
+		}
+	}
+
+	return output(result, func(w io.Writer) {
+		if result.Saved != "" {
+			fmt.Fprintf(w, "Saved to %s\n", result.Saved)
+			return
+		}
+
+		fmt.Fprintln(w, result.Download)
+	})
+}
+
+func runDrop(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error {
+	wait := flags.Bool("wait", false, "Wait until the database is dropped.")
+	flags.Parse(args)
+
+	row, err := databaseArg(ctx, c, flags)
+	if err != nil {
+		return err
+	}
+
+	err = c.Drop(ctx, row.ID)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Started dropping %s", row.DBName)
+
+	if *wait {
+		_, err = c.Wait(ctx, row.ID, waitInterval)
+		if e, ok := err.(*client.Error); !ok || e.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("dropping %s failed: %v", row.DBName, err)
+		}
+
+		msg = fmt.Sprintf("Dropped %s", row.DBName)
+	}
+
+	return output(row, func(w io.Writer) { fmt.Fprintln(w, msg) })
+}
+
+// databaseArg returns the database named by the only argument, either by its
+// id or as agent/dbname.
+func databaseArg(ctx context.Context, c *client.Client, flags *flag.FlagSet) (data.Row, error) {
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	arg := flags.Arg(0)
+
+	if i := strings.Index(arg, "/"); i != -1 {
+		return c.DatabaseByName(ctx, arg[:i], arg[i+1:])
+	}
+
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return data.Row{}, fmt.Errorf("%q is neither an id, nor agent/dbname", arg)
+	}
+
+	return c.Database(ctx, id)
+}
+
+// setPublic makes the database visible to everyone.
+func setPublic(ctx context.Context, c *client.Client, row *data.Row) error {
+	err := c.SetVisibility(ctx, row.ID, true)
+	if err != nil {
+		return fmt.Errorf("making %s public failed: %v", row.DBName, err)
+	}
+
+	row.Public = vis.Public
+
+	return nil
+}
+
+func printRow(w io.Writer, row data.Row) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "ID:\t%d\n", row.ID)
+	fmt.Fprintf(tw, "Name:\t%s\n", row.DBName)
+	fmt.Fprintf(tw, "User:\t%s\n", row.DBUser)
+	fmt.Fprintf(tw, "Vendor:\t%s\n", row.DBVendor)
+	fmt.Fprintf(tw, "Agent:\t%s\n", row.AgentName)
+	fmt.Fprintf(tw, "Address:\t%s:%s\n", row.DBAddress, row.DBPort)
+	fmt.Fprintf(tw, "Status:\t%s\n", row.StatusLabel())
+
+	if row.Message != "" {
+		fmt.Fprintf(tw, "Message:\t%s\n", row.Message)
+	}
+
+	fmt.Fprintf(tw, "Creator:\t%s\n", row.Creator)
+	fmt.Fprintf(tw, "Created:\t%s\n", row.CreateDate.Format("2006-01-02 15:04"))
+	fmt.Fprintf(tw, "Expires:\t%s\n", row.ExpiryDate.Format("2006-01-02 15:04"))
+	fmt.Fprintf(tw, "Public:\t%v\n", row.Public == vis.Public)
+
+	tw.Flush()
+}
+
+// portalExt returns the properties to put into portal-ext.properties to connect
+// to the database. If Liferay 6.2 and earlier need a different URL, the
+// properties are listed for both.
+func portalExt(a client.Access) string {
+	property := func(key, value string) string {
+		if strings.HasPrefix(value, key+"=") {
+			return value + "\n"
+		}
+
+		return key + "=" + value + "\n"
+	}
+
+	props := func(url string) string {
+		return property("jdbc.default.driverClassName", a.JDBCDriver) +
+			property("jdbc.default.url", url) +
+			property("jdbc.default.username", a.User) +
+			property("jdbc.default.password", a.Password)
+	}
+
+	if a.JDBCUrl6210 == "" || a.JDBCUrl6210 == a.JDBCUrl {
+		return props(a.JDBCUrl)
+	}
+
+	return "# DXP\n" + props(a.JDBCUrl) + "\n# 6.2 EE and earlier\n" + props(a.JDBCUrl6210)
+}
+
+// exportFile returns the name of the dump of the database's latest export.
+func exportFile(row data.Row) (string, bool) {
+	if !strings.HasPrefix(row.Message, model.ExportCompleted) {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(row.Message, model.ExportCompleted)), true
+}
+
+// download saves the file at location to dest.
+func download(ctx context.Context, c *client.Client, location, dest string) error {
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return fmt.Errorf("creating request failed: %v", err)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("downloading dump failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading dump failed: %s", resp.Status)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("creating %s failed: %v", dest, err)
+	}
+
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		f.Close()
+		os.Remove(dest)
+
+		return fmt.Errorf("saving dump failed: %v", err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/djavorszky/ddn/client"
+	"github.com/djavorszky/ddn/common/status"
+	"github.com/djavorszky/ddn/server/database/data"
+)
+
+func TestPortalExt(t *testing.T) {
+	access := client.Access{
+		JDBCDriver: "jdbc.default.driverClassName=org.mariadb.jdbc.Driver",
+		JDBCUrl:    "jdbc.default.url=jdbc:mariadb://127.0.0.1:3306/mydb",
+		User:       "myuser",
+		Password:   "mypass",
+	}
+
+	want := `jdbc.default.driverClassName=org.mariadb.jdbc.Driver
+jdbc.default.url=jdbc:mariadb://127.0.0.1:3306/mydb
+jdbc.default.username=myuser
+jdbc.default.password=mypass
+`
+
+	if got := portalExt(access); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	access.JDBCUrl6210 = "jdbc:mysql://127.0.0.1:3306/mydb?useUnicode=true"
+
+	want = `# DXP
+jdbc.default.driverClassName=org.mariadb.jdbc.Driver
+jdbc.default.url=jdbc:mariadb://127.0.0.1:3306/mydb
+jdbc.default.username=myuser
+jdbc.default.password=mypass
+
+# 6.2 EE and earlier
+jdbc.default.driverClassName=org.mariadb.jdbc.Driver
+jdbc.default.url=jdbc:mysql://127.0.0.1:3306/mydb?useUnicode=true
+jdbc.default.username=myuser
+jdbc.default.password=mypass
+`
+
+	if got := portalExt(access); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestExportFile(t *testing.T) {
+	file, ok := exportFile(data.Row{Status: status.Success, Message: "Export completed:mydb_20180501.zip"})
+	if !ok || file != "mydb_20180501.zip" {
+		t.Errorf("expected the name of the dump, got %q, %v", file, ok)
+	}
+
+	_, ok = exportFile(data.Row{Status: status.Success, Message: "Completed"})
+	if ok {
+		t.Errorf("expected no dump for a database that wasn't exported")
+	}
+}
@@ -0,0 +1,146 @@
+// Command ddnctl manages databases through the API of the server, e.g.
+//
+//	ddnctl -server http://localhost:7010 -user me@example.com list
+//	ddnctl import -vendor mysql -file dump.sql -wait
+//
+// Run ddnctl without arguments for the list of commands.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/djavorszky/ddn/client"
+	"github.com/djavorszky/ddn/common/inet"
+)
+
+// command is a subcommand of ddnctl.
+type command struct {
+	// usage lists the flags and arguments of the command, help describes it.
+	usage string
+	help  string
+
+	// run registers the flags of the command in flags, and parses args with them.
+	run func(ctx context.Context, c *client.Client, flags *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]command{
+	"agents": {"[-active]", "List the agents.", runAgents},
+	"list":   {"[-vendor v] [-agent a] [-creator c] [-name n] [-status 100,101] [-sort field] [-limit n] [-cursor c]", "List the databases you can see, all of them unless limited.", runList},
+	"show":   {"id | agent/dbname", "Show a database.", runShow},
+	"create": {"[-agent a | -vendor v] [-name n] [-user u] [-password p] [-public]", "Create an empty database.", runCreate},
+	"import": {"[-agent a | -vendor v] [-name n] [-user u] [-password p] [-public] (-url url | -file path) [-wait]", "Import a dump from a URL, or upload and import a local file.", runImport},
+	"wait":   {"id", "Wait until the import or export of a database finishes.", runWait},
+	"access": {"id | agent/dbname", "Print the portal-ext.properties to connect to a database.", runAccess},
+	"extend": {"id amount days|months|years", "Extend the expiry of a database.", runExtend},
+	"export": {"[-wait] [-o file] id", "Export a database, and download the dump if -o is given.", runExport},
+	"drop":   {"[-wait] id", "Drop a database.", runDrop},
+}
+
+// jsonOutput is true if the results are printed as JSON instead of text.
+var jsonOutput bool
+
+func main() {
+	server := flag.String("server", os.Getenv("DDN_SERVER"), "Address of the server, e.g. http://localhost:7010. Defaults to $DDN_SERVER.")
+	user := flag.String("user", os.Getenv("DDN_USER"), "Your email address. Defaults to $DDN_USER.")
+	caFile := flag.String("ca", "", "CA certificate to verify the server with, if it's not signed by a known one.")
+	certFile := flag.String("cert", "", "Client certificate, if the server requires one.")
+	keyFile := flag.String("key", "", "Key of the client certificate.")
+	flag.BoolVar(&jsonOutput, "json", false, "Print the results as JSON.")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if *server == "" || *user == "" {
+		fmt.Fprintln(os.Stderr, "ddnctl: -server and -user (or $DDN_SERVER and $DDN_USER) are required")
+		os.Exit(2)
+	}
+
+	c := client.New(*server, *user)
+
+	if *caFile != "" || *certFile != "" {
+		tlsConf, err := inet.TLSConfig{CertFile: *certFile, KeyFile: *keyFile, RootCAFile: *caFile}.ClientConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ddnctl: %v\n", err)
+			os.Exit(1)
+		}
+
+		c.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}
+	}
+
+	err := cmd.run(context.Background(), c, newFlags(flag.Arg(0), cmd.usage), flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ddnctl %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: ddnctl [flags] command [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n    \t%s\n", name, commands[name].usage, commands[name].help)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+}
+
+// newFlags returns the flag set of a command, which prints its usage on errors.
+func newFlags(name, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: ddnctl %s %s\n", name, usage)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// output prints v as JSON if -json is given, with text otherwise.
+func output(v interface{}, text func(w io.Writer)) error {
+	if !jsonOutput {
+		text(os.Stdout)
+		return nil
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding output failed: %v", err)
+	}
+
+	fmt.Println(string(b))
+
+	return nil
+}
+
+// progress prints what's happening to stderr, unless the output is JSON.
+func progress(format string, args ...interface{}) {
+	if jsonOutput {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, format, args...)
+	fmt.Fprintln(os.Stderr)
+}
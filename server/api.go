@@ -5,17 +5,14 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
-	"time"
 
 	"github.com/djavorszky/ddn/common/errs"
 	"github.com/djavorszky/ddn/common/inet"
 	"github.com/djavorszky/ddn/common/logger"
 	"github.com/djavorszky/ddn/common/model"
 	"github.com/djavorszky/ddn/common/status"
-	"github.com/djavorszky/ddn/common/visibility"
 	"github.com/djavorszky/ddn/server/database/data"
 	"github.com/djavorszky/ddn/server/registry"
-	"github.com/djavorszky/liferay"
 	"github.com/djavorszky/sutils"
 	"github.com/gorilla/mux"
 )
@@ -56,51 +53,26 @@ func apiListAgents(w http.ResponseWriter, r *http.Request) {
 	inet.SendResponse(w, http.StatusOK, msg)
 }
 
-// apiListDatabases will list all databases
+// apiListDatabases will list the databases visible to the user, keyed by their ID.
 func apiListDatabases(w http.ResponseWriter, r *http.Request) {
 	user, err := getAPIUser(r)
 	if err != nil {
-		inet.SendResponse(w, http.StatusForbidden, inet.Message{
-			Status:  http.StatusForbidden,
-			Message: errs.AccessDenied,
-		})
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	// Get private ones
-	dbs, err := db.FetchByCreator(user)
+	rows, err := db.FetchRows(data.RowFilter{VisibleTo: user})
 	if err != nil {
-		if err != nil {
-			inet.SendResponse(w, http.StatusInternalServerError, inet.Message{
-				Status:  http.StatusInternalServerError,
-				Message: errs.QueryFailed,
-			})
-
-			logger.Error("Fetching private dbs failed: %v", err)
-			return
-		}
-	}
-
-	databases := make(map[int]data.Row)
-
-	for _, db := range dbs {
-		databases[db.ID] = db
-	}
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed)
 
-	// Get public ones
-	dbs, err = db.FetchPublic()
-	if err != nil {
-		inet.SendResponse(w, http.StatusInternalServerError, inet.Message{
-			Status:  http.StatusInternalServerError,
-			Message: errs.QueryFailed,
-		})
-
-		logger.Error("Fetching public dbs failed: %v", err)
+		logger.Error("Fetching dbs failed: %v", err)
 		return
 	}
 
-	for _, db := range dbs {
-		databases[db.ID] = db
+	databases := make(map[int]data.Row, len(rows))
+
+	for _, row := range rows {
+		databases[row.ID] = row
 	}
 
 	msg := inet.StructMessage{Status: http.StatusOK, Message: databases}
@@ -108,39 +80,27 @@ func apiListDatabases(w http.ResponseWriter, r *http.Request) {
 	inet.SendResponse(w, http.StatusOK, msg)
 }
 
-// apiCreate will create a database with the provided details
+// apiCreate will create a database with the provided details. The creator is
+// the user in the Authorization header, or the requester_email of the request
+// for the clients that don't send one.
 func apiCreate(w http.ResponseWriter, r *http.Request) {
-	var (
-		req model.ClientRequest
-		err error
-	)
+	var req model.ClientRequest
 
-	err = json.NewDecoder(r.Body).Decode(&req)
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		logger.Error("couldn't decode json request: %v", err)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  http.StatusBadRequest,
-			Message: errs.JSONDecodeFailed,
-		})
+		inet.SendFailure(w, http.StatusBadRequest, errs.JSONDecodeFailed)
 		return
 	}
 
-	if ok := sutils.Present(req.AgentIdentifier, req.RequesterEmail); !ok {
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  http.StatusBadRequest,
-			Message: errs.MissingParameters,
-		})
-		return
+	user, err := getAPIUser(r)
+	if err != nil {
+		user = req.RequesterEmail
 	}
 
-	agent, ok := registry.Get(req.AgentIdentifier)
-	if !ok {
-		logger.Error("Agent %q not found", req.AgentIdentifier)
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  status.MissingParameters,
-			Message: errs.AgentNotFound,
-		})
+	if ok := sutils.Present(req.AgentIdentifier, user); !ok {
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters)
 		return
 	}
 
@@ -148,54 +108,17 @@ func apiCreate(w http.ResponseWriter, r *http.Request) {
 		req.DatabaseName = req.Username
 	}
 
-	ensureValues(&req.DatabaseName, &req.Username, &req.Password, agent.DBVendor)
-
-	req.ID = registry.ID()
-
-	dbe := data.Row{
-		DBName:     req.DatabaseName,
-		DBUser:     req.Username,
-		DBPass:     req.Password,
-		DBSID:      agent.DBSID,
-		AgentName:  req.AgentIdentifier,
-		Creator:    req.RequesterEmail,
-		CreateDate: time.Now(),
-		ExpiryDate: time.Now().AddDate(0, 1, 0),
-		DBAddress:  agent.DBAddr,
-		DBPort:     agent.DBPort,
-		DBVendor:   agent.DBVendor,
-		Status:     status.Success,
-	}
-
-	err = db.Insert(&dbe)
-	if err != nil {
-		logger.Error("failed inserting database: %v", err)
-
-		inet.SendResponse(w, http.StatusInternalServerError, inet.Message{
-			Status:  http.StatusInternalServerError,
-			Message: errs.PersistFailed,
-		})
+	result, errr := createDatabase(r, user, req)
+	if errr.httpStatus != 0 {
+		inet.SendFailure(w, errr.httpStatus, errr.errors...)
 		return
 	}
 
-	_, err = agent.CreateDatabase(req.ID, req.DatabaseName, req.Username, req.Password)
-	if err != nil {
-		inet.SendResponse(w, http.StatusInternalServerError, inet.Message{
-			Status:  http.StatusInternalServerError,
-			Message: fmt.Sprintf("creating database failed: %v", err),
-		})
-
-		db.Delete(dbe)
-		return
-	}
-
-	resp, err := json.Marshal(dbe)
+	// Unlike v2, the created row is sent as is
+	resp, err := json.Marshal(result.Row)
 	if err != nil {
 		logger.Error("json marshal failed: %v", err)
-		inet.SendResponse(w, http.StatusInternalServerError, inet.Message{
-			Status:  http.StatusInternalServerError,
-			Message: errs.JSONEncodeFailed,
-		})
+		inet.SendFailure(w, http.StatusInternalServerError, errs.JSONEncodeFailed)
 		return
 	}
 
@@ -212,12 +135,7 @@ func apiAgentByName(w http.ResponseWriter, r *http.Request) {
 
 	conn, ok := registry.Get(shortname)
 	if !ok {
-		msg := inet.Message{
-			Status:  http.StatusServiceUnavailable,
-			Message: errs.AgentNotFound,
-		}
-
-		inet.SendResponse(w, http.StatusServiceUnavailable, msg)
+		inet.SendFailure(w, http.StatusNotFound, errs.AgentNotFound, shortname)
 		return
 	}
 
@@ -233,12 +151,7 @@ func apiSafe2Restart(w http.ResponseWriter, r *http.Request) {
 	entries, err := db.FetchAll()
 	if err != nil {
 		logger.Error("failed FetchAll: %v", err)
-		msg := inet.Message{
-			Status:  http.StatusInternalServerError,
-			Message: errs.QueryFailed,
-		}
-
-		inet.SendResponse(w, http.StatusInternalServerError, msg)
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
 		return
 	}
 
@@ -288,10 +201,7 @@ func apiSaveSubscription(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error("couldn't decode json request: %v", err)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  http.StatusBadRequest,
-			Message: errs.JSONDecodeFailed,
-		})
+		inet.SendFailure(w, http.StatusBadRequest, errs.JSONDecodeFailed)
 		return
 	}
 
@@ -299,29 +209,20 @@ func apiSaveSubscription(w http.ResponseWriter, r *http.Request) {
 		logger.Error("Missing or empty subscription parameters were received from the /api/save-subscription API call!")
 		//TODO
 		// log the received request body
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  http.StatusBadRequest,
-			Message: errs.MissingParameters,
-		})
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters)
 		return
 	}
 
 	userCookie, err := r.Cookie("user")
 	if err != nil {
 		logger.Error("getting user cookie failed: %v", err)
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  http.StatusBadRequest,
-			Message: errs.MissingUserCookie,
-		})
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingUserCookie)
 		return
 	}
 
 	err = db.InsertPushSubscription(&subscription, userCookie.Value)
 	if err != nil {
-		inet.SendResponse(w, http.StatusInternalServerError, inet.Message{
-			Status:  http.StatusInternalServerError,
-			Message: errs.PersistFailed,
-		})
+		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed)
 		return
 	}
 
@@ -341,10 +242,7 @@ func apiRemoveSubscription(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error("couldn't decode json request: %v", err)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  http.StatusBadRequest,
-			Message: errs.JSONDecodeFailed,
-		})
+		inet.SendFailure(w, http.StatusBadRequest, errs.JSONDecodeFailed)
 		return
 	}
 
@@ -352,20 +250,14 @@ func apiRemoveSubscription(w http.ResponseWriter, r *http.Request) {
 		logger.Error("Missing or empty subscription parameters were received from the /api/remove-subscription API call!")
 		//TODO
 		// log the received request body
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  http.StatusBadRequest,
-			Message: errs.MissingParameters,
-		})
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters)
 		return
 	}
 
 	userCookie, err := r.Cookie("user")
 	if err != nil {
 		logger.Error("getting user cookie failed: " + err.Error())
-		inet.SendResponse(w, http.StatusInternalServerError, inet.Message{
-			Status:  http.StatusBadRequest,
-			Message: errs.MissingUserCookie,
-		})
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingUserCookie)
 		return
 	}
 
@@ -373,10 +265,7 @@ func apiRemoveSubscription(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error("failed deleting push subscription: %v", err)
 
-		inet.SendResponse(w, http.StatusInternalServerError, inet.Message{
-			Status:  http.StatusInternalServerError,
-			Message: errs.DropFailed,
-		})
+		inet.SendFailure(w, http.StatusInternalServerError, errs.DropFailed)
 		return
 	}
 
@@ -385,59 +274,55 @@ func apiRemoveSubscription(w http.ResponseWriter, r *http.Request) {
 	inet.SendResponse(w, http.StatusOK, msg)
 }
 
-// apiDBAccess will list useful information for connecting to the specified database (JDBC driver, url, etc.)
+// apiDBAccess will list useful information for connecting to the specified
+// database (JDBC driver, url, etc.) The Authorization header is required, and
+// its user has to match the requester in the path.
 func apiDBAccess(w http.ResponseWriter, r *http.Request) {
-	var jdbcParams liferay.JDBC
-
-	list := make(map[string]string, 5)
 	vars := mux.Vars(r)
-	requester, dbname, agent := vars["requester"], vars["dbname"], vars["agent"]
-
-	if ok := sutils.Present(requester, dbname, agent); !ok {
-		logger.Error("Missing parameters: requester: %q, dbname: %q, agent: %q", requester, dbname, agent)
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  http.StatusBadRequest,
-			Message: errs.MissingParameters,
-		})
+	requester := vars["requester"]
+
+	// The requester in the path can't be trusted, so the user is the one of the
+	// Authorization header, like in v2
+	user, err := getAPIUser(r)
+	if err != nil {
+		logger.Error("User %q tried to get portalext without the Authorization header.", requester)
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied, err.Error())
 		return
 	}
 
-	dbe, err := db.FetchByDBNameAgent(dbname, agent)
-	if err != nil {
-		logger.Error("FetchByAgentDBName: %v", err)
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  http.StatusInternalServerError,
-			Message: errs.QueryFailed,
-		})
+	if user != requester {
+		logger.Error("User %q tried to get portalext as %q.", user, requester)
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	if dbe.Public == vis.Private && dbe.Creator != requester {
-		logger.Error("User %q tried to get portalext of db created by %q.", requester, dbe.Creator)
-		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
-			Status:  http.StatusForbidden,
-			Message: errs.AccessDenied})
+	dbe, errr := getDatabaseByAgentDBNameFrom(vars)
+	if errr.httpStatus != 0 {
+		inet.SendFailure(w, errr.httpStatus, errr.errors...)
 		return
 	}
 
-	switch dbe.DBVendor {
-	case "mysql":
-		jdbcParams = liferay.MysqlJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbe.DBPass)
-	case "mariadb":
-		jdbcParams = liferay.MariaDBJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbe.DBPass)
-	case "postgres":
-		jdbcParams = liferay.PostgreJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbe.DBPass)
-	case "oracle":
-		jdbcParams = liferay.OracleJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBSID, dbe.DBUser, dbe.DBPass)
-	case "mssql":
-		jdbcParams = liferay.MSSQLJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbe.DBPass)
+	if !hasAccess(dbe, user) {
+		logger.Error("User %q tried to get portalext of db created by %q.", user, dbe.Creator)
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
 	}
 
-	list["jdbc-driver"] = jdbcParams.Driver
-	list["jdbc-url"] = jdbcParams.URL
-	list["user"] = dbe.DBUser
-	list["password"] = dbe.DBPass
-	list["url"] = dbe.DBAddress + ":" + dbe.DBPort
+	access := getDBAccess(dbe)
+
+	// v1 has always returned the JDBC URL that works with 6.2 and 7.0
+	jdbcURL := access.JDBCUrl
+	if access.JDBCUrl6210 != "" {
+		jdbcURL = access.JDBCUrl6210
+	}
+
+	list := map[string]string{
+		"jdbc-driver": access.JDBCDriver,
+		"jdbc-url":    jdbcURL,
+		"user":        access.User,
+		"password":    access.Password,
+		"url":         access.URL,
+	}
 
 	msg := inet.MapMessage{Status: status.Success, Message: list}
 
@@ -15,6 +15,7 @@ import (
 	"github.com/djavorszky/ddn/common/inet"
 	"github.com/djavorszky/ddn/common/logger"
 	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/common/srv"
 	"github.com/djavorszky/ddn/common/status"
 	vis "github.com/djavorszky/ddn/common/visibility"
 	"github.com/djavorszky/ddn/server/brwsr"
@@ -25,7 +26,7 @@ import (
 )
 
 func apiSetLogLevel(w http.ResponseWriter, r *http.Request) {
-	_, err := getAPIUser(r)
+	user, err := getAPIUser(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -61,6 +62,8 @@ func apiSetLogLevel(w http.ResponseWriter, r *http.Request) {
 
 	logger.Level = lvl
 
+	audit(r, user, "loglevel", data.Row{}, map[string]string{"level": level}, nil)
+
 	inet.SendSuccess(w, http.StatusOK, msg)
 	return
 }
@@ -136,35 +139,35 @@ func getAPIDatabases(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get private ones
-	metas, err := db.FetchByCreator(user)
+	filter, err := parseRowFilter(r)
 	if err != nil {
-		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
-
-		logger.Error("Fetching private dbs failed: %v", err)
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, err.Error())
 		return
 	}
 
-	databases := make([]data.Row, 0, len(metas))
+	filter.VisibleTo = user
 
-	for _, meta := range metas {
-		databases = append(databases, meta)
+	// Fetch one more than asked for, to know if there is a next page
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++
 	}
 
-	// Get public ones
-	metas, err = db.FetchPublic()
+	databases, err := db.FetchRows(filter)
 	if err != nil {
 		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
 
-		logger.Error("Fetching public dbs failed: %v", err)
+		logger.Error("Fetching dbs failed: %v", err)
 		return
 	}
 
-	for _, meta := range metas {
-		databases = append(databases, meta)
+	var next string
+	if limit > 0 && len(databases) > limit {
+		databases = databases[:limit]
+		next = encodeCursor(filter, databases[limit-1])
 	}
 
-	inet.SendSuccess(w, http.StatusOK, databases)
+	inet.SendPage(w, http.StatusOK, databases, next)
 }
 
 func getAPIDatabaseByID(w http.ResponseWriter, r *http.Request) {
@@ -227,21 +230,29 @@ func dropAPIDatabaseByID(w http.ResponseWriter, r *http.Request) {
 
 	if !hasAccess(meta, user) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+
+		audit(r, user, "drop", meta, nil, errDenied)
 		return
 	}
 
 	agent, ok := registry.Get(meta.AgentName)
 	if !ok {
 		inet.SendFailure(w, http.StatusForbidden, errs.AgentNotFound)
+
+		audit(r, user, "drop", meta, nil, fmt.Errorf("agent %s not found", meta.AgentName))
 		return
 	}
 
+	agent = agent.WithRequestID(srv.RequestID(r))
+
 	meta.Status = status.DropInProgress
 
 	db.Update(&meta)
 
 	go dropAsync(agent, meta.ID, meta.DBName, meta.DBUser)
 
+	audit(r, user, "drop", meta, nil, nil)
+
 	inet.SendSuccess(w, http.StatusOK, "Started dropping database")
 }
 
@@ -266,21 +277,29 @@ func dropAPIDatabaseByAgentDBName(w http.ResponseWriter, r *http.Request) {
 
 	if !hasAccess(meta, user) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+
+		audit(r, user, "drop", meta, nil, errDenied)
 		return
 	}
 
 	agent, ok := registry.Get(meta.AgentName)
 	if !ok {
 		inet.SendFailure(w, http.StatusForbidden, errs.AgentNotFound)
+
+		audit(r, user, "drop", meta, nil, fmt.Errorf("agent %s not found", meta.AgentName))
 		return
 	}
 
+	agent = agent.WithRequestID(srv.RequestID(r))
+
 	meta.Status = status.DropInProgress
 
 	db.Update(&meta)
 
 	go dropAsync(agent, meta.ID, meta.DBName, meta.DBUser)
 
+	audit(r, user, "drop", meta, nil, nil)
+
 	inet.SendSuccess(w, http.StatusOK, "Started dropping database")
 }
 
@@ -295,37 +314,41 @@ func importAPIDB(w http.ResponseWriter, r *http.Request) {
 
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		inet.SendFailure(w, http.StatusBadRequest, errs.InvalidURL, err.Error())
+		inet.SendFailure(w, http.StatusBadRequest, errs.JSONDecodeFailed, err.Error())
 
 		logger.Error("couldn't decode json request: %v", err)
 		return
 	}
 
-	if req.AgentIdentifier == "" {
-		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "agent_identifier")
-		return
-	}
-
 	if req.DumpLocation == "" {
 		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "dumpfile_location")
 		return
 	}
 
-	agent, ok := registry.Get(req.AgentIdentifier)
-	if !ok {
-		inet.SendFailure(w, http.StatusBadRequest, errs.AgentNotFound, req.AgentIdentifier)
+	err = checkPassword(req.Password)
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.InvalidPassword, err.Error())
+		return
+	}
 
+	agent, errr := getAgentFor(req)
+	if errr.httpStatus != 0 {
+		inet.SendFailure(w, errr.httpStatus, errr.errors...)
 		return
 	}
 
+	agent = agent.WithRequestID(srv.RequestID(r))
+
 	ensureValues(&req.DatabaseName, &req.Username, &req.Password, agent.DBVendor)
 
+	params := map[string]string{"dumpfile": req.DumpLocation, "vendor": req.Vendor}
+
 	dbe := data.Row{
 		DBName:     req.DatabaseName,
 		DBUser:     req.Username,
 		DBPass:     req.Password,
 		DBSID:      agent.DBSID,
-		AgentName:  req.AgentIdentifier,
+		AgentName:  agent.ShortName,
 		Dumpfile:   req.DumpLocation,
 		Creator:    user,
 		CreateDate: time.Now(),
@@ -342,6 +365,8 @@ func importAPIDB(w http.ResponseWriter, r *http.Request) {
 
 		logger.Error("failed inserting database: %v", err)
 		db.Delete(dbe)
+
+		audit(r, user, "import", dbe, params, err)
 		return
 	}
 
@@ -353,7 +378,9 @@ func importAPIDB(w http.ResponseWriter, r *http.Request) {
 
 	go startImport(agent, dbe)
 
-	inet.SendSuccess(w, http.StatusAccepted, dbe)
+	audit(r, user, "import", dbe, params, nil)
+
+	inet.SendSuccess(w, http.StatusAccepted, databaseResult{dbe, agent})
 }
 
 func startImport(agent model.Agent, dbe data.Row) {
@@ -397,10 +424,10 @@ func startImport(agent model.Agent, dbe data.Row) {
 
 		logger.Debug("Copy successful, starting import")
 
-		url = fmt.Sprintf("http://%s:%s/dumps/%s", config.ServerHost, config.ServerPort, filename)
+		url = inet.WithScheme(fmt.Sprintf("%s:%s/dumps/%s", config.ServerHost, config.ServerPort, filename))
 	}
 
-	_, err := agent.ImportDatabase(dbe.ID, dbe.DBName, dbe.DBUser, dbe.DBPass, url)
+	_, err := agent.ImportDatabase(dbe.ID, dbe.DBName, dbe.DBUser, password(dbe), url)
 	if err != nil {
 		errMsg := fmt.Sprintf("Import failed: %v", err)
 
@@ -414,6 +441,71 @@ func startImport(agent model.Agent, dbe data.Row) {
 	dbe.Status = status.ImportInProgress
 }
 
+// uploadAPIDump saves the dump uploaded in the "dumpfile" field of a multipart
+// form to web/dumps, and returns the location to import it from. Each upload
+// is saved under a name of its own, so that it can't overwrite another one,
+// e.g. a dump that is still being imported.
+func uploadAPIDump(w http.ResponseWriter, r *http.Request) {
+	_, err := getAPIUser(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	file, header, err := r.FormFile("dumpfile")
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "dumpfile")
+		return
+	}
+	defer file.Close()
+	defer r.MultipartForm.RemoveAll()
+
+	filename := filepath.Base(header.Filename)
+	if filename == "." || filename == "/" {
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "dumpfile")
+		return
+	}
+
+	dst, err := createUpload(fmt.Sprintf("%s/web/dumps", workdir), filename)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.FileIOFailed, err.Error())
+
+		logger.Error("Failed creating file: %v", err)
+		return
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, file)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.FileIOFailed, err.Error())
+
+		logger.Error("Failed saving file: %v", err)
+		os.Remove(dst.Name())
+		return
+	}
+
+	url := inet.WithScheme(fmt.Sprintf("%s:%s/dumps/%s", config.ServerHost, config.ServerPort, filepath.Base(dst.Name())))
+
+	inet.SendSuccess(w, http.StatusCreated, url)
+}
+
+// createUpload creates the file of an uploaded dump in dir. It is named after
+// the dump with a random prefix, keeping the extension its type is told by.
+func createUpload(dir, filename string) (*os.File, error) {
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("upload-%s-%s", srv.NewRequestID(), filename)
+
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+
+		return f, err
+	}
+
+	return nil, fmt.Errorf("could not find a free name for %s", filename)
+}
+
 func createAPIDB(w http.ResponseWriter, r *http.Request) {
 	user, err := getAPIUser(r)
 	if err != nil {
@@ -425,34 +517,50 @@ func createAPIDB(w http.ResponseWriter, r *http.Request) {
 
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		inet.SendFailure(w, http.StatusBadRequest, errs.InvalidURL, err.Error())
+		inet.SendFailure(w, http.StatusBadRequest, errs.JSONDecodeFailed, err.Error())
 
 		logger.Error("couldn't decode json request: %v", err)
 		return
 	}
 
-	if req.AgentIdentifier == "" {
-		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "agent_identifier")
-
+	result, errr := createDatabase(r, user, req)
+	if errr.httpStatus != 0 {
+		inet.SendFailure(w, errr.httpStatus, errr.errors...)
 		return
 	}
 
-	agent, ok := registry.Get(req.AgentIdentifier)
-	if !ok {
-		inet.SendFailure(w, http.StatusBadRequest, errs.AgentNotFound, req.AgentIdentifier)
+	inet.SendSuccess(w, http.StatusOK, result)
+}
 
-		return
+// createDatabase creates the database of the request for user, on the agent
+// named in it or chosen by the server. It is shared by the v1 and v2 APIs.
+func createDatabase(r *http.Request, user string, req model.ClientRequest) (databaseResult, errResult) {
+	err := checkPassword(req.Password)
+	if err != nil {
+		return databaseResult{}, errResult{
+			httpStatus: http.StatusBadRequest,
+			errors:     []string{errs.InvalidPassword, err.Error()},
+		}
 	}
 
+	agent, errr := getAgentFor(req)
+	if errr.httpStatus != 0 {
+		return databaseResult{}, errr
+	}
+
+	agent = agent.WithRequestID(srv.RequestID(r))
+
 	ensureValues(&req.DatabaseName, &req.Username, &req.Password, agent.DBVendor)
 
+	params := map[string]string{"vendor": req.Vendor}
+
 	req.ID = registry.ID()
 	dbe := data.Row{
 		DBName:     req.DatabaseName,
 		DBUser:     req.Username,
 		DBPass:     req.Password,
 		DBSID:      agent.DBSID,
-		AgentName:  req.AgentIdentifier,
+		AgentName:  agent.ShortName,
 		Creator:    user,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().AddDate(0, 1, 0),
@@ -464,21 +572,31 @@ func createAPIDB(w http.ResponseWriter, r *http.Request) {
 
 	err = db.Insert(&dbe)
 	if err != nil {
-		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed, err.Error())
-
 		logger.Error("failed inserting database: %v", err)
-		return
+
+		audit(r, user, "create", dbe, params, err)
+
+		return databaseResult{}, errResult{
+			httpStatus: http.StatusInternalServerError,
+			errors:     []string{errs.PersistFailed, err.Error()},
+		}
 	}
 
 	_, err = agent.CreateDatabase(req.ID, req.DatabaseName, req.Username, req.Password)
 	if err != nil {
-		inet.SendFailure(w, http.StatusInternalServerError, errs.CreateFailed, err.Error())
-
 		db.Delete(dbe)
-		return
+
+		audit(r, user, "create", dbe, params, err)
+
+		return databaseResult{}, errResult{
+			httpStatus: http.StatusInternalServerError,
+			errors:     []string{errs.CreateFailed, err.Error()},
+		}
 	}
 
-	inet.SendSuccess(w, http.StatusOK, dbe)
+	audit(r, user, "create", dbe, params, nil)
+
+	return databaseResult{dbe, agent}, errResult{}
 }
 
 func exportAPIDB(w http.ResponseWriter, r *http.Request) {
@@ -497,6 +615,8 @@ func exportAPIDB(w http.ResponseWriter, r *http.Request) {
 
 	if !hasAccess(meta, user) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+
+		audit(r, user, "export", meta, nil, errDenied)
 		return
 	}
 
@@ -506,15 +626,25 @@ func exportAPIDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := agent.ExportDatabase(meta.ID, meta.DBName, meta.DBUser, meta.DBPass)
+	agent = agent.WithRequestID(srv.RequestID(r))
+
+	meta.Status = status.ExportInProgress
+
+	db.Update(&meta)
+
+	resp, err := agent.ExportDatabase(meta.ID, meta.DBName, meta.DBUser, password(meta))
 	if err != nil {
 		meta.Status = status.ExportFailed
 		db.Update(&meta)
 
 		inet.SendFailure(w, http.StatusInternalServerError, errs.ExportFailed)
+
+		audit(r, user, "export", meta, nil, err)
 		return
 	}
 
+	audit(r, user, "export", meta, nil, nil)
+
 	inet.SendSuccess(w, http.StatusOK, resp)
 }
 
@@ -534,6 +664,8 @@ func recreateAPIDB(w http.ResponseWriter, r *http.Request) {
 
 	if !hasAccess(meta, user) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+
+		audit(r, user, "recreate", meta, nil, errDenied)
 		return
 	}
 
@@ -543,27 +675,96 @@ func recreateAPIDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	agent = agent.WithRequestID(srv.RequestID(r))
+
 	_, err = agent.DropDatabase(meta.ID, meta.DBName, meta.DBUser)
 	if err != nil {
 		meta.Status = status.DropDatabaseFailed
 		db.Update(&meta)
 
 		inet.SendFailure(w, http.StatusInternalServerError, errs.DropFailed)
+
+		audit(r, user, "recreate", meta, nil, err)
 		return
 	}
 
-	_, err = agent.CreateDatabase(meta.ID, meta.DBName, meta.DBUser, meta.DBPass)
+	_, err = agent.CreateDatabase(meta.ID, meta.DBName, meta.DBUser, password(meta))
 	if err != nil {
 		meta.Status = status.CreateDatabaseFailed
 		db.Update(&meta)
 
 		inet.SendFailure(w, http.StatusInternalServerError, errs.CreateFailed)
+
+		audit(r, user, "recreate", meta, nil, err)
 		return
 	}
 
+	audit(r, user, "recreate", meta, nil, nil)
+
 	inet.SendSuccess(w, http.StatusOK, meta)
 }
 
+// getAPIReconcile returns the latest reconciliation report, running one
+// if there hasn't been any yet.
+func getAPIReconcile(w http.ResponseWriter, r *http.Request) {
+	_, err := getAPIAdmin(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	reconcileMutex.Lock()
+	report := lastReconcile
+	reconcileMutex.Unlock()
+
+	if report == nil {
+		result := reconcile(false, false)
+		report = &result
+	}
+
+	inet.SendSuccess(w, http.StatusOK, report)
+}
+
+// runAPIReconcile runs the reconciliation right away. Untracked databases are
+// adopted if the "adopt" query parameter is true, orphaned rows removed if "cleanup" is.
+func runAPIReconcile(w http.ResponseWriter, r *http.Request) {
+	user, err := getAPIAdmin(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	adopt, err := parseBoolParam(r, "adopt")
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, "adopt")
+		return
+	}
+
+	cleanup, err := parseBoolParam(r, "cleanup")
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, "cleanup")
+		return
+	}
+
+	logger.Info("Reconciliation started by %s (adopt: %t, cleanup: %t)", user, adopt, cleanup)
+
+	report := reconcile(adopt, cleanup)
+
+	audit(r, user, "reconcile", data.Row{}, map[string]string{"adopt": strconv.FormatBool(adopt), "cleanup": strconv.FormatBool(cleanup)}, nil)
+
+	inet.SendSuccess(w, http.StatusOK, report)
+}
+
+// parseBoolParam returns the value of a boolean query parameter, false if missing.
+func parseBoolParam(r *http.Request, name string) (bool, error) {
+	val := r.URL.Query().Get(name)
+	if val == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(val)
+}
+
 func browseAPI(w http.ResponseWriter, r *http.Request) {
 	_, err := getAPIUser(r)
 	if err != nil {
@@ -609,6 +810,8 @@ func apiSetVisibility(w http.ResponseWriter, r *http.Request) {
 
 	if !isOwner(meta, user) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+
+		audit(r, user, "visibility", meta, nil, errDenied)
 		return
 	}
 
@@ -633,14 +836,20 @@ func apiSetVisibility(w http.ResponseWriter, r *http.Request) {
 
 	meta.Public = visibilityNum
 
+	params := map[string]string{"visibility": visibility}
+
 	err = db.Update(&meta)
 	if err != nil {
 		inet.SendFailure(w, http.StatusInternalServerError, errs.UpdateFailed, err.Error())
 
 		logger.Error("failed listing folder: %v", err)
+
+		audit(r, user, "visibility", meta, params, err)
 		return
 	}
 
+	audit(r, user, "visibility", meta, params, nil)
+
 	inet.SendSuccess(w, http.StatusOK, "Visibility updated successfully")
 }
 
@@ -660,6 +869,8 @@ func apiExtendExpiry(w http.ResponseWriter, r *http.Request) {
 
 	if !hasAccess(meta, user) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+
+		audit(r, user, "extend", meta, nil, errDenied)
 		return
 	}
 
@@ -686,14 +897,20 @@ func apiExtendExpiry(w http.ResponseWriter, r *http.Request) {
 
 	meta.ExpiryDate = newExpiry
 
+	params := map[string]string{"amount": vars["amount"], "unit": vars["unit"]}
+
 	err = db.Update(&meta)
 	if err != nil {
 		inet.SendFailure(w, http.StatusInternalServerError, errs.UpdateFailed, err.Error())
 
 		logger.Error("failed listing folder: %v", err)
+
+		audit(r, user, "extend", meta, params, err)
 		return
 	}
 
+	audit(r, user, "extend", meta, params, nil)
+
 	inet.SendSuccess(w, http.StatusOK, meta.ExpiryDate)
 }
 
@@ -755,27 +972,28 @@ func getDBAccess(meta data.Row) dbAccess {
 	var (
 		jdbc     liferay.JDBC
 		jdbc6210 liferay.JDBC
+		dbpass   = password(meta)
 	)
 
 	switch meta.DBVendor {
 	case "mysql":
-		jdbc = liferay.MysqlJDBCDXP(meta.DBAddress, meta.DBPort, meta.DBName, meta.DBUser, meta.DBPass)
-		jdbc6210 = liferay.MysqlJDBC(meta.DBAddress, meta.DBPort, meta.DBName, meta.DBUser, meta.DBPass)
+		jdbc = liferay.MysqlJDBCDXP(meta.DBAddress, meta.DBPort, meta.DBName, meta.DBUser, dbpass)
+		jdbc6210 = liferay.MysqlJDBC(meta.DBAddress, meta.DBPort, meta.DBName, meta.DBUser, dbpass)
 	case "mariadb":
-		jdbc = liferay.MariaDBJDBC(meta.DBAddress, meta.DBPort, meta.DBName, meta.DBUser, meta.DBPass)
+		jdbc = liferay.MariaDBJDBC(meta.DBAddress, meta.DBPort, meta.DBName, meta.DBUser, dbpass)
 	case "postgres":
-		jdbc = liferay.PostgreJDBC(meta.DBAddress, meta.DBPort, meta.DBName, meta.DBUser, meta.DBPass)
+		jdbc = liferay.PostgreJDBC(meta.DBAddress, meta.DBPort, meta.DBName, meta.DBUser, dbpass)
 	case "oracle":
-		jdbc = liferay.OracleJDBC(meta.DBAddress, meta.DBPort, meta.DBSID, meta.DBUser, meta.DBPass)
+		jdbc = liferay.OracleJDBC(meta.DBAddress, meta.DBPort, meta.DBSID, meta.DBUser, dbpass)
 	case "mssql":
-		jdbc = liferay.MSSQLJDBC(meta.DBAddress, meta.DBPort, meta.DBName, meta.DBUser, meta.DBPass)
+		jdbc = liferay.MSSQLJDBC(meta.DBAddress, meta.DBPort, meta.DBName, meta.DBUser, dbpass)
 	}
 
 	dba := dbAccess{
 		JDBCDriver: jdbc.Driver,
 		JDBCUrl:    jdbc.URL,
 		User:       meta.DBUser,
-		Password:   meta.DBPass,
+		Password:   dbpass,
 		URL:        meta.DBAddress + ":" + meta.DBPort,
 	}
 
@@ -790,6 +1008,30 @@ func getDBAccess(meta data.Row) dbAccess {
 	return dba
 }
 
+// getAPIAdmin returns the user of the request if it is one of the admins.
+func getAPIAdmin(r *http.Request) (string, error) {
+	user, err := getAPIUser(r)
+	if err != nil {
+		return "", err
+	}
+
+	if !isAdmin(user) {
+		return "", fmt.Errorf("user %q is not an admin", user)
+	}
+
+	return user, nil
+}
+
+func isAdmin(user string) bool {
+	for _, admin := range config.AdminEmail {
+		if strings.EqualFold(admin, user) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func getAPIUser(r *http.Request) (string, error) {
 	auth := r.Header.Get("Authorization")
 	if auth == "" {
@@ -817,6 +1059,57 @@ func isOwner(meta data.Row, user string) bool {
 	return meta.Creator == user
 }
 
+// databaseResult is returned when creating or importing databases. The agent is
+// included so that clients know where the database ended up if the server chose
+// the agent for them.
+type databaseResult struct {
+	data.Row
+	Agent model.Agent `json:"agent_info"`
+}
+
+// getAgentFor returns the agent named in the request, or if none is named, the
+// one chosen by the server based on the vendor constraint.
+func getAgentFor(req model.ClientRequest) (model.Agent, errResult) {
+	if req.AgentIdentifier == "" && req.Vendor == "" {
+		return model.Agent{}, errResult{
+			httpStatus: http.StatusBadRequest,
+			errors:     []string{errs.MissingParameters, "agent_identifier", "vendor"},
+		}
+	}
+
+	if req.AgentIdentifier != "" {
+		agent, ok := registry.Get(req.AgentIdentifier)
+		if !ok {
+			return model.Agent{}, errResult{
+				httpStatus: http.StatusBadRequest,
+				errors:     []string{errs.AgentNotFound, req.AgentIdentifier},
+			}
+		}
+
+		return agent, errResult{}
+	}
+
+	constraint, err := parseAgentConstraint(req.Vendor)
+	if err != nil {
+		return model.Agent{}, errResult{
+			httpStatus: http.StatusBadRequest,
+			errors:     []string{errs.InvalidVendorConstraint, err.Error()},
+		}
+	}
+
+	agent, ok := selectAgent(constraint)
+	if !ok {
+		return model.Agent{}, errResult{
+			httpStatus: http.StatusServiceUnavailable,
+			errors:     []string{errs.NoAgentsAvailable, req.Vendor},
+		}
+	}
+
+	logger.Debug("chose agent %s for %q", agent.ShortName, req.Vendor)
+
+	return agent, errResult{}
+}
+
 type errResult struct {
 	httpStatus int
 	errors     []string
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/djavorszky/ddn/common/errs"
+	"github.com/djavorszky/ddn/common/inet"
+	"github.com/djavorszky/ddn/common/logger"
+	"github.com/djavorszky/ddn/common/srv"
+	"github.com/djavorszky/ddn/server/database/data"
+)
+
+// defaultAuditLimit is the number of entries returned if the request doesn't specify one.
+const defaultAuditLimit = 500
+
+// errDenied is passed to audit when the actor was not allowed to do the action.
+var errDenied = errors.New(errs.AccessDenied)
+
+// audit records an action in the audit log. A nil err is recorded as a success,
+// errDenied as denied and anything else as a failure. The action is not stopped
+// if it can't be recorded.
+func audit(r *http.Request, actor, action string, row data.Row, params map[string]string, err error) {
+	entry := data.AuditEntry{
+		Time:       time.Now(),
+		Actor:      actor,
+		Action:     action,
+		DatabaseID: row.ID,
+		Params:     "{}",
+		Outcome:    data.AuditSuccess,
+		RequestID:  srv.RequestID(r),
+	}
+
+	if row.AgentName != "" || row.DBName != "" {
+		entry.Target = fmt.Sprintf("%s/%s", row.AgentName, row.DBName)
+	}
+
+	if len(params) > 0 {
+		b, err := json.Marshal(params)
+		if err == nil {
+			entry.Params = string(b)
+		}
+	}
+
+	switch {
+	case err == errDenied:
+		entry.Outcome = data.AuditDenied
+		entry.Message = err.Error()
+	case err != nil:
+		entry.Outcome = data.AuditFailure
+		entry.Message = err.Error()
+	}
+
+	err = db.InsertAudit(&entry)
+	if err != nil {
+		logger.With("request_id", entry.RequestID, "actor", actor, "action", action).Error("failed recording audit entry: %v", err)
+	}
+}
+
+// getAPIAudit returns the entries of the audit log, filtered by the "actor", "action",
+// "outcome", "database", "from" and "to" query parameters. The entries are returned
+// as CSV if "format" is csv, as JSON otherwise.
+func getAPIAudit(w http.ResponseWriter, r *http.Request) {
+	_, err := getAPIAdmin(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, err.Error())
+		return
+	}
+
+	entries, err := db.FetchAudit(filter)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Fetching audit log failed: %v", err)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		inet.SendSuccess(w, http.StatusOK, entries)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=audit.csv")
+		w.WriteHeader(http.StatusOK)
+
+		err = writeAuditCSV(w, entries)
+		if err != nil {
+			logger.Error("Writing audit log failed: %v", err)
+		}
+	default:
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, "format")
+	}
+}
+
+// parseAuditFilter reads the filter from the query parameters of the request.
+func parseAuditFilter(r *http.Request) (data.AuditFilter, error) {
+	q := r.URL.Query()
+
+	filter := data.AuditFilter{
+		Actor:   q.Get("actor"),
+		Action:  q.Get("action"),
+		Outcome: q.Get("outcome"),
+		Limit:   defaultAuditLimit,
+	}
+
+	var err error
+	if val := q.Get("database"); val != "" {
+		filter.DatabaseID, err = strconv.Atoi(val)
+		if err != nil {
+			return filter, fmt.Errorf("database: %v", err)
+		}
+	}
+
+	if val := q.Get("from"); val != "" {
+		filter.From, err = time.Parse(time.RFC3339, val)
+		if err != nil {
+			return filter, fmt.Errorf("from: %v", err)
+		}
+	}
+
+	if val := q.Get("to"); val != "" {
+		filter.To, err = time.Parse(time.RFC3339, val)
+		if err != nil {
+			return filter, fmt.Errorf("to: %v", err)
+		}
+	}
+
+	if val := q.Get("limit"); val != "" {
+		filter.Limit, err = strconv.Atoi(val)
+		if err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("limit: %q is not a valid number", val)
+		}
+	}
+
+	return filter, nil
+}
+
+// writeAuditCSV writes the entries as CSV, with a header line.
+func writeAuditCSV(w io.Writer, entries []data.AuditEntry) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write([]string{"id", "time", "actor", "action", "database_id", "target", "params", "outcome", "message", "request_id"})
+
+	for _, e := range entries {
+		cw.Write([]string{
+			strconv.Itoa(e.ID),
+			e.Time.Format(time.RFC3339),
+			e.Actor,
+			e.Action,
+			strconv.Itoa(e.DatabaseID),
+			e.Target,
+			e.Params,
+			e.Outcome,
+			e.Message,
+			e.RequestID,
+		})
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn/server/database/data"
+)
+
+func TestParseAuditFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/admin/audit?actor=a@example.com&action=drop&outcome=denied&database=42&from=2018-05-01T00:00:00Z&limit=10", nil)
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		t.Fatalf("parseAuditFilter failed: %v", err)
+	}
+
+	want := data.AuditFilter{
+		Actor:      "a@example.com",
+		Action:     "drop",
+		Outcome:    "denied",
+		DatabaseID: 42,
+		From:       time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC),
+		Limit:      10,
+	}
+
+	if filter != want {
+		t.Errorf("expected %+v, got %+v", want, filter)
+	}
+
+	filter, err = parseAuditFilter(httptest.NewRequest("GET", "/api/admin/audit", nil))
+	if err != nil || filter.Limit != defaultAuditLimit {
+		t.Errorf("expected the default limit, got %+v, %v", filter, err)
+	}
+
+	for _, query := range []string{"database=x", "from=yesterday", "to=2018-05-01", "limit=-1"} {
+		_, err = parseAuditFilter(httptest.NewRequest("GET", "/api/admin/audit?"+query, nil))
+		if err == nil {
+			t.Errorf("expected %q to fail", query)
+		}
+	}
+}
+
+func TestWriteAuditCSV(t *testing.T) {
+	entries := []data.AuditEntry{
+		{
+			ID:         12,
+			Time:       time.Date(2018, 5, 2, 8, 0, 0, 0, time.UTC),
+			Actor:      "user@example.com",
+			Action:     "extend",
+			DatabaseID: 42,
+			Target:     "mysql-55/mydb",
+			Params:     `{"amount":"1","unit":"months"}`,
+			Outcome:    data.AuditSuccess,
+			RequestID:  "abc",
+		},
+	}
+
+	var buf bytes.Buffer
+	err := writeAuditCSV(&buf, entries)
+	if err != nil {
+		t.Fatalf("writeAuditCSV failed: %v", err)
+	}
+
+	want := "id,time,actor,action,database_id,target,params,outcome,message,request_id\n" +
+		`12,2018-05-02T08:00:00Z,user@example.com,extend,42,mysql-55/mydb,"{""amount"":""1"",""unit"":""months""}",success,,abc` + "\n"
+
+	if buf.String() != want {
+		t.Errorf("unexpected CSV:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/djavorszky/ddn/common/logger"
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/server/mail"
+)
+
+// defaultDiskWarnPercent is used if no disk warning threshold is configured.
+const defaultDiskWarnPercent = 90
+
+// capacityWarning is a threshold crossed by an agent. The key identifies the
+// threshold, so that the admins are only warned once while it stays crossed.
+type capacityWarning struct {
+	key     string
+	message string
+}
+
+var (
+	// warned holds the keys of the warnings that were already sent, per agent.
+	warned      = make(map[string]map[string]bool)
+	warnedMutex sync.Mutex
+)
+
+// capacityWarnings returns the thresholds the capacity crosses.
+func capacityWarnings(c model.Capacity) []capacityWarning {
+	diskLimit := config.DiskWarnPercent
+	if diskLimit <= 0 {
+		diskLimit = defaultDiskWarnPercent
+	}
+
+	var warnings []capacityWarning
+	for _, disk := range c.Disks {
+		used := disk.UsedPercent()
+		if used < diskLimit {
+			continue
+		}
+
+		warnings = append(warnings, capacityWarning{
+			key:     "disk:" + disk.Path,
+			message: fmt.Sprintf("Disk of %s (%s) is %.1f%% full, %s free of %s.", disk.Label, disk.Path, used, disk.HumanFree(), disk.HumanTotal()),
+		})
+	}
+
+	if config.ConnWarnCount > 0 && c.Connections >= config.ConnWarnCount {
+		warnings = append(warnings, capacityWarning{
+			key:     "connections",
+			message: fmt.Sprintf("Database server has %d connections open.", c.Connections),
+		})
+	}
+
+	return warnings
+}
+
+// checkCapacity emails the admins about the thresholds the agent newly crossed.
+// Thresholds the agent is back under are forgotten, so they are warned about again
+// if they're crossed once more.
+func checkCapacity(agent model.Agent) {
+	if agent.Capacity == nil {
+		return
+	}
+
+	warnings := capacityWarnings(*agent.Capacity)
+
+	warnedMutex.Lock()
+	previous := warned[agent.ShortName]
+	current := make(map[string]bool)
+
+	var fresh []string
+	for _, w := range warnings {
+		current[w.key] = true
+
+		if !previous[w.key] {
+			fresh = append(fresh, w.message)
+		}
+	}
+
+	warned[agent.ShortName] = current
+	warnedMutex.Unlock()
+
+	if len(fresh) == 0 {
+		return
+	}
+
+	logger.Warn("capacity of %s: %s", agent.ShortName, strings.Join(fresh, " "))
+
+	for _, addr := range config.AdminEmail {
+		mail.Send(addr, fmt.Sprintf("[Cloud DB] Agent %q is running out of capacity", agent.ShortName),
+			fmt.Sprintf("<h3>Agent %q</h3><ul><li>%s</li></ul>", agent.ShortName, strings.Join(fresh, "</li><li>")))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/djavorszky/ddn/common/model"
+)
+
+func TestCapacityWarnings(t *testing.T) {
+	defer func(old Config) { config = old }(config)
+
+	c := model.Capacity{
+		Disks: []model.DiskUsage{
+			{Label: "dumps", Path: "/dumps", Total: 100, Free: 5},
+			{Label: "exports", Path: "/exports", Total: 100, Free: 50},
+			{Label: "unknown", Path: "/unknown"},
+		},
+		Connections: 20,
+	}
+
+	config = Config{}
+
+	warnings := capacityWarnings(c)
+	if len(warnings) != 1 || warnings[0].key != "disk:/dumps" {
+		t.Errorf("default thresholds: unexpected warnings %v", warnings)
+	}
+
+	config = Config{DiskWarnPercent: 40, ConnWarnCount: 20}
+
+	var keys []string
+	for _, w := range capacityWarnings(c) {
+		keys = append(keys, w.key)
+	}
+
+	if len(keys) != 3 || keys[0] != "disk:/dumps" || keys[1] != "disk:/exports" || keys[2] != "connections" {
+		t.Errorf("configured thresholds: unexpected warnings %v", keys)
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	defer func(old Config) { config = old }(config)
+	config = Config{}
+
+	full := model.Capacity{Disks: []model.DiskUsage{{Label: "dumps", Path: "/dumps", Total: 100, Free: 1}}}
+	agent := model.Agent{ShortName: "capacity-test", Capacity: &full}
+
+	checkCapacity(agent)
+	if !warned[agent.ShortName]["disk:/dumps"] {
+		t.Fatalf("full disk was not warned about")
+	}
+
+	empty := model.Capacity{Disks: []model.DiskUsage{{Label: "dumps", Path: "/dumps", Total: 100, Free: 99}}}
+	agent.Capacity = &empty
+
+	checkCapacity(agent)
+	if len(warned[agent.ShortName]) != 0 {
+		t.Errorf("warning was not cleared: %v", warned[agent.ShortName])
+	}
+}
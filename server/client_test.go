@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn/client"
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/common/status"
+	vis "github.com/djavorszky/ddn/common/visibility"
+)
+
+func TestClient(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL, "alice@example.com")
+
+	agents, err := c.Agents(ctx)
+	if err != nil || len(agents) != 1 || agents[0].ShortName != "mysql-57" {
+		t.Fatalf("Agents returned %+v, %v", agents, err)
+	}
+
+	agent, err := c.Agent(ctx, "mysql-57")
+	if err != nil || agent.DBVendor != "mysql" {
+		t.Errorf("Agent returned %+v, %v", agent, err)
+	}
+
+	created, err := c.Create(ctx, model.ClientRequest{
+		Vendor:    "mysql",
+		DBRequest: model.DBRequest{DatabaseName: "clientdb", Username: "clientuser", Password: "clientpass"},
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if created.ID == 0 || created.Agent.ShortName != "mysql-57" || !srv.agent.called("create-database") {
+		t.Errorf("expected the database to be created on mysql-57, got %+v", created)
+	}
+
+	row, err := c.Database(ctx, created.ID)
+	if err != nil || row.DBName != "clientdb" {
+		t.Errorf("Database returned %+v, %v", row, err)
+	}
+
+	row, err = c.DatabaseByName(ctx, "mysql-57", "clientdb")
+	if err != nil || row.ID != created.ID {
+		t.Errorf("DatabaseByName returned %+v, %v", row, err)
+	}
+
+	rows, next, err := c.Databases(ctx, client.DatabaseQuery{Name: "client", Status: []int{status.Success}, Limit: 1})
+	if err != nil || len(rows) != 1 || rows[0].ID != created.ID || next != "" {
+		t.Errorf("Databases returned %+v, %q, %v", rows, next, err)
+	}
+
+	err = c.SetVisibility(ctx, created.ID, true)
+	if err != nil {
+		t.Errorf("SetVisibility failed: %v", err)
+	}
+
+	row, _ = c.Database(ctx, created.ID)
+	if row.Public != vis.Public {
+		t.Errorf("expected the database to be public, got %d", row.Public)
+	}
+
+	expiry, err := c.ExtendExpiry(ctx, created.ID, 2, "days")
+	if err != nil || !expiry.Equal(row.ExpiryDate.AddDate(0, 0, 2)) {
+		t.Errorf("ExtendExpiry returned %v, %v, expected %v", expiry, err, row.ExpiryDate.AddDate(0, 0, 2))
+	}
+
+	access, err := c.AccessInfo(ctx, created.ID)
+	if err != nil || access.User != "clientuser" || access.Password != "clientpass" || access.Database != "clientdb" {
+		t.Errorf("AccessInfo returned %+v, %v", access, err)
+	}
+
+	_, err = c.Export(ctx, created.ID)
+	if err != nil || !srv.agent.called("export-database") {
+		t.Errorf("Export failed: %v", err)
+	}
+
+	_, err = c.Recreate(ctx, created.ID)
+	if err != nil || !srv.agent.called("drop-database") {
+		t.Errorf("Recreate failed: %v", err)
+	}
+
+	err = c.Drop(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Drop failed: %v", err)
+	}
+
+	// The row is removed once the agent dropped the database
+	waitCtx, cancelWait := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelWait()
+
+	_, err = c.Wait(waitCtx, created.ID, 50*time.Millisecond)
+	if e, ok := err.(*client.Error); !ok || e.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the database to be gone, got %v", err)
+	}
+
+	_, err = client.New(srv.URL, "").Agents(ctx)
+	if e, ok := err.(*client.Error); !ok || e.StatusCode != http.StatusForbidden || e.Status != status.AccessDenied {
+		t.Errorf("expected a 403 without a user, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = c.Agents(cancelled)
+	if _, ok := err.(*client.Error); err == nil || ok {
+		t.Errorf("expected the cancelled call to fail before reaching the server, got %v", err)
+	}
+}
+
+func TestClientUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddn-upload")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "web", "dumps"), 0755)
+	if err != nil {
+		t.Fatalf("could not create dumps dir: %v", err)
+	}
+
+	defer func(old string) { workdir = old }(workdir)
+	workdir = dir
+
+	srv := httptest.NewServer(Router())
+	defer srv.Close()
+
+	c := client.New(srv.URL, "alice@example.com")
+
+	location, err := c.Upload(context.Background(), "/home/alice/dumps/mydump.sql", strings.NewReader("CREATE TABLE t (id int);"))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if !strings.Contains(location, "/dumps/upload-") || !strings.HasSuffix(location, "-mydump.sql") {
+		t.Errorf("expected the location of the dump, got %q", location)
+	}
+
+	// Uploading a dump of the same name doesn't overwrite the first one
+	other, err := c.Upload(context.Background(), "mydump.sql", strings.NewReader("DROP TABLE t;"))
+	if err != nil || other == location {
+		t.Fatalf("expected the second upload to be saved elsewhere, got %q, %v", other, err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "web", "dumps", path.Base(location)))
+	if err != nil || string(b) != "CREATE TABLE t (id int);" {
+		t.Errorf("expected the dump to be saved, got %q, %v", b, err)
+	}
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/djavorszky/ddn/common/discovery"
+	"github.com/djavorszky/ddn/common/inet"
 	"github.com/djavorszky/ddn/common/logger"
 )
 
@@ -12,6 +17,7 @@ type Config struct {
 	DBUser            string   `toml:"db-username"`
 	DBPass            string   `toml:"db-userpass"`
 	DBName            string   `toml:"db-name"`
+	DBSSLMode         string   `toml:"db-sslmode"`
 	ServerHost        string   `toml:"server-host"`
 	ServerPort        string   `toml:"server-port"`
 	SMTPAddr          string   `toml:"smtp-host"`
@@ -25,13 +31,62 @@ type Config struct {
 	WebPushSubscriber string   `toml:"webpush-subscriber"`
 	VAPIDPrivateKey   string   `toml:"vapid-private-key"`
 	GoogleAnalyticsID string   `toml:"google-analytics-id"`
+	ReconcileInterval int      `toml:"reconcile-interval-minutes"`
+	ReconcileAdopt    bool     `toml:"reconcile-adopt"`
+	ReconcileCleanup  bool     `toml:"reconcile-cleanup"`
+	DiskWarnPercent   float64  `toml:"disk-warning-percent"`
+	ConnWarnCount     int      `toml:"connection-warning-count"`
+	DBPassKeys        []string `toml:"dbpass-keys"`
+	TLSCert           string   `toml:"tls-cert"`
+	TLSKey            string   `toml:"tls-key"`
+	TLSRootCA         string   `toml:"tls-root-ca"`
+	TLSClientCA       string   `toml:"tls-client-ca"`
+	DiscoveryGroup    string   `toml:"discovery-group"`
+	DiscoveryDisabled bool     `toml:"discovery-disabled"`
+	DiscoveryToken    string   `toml:"discovery-token"`
+	APIv1Sunset       string   `toml:"api-v1-sunset"`
+	RealIPHeader      string   `toml:"real-ip-header"`
+}
+
+// TLS returns the TLS configuration of the server.
+func (c Config) TLS() inet.TLSConfig {
+	return inet.TLSConfig{
+		CertFile:     c.TLSCert,
+		KeyFile:      c.TLSKey,
+		RootCAFile:   c.TLSRootCA,
+		ClientCAFile: c.TLSClientCA,
+	}
+}
+
+// discoveryGroup returns the multicast group the server is announced on.
+func (c Config) discoveryGroup() string {
+	if c.DiscoveryGroup == "" {
+		return discovery.DefaultGroup
+	}
+
+	return c.DiscoveryGroup
+}
+
+// apiV1Sunset returns the date the v1 API is removed on, or the zero time if
+// it's not set.
+func (c Config) apiV1Sunset() (time.Time, error) {
+	if c.APIv1Sunset == "" {
+		return time.Time{}, nil
+	}
+
+	sunset, err := time.Parse("2006-01-02", c.APIv1Sunset)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", c.APIv1Sunset)
+	}
+
+	return sunset, nil
 }
 
 // Print prints the configuration to the log.
 func (c Config) Print() {
 	logger.Info("Database Provider:\t\t%s", c.DBProvider)
 
-	if c.DBProvider == "mysql" {
+	if c.DBProvider == "mysql" || c.DBProvider == "postgres" {
 		logger.Info("Database Address:\t\t%s", c.DBAddress)
 		logger.Info("Database Port:\t\t%s", c.DBPort)
 		logger.Info("Database User:\t\t%s", c.DBUser)
@@ -43,11 +98,39 @@ func (c Config) Print() {
 	logger.Info("Server Host:\t\t%s", c.ServerHost)
 	logger.Info("Server Port:\t\t%s", c.ServerPort)
 
+	if c.TLS().Mutual() {
+		logger.Info("TLS:\t\t\tenabled, agents need client certificates")
+	} else if c.TLS().Enabled() {
+		logger.Info("TLS:\t\t\tenabled")
+	}
+
+	if !c.DiscoveryDisabled && c.DiscoveryToken != "" {
+		logger.Info("Announcing on:\t\t%s, signed", c.discoveryGroup())
+	} else if !c.DiscoveryDisabled {
+		logger.Info("Announcing on:\t\t%s", c.discoveryGroup())
+	}
+
 	if c.SMTPAddr != "" && c.SMTPPort != 0 && c.EmailSender != "" {
 		logger.Info("Admin email:\t\t%s", c.AdminEmail)
 		logger.Info("Server configured to send emails.")
 	}
 
+	if c.ReconcileAdopt || c.ReconcileCleanup {
+		logger.Info("Reconciliation:\t\tadopt: %t, cleanup: %t", c.ReconcileAdopt, c.ReconcileCleanup)
+	}
+
+	if c.APIv1Sunset != "" {
+		logger.Info("API v1 removed on:\t\t%s", c.APIv1Sunset)
+	}
+
+	if c.RealIPHeader != "" {
+		logger.Info("Client IPs taken from:\t%s", c.RealIPHeader)
+	}
+
+	if len(c.DBPassKeys) > 0 {
+		logger.Info("Database passwords are encrypted.")
+	}
+
 	if c.GoogleAnalyticsID != "" {
 		logger.Info("Google analytics enabled.")
 	}
@@ -0,0 +1,35 @@
+package data
+
+import "time"
+
+// Outcomes of the actions recorded in the audit log
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditEntry is a single action recorded in the audit log
+type AuditEntry struct {
+	ID         int       `json:"id"`
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	DatabaseID int       `json:"database_id"`
+	Target     string    `json:"target"`
+	Params     string    `json:"params"`
+	Outcome    string    `json:"outcome"`
+	Message    string    `json:"message"`
+	RequestID  string    `json:"request_id"`
+}
+
+// AuditFilter narrows down the entries fetched from the audit log. Fields
+// left at their zero value match every entry.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	Outcome    string
+	DatabaseID int
+	From, To   time.Time
+	Limit      int
+}
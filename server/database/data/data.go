@@ -12,7 +12,7 @@ type Row struct {
 	DBVendor   string    `json:"vendor"`
 	DBName     string    `json:"dbname"`
 	DBUser     string    `json:"dbuser"`
-	DBPass     string    `json:"dbpass"`
+	DBPass     string    `json:"-"` // Encrypted, only sent as part of the access info
 	DBSID      string    `json:"sid"`
 	Dumpfile   string    `json:"dumplocation"`
 	CreateDate time.Time `json:"createdate"`
@@ -0,0 +1,37 @@
+package data
+
+import "time"
+
+// RowFilter narrows down, sorts and pages the rows fetched. Fields left at
+// their zero value match every row.
+type RowFilter struct {
+	// VisibleTo limits the rows to the public ones and the ones created by the user
+	VisibleTo string
+
+	Vendor  string
+	Agent   string
+	Creator string
+	Status  []int
+
+	// Name matches the rows whose database name contains it, ignoring case
+	Name string
+
+	CreatedFrom, CreatedTo time.Time
+	ExpiresFrom, ExpiresTo time.Time
+
+	// Sort is the field to sort by, as named in the JSON of the rows, e.g.
+	// "createdate". Rows with the same value are sorted by their ID. Defaults to "id".
+	Sort string
+	Desc bool
+
+	// After continues from a previous page, returning the rows sorted after it
+	After *RowCursor
+	Limit int
+}
+
+// RowCursor marks the last row of a page: its ID, and its value of the field
+// the rows are sorted by.
+type RowCursor struct {
+	ID    int
+	Value interface{}
+}
@@ -1,8 +1,10 @@
 package dbutil
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/djavorszky/ddn/server/database/data"
@@ -157,3 +159,226 @@ func ReadSubscriptionRows(rows *sql.Rows) (webpush.Subscription, error) {
 
 	return row, nil
 }
+
+// ReadSubscriberRows reads an sql.Rows into the subscriber and its subscription
+func ReadSubscriberRows(rows *sql.Rows) (string, webpush.Subscription, error) {
+	var (
+		subscriber string
+		row        webpush.Subscription
+	)
+
+	err := rows.Scan(
+		&subscriber,
+		&row.Endpoint,
+		&row.Keys.P256dh,
+		&row.Keys.Auth)
+	if err != nil {
+		return subscriber, row, fmt.Errorf("failed reading row: %v", err)
+	}
+
+	return subscriber, row, nil
+}
+
+// AuditQuery returns the query and its arguments that select the entries of the
+// audit log matching the filter, newest first.
+func AuditQuery(filter data.AuditFilter) (string, []interface{}) {
+	var (
+		query bytes.Buffer
+		args  []interface{}
+	)
+
+	query.WriteString("SELECT id, time, actor, action, databaseId, target, params, outcome, message, requestId FROM `audit` WHERE 1 = 1")
+
+	if filter.Actor != "" {
+		query.WriteString(" AND actor = ?")
+		args = append(args, filter.Actor)
+	}
+
+	if filter.Action != "" {
+		query.WriteString(" AND action = ?")
+		args = append(args, filter.Action)
+	}
+
+	if filter.Outcome != "" {
+		query.WriteString(" AND outcome = ?")
+		args = append(args, filter.Outcome)
+	}
+
+	if filter.DatabaseID != 0 {
+		query.WriteString(" AND databaseId = ?")
+		args = append(args, filter.DatabaseID)
+	}
+
+	if !filter.From.IsZero() {
+		query.WriteString(" AND time >= ?")
+		args = append(args, filter.From.UTC())
+	}
+
+	if !filter.To.IsZero() {
+		query.WriteString(" AND time < ?")
+		args = append(args, filter.To.UTC())
+	}
+
+	query.WriteString(" ORDER BY id DESC")
+
+	if filter.Limit > 0 {
+		query.WriteString(" LIMIT ?")
+		args = append(args, filter.Limit)
+	}
+
+	return query.String(), args
+}
+
+// RowSortColumns maps the fields the rows can be sorted by to their columns.
+var RowSortColumns = map[string]string{
+	"id":         "id",
+	"dbname":     "dbname",
+	"vendor":     "dbvendor",
+	"agent":      "agentName",
+	"creator":    "creator",
+	"status":     "status",
+	"createdate": "createDate",
+	"expirydate": "expiryDate",
+}
+
+// RowQuery returns the query and its arguments that select the rows matching
+// the filter, in the order it asks for.
+func RowQuery(filter data.RowFilter) (string, []interface{}) {
+	var query bytes.Buffer
+
+	query.WriteString("SELECT * FROM `databases` WHERE 1 = 1")
+
+	args := writeRowConditions(&query, filter)
+
+	column, ok := RowSortColumns[filter.Sort]
+	if !ok {
+		column = "id"
+	}
+
+	dir, cmp := "ASC", ">"
+	if filter.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if filter.After != nil {
+		if column == "id" {
+			query.WriteString(fmt.Sprintf(" AND id %s ?", cmp))
+			args = append(args, filter.After.ID)
+		} else {
+			query.WriteString(fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, cmp))
+			args = append(args, filter.After.Value, filter.After.Value, filter.After.ID)
+		}
+	}
+
+	if column == "id" {
+		query.WriteString(fmt.Sprintf(" ORDER BY id %s", dir))
+	} else {
+		query.WriteString(fmt.Sprintf(" ORDER BY %s %s, id %s", column, dir, dir))
+	}
+
+	if filter.Limit > 0 {
+		query.WriteString(" LIMIT ?")
+		args = append(args, filter.Limit)
+	}
+
+	return query.String(), args
+}
+
+// RowCountQuery returns the query and its arguments that count the rows matching
+// the filter. The sorting, cursor and limit of the filter are ignored.
+func RowCountQuery(filter data.RowFilter) (string, []interface{}) {
+	var query bytes.Buffer
+
+	query.WriteString("SELECT count(*) FROM `databases` WHERE 1 = 1")
+
+	args := writeRowConditions(&query, filter)
+
+	return query.String(), args
+}
+
+// writeRowConditions writes the conditions of the filter to the query, and
+// returns their arguments.
+func writeRowConditions(query *bytes.Buffer, filter data.RowFilter) []interface{} {
+	var args []interface{}
+
+	if filter.VisibleTo != "" {
+		query.WriteString(" AND (creator = ? OR visibility = 1)")
+		args = append(args, filter.VisibleTo)
+	}
+
+	if filter.Vendor != "" {
+		query.WriteString(" AND dbvendor = ?")
+		args = append(args, filter.Vendor)
+	}
+
+	if filter.Agent != "" {
+		query.WriteString(" AND agentName = ?")
+		args = append(args, filter.Agent)
+	}
+
+	if filter.Creator != "" {
+		query.WriteString(" AND creator = ?")
+		args = append(args, filter.Creator)
+	}
+
+	if len(filter.Status) > 0 {
+		query.WriteString(" AND status IN (")
+		for i, status := range filter.Status {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+
+			query.WriteString("?")
+			args = append(args, status)
+		}
+		query.WriteString(")")
+	}
+
+	if filter.Name != "" {
+		query.WriteString(" AND LOWER(dbname) LIKE ? ESCAPE '!'")
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(filter.Name))+"%")
+	}
+
+	for _, cond := range []struct {
+		op, column string
+		value      time.Time
+	}{
+		{">=", "createDate", filter.CreatedFrom},
+		{"<", "createDate", filter.CreatedTo},
+		{">=", "expiryDate", filter.ExpiresFrom},
+		{"<", "expiryDate", filter.ExpiresTo},
+	} {
+		if !cond.value.IsZero() {
+			query.WriteString(fmt.Sprintf(" AND %s %s ?", cond.column, cond.op))
+			args = append(args, cond.value)
+		}
+	}
+
+	return args
+}
+
+// likeEscaper escapes the wildcards of LIKE, with ! as the escape character
+// as it needs no escaping in any of the backends.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// ReadAuditRows reads an sql.Rows into a data.AuditEntry
+func ReadAuditRows(rows *sql.Rows) (data.AuditEntry, error) {
+	var entry data.AuditEntry
+
+	err := rows.Scan(
+		&entry.ID,
+		&entry.Time,
+		&entry.Actor,
+		&entry.Action,
+		&entry.DatabaseID,
+		&entry.Target,
+		&entry.Params,
+		&entry.Outcome,
+		&entry.Message,
+		&entry.RequestID)
+	if err != nil {
+		return entry, fmt.Errorf("failed reading row: %v", err)
+	}
+
+	return entry, nil
+}
@@ -19,6 +19,8 @@ type BackendConnection interface {
 	FetchByCreator(creator string) ([]data.Row, error)
 	FetchPublic() ([]data.Row, error)
 	FetchAll() ([]data.Row, error)
+	FetchRows(filter data.RowFilter) ([]data.Row, error)
+	CountRows(filter data.RowFilter) (int, error)
 
 	Insert(row *data.Row) error
 	Update(row *data.Row) error
@@ -27,4 +29,12 @@ type BackendConnection interface {
 	InsertPushSubscription(row *model.PushSubscription, subscriber string) error
 	DeletePushSubscription(row *model.PushSubscription, subscriber string) error
 	FetchUserPushSubscriptions(subscriber string) ([]webpush.Subscription, error)
+
+	// Used to copy everything to another backend
+	Restore(row data.Row) error
+	FetchAllPushSubscriptions() (map[string][]webpush.Subscription, error)
+
+	// The audit log is append-only, entries can't be updated or removed
+	InsertAudit(entry *data.AuditEntry) error
+	FetchAudit(filter data.AuditFilter) ([]data.AuditEntry, error)
 }
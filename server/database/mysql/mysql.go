@@ -9,6 +9,7 @@ import (
 	"github.com/djavorszky/ddn/common/model"
 	"github.com/djavorszky/ddn/server/database/data"
 	"github.com/djavorszky/ddn/server/database/dbutil"
+	"github.com/djavorszky/ddn/server/database/secret"
 	"github.com/djavorszky/sutils"
 	webpush "github.com/sherclockholmes/webpush-go"
 
@@ -19,7 +20,11 @@ import (
 // DB implements the BackendConnection
 type DB struct {
 	Address, Port, User, Pass, Database string
-	conn                                *sql.DB
+
+	// Keys encrypt the passwords of the databases. If nil, they are stored as they are.
+	Keys *secret.Keyring
+
+	conn *sql.DB
 }
 
 // ConnectAndPrepare establishes a database connection and initializes the tables, if needed
@@ -172,6 +177,55 @@ func (mys *DB) FetchAll() ([]data.Row, error) {
 	return entries, nil
 }
 
+// CountRows returns the number of rows that match the filter
+func (mys *DB) CountRows(filter data.RowFilter) (int, error) {
+	if err := mys.alive(); err != nil {
+		return 0, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query, args := dbutil.RowCountQuery(filter)
+
+	var count int
+	err := mys.conn.QueryRow(query, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+
+	return count, nil
+}
+
+// FetchRows returns the rows that match the filter, sorted and limited as it asks
+func (mys *DB) FetchRows(filter data.RowFilter) ([]data.Row, error) {
+	if err := mys.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query, args := dbutil.RowQuery(filter)
+
+	rows, err := mys.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	entries := make([]data.Row, 0)
+	for rows.Next() {
+		row, err := dbutil.ReadRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, row)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
 // FetchUserPushSubscriptions fetches the subscriptions for the specified user
 func (mys *DB) FetchUserPushSubscriptions(subscriber string) ([]webpush.Subscription, error) {
 	if err := mys.alive(); err != nil {
@@ -213,12 +267,17 @@ func (mys *DB) Insert(entry *data.Row) error {
 		return fmt.Errorf("database down: %s", err.Error())
 	}
 
+	dbpass, err := mys.Keys.Encrypt(entry.DBPass)
+	if err != nil {
+		return fmt.Errorf("encrypting password failed: %v", err)
+	}
+
 	query := "INSERT INTO `databases` (`dbname`, `dbuser`, `dbpass`, `dbsid`, `dumpfile`, `createDate`, `expiryDate`, `creator`, `agentName`, `dbAddress`, `dbPort`, `dbvendor`, `status`, `message`, `visibility`, `comment`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 
 	res, err := mys.conn.Exec(query,
 		entry.DBName,
 		entry.DBUser,
-		entry.DBPass,
+		dbpass,
 		entry.DBSID,
 		entry.Dumpfile,
 		entry.CreateDate,
@@ -293,12 +352,17 @@ func (mys *DB) Update(entry *data.Row) error {
 		return mys.Insert(entry)
 	}
 
+	dbpass, err := mys.Keys.Encrypt(entry.DBPass)
+	if err != nil {
+		return fmt.Errorf("encrypting password failed: %v", err)
+	}
+
 	query := "UPDATE `databases` SET `dbname`= ?, `dbuser`= ?, `dbpass`= ?, `dbsid`= ?, `dumpfile`= ?, `createDate`= ?, `expiryDate`= ?, `creator`= ?, `agentName`= ?, `dbAddress`= ?, `dbPort`= ?, `dbvendor`= ?, `status`= ?, `message`= ?, `visibility`= ?, `comment` = ? WHERE id = ?"
 
 	_, err = mys.conn.Exec(query,
 		entry.DBName,
 		entry.DBUser,
-		entry.DBPass,
+		dbpass,
 		entry.DBSID,
 		entry.Dumpfile,
 		entry.CreateDate,
@@ -366,6 +430,136 @@ func (mys *DB) DeletePushSubscription(subscription *model.PushSubscription, subs
 	return err
 }
 
+// Restore inserts the row as it is, keeping its ID and its password, which is
+// not encrypted again. It is used to copy rows from another backend.
+func (mys *DB) Restore(entry data.Row) error {
+	if err := mys.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query := "INSERT INTO `databases` (`id`, `dbname`, `dbuser`, `dbpass`, `dbsid`, `dumpfile`, `createDate`, `expiryDate`, `creator`, `agentName`, `dbAddress`, `dbPort`, `dbvendor`, `status`, `message`, `visibility`, `comment`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	_, err := mys.conn.Exec(query,
+		entry.ID,
+		entry.DBName,
+		entry.DBUser,
+		entry.DBPass,
+		entry.DBSID,
+		entry.Dumpfile,
+		entry.CreateDate,
+		entry.ExpiryDate,
+		entry.Creator,
+		entry.AgentName,
+		entry.DBAddress,
+		entry.DBPort,
+		entry.DBVendor,
+		entry.Status,
+		entry.Message,
+		entry.Public,
+		entry.Comment,
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	return nil
+}
+
+// FetchAllPushSubscriptions returns the subscriptions of every user, by subscriber
+func (mys *DB) FetchAllPushSubscriptions() (map[string][]webpush.Subscription, error) {
+	if err := mys.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	rows, err := mys.conn.Query("SELECT subscriber, endpoint, p256dh_key, auth_key FROM `push_subscriptions`")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	entries := make(map[string][]webpush.Subscription)
+	for rows.Next() {
+		subscriber, row, err := dbutil.ReadSubscriberRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries[subscriber] = append(entries[subscriber], row)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
+// InsertAudit appends an entry to the audit log
+func (mys *DB) InsertAudit(entry *data.AuditEntry) error {
+	if err := mys.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query := "INSERT INTO `audit` (`time`, `actor`, `action`, `databaseId`, `target`, `params`, `outcome`, `message`, `requestId`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	res, err := mys.conn.Exec(query,
+		entry.Time.UTC(),
+		entry.Actor,
+		entry.Action,
+		entry.DatabaseID,
+		entry.Target,
+		entry.Params,
+		entry.Outcome,
+		entry.Message,
+		entry.RequestID,
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed getting new ID: %v", err)
+	}
+
+	entry.ID = int(id)
+
+	return nil
+}
+
+// FetchAudit returns the entries of the audit log that match the filter, newest first
+func (mys *DB) FetchAudit(filter data.AuditFilter) ([]data.AuditEntry, error) {
+	if err := mys.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query, args := dbutil.AuditQuery(filter)
+
+	rows, err := mys.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	entries := make([]data.AuditEntry, 0)
+	for rows.Next() {
+		entry, err := dbutil.ReadAuditRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
 type dbUpdate struct {
 	Query   string
 	Comment string
@@ -416,6 +610,14 @@ var queries = []dbUpdate{
 		Query:   "CREATE UNIQUE INDEX `agent_db_idx` ON `databases` (`dbname`, `agentName`);",
 		Comment: "Create unique index on columns (dbname, agentName) for table databases",
 	},
+	{
+		Query:   "CREATE TABLE IF NOT EXISTS `audit` ( `id` INT NOT NULL AUTO_INCREMENT, `time` DATETIME NOT NULL, `actor` VARCHAR(255) NOT NULL, `action` VARCHAR(45) NOT NULL, `databaseId` INT NOT NULL DEFAULT 0, `target` VARCHAR(255) NOT NULL, `params` LONGTEXT NOT NULL, `outcome` VARCHAR(45) NOT NULL, `message` LONGTEXT NOT NULL, `requestId` VARCHAR(45) NOT NULL, PRIMARY KEY (`id`));",
+		Comment: "Create the audit table",
+	},
+	{
+		Query:   "CREATE INDEX `audit_time_idx` ON `audit` (`time`);",
+		Comment: "Create index on column time for table audit",
+	},
 }
 
 func (mys *DB) connect(datasource string) error {
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -578,3 +579,212 @@ func TestDeleteUserPushNotification(t *testing.T) {
 		})
 	}
 }
+
+func TestAudit(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+
+	entries := []data.AuditEntry{
+		{Time: time.Now(), Actor: "alice@example.com", Action: "drop", DatabaseID: 1, Target: "mysql-55/first", Params: "{}", Outcome: data.AuditSuccess},
+		{Time: time.Now(), Actor: "bob@example.com", Action: "drop", DatabaseID: 2, Target: "mysql-55/second", Params: "{}", Outcome: data.AuditDenied, Message: "access denied"},
+		{Time: time.Now(), Actor: "alice@example.com", Action: "extend", DatabaseID: 1, Target: "mysql-55/first", Params: "{\"amount\":\"30\"}", Outcome: data.AuditSuccess, RequestID: "abc"},
+	}
+
+	for i := range entries {
+		err := mys.InsertAudit(&entries[i])
+		if err != nil {
+			t.Fatalf("InsertAudit failed: %v", err)
+		}
+
+		if entries[i].ID == 0 {
+			t.Errorf("InsertAudit did not set the ID")
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter data.AuditFilter
+		want   []int
+	}{
+		{"all", data.AuditFilter{}, []int{2, 1, 0}},
+		{"actor", data.AuditFilter{Actor: "alice@example.com"}, []int{2, 0}},
+		{"action and outcome", data.AuditFilter{Action: "drop", Outcome: data.AuditDenied}, []int{1}},
+		{"database", data.AuditFilter{DatabaseID: 2}, []int{1}},
+		{"time range", data.AuditFilter{From: start, To: time.Now().Add(time.Minute)}, []int{2, 1, 0}},
+		{"in the future", data.AuditFilter{From: time.Now().Add(time.Minute)}, []int{}},
+		{"limit", data.AuditFilter{Limit: 1}, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mys.FetchAudit(tt.filter)
+			if err != nil {
+				t.Fatalf("FetchAudit failed: %v", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d entries, got %d: %v", len(tt.want), len(got), got)
+			}
+
+			for i, idx := range tt.want {
+				want := entries[idx]
+				if got[i].ID != want.ID || got[i].Actor != want.Actor || got[i].Params != want.Params || got[i].Message != want.Message || got[i].RequestID != want.RequestID {
+					t.Errorf("entry %d mismatch: expected %+v, got %+v", i, want, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	entry := testEntry
+	entry.DBName = "restoredDB"
+	entry.ID = 4242
+	entry.DBPass = "enc:k1:notreallyencrypted"
+
+	err := mys.Restore(entry)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	res, err := mys.FetchByID(entry.ID)
+	if err != nil {
+		t.Fatalf("FetchByID failed: %v", err)
+	}
+
+	if err = dbutil.CompareRows(entry, res); err != nil {
+		t.Errorf("Restored and read rows not the same: %v", err)
+	}
+
+	if err = mys.Restore(entry); err == nil {
+		t.Errorf("Restoring a row with a taken ID should have failed")
+	}
+
+	// Rows inserted afterwards get IDs after the restored one
+	next := entry
+	next.DBName = "afterRestore"
+	err = mys.Insert(&next)
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	if next.ID <= entry.ID {
+		t.Errorf("expected an ID higher than %d, got %d", entry.ID, next.ID)
+	}
+}
+
+func TestFetchAllPushSubscriptions(t *testing.T) {
+	subscriptions := map[string][]string{
+		"first@example.com":  {"firstEndpoint", "secondEndpoint"},
+		"second@example.com": {"thirdEndpoint"},
+	}
+
+	for subscriber, endpoints := range subscriptions {
+		for _, endpoint := range endpoints {
+			err := mys.InsertPushSubscription(&model.PushSubscription{
+				Endpoint: endpoint,
+				Keys:     webpush.Keys{P256dh: "key-" + endpoint, Auth: "auth-" + endpoint},
+			}, subscriber)
+			if err != nil {
+				t.Fatalf("InsertPushSubscription failed: %v", err)
+			}
+		}
+	}
+
+	all, err := mys.FetchAllPushSubscriptions()
+	if err != nil {
+		t.Fatalf("FetchAllPushSubscriptions failed: %v", err)
+	}
+
+	for subscriber, endpoints := range subscriptions {
+		if len(all[subscriber]) != len(endpoints) {
+			t.Errorf("expected %d subscriptions for %s, got %v", len(endpoints), subscriber, all[subscriber])
+			continue
+		}
+
+		for i, s := range all[subscriber] {
+			if s.Endpoint != endpoints[i] || s.Keys.P256dh != "key-"+endpoints[i] || s.Keys.Auth != "auth-"+endpoints[i] {
+				t.Errorf("unexpected subscription for %s: %+v", subscriber, s)
+			}
+		}
+	}
+}
+
+func TestFetchRows(t *testing.T) {
+	names := []string{"alpha_one", "Beta_two", "gamma%three", "delta_four"}
+	base := time.Now().In(gmt).Add(-time.Hour)
+
+	ids := make(map[string]int)
+	for i, name := range names {
+		entry := testEntry
+		entry.AgentName = "filterAgent"
+		entry.DBName = name
+		entry.Creator = "filter@example.com"
+		entry.CreateDate = base.Add(time.Duration(i) * time.Minute)
+		entry.ExpiryDate = base.AddDate(0, 0, 30-i)
+		entry.Status = 100 + i
+		entry.Public = 0
+
+		if name == "delta_four" {
+			entry.Creator = "other@example.com"
+			entry.Public = 1
+		}
+
+		err := mys.Insert(&entry)
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+
+		ids[name] = entry.ID
+	}
+
+	// Every filter names the agent, so rows of the other tests don't count
+	tests := []struct {
+		name   string
+		filter data.RowFilter
+		want   []string
+	}{
+		{"all", data.RowFilter{}, names},
+		{"descending", data.RowFilter{Desc: true}, []string{"delta_four", "gamma%three", "Beta_two", "alpha_one"}},
+		{"visible to", data.RowFilter{VisibleTo: "filter@example.com"}, names},
+		{"visible to someone else", data.RowFilter{VisibleTo: "nobody@example.com"}, []string{"delta_four"}},
+		{"creator", data.RowFilter{Creator: "other@example.com"}, []string{"delta_four"}},
+		{"name ignoring case", data.RowFilter{Name: "BETA"}, []string{"Beta_two"}},
+		{"name with wildcard", data.RowFilter{Name: "%"}, []string{"gamma%three"}},
+		{"name with underscore", data.RowFilter{Name: "a_o"}, []string{"alpha_one"}},
+		{"status", data.RowFilter{Status: []int{101, 103}}, []string{"Beta_two", "delta_four"}},
+		{"created range", data.RowFilter{CreatedFrom: base.Add(time.Minute), CreatedTo: base.Add(3 * time.Minute)}, []string{"Beta_two", "gamma%three"}},
+		{"expiry range", data.RowFilter{ExpiresFrom: base.AddDate(0, 0, 28)}, []string{"alpha_one", "Beta_two", "gamma%three"}},
+		{"sort by expiry", data.RowFilter{Sort: "expirydate"}, []string{"delta_four", "gamma%three", "Beta_two", "alpha_one"}},
+		{"limit", data.RowFilter{Limit: 2}, []string{"alpha_one", "Beta_two"}},
+		{"after id", data.RowFilter{After: &data.RowCursor{ID: ids["Beta_two"]}}, []string{"gamma%three", "delta_four"}},
+		{"after expiry", data.RowFilter{Sort: "expirydate", Desc: true, Limit: 2, After: &data.RowCursor{ID: ids["Beta_two"], Value: base.AddDate(0, 0, 29)}}, []string{"gamma%three", "delta_four"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.Agent = "filterAgent"
+
+			rows, err := mys.FetchRows(tt.filter)
+			if err != nil {
+				t.Fatalf("FetchRows failed: %v", err)
+			}
+
+			// Counting ignores the cursor and the limit
+			if tt.filter.After == nil && tt.filter.Limit == 0 {
+				count, err := mys.CountRows(tt.filter)
+				if err != nil || count != len(tt.want) {
+					t.Errorf("CountRows() = %d, %v, want %d", count, err, len(tt.want))
+				}
+			}
+
+			var got []string
+			for _, row := range rows {
+				got = append(got, row.DBName)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("FetchRows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
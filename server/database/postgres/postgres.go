@@ -0,0 +1,667 @@
+package postgres
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/djavorszky/ddn/common/logger"
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/server/database/data"
+	"github.com/djavorszky/ddn/server/database/dbutil"
+	"github.com/djavorszky/ddn/server/database/secret"
+	"github.com/djavorszky/sutils"
+	webpush "github.com/sherclockholmes/webpush-go"
+
+	// Db
+	_ "github.com/lib/pq"
+)
+
+// DB implements the BackendConnection
+type DB struct {
+	Address, Port, User, Pass, Database string
+
+	// SSLMode is passed on to the driver as is. If empty, SSL is disabled.
+	SSLMode string
+
+	// Keys encrypt the passwords of the databases. If nil, they are stored as they are.
+	Keys *secret.Keyring
+
+	conn *sql.DB
+}
+
+// ConnectAndPrepare establishes a database connection and initializes the tables, if needed
+func (pg *DB) ConnectAndPrepare() error {
+	err := pg.connect(pg.datasource("postgres"))
+	if err != nil {
+		return fmt.Errorf("couldn't connect to the database: %s", err.Error())
+	}
+
+	var exists bool
+
+	err = pg.conn.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", pg.Database).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("checking if the database exists failed: %s", sutils.TrimNL(err.Error()))
+	}
+
+	if !exists {
+		_, err = pg.conn.Exec(fmt.Sprintf("CREATE DATABASE %s ENCODING 'UTF8';", pg.Database))
+		if err != nil {
+			return fmt.Errorf("executing create database query failed: %s", sutils.TrimNL(err.Error()))
+		}
+	}
+
+	pg.Close()
+
+	err = pg.connect(pg.datasource(pg.Database))
+	if err != nil {
+		return fmt.Errorf("couldn't connect to the database: %s", err.Error())
+	}
+
+	err = pg.initTables()
+	if err != nil {
+		return fmt.Errorf("initializing tables failed: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Close closes the database connection
+func (pg *DB) Close() error {
+	return pg.conn.Close()
+}
+
+// FetchByID returns the entry associated with that ID, or
+// an error if it does not exist
+func (pg *DB) FetchByID(ID int) (data.Row, error) {
+	if err := pg.alive(); err != nil {
+		return data.Row{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	row := pg.conn.QueryRow("SELECT * FROM databases WHERE id = $1", ID)
+	res, err := dbutil.ReadRow(row)
+	if err != nil {
+		return data.Row{}, fmt.Errorf("failed reading result: %v", err)
+	}
+
+	return res, nil
+}
+
+// FetchByDBNameAgent returns the entry for the database with the given name, from the given agent,
+// or an error if it does not exist
+func (pg *DB) FetchByDBNameAgent(dbname, agent string) (data.Row, error) {
+	if err := pg.alive(); err != nil {
+		return data.Row{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	row := pg.conn.QueryRow("SELECT * FROM databases WHERE dbname = $1 AND agentName = $2", dbname, agent)
+	res, err := dbutil.ReadRow(row)
+	if err != nil {
+		return data.Row{}, fmt.Errorf("failed reading result: %v", err)
+	}
+
+	return res, nil
+}
+
+// FetchByCreator returns private entries that were created by the
+// specified user, an empty list if it's not the user does
+// not have any entries, or an error if something went
+// wrong
+func (pg *DB) FetchByCreator(creator string) ([]data.Row, error) {
+	if err := pg.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return pg.fetchRows("SELECT * FROM databases WHERE creator = $1 AND visibility = 0 ORDER BY id DESC", creator)
+}
+
+// FetchPublic returns all entries that have "Public" set to true
+func (pg *DB) FetchPublic() ([]data.Row, error) {
+	if err := pg.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return pg.fetchRows("SELECT * FROM databases WHERE visibility = 1 ORDER BY id DESC")
+}
+
+// FetchAll returns all entries.
+func (pg *DB) FetchAll() ([]data.Row, error) {
+	if err := pg.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return pg.fetchRows("SELECT * FROM databases ORDER BY id DESC")
+}
+
+// fetchRows runs the query and reads all the rows it returns.
+func (pg *DB) fetchRows(query string, args ...interface{}) ([]data.Row, error) {
+	var entries []data.Row
+
+	rows, err := pg.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row, err := dbutil.ReadRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, row)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
+// CountRows returns the number of rows that match the filter
+func (pg *DB) CountRows(filter data.RowFilter) (int, error) {
+	if err := pg.alive(); err != nil {
+		return 0, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query, args := dbutil.RowCountQuery(filter)
+
+	var count int
+	err := pg.conn.QueryRow(rebind(query), args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+
+	return count, nil
+}
+
+// FetchRows returns the rows that match the filter, sorted and limited as it asks
+func (pg *DB) FetchRows(filter data.RowFilter) ([]data.Row, error) {
+	if err := pg.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query, args := dbutil.RowQuery(filter)
+
+	rows, err := pg.conn.Query(rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	entries := make([]data.Row, 0)
+	for rows.Next() {
+		row, err := dbutil.ReadRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, row)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
+// FetchUserPushSubscriptions fetches the subscriptions for the specified user
+func (pg *DB) FetchUserPushSubscriptions(subscriber string) ([]webpush.Subscription, error) {
+	if err := pg.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(subscriber) {
+		return nil, fmt.Errorf("missing subscriber")
+	}
+
+	var entries []webpush.Subscription
+
+	rows, err := pg.conn.Query("SELECT endpoint, p256dh_key, auth_key FROM push_subscriptions WHERE subscriber = $1", subscriber)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		row, err := dbutil.ReadSubscriptionRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, row)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
+// Insert adds an entry to the database, returning its ID
+func (pg *DB) Insert(entry *data.Row) error {
+	if err := pg.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	dbpass, err := pg.Keys.Encrypt(entry.DBPass)
+	if err != nil {
+		return fmt.Errorf("encrypting password failed: %v", err)
+	}
+
+	query := "INSERT INTO databases (dbname, dbuser, dbpass, dbsid, dumpfile, createDate, expiryDate, creator, agentName, dbAddress, dbPort, dbvendor, status, message, visibility, comment) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) RETURNING id"
+
+	var id int
+
+	err = pg.conn.QueryRow(query,
+		entry.DBName,
+		entry.DBUser,
+		dbpass,
+		entry.DBSID,
+		entry.Dumpfile,
+		entry.CreateDate,
+		entry.ExpiryDate,
+		entry.Creator,
+		entry.AgentName,
+		entry.DBAddress,
+		entry.DBPort,
+		entry.DBVendor,
+		entry.Status,
+		entry.Message,
+		entry.Public,
+		entry.Comment,
+	).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	entry.ID = id
+
+	return nil
+}
+
+// InsertPushSubscription adds a record to the push_subscriptions table
+func (pg *DB) InsertPushSubscription(subscription *model.PushSubscription, subscriber string) error {
+	if err := pg.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(subscriber) {
+		return fmt.Errorf("missing subscriber")
+	}
+
+	if !sutils.Present(subscription.Endpoint) {
+		return fmt.Errorf("missing endpoint")
+	}
+
+	query := "INSERT INTO push_subscriptions (subscriber, endpoint, p256dh_key, auth_key) VALUES ($1, $2, $3, $4)"
+
+	_, err := pg.conn.Exec(query,
+		subscriber,
+		subscription.Endpoint,
+		subscription.Keys.P256dh,
+		subscription.Keys.Auth,
+	)
+	if err != nil {
+		return fmt.Errorf("saving push subscription to the database failed: %v", err)
+	}
+
+	return nil
+}
+
+// Update updates an already existing entry
+func (pg *DB) Update(entry *data.Row) error {
+	if err := pg.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var count int
+
+	err := pg.conn.QueryRow("SELECT count(*) FROM databases WHERE id = $1", entry.ID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed existence check: %v", err)
+	}
+
+	if count == 0 {
+		return pg.Insert(entry)
+	}
+
+	dbpass, err := pg.Keys.Encrypt(entry.DBPass)
+	if err != nil {
+		return fmt.Errorf("encrypting password failed: %v", err)
+	}
+
+	query := "UPDATE databases SET dbname = $1, dbuser = $2, dbpass = $3, dbsid = $4, dumpfile = $5, createDate = $6, expiryDate = $7, creator = $8, agentName = $9, dbAddress = $10, dbPort = $11, dbvendor = $12, status = $13, message = $14, visibility = $15, comment = $16 WHERE id = $17"
+
+	_, err = pg.conn.Exec(query,
+		entry.DBName,
+		entry.DBUser,
+		dbpass,
+		entry.DBSID,
+		entry.Dumpfile,
+		entry.CreateDate,
+		entry.ExpiryDate,
+		entry.Creator,
+		entry.AgentName,
+		entry.DBAddress,
+		entry.DBPort,
+		entry.DBVendor,
+		entry.Status,
+		entry.Message,
+		entry.Public,
+		entry.Comment,
+		entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed update: %v", err)
+	}
+
+	return nil
+}
+
+// Delete removes the entry from the database
+func (pg *DB) Delete(entry data.Row) error {
+	if err := pg.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	_, err := pg.conn.Exec("DELETE FROM databases WHERE id = $1", entry.ID)
+
+	return err
+}
+
+// Alive checks whether the connection is alive. Returns error if not.
+func (pg *DB) alive() error {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error("Panic Attack! Database seems to be down.")
+		}
+	}()
+
+	_, err := pg.conn.Exec("SELECT * FROM databases WHERE 1 = 0")
+	if err != nil {
+		return fmt.Errorf("executing stayalive query failed: %s", sutils.TrimNL(err.Error()))
+	}
+
+	return nil
+}
+
+// DeletePushSubscription deletes a record from the push_subscriptions table
+func (pg *DB) DeletePushSubscription(subscription *model.PushSubscription, subscriber string) error {
+	if err := pg.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(subscriber) {
+		return fmt.Errorf("missing subscriber")
+	}
+
+	if !sutils.Present(subscription.Endpoint) {
+		return fmt.Errorf("missing endpoint")
+	}
+
+	_, err := pg.conn.Exec("DELETE FROM push_subscriptions WHERE subscriber = $1 AND endpoint = $2", subscriber, subscription.Endpoint)
+
+	return err
+}
+
+// Restore inserts the row as it is, keeping its ID and its password, which is
+// not encrypted again. It is used to copy rows from another backend.
+func (pg *DB) Restore(entry data.Row) error {
+	if err := pg.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query := "INSERT INTO databases (id, dbname, dbuser, dbpass, dbsid, dumpfile, createDate, expiryDate, creator, agentName, dbAddress, dbPort, dbvendor, status, message, visibility, comment) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)"
+
+	_, err := pg.conn.Exec(query,
+		entry.ID,
+		entry.DBName,
+		entry.DBUser,
+		entry.DBPass,
+		entry.DBSID,
+		entry.Dumpfile,
+		entry.CreateDate,
+		entry.ExpiryDate,
+		entry.Creator,
+		entry.AgentName,
+		entry.DBAddress,
+		entry.DBPort,
+		entry.DBVendor,
+		entry.Status,
+		entry.Message,
+		entry.Public,
+		entry.Comment,
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	// Inserting the ID doesn't move the sequence along, so it has to be set to the highest ID
+	_, err = pg.conn.Exec("SELECT setval(pg_get_serial_sequence('databases', 'id'), (SELECT MAX(id) FROM databases))")
+	if err != nil {
+		return fmt.Errorf("updating the id sequence failed: %v", err)
+	}
+
+	return nil
+}
+
+// FetchAllPushSubscriptions returns the subscriptions of every user, by subscriber
+func (pg *DB) FetchAllPushSubscriptions() (map[string][]webpush.Subscription, error) {
+	if err := pg.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	rows, err := pg.conn.Query("SELECT subscriber, endpoint, p256dh_key, auth_key FROM push_subscriptions")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	entries := make(map[string][]webpush.Subscription)
+	for rows.Next() {
+		subscriber, row, err := dbutil.ReadSubscriberRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries[subscriber] = append(entries[subscriber], row)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
+// InsertAudit appends an entry to the audit log
+func (pg *DB) InsertAudit(entry *data.AuditEntry) error {
+	if err := pg.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query := "INSERT INTO audit (time, actor, action, databaseId, target, params, outcome, message, requestId) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id"
+
+	var id int
+
+	err := pg.conn.QueryRow(query,
+		entry.Time.UTC(),
+		entry.Actor,
+		entry.Action,
+		entry.DatabaseID,
+		entry.Target,
+		entry.Params,
+		entry.Outcome,
+		entry.Message,
+		entry.RequestID,
+	).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	entry.ID = id
+
+	return nil
+}
+
+// FetchAudit returns the entries of the audit log that match the filter, newest first
+func (pg *DB) FetchAudit(filter data.AuditFilter) ([]data.AuditEntry, error) {
+	if err := pg.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query, args := dbutil.AuditQuery(filter)
+
+	rows, err := pg.conn.Query(rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	entries := make([]data.AuditEntry, 0)
+	for rows.Next() {
+		entry, err := dbutil.ReadAuditRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
+// rebind turns a query written for MySQL and SQLite into one Postgres understands:
+// the ? placeholders are numbered, and the identifiers are quoted with double quotes.
+func rebind(query string) string {
+	var (
+		buf bytes.Buffer
+		n   int
+	)
+
+	for _, r := range query {
+		switch r {
+		case '?':
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+		case '`':
+			buf.WriteRune('"')
+		default:
+			buf.WriteRune(r)
+		}
+	}
+
+	return buf.String()
+}
+
+type dbUpdate struct {
+	Query   string
+	Comment string
+}
+
+// The tables are the same as the ones of the other backends, the columns are in the same
+// order, so that the rows can be read with dbutil. Identifiers aren't quoted, so Postgres
+// folds them to lowercase.
+var queries = []dbUpdate{
+	{
+		Query:   "CREATE TABLE version (queryId SERIAL PRIMARY KEY, query TEXT NULL, comment TEXT NULL, date TIMESTAMP WITH TIME ZONE NULL);",
+		Comment: "Create the version table",
+	},
+	{
+		Query:   "CREATE TABLE IF NOT EXISTS databases (id SERIAL PRIMARY KEY, dbname VARCHAR(255) NOT NULL DEFAULT '', dbuser VARCHAR(255) NOT NULL DEFAULT '', dbpass VARCHAR(255) NOT NULL DEFAULT '', dbsid VARCHAR(45) NOT NULL DEFAULT '', dumpfile TEXT NOT NULL DEFAULT '', createDate TIMESTAMP WITH TIME ZONE NULL, expiryDate TIMESTAMP WITH TIME ZONE NULL, creator VARCHAR(255) NOT NULL DEFAULT '', agentName VARCHAR(255) NOT NULL DEFAULT '', dbAddress VARCHAR(255) NOT NULL DEFAULT '', dbPort VARCHAR(45) NOT NULL DEFAULT '', dbvendor VARCHAR(255) NOT NULL DEFAULT '', status INT NOT NULL DEFAULT 0, message TEXT NOT NULL DEFAULT '', visibility INT NOT NULL DEFAULT 0, comment TEXT NOT NULL DEFAULT '');",
+		Comment: "Create the databases table",
+	},
+	{
+		Query:   "CREATE TABLE IF NOT EXISTS push_subscriptions (subscriber VARCHAR(255) NOT NULL, endpoint VARCHAR(255) NOT NULL, p256dh_key VARCHAR(255) NOT NULL, auth_key VARCHAR(255) NOT NULL);",
+		Comment: "Create the push_subscriptions table",
+	},
+	{
+		Query:   "CREATE UNIQUE INDEX push_subscription ON push_subscriptions (subscriber, endpoint);",
+		Comment: "Create unique index on columns (subscriber,endpoint) for table push_subscriptions",
+	},
+	{
+		Query:   "CREATE UNIQUE INDEX agent_db_idx ON databases (dbname, agentName);",
+		Comment: "Create unique index on columns (dbname, agentName) for table databases",
+	},
+	{
+		Query:   "CREATE TABLE IF NOT EXISTS audit (id SERIAL PRIMARY KEY, time TIMESTAMP WITH TIME ZONE NOT NULL, actor VARCHAR(255) NOT NULL, action VARCHAR(45) NOT NULL, databaseId INT NOT NULL DEFAULT 0, target VARCHAR(255) NOT NULL, params TEXT NOT NULL, outcome VARCHAR(45) NOT NULL, message TEXT NOT NULL, requestId VARCHAR(45) NOT NULL);",
+		Comment: "Create the audit table",
+	},
+	{
+		Query:   "CREATE INDEX audit_time_idx ON audit (time);",
+		Comment: "Create index on column time for table audit",
+	},
+}
+
+// datasource returns the connection string to the given database.
+func (pg *DB) datasource(database string) string {
+	sslmode := pg.SSLMode
+	if sslmode == "" {
+		sslmode = "disable"
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(pg.User, pg.Pass),
+		Host:     fmt.Sprintf("%s:%s", pg.Address, pg.Port),
+		Path:     database,
+		RawQuery: url.Values{"sslmode": {sslmode}}.Encode(),
+	}
+
+	return u.String()
+}
+
+func (pg *DB) connect(datasource string) error {
+	db, err := sql.Open("postgres", datasource)
+	if err != nil {
+		return fmt.Errorf("creating connection pool failed: %s", err.Error())
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("database ping failed: %s", sutils.TrimNL(err.Error()))
+	}
+	pg.conn = db
+
+	return nil
+}
+
+func (pg *DB) initTables() error {
+	var (
+		err      error
+		startLoc int
+	)
+
+	pg.conn.QueryRow("SELECT count(*) FROM version").Scan(&startLoc)
+
+	for _, q := range queries[startLoc:] {
+		logger.Info("Updating database %q", q.Comment)
+		_, err = pg.conn.Exec(q.Query)
+		if err != nil {
+			return fmt.Errorf("executing query %q (%q) failed: %s", q.Comment, q.Query, sutils.TrimNL(err.Error()))
+		}
+
+		_, err = pg.conn.Exec("INSERT INTO version (query, comment, date) VALUES ($1, $2, $3)", q.Query, q.Comment, time.Now())
+		if err != nil {
+			return fmt.Errorf("updating version table with query %q (%q) failed: %s", q.Comment, q.Query, sutils.TrimNL(err.Error()))
+		}
+	}
+
+	return nil
+}
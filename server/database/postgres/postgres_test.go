@@ -0,0 +1,882 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/server/database/data"
+	"github.com/djavorszky/ddn/server/database/dbutil"
+	"github.com/djavorszky/ddn/server/database/secret"
+	"github.com/djavorszky/sutils"
+	webpush "github.com/sherclockholmes/webpush-go"
+
+	_ "github.com/lib/pq"
+)
+
+const (
+	testAddr = "127.0.0.1"
+	testPort = "5432"
+	testUser = "postgres"
+	testPass = ""
+	testName = "unit_test"
+)
+
+var (
+	testConn *sql.DB
+
+	pg = DB{Address: testAddr, Port: testPort, User: testUser, Pass: testPass}
+
+	gmt, _ = time.LoadLocation("GMT")
+
+	testEntry = data.Row{
+		ID:         1,
+		DBName:     "testDB",
+		DBUser:     "testUser",
+		DBPass:     "testPass",
+		DBSID:      "testsid",
+		Dumpfile:   "testloc",
+		CreateDate: time.Now().In(gmt),
+		ExpiryDate: time.Now().In(gmt).AddDate(0, 0, 30),
+		Creator:    "test@gmail.com",
+		AgentName:  "postgres-96",
+		DBAddress:  "localhost",
+		DBPort:     "5432",
+		DBVendor:   "postgres",
+		Comment:    "This is just a comment somewhere",
+		Message:    "",
+		Status:     100,
+	}
+)
+
+func TestMain(m *testing.M) {
+	// For these tests to run, a local database should be present
+	// which has a user named 'postgres' with no password authentication
+
+	err := setup()
+	if err != nil {
+		fmt.Printf("Failed setup: %s", err.Error())
+		os.Exit(-1)
+	}
+
+	res := m.Run()
+
+	err = teardown()
+	if err != nil {
+		fmt.Printf("Failed teardown: %s", err.Error())
+		os.Exit(-1)
+	}
+
+	os.Exit(res)
+}
+
+// Connect to a local database
+func setup() error {
+	var err error
+
+	err = testConnDS(pg.datasource("postgres"))
+	if err != nil {
+		return fmt.Errorf("failed to setup test connection: %v", err)
+	}
+
+	_, err = testConn.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", testName))
+	if err != nil {
+		return fmt.Errorf("failed dropping leftover database: %s", sutils.TrimNL(err.Error()))
+	}
+
+	_, err = testConn.Exec(fmt.Sprintf("CREATE DATABASE %s ENCODING 'UTF8';", testName))
+	if err != nil {
+		return fmt.Errorf("failed creating database: %s", sutils.TrimNL(err.Error()))
+	}
+
+	testConn.Close()
+
+	err = testConnDS(pg.datasource(testName))
+	if err != nil {
+		return fmt.Errorf("failed connecting to created database")
+	}
+
+	err = pg.connect(pg.datasource(testName))
+	if err != nil {
+		return fmt.Errorf("failed initializing main connection")
+	}
+
+	return nil
+}
+
+// DROP EVERYTHING!!4one
+func teardown() error {
+	var err error
+
+	// The database can't be dropped while connected to it
+	testConn.Close()
+	pg.Close()
+
+	err = testConnDS(pg.datasource("postgres"))
+	if err != nil {
+		return fmt.Errorf("failed to setup test connection: %v", err)
+	}
+	defer testConn.Close()
+
+	_, err = testConn.Exec(fmt.Sprintf("DROP DATABASE %s;", testName))
+	if err != nil {
+		return fmt.Errorf("failed dropping database: %s", sutils.TrimNL(err.Error()))
+	}
+
+	return nil
+}
+
+func testConnDS(datasource string) error {
+	var err error
+
+	testConn, err = sql.Open("postgres", datasource)
+	if err != nil {
+		return fmt.Errorf("creating connection pool failed: %s", err.Error())
+	}
+
+	err = testConn.Ping()
+	if err != nil {
+		testConn.Close()
+		return fmt.Errorf("database ping failed: %s", sutils.TrimNL(err.Error()))
+	}
+
+	return nil
+}
+
+func TestInitTables(t *testing.T) {
+	var err error
+
+	_, err = testConn.Exec("SELECT 1 FROM version LIMIT 1;")
+	if err == nil {
+		t.Errorf("Version table already exists before test even ran.")
+	}
+
+	_, err = testConn.Exec("SELECT 1 FROM databases LIMIT 1;")
+	if err == nil {
+		t.Errorf("Databases table already exists before test even ran.")
+	}
+
+	err = pg.initTables()
+	if err != nil {
+		t.Errorf("Failed initializing tables: %s", err.Error())
+	}
+
+	_, err = testConn.Exec("SELECT 1 FROM version LIMIT 1;")
+	if err != nil {
+		t.Errorf("Version table has not been created.")
+	}
+
+	_, err = testConn.Exec("SELECT 1 FROM databases LIMIT 1;")
+	if err != nil {
+		t.Errorf("Databases table has not been created.")
+	}
+
+	type versiontest struct {
+		queryID int
+		query   string
+		comment string
+		date    time.Time
+	}
+
+	rows, _ := testConn.Query("SELECT * FROM version")
+
+	for rows.Next() {
+		var row versiontest
+
+		rows.Scan(&row.queryID, &row.query, &row.comment, &row.date)
+
+		dbu := queries[row.queryID-1]
+
+		if row.query != dbu.Query {
+			t.Errorf("Saved query not what was expected")
+		}
+
+		if row.comment != dbu.Comment {
+			t.Errorf("Saved comment not what was expected")
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		t.Errorf("error reading result from query: %s", err.Error())
+	}
+}
+
+func TestFetchByID(t *testing.T) {
+	testEntry.DBName = "fetchByID"
+	pg.Insert(&testEntry)
+
+	res, err := pg.FetchByID(testEntry.ID)
+	if err != nil {
+		t.Errorf("FetchById(%d) failed with error: %v", testEntry.ID, err)
+	}
+
+	if err := dbutil.CompareRows(res, testEntry); err != nil {
+		t.Errorf("Fetched result not the same as queried: %v", err)
+	}
+}
+
+func TestFetchByDBNameAgent(t *testing.T) {
+	pg.Insert(&testEntry)
+
+	res, err := pg.FetchByDBNameAgent(testEntry.DBName, testEntry.AgentName)
+	if err != nil {
+		t.Errorf("FetchByDBNameAgent(%s, %s) failed with error: %v", testEntry.DBName, testEntry.AgentName, err)
+	}
+
+	if err := dbutil.CompareRows(res, testEntry); err != nil {
+		t.Errorf("Fetched result not the same as queried: %v", err)
+	}
+}
+
+func TestFetchByCreator(t *testing.T) {
+	creator := "someone@somewhere.com"
+
+	testEntry.Creator = creator
+
+	testEntry.DBName = "fetchByCreator_1"
+	pg.Insert(&testEntry)
+
+	testEntry.DBName = "fetchByCreator_2"
+	pg.Insert(&testEntry)
+
+	results, err := pg.FetchByCreator(creator)
+	if err != nil {
+		t.Errorf("failed to fetch by creator: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Errorf("Expected resultset to have 2 results, %d instead", len(results))
+	}
+
+	for _, res := range results {
+		if res.Creator != creator {
+			t.Errorf("Creator mismatch: Got %q, expected %q", res.Creator, creator)
+		}
+	}
+}
+
+func TestInsert(t *testing.T) {
+	testEntry.DBName = "insert"
+	err := pg.Insert(&testEntry)
+	if err != nil {
+		t.Errorf("pg.Insert(testEntry) failed with error: %v", err)
+	}
+
+	if testEntry.ID == 0 {
+		t.Errorf("pg.Insert(testEntry) resulted in id of 0")
+	}
+
+	result, err := pg.FetchByID(testEntry.ID)
+	if err != nil {
+		t.Errorf("FetchById(%d) resulted in error: %v", testEntry.ID, err)
+	}
+
+	if err = dbutil.CompareRows(testEntry, result); err != nil {
+		t.Errorf("Persisted and read results not the same: %v", err)
+	}
+}
+
+func TestDupInsert(t *testing.T) {
+	insertTest := testEntry
+	insertTest.DBName = "dupInsert"
+
+	err := pg.Insert(&insertTest)
+	if err != nil {
+		t.Errorf("pg.Insert(insertTest) failed with error: %v", err)
+		return
+	}
+
+	err = pg.Insert(&insertTest)
+	if err == nil {
+		t.Errorf("Second pg.Insert(insertTest) should have failed.")
+		return
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	pg.Insert(&testEntry)
+
+	// We're updating by ID - this should updated the row for "testEntry"
+	updatedEntry := data.Row{
+		ID:         testEntry.ID,
+		DBName:     "updatedtestDB",
+		DBUser:     "updatedtestUser",
+		DBPass:     "updatedtestPass",
+		DBSID:      "updatedtestsid",
+		Dumpfile:   "updatedtestloc",
+		CreateDate: time.Now().In(gmt),
+		ExpiryDate: time.Now().In(gmt).AddDate(0, 0, 30),
+		Creator:    "updatedtest@gmail.com",
+		AgentName:  "updatedysql-55",
+		DBAddress:  "updatedlocalhost",
+		DBPort:     "updated5432",
+		DBVendor:   "updatedpostgres",
+		Comment:    "This is just a comment somewhere",
+		Message:    "updated",
+		Status:     200,
+	}
+
+	err := pg.Update(&updatedEntry)
+	if err != nil {
+		t.Errorf("Update(updatedEntry) failed: %v", err)
+	}
+
+	readEntry, _ := pg.FetchByID(testEntry.ID)
+
+	if err := dbutil.CompareRows(updatedEntry, readEntry); err != nil {
+		t.Errorf("Updated and read entries not the same: %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	pg.Insert(&testEntry)
+
+	err := pg.Delete(testEntry)
+	if err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+
+	row, _ := pg.FetchByID(testEntry.ID)
+	if row.ID == testEntry.ID {
+		t.Errorf("Row was not deleted, managed to fetch it back")
+	}
+}
+
+func TestFetchPublic(t *testing.T) {
+	res, err := pg.FetchPublic()
+	if err != nil {
+		t.Errorf("FetchPublic() error: %v", err)
+	}
+
+	if len(res) != 0 {
+		t.Errorf("FetchPublic() returned with entries, shouldn't have")
+	}
+
+	testEntry.Public = 1
+
+	pg.Insert(&testEntry)
+
+	res, err = pg.FetchPublic()
+	if err != nil {
+		t.Errorf("FetchPublic() error: %v", err)
+		return
+	}
+
+	if len(res) != 1 {
+		t.Errorf("FetchPublic() expected 1 result, got %d instead", len(res))
+		return
+	}
+
+	if err := dbutil.CompareRows(res[0], testEntry); err != nil {
+		t.Errorf("Read and persisted mismatch: %v", err)
+	}
+}
+
+func TestFetchAll(t *testing.T) {
+	var count int
+
+	pg.conn.QueryRow("SELECT count(*) FROM databases").Scan(&count)
+
+	entries, err := pg.FetchAll()
+	if err != nil {
+		t.Errorf("FetchAll() encountered error: %v", err)
+	}
+
+	if len(entries) != count {
+		t.Errorf("Expected size %d, got %d instead", count, len(entries))
+	}
+}
+
+func TestReadRow(t *testing.T) {
+	testEntry.DBName = "readRow"
+	err := pg.Insert(&testEntry)
+	if err != nil {
+		t.Errorf("Failed adding a entry: %s", err.Error())
+	}
+
+	rows, err := testConn.Query("SELECT * FROM databases WHERE id = $1", testEntry.ID)
+	if err != nil {
+		t.Errorf("Failed querying for entries: %s", err.Error())
+	}
+
+	for rows.Next() {
+		row, err := dbutil.ReadRows(rows)
+		if err != nil {
+			t.Errorf("Failed reading row from rows: %s", err.Error())
+		}
+
+		if err = dbutil.CompareRows(testEntry, row); err != nil {
+			t.Errorf("Persisted and read DBEntry not the same: %s", err.Error())
+		}
+	}
+
+	// cleanup
+	_, err = testConn.Exec("DELETE FROM databases WHERE id = $1", testEntry.ID)
+	if err != nil {
+		t.Errorf("Could not delete created entry")
+	}
+
+	testEntry.ID++
+}
+
+func TestInsertPushSubscription(t *testing.T) {
+	type args struct {
+		subscription *model.PushSubscription
+		subscriber   string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"Success", args{
+			subscriber: "test@example.com",
+			subscription: &model.PushSubscription{
+				Endpoint:       "testEndpoint",
+				ExpirationTime: "testExpirationTime",
+				Keys: webpush.Keys{
+					P256dh: "randomTestKey",
+					Auth:   "randomTestAuth",
+				},
+			},
+		}, false},
+		{"Missing Subscriber", args{
+			subscription: &model.PushSubscription{
+				Endpoint:       "testEndpoint",
+				ExpirationTime: "testExpirationTime",
+				Keys: webpush.Keys{
+					P256dh: "randomTestKey",
+					Auth:   "randomTestAuth",
+				},
+			},
+		}, true},
+		{"Missing Endpoint", args{
+			subscriber: "test@example.com",
+			subscription: &model.PushSubscription{
+				ExpirationTime: "testExpirationTime",
+				Keys: webpush.Keys{
+					P256dh: "randomTestKey",
+					Auth:   "randomTestAuth",
+				},
+			},
+		}, true},
+		{"Missing ExpirationTime", args{
+			subscriber: "test@example.com",
+			subscription: &model.PushSubscription{
+				Endpoint: "testEndpoint",
+				Keys: webpush.Keys{
+					P256dh: "randomTestKey",
+					Auth:   "randomTestAuth",
+				},
+			},
+		}, true},
+		{"Missing Keys", args{
+			subscriber: "test@example.com",
+			subscription: &model.PushSubscription{
+				Endpoint:       "testEndpoint",
+				ExpirationTime: "testExpirationTime",
+			},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := pg.InsertPushSubscription(tt.args.subscription, tt.args.subscriber); (err != nil) != tt.wantErr {
+				t.Errorf("DB.InsertPushSubscription() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			read, _ := pg.FetchUserPushSubscriptions(tt.args.subscriber)
+			if len(read) == 0 {
+				t.Errorf("Did not find inserted data after DB.InsertPushSubscription")
+				return
+			}
+
+			s := read[0]
+			if s.Endpoint != tt.args.subscription.Endpoint {
+				t.Errorf("endpoint mismatch; expected %v, got %v", tt.args.subscription.Endpoint, s.Endpoint)
+			}
+
+			if s.Keys.Auth != tt.args.subscription.Keys.Auth {
+				t.Errorf("auth mismatch; expected %v, got %v", tt.args.subscription.Keys.Auth, s.Keys.Auth)
+			}
+
+			if s.Keys.P256dh != tt.args.subscription.Keys.P256dh {
+				t.Errorf("P256Dh mismatch; expected %v, got %v", tt.args.subscription.Keys.P256dh, s.Keys.P256dh)
+			}
+		})
+	}
+}
+
+func TestFetchUserPushSubscriptions(t *testing.T) {
+	testUser := "test@example.com"
+	testSubscription := &model.PushSubscription{
+		Endpoint:       "testEndpoint",
+		ExpirationTime: "testExpirationTime",
+		Keys: webpush.Keys{
+			P256dh: "randomTestKey",
+			Auth:   "randomTestAuth",
+		},
+	}
+
+	tests := []struct {
+		name          string
+		subscriber    string
+		expectedCount int
+		wantErr       bool
+	}{
+		{"Success", testUser, 1, false},
+		{"No subscription for user", "random@user.com", 0, false},
+		{"No user specified", "", 0, true},
+	}
+
+	pg.InsertPushSubscription(testSubscription, testUser)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				read []webpush.Subscription
+				err  error
+			)
+
+			if read, err = pg.FetchUserPushSubscriptions(tt.subscriber); (err != nil) != tt.wantErr {
+				t.Errorf("DB.FetchUserPushSubscriptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr || tt.expectedCount == 0 {
+				return
+			}
+
+			if len(read) != tt.expectedCount {
+				t.Errorf("Wrong number of results returned. Expected %v, got %v", tt.expectedCount, len(read))
+				return
+			}
+
+			s := read[0]
+			if s.Endpoint != testSubscription.Endpoint {
+				t.Errorf("endpoint mismatch; expected %v, got %v", testSubscription.Endpoint, s.Endpoint)
+			}
+
+			if s.Keys.Auth != testSubscription.Keys.Auth {
+				t.Errorf("auth mismatch; expected %v, got %v", testSubscription.Keys.Auth, s.Keys.Auth)
+			}
+
+			if s.Keys.P256dh != testSubscription.Keys.P256dh {
+				t.Errorf("P256Dh mismatch; expected %v, got %v", testSubscription.Keys.P256dh, s.Keys.P256dh)
+			}
+		})
+	}
+}
+
+func TestDeleteUserPushNotification(t *testing.T) {
+	testUser := "test@example.com"
+	testSubscription := &model.PushSubscription{
+		Endpoint:       "testEndpoint",
+		ExpirationTime: "testExpirationTime",
+		Keys: webpush.Keys{
+			P256dh: "randomTestKey",
+			Auth:   "randomTestAuth",
+		},
+	}
+
+	tests := []struct {
+		name       string
+		subscriber string
+		endpoint   string
+		wantErr    bool
+	}{
+		{"Success", testUser, testSubscription.Endpoint, false},
+		{"No Subscription for user", "random@user.com", testSubscription.Endpoint, false},
+		{"No User specified", "", testSubscription.Endpoint, true},
+		{"No Endpoint specified", testUser, "", true},
+	}
+
+	pg.InsertPushSubscription(testSubscription, testUser)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpSub := &model.PushSubscription{Endpoint: tt.endpoint}
+
+			if err := pg.DeletePushSubscription(tmpSub, tt.subscriber); (err != nil) != tt.wantErr {
+				t.Errorf("DB.FetchUserPushSubscriptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAudit(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+
+	entries := []data.AuditEntry{
+		{Time: time.Now(), Actor: "alice@example.com", Action: "drop", DatabaseID: 1, Target: "postgres-96/first", Params: "{}", Outcome: data.AuditSuccess},
+		{Time: time.Now(), Actor: "bob@example.com", Action: "drop", DatabaseID: 2, Target: "postgres-96/second", Params: "{}", Outcome: data.AuditDenied, Message: "access denied"},
+		{Time: time.Now(), Actor: "alice@example.com", Action: "extend", DatabaseID: 1, Target: "postgres-96/first", Params: "{\"amount\":\"30\"}", Outcome: data.AuditSuccess, RequestID: "abc"},
+	}
+
+	for i := range entries {
+		err := pg.InsertAudit(&entries[i])
+		if err != nil {
+			t.Fatalf("InsertAudit failed: %v", err)
+		}
+
+		if entries[i].ID == 0 {
+			t.Errorf("InsertAudit did not set the ID")
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter data.AuditFilter
+		want   []int
+	}{
+		{"all", data.AuditFilter{}, []int{2, 1, 0}},
+		{"actor", data.AuditFilter{Actor: "alice@example.com"}, []int{2, 0}},
+		{"action and outcome", data.AuditFilter{Action: "drop", Outcome: data.AuditDenied}, []int{1}},
+		{"database", data.AuditFilter{DatabaseID: 2}, []int{1}},
+		{"time range", data.AuditFilter{From: start, To: time.Now().Add(time.Minute)}, []int{2, 1, 0}},
+		{"in the future", data.AuditFilter{From: time.Now().Add(time.Minute)}, []int{}},
+		{"limit", data.AuditFilter{Limit: 1}, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pg.FetchAudit(tt.filter)
+			if err != nil {
+				t.Fatalf("FetchAudit failed: %v", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d entries, got %d: %v", len(tt.want), len(got), got)
+			}
+
+			for i, idx := range tt.want {
+				want := entries[idx]
+				if got[i].ID != want.ID || got[i].Actor != want.Actor || got[i].Params != want.Params || got[i].Message != want.Message || got[i].RequestID != want.RequestID {
+					t.Errorf("entry %d mismatch: expected %+v, got %+v", i, want, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptedPassword(t *testing.T) {
+	keys, err := secret.NewKeyring(map[string]string{"k1": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}, "k1")
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+
+	enc := &DB{Keys: keys, conn: pg.conn}
+
+	entry := testEntry
+	entry.DBName = "encryptedDB"
+	entry.DBPass = "testPass"
+
+	err = enc.Insert(&entry)
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	if entry.DBPass != "testPass" {
+		t.Errorf("Insert changed the password of the row to %q", entry.DBPass)
+	}
+
+	var stored string
+	testConn.QueryRow("SELECT dbpass FROM databases WHERE id = $1", entry.ID).Scan(&stored)
+
+	if !secret.IsEncrypted(stored) || stored == entry.DBPass {
+		t.Fatalf("password stored in plaintext: %q", stored)
+	}
+
+	res, err := enc.FetchByID(entry.ID)
+	if err != nil {
+		t.Fatalf("FetchByID failed: %v", err)
+	}
+
+	if res.DBPass != stored {
+		t.Errorf("expected fetched password to stay encrypted, got %q", res.DBPass)
+	}
+
+	// Updating a fetched row must not encrypt the password again
+	res.Comment = "updated"
+	err = enc.Update(&res)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	testConn.QueryRow("SELECT dbpass FROM databases WHERE id = $1", entry.ID).Scan(&stored)
+
+	if pass, err := keys.Decrypt(stored); err != nil || pass != "testPass" {
+		t.Errorf("stored password decrypts to %q, %v", pass, err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query, args := dbutil.AuditQuery(data.AuditFilter{Actor: "alice@example.com", Outcome: data.AuditSuccess, Limit: 10})
+
+	want := `SELECT id, time, actor, action, databaseId, target, params, outcome, message, requestId FROM "audit" WHERE 1 = 1 AND actor = $1 AND outcome = $2 ORDER BY id DESC LIMIT $3`
+	if got := rebind(query); got != want {
+		t.Errorf("rebind() = %q, want %q", got, want)
+	}
+
+	if len(args) != 3 {
+		t.Errorf("expected 3 arguments, got %d", len(args))
+	}
+}
+
+func TestRestore(t *testing.T) {
+	entry := testEntry
+	entry.DBName = "restoredDB"
+	entry.ID = 4242
+	entry.DBPass = "enc:k1:notreallyencrypted"
+
+	err := pg.Restore(entry)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	res, err := pg.FetchByID(entry.ID)
+	if err != nil {
+		t.Fatalf("FetchByID failed: %v", err)
+	}
+
+	if err = dbutil.CompareRows(entry, res); err != nil {
+		t.Errorf("Restored and read rows not the same: %v", err)
+	}
+
+	if err = pg.Restore(entry); err == nil {
+		t.Errorf("Restoring a row with a taken ID should have failed")
+	}
+
+	// Rows inserted afterwards get IDs after the restored one
+	next := entry
+	next.DBName = "afterRestore"
+	err = pg.Insert(&next)
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	if next.ID <= entry.ID {
+		t.Errorf("expected an ID higher than %d, got %d", entry.ID, next.ID)
+	}
+}
+
+func TestFetchAllPushSubscriptions(t *testing.T) {
+	subscriptions := map[string][]string{
+		"first@example.com":  {"firstEndpoint", "secondEndpoint"},
+		"second@example.com": {"thirdEndpoint"},
+	}
+
+	for subscriber, endpoints := range subscriptions {
+		for _, endpoint := range endpoints {
+			err := pg.InsertPushSubscription(&model.PushSubscription{
+				Endpoint: endpoint,
+				Keys:     webpush.Keys{P256dh: "key-" + endpoint, Auth: "auth-" + endpoint},
+			}, subscriber)
+			if err != nil {
+				t.Fatalf("InsertPushSubscription failed: %v", err)
+			}
+		}
+	}
+
+	all, err := pg.FetchAllPushSubscriptions()
+	if err != nil {
+		t.Fatalf("FetchAllPushSubscriptions failed: %v", err)
+	}
+
+	for subscriber, endpoints := range subscriptions {
+		if len(all[subscriber]) != len(endpoints) {
+			t.Errorf("expected %d subscriptions for %s, got %v", len(endpoints), subscriber, all[subscriber])
+			continue
+		}
+
+		for i, s := range all[subscriber] {
+			if s.Endpoint != endpoints[i] || s.Keys.P256dh != "key-"+endpoints[i] || s.Keys.Auth != "auth-"+endpoints[i] {
+				t.Errorf("unexpected subscription for %s: %+v", subscriber, s)
+			}
+		}
+	}
+}
+
+func TestFetchRows(t *testing.T) {
+	names := []string{"alpha_one", "Beta_two", "gamma%three", "delta_four"}
+	base := time.Now().In(gmt).Add(-time.Hour)
+
+	ids := make(map[string]int)
+	for i, name := range names {
+		entry := testEntry
+		entry.AgentName = "filterAgent"
+		entry.DBName = name
+		entry.Creator = "filter@example.com"
+		entry.CreateDate = base.Add(time.Duration(i) * time.Minute)
+		entry.ExpiryDate = base.AddDate(0, 0, 30-i)
+		entry.Status = 100 + i
+		entry.Public = 0
+
+		if name == "delta_four" {
+			entry.Creator = "other@example.com"
+			entry.Public = 1
+		}
+
+		err := pg.Insert(&entry)
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+
+		ids[name] = entry.ID
+	}
+
+	// Every filter names the agent, so rows of the other tests don't count
+	tests := []struct {
+		name   string
+		filter data.RowFilter
+		want   []string
+	}{
+		{"all", data.RowFilter{}, names},
+		{"descending", data.RowFilter{Desc: true}, []string{"delta_four", "gamma%three", "Beta_two", "alpha_one"}},
+		{"visible to", data.RowFilter{VisibleTo: "filter@example.com"}, names},
+		{"visible to someone else", data.RowFilter{VisibleTo: "nobody@example.com"}, []string{"delta_four"}},
+		{"creator", data.RowFilter{Creator: "other@example.com"}, []string{"delta_four"}},
+		{"name ignoring case", data.RowFilter{Name: "BETA"}, []string{"Beta_two"}},
+		{"name with wildcard", data.RowFilter{Name: "%"}, []string{"gamma%three"}},
+		{"name with underscore", data.RowFilter{Name: "a_o"}, []string{"alpha_one"}},
+		{"status", data.RowFilter{Status: []int{101, 103}}, []string{"Beta_two", "delta_four"}},
+		{"created range", data.RowFilter{CreatedFrom: base.Add(time.Minute), CreatedTo: base.Add(3 * time.Minute)}, []string{"Beta_two", "gamma%three"}},
+		{"expiry range", data.RowFilter{ExpiresFrom: base.AddDate(0, 0, 28)}, []string{"alpha_one", "Beta_two", "gamma%three"}},
+		{"sort by expiry", data.RowFilter{Sort: "expirydate"}, []string{"delta_four", "gamma%three", "Beta_two", "alpha_one"}},
+		{"limit", data.RowFilter{Limit: 2}, []string{"alpha_one", "Beta_two"}},
+		{"after id", data.RowFilter{After: &data.RowCursor{ID: ids["Beta_two"]}}, []string{"gamma%three", "delta_four"}},
+		{"after expiry", data.RowFilter{Sort: "expirydate", Desc: true, Limit: 2, After: &data.RowCursor{ID: ids["Beta_two"], Value: base.AddDate(0, 0, 29)}}, []string{"gamma%three", "delta_four"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.Agent = "filterAgent"
+
+			rows, err := pg.FetchRows(tt.filter)
+			if err != nil {
+				t.Fatalf("FetchRows failed: %v", err)
+			}
+
+			// Counting ignores the cursor and the limit
+			if tt.filter.After == nil && tt.filter.Limit == 0 {
+				count, err := pg.CountRows(tt.filter)
+				if err != nil || count != len(tt.want) {
+					t.Errorf("CountRows() = %d, %v, want %d", count, err, len(tt.want))
+				}
+			}
+
+			var got []string
+			for _, row := range rows {
+				got = append(got, row.DBName)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("FetchRows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
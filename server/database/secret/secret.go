@@ -0,0 +1,176 @@
+// Package secret encrypts the database passwords stored by the backends.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// prefix marks the values that are encrypted. Anything without it is
+// treated as plaintext, as stored before encryption was enabled.
+const prefix = "enc:"
+
+// Keyring holds the keys used to encrypt and decrypt values. New values are
+// always encrypted with the current key, the others are only kept so that
+// values encrypted with them can still be decrypted while keys are rotated.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring returns a keyring with the given keys, which map the ID of each
+// key to the key itself, base64 encoded. Keys have to be 16, 24 or 32 bytes
+// long. current is the ID of the key new values are encrypted with.
+func NewKeyring(keys map[string]string, current string) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not among the keys", current)
+	}
+
+	k := &Keyring{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+
+	for id, encoded := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q: must not be empty or contain ':'", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key %q failed: %v", id, err)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", id, err)
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", id, err)
+		}
+
+		k.keys[id] = gcm
+	}
+
+	return k, nil
+}
+
+// GenerateKey returns a new random 32 byte key, base64 encoded.
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return "", fmt.Errorf("generating key failed: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Encrypt encrypts the value with the current key. Values that are already
+// encrypted and empty values are returned as they are, and so is everything
+// if the keyring is nil.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if k == nil || value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+
+	gcm := k.keys[k.current]
+
+	nonce := make([]byte, gcm.NonceSize())
+
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", fmt.Errorf("generating nonce failed: %v", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+
+	return prefix + k.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plaintext of the value. Values that are not
+// encrypted are returned as they are.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	id, sealed, err := split(value)
+	if err != nil {
+		return "", err
+	}
+
+	if k == nil {
+		return "", fmt.Errorf("value is encrypted with key %q, but no keys are configured", id)
+	}
+
+	gcm, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("value is encrypted with unknown key %q", id)
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted value is too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypting value failed: %v", err)
+	}
+
+	return string(plain), nil
+}
+
+// NeedsRotation returns true if the value is not encrypted with the current
+// key, either because it is plaintext or because it uses an older key.
+func (k *Keyring) NeedsRotation(value string) bool {
+	if k == nil || value == "" {
+		return false
+	}
+
+	if !IsEncrypted(value) {
+		return true
+	}
+
+	id, _, err := split(value)
+
+	return err == nil && id != k.current
+}
+
+// Rotate returns the value encrypted with the current key.
+func (k *Keyring) Rotate(value string) (string, error) {
+	if !k.NeedsRotation(value) {
+		return value, nil
+	}
+
+	plain, err := k.Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+
+	return k.Encrypt(plain)
+}
+
+// IsEncrypted returns true if the value was encrypted by a Keyring.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+func split(value string) (string, []byte, error) {
+	parts := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 2)
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("malformed encrypted value")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed encrypted value: %v", err)
+	}
+
+	return parts[0], sealed, nil
+}
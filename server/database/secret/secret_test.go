@@ -0,0 +1,120 @@
+package secret
+
+import (
+	"strings"
+	"testing"
+)
+
+// testKeys are "0123456789abcdef0123456789abcdef" and "fedcba9876543210fedcba9876543210"
+var testKeys = map[string]string{
+	"old": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+	"new": "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=",
+}
+
+func testKeyring(t *testing.T, current string, ids ...string) *Keyring {
+	keys := make(map[string]string)
+	for _, id := range ids {
+		keys[id] = testKeys[id]
+	}
+
+	k, err := NewKeyring(keys, current)
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+
+	return k
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := testKeyring(t, "old", "old")
+
+	enc, err := k.Encrypt("s3cr3t")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	if !IsEncrypted(enc) || strings.Contains(enc, "s3cr3t") {
+		t.Fatalf("value not encrypted: %q", enc)
+	}
+
+	again, _ := k.Encrypt(enc)
+	if again != enc {
+		t.Errorf("encrypted value encrypted again: %q", again)
+	}
+
+	dec, err := k.Decrypt(enc)
+	if err != nil || dec != "s3cr3t" {
+		t.Errorf("Decrypt = %q, %v", dec, err)
+	}
+
+	dec, err = k.Decrypt("plain")
+	if err != nil || dec != "plain" {
+		t.Errorf("plaintext Decrypt = %q, %v", dec, err)
+	}
+
+	_, err = k.Decrypt(enc[:len(enc)-4] + "AAA=")
+	if err == nil {
+		t.Errorf("expected tampered value to fail")
+	}
+
+	var nokeys *Keyring
+	if _, err = nokeys.Decrypt(enc); err == nil {
+		t.Errorf("expected decrypting without keys to fail")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	old := testKeyring(t, "old", "old")
+	k := testKeyring(t, "new", "old", "new")
+
+	enc, _ := old.Encrypt("s3cr3t")
+
+	if !k.NeedsRotation(enc) || !k.NeedsRotation("plain") || k.NeedsRotation("") {
+		t.Errorf("unexpected NeedsRotation results")
+	}
+
+	rotated, err := k.Rotate(enc)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	if !strings.HasPrefix(rotated, "enc:new:") || k.NeedsRotation(rotated) {
+		t.Errorf("value not rotated to the new key: %q", rotated)
+	}
+
+	if dec, _ := k.Decrypt(rotated); dec != "s3cr3t" {
+		t.Errorf("rotated value decrypts to %q", dec)
+	}
+
+	if _, err = old.Decrypt(rotated); err == nil {
+		t.Errorf("expected old keyring to fail on the new key")
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    map[string]string
+		current string
+	}{
+		{"missing current", map[string]string{"a": "MDEyMzQ1Njc4OWFiY2RlZg=="}, "b"},
+		{"invalid id", map[string]string{"a:b": "MDEyMzQ1Njc4OWFiY2RlZg=="}, "a:b"},
+		{"not base64", map[string]string{"a": "???"}, "a"},
+		{"wrong length", map[string]string{"a": "MDEy"}, "a"},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewKeyring(tt.keys, tt.current); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	if _, err = NewKeyring(map[string]string{"a": key}, "a"); err != nil {
+		t.Errorf("generated key rejected: %v", err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/djavorszky/ddn/common/model"
 	"github.com/djavorszky/ddn/server/database/data"
 	"github.com/djavorszky/ddn/server/database/dbutil"
+	"github.com/djavorszky/ddn/server/database/secret"
 	"github.com/djavorszky/sutils"
 	webpush "github.com/sherclockholmes/webpush-go"
 
@@ -20,6 +21,9 @@ import (
 type DB struct {
 	DBLocation string
 
+	// Keys encrypt the passwords of the databases. If nil, they are stored as they are.
+	Keys *secret.Keyring
+
 	conn *sql.DB
 }
 
@@ -41,6 +45,11 @@ func (lite *DB) ConnectAndPrepare() error {
 		return fmt.Errorf("failed updating tables: %v", err)
 	}
 
+	err = lite.utcDates()
+	if err != nil {
+		return fmt.Errorf("failed converting dates to UTC: %v", err)
+	}
+
 	return nil
 }
 
@@ -164,6 +173,62 @@ func (lite *DB) FetchAll() ([]data.Row, error) {
 	return entries, nil
 }
 
+// CountRows returns the number of rows that match the filter
+func (lite *DB) CountRows(filter data.RowFilter) (int, error) {
+	if err := lite.alive(); err != nil {
+		return 0, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	// Times are stored in UTC, see FetchRows
+	filter = utcTimes(filter)
+
+	query, args := dbutil.RowCountQuery(filter)
+
+	var count int
+	err := lite.conn.QueryRow(query, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+
+	return count, nil
+}
+
+// FetchRows returns the rows that match the filter, sorted and limited as it asks
+func (lite *DB) FetchRows(filter data.RowFilter) ([]data.Row, error) {
+	if err := lite.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	// Times are stored as text with the offset they were inserted with, so they
+	// are only compared correctly with times in the same zone
+	filter = utcTimes(filter)
+
+	query, args := dbutil.RowQuery(filter)
+
+	rows, err := lite.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	entries := make([]data.Row, 0)
+	for rows.Next() {
+		row, err := dbutil.ReadRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, row)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
 // FetchUserPushSubscriptions fetches the subscriptions for the specified user
 func (lite *DB) FetchUserPushSubscriptions(subscriber string) ([]webpush.Subscription, error) {
 	if err := lite.alive(); err != nil {
@@ -215,16 +280,21 @@ func (lite *DB) Insert(row *data.Row) error {
 		return fmt.Errorf("Database with name %q on agent %q already exists", row.DBName, row.AgentName)
 	}
 
+	dbpass, err := lite.Keys.Encrypt(row.DBPass)
+	if err != nil {
+		return fmt.Errorf("encrypting password failed: %v", err)
+	}
+
 	query := "INSERT INTO `databases` (`dbname`, `dbuser`, `dbpass`, `dbsid`, `dumpfile`, `createDate`, `expiryDate`, `creator`, `agentName`, `dbAddress`, `dbPort`, `dbvendor`, `status`, `message`, `visibility`, `comment`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 
 	res, err := lite.conn.Exec(query,
 		row.DBName,
 		row.DBUser,
-		row.DBPass,
+		dbpass,
 		row.DBSID,
 		row.Dumpfile,
-		row.CreateDate,
-		row.ExpiryDate,
+		row.CreateDate.UTC(),
+		row.ExpiryDate.UTC(),
 		row.Creator,
 		row.AgentName,
 		row.DBAddress,
@@ -295,16 +365,21 @@ func (lite *DB) Update(entry *data.Row) error {
 		return lite.Insert(entry)
 	}
 
+	dbpass, err := lite.Keys.Encrypt(entry.DBPass)
+	if err != nil {
+		return fmt.Errorf("encrypting password failed: %v", err)
+	}
+
 	query := "UPDATE `databases` SET `dbname`= ?, `dbuser`= ?, `dbpass`= ?, `dbsid`= ?, `dumpfile`= ?, `createDate`= ?, `expiryDate`= ?, `creator`= ?, `agentName`= ?, `dbAddress`= ?, `dbPort`= ?, `dbvendor`= ?, `status`= ?, `message`= ?, `visibility`= ?, `comment` = ? WHERE id = ?"
 
 	_, err = lite.conn.Exec(query,
 		entry.DBName,
 		entry.DBUser,
-		entry.DBPass,
+		dbpass,
 		entry.DBSID,
 		entry.Dumpfile,
-		entry.CreateDate,
-		entry.ExpiryDate,
+		entry.CreateDate.UTC(),
+		entry.ExpiryDate.UTC(),
 		entry.Creator,
 		entry.AgentName,
 		entry.DBAddress,
@@ -353,6 +428,136 @@ func (lite *DB) DeletePushSubscription(subscription *model.PushSubscription, sub
 	return err
 }
 
+// Restore inserts the row as it is, keeping its ID and its password, which is
+// not encrypted again. It is used to copy rows from another backend.
+func (lite *DB) Restore(entry data.Row) error {
+	if err := lite.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query := "INSERT INTO `databases` (`id`, `dbname`, `dbuser`, `dbpass`, `dbsid`, `dumpfile`, `createDate`, `expiryDate`, `creator`, `agentName`, `dbAddress`, `dbPort`, `dbvendor`, `status`, `message`, `visibility`, `comment`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	_, err := lite.conn.Exec(query,
+		entry.ID,
+		entry.DBName,
+		entry.DBUser,
+		entry.DBPass,
+		entry.DBSID,
+		entry.Dumpfile,
+		entry.CreateDate.UTC(),
+		entry.ExpiryDate.UTC(),
+		entry.Creator,
+		entry.AgentName,
+		entry.DBAddress,
+		entry.DBPort,
+		entry.DBVendor,
+		entry.Status,
+		entry.Message,
+		entry.Public,
+		entry.Comment,
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	return nil
+}
+
+// FetchAllPushSubscriptions returns the subscriptions of every user, by subscriber
+func (lite *DB) FetchAllPushSubscriptions() (map[string][]webpush.Subscription, error) {
+	if err := lite.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	rows, err := lite.conn.Query("SELECT subscriber, endpoint, p256dh_key, auth_key FROM `push_subscriptions`")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	entries := make(map[string][]webpush.Subscription)
+	for rows.Next() {
+		subscriber, row, err := dbutil.ReadSubscriberRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries[subscriber] = append(entries[subscriber], row)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
+// InsertAudit appends an entry to the audit log
+func (lite *DB) InsertAudit(entry *data.AuditEntry) error {
+	if err := lite.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query := "INSERT INTO `audit` (`time`, `actor`, `action`, `databaseId`, `target`, `params`, `outcome`, `message`, `requestId`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	res, err := lite.conn.Exec(query,
+		entry.Time.UTC(),
+		entry.Actor,
+		entry.Action,
+		entry.DatabaseID,
+		entry.Target,
+		entry.Params,
+		entry.Outcome,
+		entry.Message,
+		entry.RequestID,
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed getting new ID: %v", err)
+	}
+
+	entry.ID = int(id)
+
+	return nil
+}
+
+// FetchAudit returns the entries of the audit log that match the filter, newest first
+func (lite *DB) FetchAudit(filter data.AuditFilter) ([]data.AuditEntry, error) {
+	if err := lite.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query, args := dbutil.AuditQuery(filter)
+
+	rows, err := lite.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	entries := make([]data.AuditEntry, 0)
+	for rows.Next() {
+		entry, err := dbutil.ReadAuditRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
 type dbUpdate struct {
 	Query   string
 	Comment string
@@ -407,6 +612,14 @@ var queries = []dbUpdate{
 		Query:   "CREATE UNIQUE INDEX IF NOT EXISTS `agent_db_idx` ON `databases` (`dbname`, `agentName`);",
 		Comment: "Create unique index on columns (dbname, agentName) for table databases",
 	},
+	{
+		Query:   "CREATE TABLE `audit` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `time` DATETIME NOT NULL, `actor` VARCHAR(255) NOT NULL, `action` VARCHAR(45) NOT NULL, `databaseId` INTEGER NOT NULL DEFAULT 0, `target` VARCHAR(255) NOT NULL, `params` TEXT NOT NULL, `outcome` VARCHAR(45) NOT NULL, `message` TEXT NOT NULL, `requestId` VARCHAR(45) NOT NULL);",
+		Comment: "Create the audit table",
+	},
+	{
+		Query:   "CREATE INDEX IF NOT EXISTS `audit_time_idx` ON `audit` (`time`);",
+		Comment: "Create index on column time for table audit",
+	},
 }
 
 func (lite *DB) initTables() error {
@@ -448,3 +661,77 @@ func (lite *DB) alive() error {
 
 	return nil
 }
+
+// utcTimes returns the filter with its times in UTC, which is the zone the rows
+// are stored in.
+func utcTimes(filter data.RowFilter) data.RowFilter {
+	for _, t := range []*time.Time{&filter.CreatedFrom, &filter.CreatedTo, &filter.ExpiresFrom, &filter.ExpiresTo} {
+		if !t.IsZero() {
+			*t = t.UTC()
+		}
+	}
+
+	if filter.After != nil {
+		if t, ok := filter.After.Value.(time.Time); ok {
+			filter.After = &data.RowCursor{ID: filter.After.ID, Value: t.UTC()}
+		}
+	}
+
+	return filter
+}
+
+// utcDates rewrites the dates of the rows stored in another zone in UTC. Rows
+// used to be stored in the local time zone, whose offset changes with daylight
+// saving time, which breaks comparing them as text.
+func (lite *DB) utcDates() error {
+	rows, err := lite.conn.Query("SELECT id, createDate, expiryDate FROM `databases` WHERE createDate NOT LIKE '%+00:00' OR expiryDate NOT LIKE '%+00:00'")
+	if err != nil {
+		return fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+
+	type dates struct {
+		id                     int
+		createDate, expiryDate interface{}
+	}
+
+	var local []dates
+	for rows.Next() {
+		var d dates
+
+		err = rows.Scan(&d.id, &d.createDate, &d.expiryDate)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		local = append(local, d)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	for _, d := range local {
+		// Values that can't be parsed are read as the zero time, those are left as they are
+		for _, date := range []*interface{}{&d.createDate, &d.expiryDate} {
+			if t, ok := (*date).(time.Time); ok && !t.IsZero() {
+				*date = t.UTC()
+			} else {
+				*date = nil
+			}
+		}
+
+		_, err = lite.conn.Exec("UPDATE `databases` SET `createDate` = COALESCE(?, `createDate`), `expiryDate` = COALESCE(?, `expiryDate`) WHERE id = ?", d.createDate, d.expiryDate, d.id)
+		if err != nil {
+			return fmt.Errorf("updating dates of row %d failed: %s", d.id, err.Error())
+		}
+	}
+
+	if len(local) > 0 {
+		logger.Info("Converted the dates of %d rows to UTC", len(local))
+	}
+
+	return nil
+}
@@ -4,12 +4,14 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/djavorszky/ddn/common/model"
 	"github.com/djavorszky/ddn/server/database/data"
 	"github.com/djavorszky/ddn/server/database/dbutil"
+	"github.com/djavorszky/ddn/server/database/secret"
 	_ "github.com/mattn/go-sqlite3"
 	webpush "github.com/sherclockholmes/webpush-go"
 )
@@ -586,3 +588,337 @@ func TestDeleteUserPushNotification(t *testing.T) {
 		})
 	}
 }
+
+func TestAudit(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+
+	entries := []data.AuditEntry{
+		{Time: time.Now(), Actor: "alice@example.com", Action: "drop", DatabaseID: 1, Target: "mysql-55/first", Params: "{}", Outcome: data.AuditSuccess},
+		{Time: time.Now(), Actor: "bob@example.com", Action: "drop", DatabaseID: 2, Target: "mysql-55/second", Params: "{}", Outcome: data.AuditDenied, Message: "access denied"},
+		{Time: time.Now(), Actor: "alice@example.com", Action: "extend", DatabaseID: 1, Target: "mysql-55/first", Params: "{\"amount\":\"30\"}", Outcome: data.AuditSuccess, RequestID: "abc"},
+	}
+
+	for i := range entries {
+		err := lite.InsertAudit(&entries[i])
+		if err != nil {
+			t.Fatalf("InsertAudit failed: %v", err)
+		}
+
+		if entries[i].ID == 0 {
+			t.Errorf("InsertAudit did not set the ID")
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter data.AuditFilter
+		want   []int
+	}{
+		{"all", data.AuditFilter{}, []int{2, 1, 0}},
+		{"actor", data.AuditFilter{Actor: "alice@example.com"}, []int{2, 0}},
+		{"action and outcome", data.AuditFilter{Action: "drop", Outcome: data.AuditDenied}, []int{1}},
+		{"database", data.AuditFilter{DatabaseID: 2}, []int{1}},
+		{"time range", data.AuditFilter{From: start, To: time.Now().Add(time.Minute)}, []int{2, 1, 0}},
+		{"in the future", data.AuditFilter{From: time.Now().Add(time.Minute)}, []int{}},
+		{"limit", data.AuditFilter{Limit: 1}, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lite.FetchAudit(tt.filter)
+			if err != nil {
+				t.Fatalf("FetchAudit failed: %v", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d entries, got %d: %v", len(tt.want), len(got), got)
+			}
+
+			for i, idx := range tt.want {
+				want := entries[idx]
+				if got[i].ID != want.ID || got[i].Actor != want.Actor || got[i].Params != want.Params || got[i].Message != want.Message || got[i].RequestID != want.RequestID {
+					t.Errorf("entry %d mismatch: expected %+v, got %+v", i, want, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptedPassword(t *testing.T) {
+	keys, err := secret.NewKeyring(map[string]string{"k1": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}, "k1")
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+
+	enc := &DB{DBLocation: testDBFile, Keys: keys, conn: testConn}
+
+	entry := getTestEntry("testEncrypted", "encryptedDB")
+	err = enc.Insert(&entry)
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	if entry.DBPass != "testPass" {
+		t.Errorf("Insert changed the password of the row to %q", entry.DBPass)
+	}
+
+	var stored string
+	testConn.QueryRow("SELECT dbpass FROM `databases` WHERE id = ?", entry.ID).Scan(&stored)
+
+	if !secret.IsEncrypted(stored) || stored == entry.DBPass {
+		t.Fatalf("password stored in plaintext: %q", stored)
+	}
+
+	res, err := enc.FetchByID(entry.ID)
+	if err != nil {
+		t.Fatalf("FetchByID failed: %v", err)
+	}
+
+	if res.DBPass != stored {
+		t.Errorf("expected fetched password to stay encrypted, got %q", res.DBPass)
+	}
+
+	// Updating a fetched row must not encrypt the password again
+	res.Comment = "updated"
+	err = enc.Update(&res)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	testConn.QueryRow("SELECT dbpass FROM `databases` WHERE id = ?", entry.ID).Scan(&stored)
+
+	if pass, err := keys.Decrypt(stored); err != nil || pass != "testPass" {
+		t.Errorf("stored password decrypts to %q, %v", pass, err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	entry := getTestEntry("testRestore", "restoredDB")
+	entry.ID = 4242
+	entry.DBPass = "enc:k1:notreallyencrypted"
+
+	err := lite.Restore(entry)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	res, err := lite.FetchByID(entry.ID)
+	if err != nil {
+		t.Fatalf("FetchByID failed: %v", err)
+	}
+
+	if err = dbutil.CompareRows(entry, res); err != nil {
+		t.Errorf("Restored and read rows not the same: %v", err)
+	}
+
+	if err = lite.Restore(entry); err == nil {
+		t.Errorf("Restoring a row with a taken ID should have failed")
+	}
+
+	// Rows inserted afterwards get IDs after the restored one
+	next := entry
+	next.DBName = "afterRestore"
+	err = lite.Insert(&next)
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	if next.ID <= entry.ID {
+		t.Errorf("expected an ID higher than %d, got %d", entry.ID, next.ID)
+	}
+}
+
+func TestFetchAllPushSubscriptions(t *testing.T) {
+	subscriptions := map[string][]string{
+		"first@example.com":  {"firstEndpoint", "secondEndpoint"},
+		"second@example.com": {"thirdEndpoint"},
+	}
+
+	for subscriber, endpoints := range subscriptions {
+		for _, endpoint := range endpoints {
+			err := lite.InsertPushSubscription(&model.PushSubscription{
+				Endpoint: endpoint,
+				Keys:     webpush.Keys{P256dh: "key-" + endpoint, Auth: "auth-" + endpoint},
+			}, subscriber)
+			if err != nil {
+				t.Fatalf("InsertPushSubscription failed: %v", err)
+			}
+		}
+	}
+
+	all, err := lite.FetchAllPushSubscriptions()
+	if err != nil {
+		t.Fatalf("FetchAllPushSubscriptions failed: %v", err)
+	}
+
+	for subscriber, endpoints := range subscriptions {
+		if len(all[subscriber]) != len(endpoints) {
+			t.Errorf("expected %d subscriptions for %s, got %v", len(endpoints), subscriber, all[subscriber])
+			continue
+		}
+
+		for i, s := range all[subscriber] {
+			if s.Endpoint != endpoints[i] || s.Keys.P256dh != "key-"+endpoints[i] || s.Keys.Auth != "auth-"+endpoints[i] {
+				t.Errorf("unexpected subscription for %s: %+v", subscriber, s)
+			}
+		}
+	}
+}
+
+func TestFetchRows(t *testing.T) {
+	names := []string{"alpha_one", "Beta_two", "gamma%three", "delta_four"}
+	base := time.Now().In(gmt).Add(-time.Hour)
+
+	ids := make(map[string]int)
+	for i, name := range names {
+		entry := getTestEntry("filterAgent", name)
+		entry.Creator = "filter@example.com"
+		entry.CreateDate = base.Add(time.Duration(i) * time.Minute)
+		entry.ExpiryDate = base.AddDate(0, 0, 30-i)
+		entry.Status = 100 + i
+		entry.Public = 0
+
+		if name == "delta_four" {
+			entry.Creator = "other@example.com"
+			entry.Public = 1
+		}
+
+		err := lite.Insert(&entry)
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+
+		ids[name] = entry.ID
+	}
+
+	// Every filter names the agent, so rows of the other tests don't count
+	tests := []struct {
+		name   string
+		filter data.RowFilter
+		want   []string
+	}{
+		{"all", data.RowFilter{}, names},
+		{"descending", data.RowFilter{Desc: true}, []string{"delta_four", "gamma%three", "Beta_two", "alpha_one"}},
+		{"visible to", data.RowFilter{VisibleTo: "filter@example.com"}, names},
+		{"visible to someone else", data.RowFilter{VisibleTo: "nobody@example.com"}, []string{"delta_four"}},
+		{"creator", data.RowFilter{Creator: "other@example.com"}, []string{"delta_four"}},
+		{"name ignoring case", data.RowFilter{Name: "BETA"}, []string{"Beta_two"}},
+		{"name with wildcard", data.RowFilter{Name: "%"}, []string{"gamma%three"}},
+		{"name with underscore", data.RowFilter{Name: "a_o"}, []string{"alpha_one"}},
+		{"status", data.RowFilter{Status: []int{101, 103}}, []string{"Beta_two", "delta_four"}},
+		{"created range", data.RowFilter{CreatedFrom: base.Add(time.Minute), CreatedTo: base.Add(3 * time.Minute)}, []string{"Beta_two", "gamma%three"}},
+		{"expiry range", data.RowFilter{ExpiresFrom: base.AddDate(0, 0, 28)}, []string{"alpha_one", "Beta_two", "gamma%three"}},
+		{"sort by expiry", data.RowFilter{Sort: "expirydate"}, []string{"delta_four", "gamma%three", "Beta_two", "alpha_one"}},
+		{"limit", data.RowFilter{Limit: 2}, []string{"alpha_one", "Beta_two"}},
+		{"after id", data.RowFilter{After: &data.RowCursor{ID: ids["Beta_two"]}}, []string{"gamma%three", "delta_four"}},
+		{"after expiry", data.RowFilter{Sort: "expirydate", Desc: true, Limit: 2, After: &data.RowCursor{ID: ids["Beta_two"], Value: base.AddDate(0, 0, 29)}}, []string{"gamma%three", "delta_four"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.Agent = "filterAgent"
+
+			rows, err := lite.FetchRows(tt.filter)
+			if err != nil {
+				t.Fatalf("FetchRows failed: %v", err)
+			}
+
+			// Counting ignores the cursor and the limit
+			if tt.filter.After == nil && tt.filter.Limit == 0 {
+				count, err := lite.CountRows(tt.filter)
+				if err != nil || count != len(tt.want) {
+					t.Errorf("CountRows() = %d, %v, want %d", count, err, len(tt.want))
+				}
+			}
+
+			var got []string
+			for _, row := range rows {
+				got = append(got, row.DBName)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("FetchRows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDaylightSaving(t *testing.T) {
+	// When the clocks go back, 01:30 summer time is before 01:10 winter time
+	summer, winter := time.FixedZone("PDT", -7*60*60), time.FixedZone("PST", -8*60*60)
+	first := time.Date(2018, 11, 4, 1, 30, 0, 0, summer)
+	second := time.Date(2018, 11, 4, 1, 10, 0, 0, winter)
+
+	ids := make(map[string]int)
+	for _, name := range []string{"dst_first", "dst_second", "local_first", "local_second"} {
+		entry := getTestEntry("dstAgent", name)
+		entry.CreateDate = first
+		if strings.HasSuffix(name, "second") {
+			entry.CreateDate = second
+		}
+
+		err := lite.Insert(&entry)
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+
+		ids[name] = entry.ID
+	}
+
+	// Rows used to be stored with the offset of the local time zone
+	for name, date := range map[string]time.Time{"local_first": first, "local_second": second} {
+		_, err := testConn.Exec("UPDATE `databases` SET `createDate` = ? WHERE id = ?", date.Format("2006-01-02 15:04:05.999999999-07:00"), ids[name])
+		if err != nil {
+			t.Fatalf("failed storing local date: %v", err)
+		}
+	}
+
+	err := lite.utcDates()
+	if err != nil {
+		t.Fatalf("utcDates failed: %v", err)
+	}
+
+	var local int
+	testConn.QueryRow("SELECT count(*) FROM `databases` WHERE createDate NOT LIKE '%+00:00' OR expiryDate NOT LIKE '%+00:00'").Scan(&local)
+	if local != 0 {
+		t.Errorf("expected every date to be in UTC, %d are not", local)
+	}
+
+	fetch := func(filter data.RowFilter) []data.Row {
+		filter.Agent = "dstAgent"
+
+		rows, err := lite.FetchRows(filter)
+		if err != nil {
+			t.Fatalf("FetchRows failed: %v", err)
+		}
+
+		return rows
+	}
+
+	names := func(rows []data.Row) string {
+		var got []string
+		for _, row := range rows {
+			got = append(got, row.DBName)
+		}
+
+		return strings.Join(got, ",")
+	}
+
+	sorted := fetch(data.RowFilter{Sort: "createdate"})
+	if got := names(sorted); got != "dst_first,local_first,dst_second,local_second" {
+		t.Fatalf("unexpected order: %s", got)
+	}
+
+	if got := names(fetch(data.RowFilter{CreatedFrom: second})); got != "dst_second,local_second" {
+		t.Errorf("unexpected rows created from %v: %s", second, got)
+	}
+
+	if got := names(fetch(data.RowFilter{CreatedTo: second})); got != "dst_first,local_first" {
+		t.Errorf("unexpected rows created before %v: %s", second, got)
+	}
+
+	after := &data.RowCursor{ID: sorted[1].ID, Value: sorted[1].CreateDate}
+	if got := names(fetch(data.RowFilter{Sort: "createdate", After: after})); got != "dst_second,local_second" {
+		t.Errorf("unexpected rows after local_first: %s", got)
+	}
+}
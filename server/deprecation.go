@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/djavorszky/ddn/common/errs"
+	"github.com/djavorszky/ddn/common/inet"
+	"github.com/djavorszky/ddn/common/logger"
+)
+
+// v1Successors maps the names of the deprecated v1 routes to the path of the
+// v2 route replacing them.
+var v1Successors = map[string]string{
+	"api/create":        "/api/databases/create",
+	"api/list":          "/api/agents",
+	"api/listAgents":    "/api/agents",
+	"api/listDatabases": "/api/databases",
+	"api/visibility/":   "/api/databases/{id}/visibility/{visibility}",
+	"api/dbaccess":      "/api/databases/{agent}/{dbname}/accessinfo",
+}
+
+// deprecated marks the responses of the v1 route as deprecated in favour of
+// successor, and logs who still calls it. Once the date in api-v1-sunset has
+// passed, the route responds with 410 Gone instead.
+func deprecated(name, successor string, inner http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deprecatedCalls.WithLabelValues(name).Inc()
+
+		logger.With("route", name, "caller", caller(r)).Warn("deprecated v1 API called, use %s instead", successor)
+
+		header := w.Header()
+		header.Set("Deprecation", "true")
+		header.Set("Link", "<"+successor+">; rel=\"successor-version\"")
+
+		// The date is checked on startup
+		sunset, _ := config.apiV1Sunset()
+		if sunset.IsZero() {
+			inner(w, r)
+			return
+		}
+
+		header.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+
+		if !time.Now().Before(sunset) {
+			inet.SendFailure(w, http.StatusGone, errs.APIRemoved, successor)
+			return
+		}
+
+		inner(w, r)
+	}
+}
+
+// caller returns the user in the Authorization header, or the IP the request
+// came from if there's none.
+func caller(r *http.Request) string {
+	if user, err := getAPIUser(r); err == nil {
+		return user
+	}
+
+	return remoteIP(r)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn/server/database/data"
+)
+
+func TestV1Successors(t *testing.T) {
+	names := make(map[string]bool)
+	for _, route := range append(append(Routes{}, routes...), webJobRoutes...) {
+		names[route.Name] = true
+	}
+
+	for _, route := range v2Routes() {
+		if _, ok := v1Successors[route.Name]; ok {
+			t.Errorf("v2 route %q has the name of a deprecated one", route.Name)
+		}
+	}
+
+	for name := range v1Successors {
+		if !names[name] {
+			t.Errorf("%q is deprecated, but there's no such route", name)
+		}
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	defer func(old Config) { config = old }(config)
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}
+
+	handler := deprecated("api/list", "/api/agents", ok)
+
+	tests := []struct {
+		name   string
+		sunset string
+		status int
+	}{
+		{"no sunset", "", http.StatusOK},
+		{"before sunset", time.Now().AddDate(0, 0, 2).Format("2006-01-02"), http.StatusOK},
+		{"after sunset", "2000-01-01", http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		config.APIv1Sunset = tt.sunset
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/api/list", nil))
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, w.Code)
+		}
+
+		if w.Header().Get("Deprecation") != "true" {
+			t.Errorf("%s: expected a Deprecation header, got %v", tt.name, w.Header())
+		}
+
+		if w.Header().Get("Link") != `</api/agents>; rel="successor-version"` {
+			t.Errorf("%s: unexpected Link header %q", tt.name, w.Header().Get("Link"))
+		}
+
+		sunset := w.Header().Get("Sunset")
+		if (sunset == "") != (tt.sunset == "") {
+			t.Errorf("%s: unexpected Sunset header %q", tt.name, sunset)
+		}
+
+		if sunset != "" {
+			if _, err := http.ParseTime(sunset); err != nil {
+				t.Errorf("%s: Sunset header %q is not an HTTP date: %v", tt.name, sunset, err)
+			}
+		}
+	}
+}
+
+func TestV1Shims(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	body := `{"agent_identifier": "mysql-57", "requester_email": "alice@example.com", "username": "v1user", "password": "v1pass"}`
+
+	res, err := http.Post(srv.URL+"/api/create", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /api/create failed: %v", err)
+	}
+
+	var row data.Row
+	err = json.NewDecoder(res.Body).Decode(&row)
+	res.Body.Close()
+
+	if err != nil || res.StatusCode != http.StatusOK || row.DBName != "v1user" || row.Creator != "alice@example.com" {
+		t.Fatalf("expected the created row, got %d: %+v, %v", res.StatusCode, row, err)
+	}
+
+	if res.Header.Get("Deprecation") != "true" {
+		t.Errorf("expected /api/create to be deprecated, got headers %v", res.Header)
+	}
+
+	tests := []struct {
+		name   string
+		auth   string
+		path   string
+		status int
+	}{
+		{"requester only in path", "", "/api/dbaccess/alice@example.com/mysql-57/v1user", http.StatusForbidden},
+		{"same user", "alice@example.com", "/api/dbaccess/alice@example.com/mysql-57/v1user", http.StatusOK},
+		{"other user", "bob@example.com", "/api/dbaccess/alice@example.com/mysql-57/v1user", http.StatusForbidden},
+		{"private database", "bob@example.com", "/api/dbaccess/bob@example.com/mysql-57/v1user", http.StatusForbidden},
+		{"missing database", "alice@example.com", "/api/dbaccess/alice@example.com/mysql-57/nosuchdb", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", srv.URL+tt.path, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: GET failed: %v", tt.name, err)
+		}
+
+		var msg struct {
+			Status  int               `json:"status"`
+			Message map[string]string `json:"map"`
+		}
+
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, res.StatusCode, b)
+			continue
+		}
+
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		err = json.Unmarshal(b, &msg)
+		if err != nil || msg.Message["user"] != "v1user" || msg.Message["password"] != "v1pass" {
+			t.Errorf("%s: unexpected access info %s, %v", tt.name, b, err)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", srv.URL+"/api/list-databases", nil)
+	req.Header.Set("Authorization", "alice@example.com")
+
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST /api/list-databases failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	var list map[string]data.Row
+
+	err = json.NewDecoder(res.Body).Decode(&list)
+	if err != nil || len(list) != 1 {
+		t.Errorf("expected the created database to be listed, got %+v, %v", list, err)
+	}
+}
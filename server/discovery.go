@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/djavorszky/ddn/common/discovery"
+	"github.com/djavorszky/ddn/common/inet"
+	"github.com/djavorszky/ddn/common/logger"
+)
+
+// announce periodically sends the address of the server to the discovery group,
+// so that agents without a configured server-address can register.
+//
+// announce should always be ran in a goroutine.
+func announce() {
+	err := discovery.Announce(config.discoveryGroup(), config.DiscoveryToken, announcement(), discovery.DefaultInterval, nil)
+	if err != nil {
+		logger.Error("discovery: %v", err)
+	}
+}
+
+// announcement returns what the agents need to know to register with the server.
+func announcement() discovery.Announcement {
+	return discovery.Announcement{
+		Address:    inet.WithScheme(fmt.Sprintf("%s:%s", config.ServerHost, config.ServerPort)),
+		Version:    version,
+		TLS:        config.TLS().Enabled(),
+		ClientCert: config.TLS().Mutual(),
+	}
+}
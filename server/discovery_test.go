@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/djavorszky/ddn/common/discovery"
+	"github.com/djavorszky/ddn/common/inet"
+)
+
+func TestAnnouncement(t *testing.T) {
+	defer func(old Config, scheme string) { config, inet.DefaultScheme = old, scheme }(config, inet.DefaultScheme)
+
+	config = Config{ServerHost: "ddn.example.com", ServerPort: "7010"}
+
+	a := announcement()
+	if a.Address != "http://ddn.example.com:7010" || a.TLS || a.ClientCert {
+		t.Errorf("unexpected announcement without TLS: %+v", a)
+	}
+
+	if config.discoveryGroup() != discovery.DefaultGroup {
+		t.Errorf("expected the default group, got %q", config.discoveryGroup())
+	}
+
+	config.TLSCert, config.TLSKey, config.TLSClientCA = "server.crt", "server.key", "ca.crt"
+	inet.DefaultScheme = "https"
+
+	a = announcement()
+	if a.Address != "https://ddn.example.com:7010" || !a.TLS || !a.ClientCert {
+		t.Errorf("unexpected announcement with mutual TLS: %+v", a)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/djavorszky/ddn/common/status"
+)
+
+// TestFailures checks that failures are sent in the same envelope by every
+// API, with the HTTP status in the body matching the one in the header.
+func TestFailures(t *testing.T) {
+	defer func(old Config) { config = old }(config)
+	config.AdminEmail = []string{"admin@example.com"}
+
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	subscription := `{"endpoint": "https://push.example.com", "keys": {"p256dh": "key", "auth": "secret"}}`
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		body   string
+		status int
+	}{
+		// v2
+		{"v2 without user", "GET", "/api/agents", "", "", http.StatusForbidden},
+		{"v2 missing database", "GET", "/api/databases/999", "alice@example.com", "", http.StatusNotFound},
+		{"v2 invalid json", "POST", "/api/databases/create", "alice@example.com", "{", http.StatusBadRequest},
+		{"v2 missing parameters", "POST", "/api/databases/create", "alice@example.com", "{}", http.StatusBadRequest},
+		{"v2 encrypted password", "POST", "/api/databases/import", "alice@example.com", `{"dumpfile_location": "dump.sql", "password": "enc:k1:abc"}`, http.StatusBadRequest},
+		{"v2 not an admin", "GET", "/api/admin/audit", "alice@example.com", "", http.StatusForbidden},
+
+		// v1
+		{"v1 invalid json", "POST", "/api/create", "", "{", http.StatusBadRequest},
+		{"v1 missing parameters", "POST", "/api/create", "", "{}", http.StatusBadRequest},
+		{"v1 without user", "POST", "/api/list-databases", "", "", http.StatusForbidden},
+		{"v1 missing database", "GET", "/api/dbaccess/alice@example.com/mysql-57/nosuchdb", "alice@example.com", "", http.StatusNotFound},
+		{"v1 requester only in path", "GET", "/api/dbaccess/alice@example.com/mysql-57/nosuchdb", "", "", http.StatusForbidden},
+		{"v1 invalid subscription", "POST", "/api/save-subscription", "", "{}", http.StatusBadRequest},
+		{"v1 without cookie", "POST", "/api/remove-subscription", "", subscription, http.StatusBadRequest},
+
+		// Endpoints of the agents
+		{"register invalid json", "POST", "/register", "", "{", http.StatusBadRequest},
+		{"unregister invalid json", "POST", "/unregister", "", "{", http.StatusBadRequest},
+		{"upd8 invalid json", "POST", "/upd8", "", "{", http.StatusBadRequest},
+		{"alive unknown agent", "GET", "/alive/nosuchagent", "", "", http.StatusNotFound},
+		{"capacity unknown agent", "POST", "/alive/nosuchagent", "", "{}", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %s %s failed: %v", tt.name, tt.method, tt.path, err)
+		}
+
+		checkFailure(t, tt.name, res, tt.status)
+	}
+
+	// Removed v1 routes as well
+	config.APIv1Sunset = "2000-01-01"
+
+	res, err := http.Get(srv.URL + "/api/list")
+	if err != nil {
+		t.Fatalf("GET /api/list failed: %v", err)
+	}
+
+	checkFailure(t, "v1 removed", res, http.StatusGone)
+}
+
+// checkFailure checks that res is a failure with the expected HTTP status, and
+// that its body is consistent with it.
+func checkFailure(t *testing.T, name string, res *http.Response, expected int) {
+	defer res.Body.Close()
+
+	b, _ := ioutil.ReadAll(res.Body)
+
+	var body struct {
+		Success    bool     `json:"success"`
+		Error      []string `json:"error"`
+		HTTPStatus int      `json:"http_status"`
+		Status     int      `json:"status"`
+		Message    string   `json:"message"`
+	}
+
+	err := json.Unmarshal(b, &body)
+	if err != nil {
+		t.Errorf("%s: couldn't decode failure %q: %v", name, b, err)
+		return
+	}
+
+	if res.StatusCode != expected {
+		t.Errorf("%s: expected status %d, got %d: %s", name, expected, res.StatusCode, b)
+	}
+
+	if body.HTTPStatus != res.StatusCode {
+		t.Errorf("%s: sent with status %d, but the body says %d", name, res.StatusCode, body.HTTPStatus)
+	}
+
+	if body.Success || body.Message == "" || len(body.Error) == 0 || body.Error[0] != body.Message {
+		t.Errorf("%s: unexpected failure %s", name, b)
+	}
+
+	clientErr := body.Status >= status.ClientError && body.Status < status.ServerError
+	if clientErr != (res.StatusCode < http.StatusInternalServerError) {
+		t.Errorf("%s: status %d doesn't match HTTP status %d", name, body.Status, res.StatusCode)
+	}
+}
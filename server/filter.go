@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn/server/database/data"
+	"github.com/djavorszky/ddn/server/database/dbutil"
+)
+
+// rowCursor is what the cursors of the pages of databases hold. The sort is
+// included so that a cursor can't be used with a different one.
+type rowCursor struct {
+	Sort  string      `json:"s"`
+	ID    int         `json:"id"`
+	Value interface{} `json:"v,omitempty"`
+}
+
+// parseRowFilter reads the filter of the databases from the query parameters of the request.
+func parseRowFilter(r *http.Request) (data.RowFilter, error) {
+	q := r.URL.Query()
+
+	filter := data.RowFilter{
+		Vendor:  q.Get("vendor"),
+		Agent:   q.Get("agent"),
+		Creator: q.Get("creator"),
+		Name:    q.Get("name"),
+		Sort:    "id",
+		Desc:    true,
+	}
+
+	var err error
+	if val := q.Get("status"); val != "" {
+		for _, s := range strings.Split(val, ",") {
+			status, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return filter, fmt.Errorf("status: %q is not a valid number", s)
+			}
+
+			filter.Status = append(filter.Status, status)
+		}
+	}
+
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{
+		{"created_from", &filter.CreatedFrom},
+		{"created_to", &filter.CreatedTo},
+		{"expires_from", &filter.ExpiresFrom},
+		{"expires_to", &filter.ExpiresTo},
+	} {
+		if val := q.Get(param.name); val != "" {
+			*param.dest, err = time.Parse(time.RFC3339, val)
+			if err != nil {
+				return filter, fmt.Errorf("%s: %v", param.name, err)
+			}
+		}
+	}
+
+	if val := q.Get("sort"); val != "" {
+		filter.Sort, filter.Desc = strings.TrimPrefix(val, "-"), strings.HasPrefix(val, "-")
+
+		if _, ok := dbutil.RowSortColumns[filter.Sort]; !ok {
+			return filter, fmt.Errorf("sort: can't sort by %q", filter.Sort)
+		}
+	}
+
+	if val := q.Get("limit"); val != "" {
+		filter.Limit, err = strconv.Atoi(val)
+		if err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("limit: %q is not a valid number", val)
+		}
+	}
+
+	if val := q.Get("cursor"); val != "" {
+		filter.After, err = decodeCursor(val, sortParam(filter))
+		if err != nil {
+			return filter, fmt.Errorf("cursor: %v", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// sortParam returns the sort of the filter the way it's given in the query.
+func sortParam(filter data.RowFilter) string {
+	if filter.Desc {
+		return "-" + filter.Sort
+	}
+
+	return filter.Sort
+}
+
+// encodeCursor returns the cursor of the page that starts after the row.
+func encodeCursor(filter data.RowFilter, row data.Row) string {
+	c := rowCursor{Sort: sortParam(filter), ID: row.ID}
+
+	switch filter.Sort {
+	case "dbname":
+		c.Value = row.DBName
+	case "vendor":
+		c.Value = row.DBVendor
+	case "agent":
+		c.Value = row.AgentName
+	case "creator":
+		c.Value = row.Creator
+	case "status":
+		c.Value = row.Status
+	case "createdate":
+		c.Value = row.CreateDate.Format(time.RFC3339Nano)
+	case "expirydate":
+		c.Value = row.ExpiryDate.Format(time.RFC3339Nano)
+	}
+
+	b, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor reads the cursor, and checks that it was made for the same sort.
+func decodeCursor(val, sort string) (*data.RowCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+
+	var c rowCursor
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+
+	if c.Sort != sort {
+		return nil, fmt.Errorf("cursor was made for sort %q, not %q", c.Sort, sort)
+	}
+
+	cursor := &data.RowCursor{ID: c.ID, Value: c.Value}
+
+	switch v := c.Value.(type) {
+	case float64:
+		cursor.Value = int(v)
+	case string:
+		if strings.HasSuffix(sort, "date") {
+			cursor.Value, err = time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, fmt.Errorf("malformed cursor")
+			}
+		}
+	}
+
+	return cursor, nil
+}
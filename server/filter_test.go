@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn/server/database"
+	"github.com/djavorszky/ddn/server/database/data"
+)
+
+func TestParseRowFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/databases?vendor=mysql&agent=mysql-57&creator=a@example.com&name=test&status=100,101&created_from=2018-05-01T00:00:00Z&expires_to=2018-06-01T00:00:00Z&sort=-expirydate&limit=10", nil)
+
+	filter, err := parseRowFilter(r)
+	if err != nil {
+		t.Fatalf("parseRowFilter failed: %v", err)
+	}
+
+	want := data.RowFilter{
+		Vendor:      "mysql",
+		Agent:       "mysql-57",
+		Creator:     "a@example.com",
+		Name:        "test",
+		Status:      []int{100, 101},
+		CreatedFrom: time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresTo:   time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC),
+		Sort:        "expirydate",
+		Desc:        true,
+		Limit:       10,
+	}
+
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("expected %+v, got %+v", want, filter)
+	}
+
+	filter, err = parseRowFilter(httptest.NewRequest("GET", "/api/databases", nil))
+	if err != nil || filter.Sort != "id" || !filter.Desc || filter.Limit != 0 {
+		t.Errorf("expected newest first without a limit, got %+v, %v", filter, err)
+	}
+
+	cursor := encodeCursor(data.RowFilter{Sort: "dbname"}, data.Row{ID: 3, DBName: "three"})
+
+	for _, query := range []string{"status=x", "created_to=yesterday", "sort=dbpass", "limit=-1", "cursor=nonsense", "sort=-dbname&cursor=" + cursor} {
+		_, err = parseRowFilter(httptest.NewRequest("GET", "/api/databases?"+query, nil))
+		if err == nil {
+			t.Errorf("expected %q to fail", query)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	created := time.Date(2018, 5, 1, 12, 30, 0, 500, time.UTC)
+	row := data.Row{ID: 7, DBName: "seven", Status: 100, CreateDate: created}
+
+	tests := []struct {
+		sort  string
+		desc  bool
+		value interface{}
+	}{
+		{"id", true, nil},
+		{"dbname", false, "seven"},
+		{"status", true, 100},
+		{"createdate", false, created},
+	}
+
+	for _, tt := range tests {
+		filter := data.RowFilter{Sort: tt.sort, Desc: tt.desc}
+
+		cursor, err := decodeCursor(encodeCursor(filter, row), sortParam(filter))
+		if err != nil {
+			t.Errorf("%s: decodeCursor failed: %v", tt.sort, err)
+			continue
+		}
+
+		if cursor.ID != row.ID {
+			t.Errorf("%s: expected ID %d, got %d", tt.sort, row.ID, cursor.ID)
+		}
+
+		if tm, ok := tt.value.(time.Time); ok {
+			if got, ok := cursor.Value.(time.Time); !ok || !got.Equal(tm) {
+				t.Errorf("%s: expected %v, got %v", tt.sort, tt.value, cursor.Value)
+			}
+		} else if cursor.Value != tt.value {
+			t.Errorf("%s: expected %v, got %v", tt.sort, tt.value, cursor.Value)
+		}
+	}
+}
+
+func TestGetAPIDatabasesPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddn-filter")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := openTestBackend(t, dir, "ddn.db")
+	defer backend.Close()
+
+	defer func(old database.BackendConnection) { db = old }(db)
+	db = backend
+
+	base := time.Now().Add(-time.Hour)
+	for i, creator := range []string{"alice@example.com", "bob@example.com", "alice@example.com", "alice@example.com", "bob@example.com"} {
+		row := data.Row{
+			DBName:     "db" + string('a'+rune(i)),
+			Creator:    creator,
+			AgentName:  "mysql-57",
+			DBVendor:   "mysql",
+			CreateDate: base.Add(time.Duration(i) * time.Minute),
+			ExpiryDate: base.AddDate(0, 1, 0),
+			Status:     100,
+		}
+
+		// One of bob's databases is public
+		if i == 4 {
+			row.Public = 1
+		}
+
+		if err := backend.Insert(&row); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	var (
+		seen   []string
+		cursor string
+	)
+
+	for page := 0; page < 5; page++ {
+		query := url.Values{"sort": {"-createdate"}, "limit": {"2"}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		r := httptest.NewRequest("GET", "/api/databases?"+query.Encode(), nil)
+		r.Header.Set("Authorization", "alice@example.com")
+		w := httptest.NewRecorder()
+
+		getAPIDatabases(w, r)
+
+		var resp struct {
+			Success bool       `json:"success"`
+			Data    []data.Row `json:"data"`
+			Next    string     `json:"next"`
+		}
+
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || !resp.Success {
+			t.Fatalf("unexpected response %d: %v, %+v", w.Code, err, resp)
+		}
+
+		for _, row := range resp.Data {
+			seen = append(seen, row.DBName)
+		}
+
+		cursor = resp.Next
+		if cursor == "" {
+			break
+		}
+	}
+
+	// Bob's private database is left out, the rest are newest first
+	want := []string{"dbe", "dbd", "dbc", "dba"}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("expected %v, got %v", want, seen)
+	}
+}
@@ -11,9 +11,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/djavorszky/ddn/common/errs"
 	"github.com/djavorszky/ddn/common/inet"
 	"github.com/djavorszky/ddn/common/logger"
 	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/common/srv"
 	"github.com/djavorszky/ddn/common/status"
 	vis "github.com/djavorszky/ddn/common/visibility"
 	"github.com/djavorszky/ddn/server/database/data"
@@ -36,6 +38,10 @@ func createdb(w http.ResponseWriter, r *http.Request) {
 	loadPage(w, r, "createdb")
 }
 
+func agents(w http.ResponseWriter, r *http.Request) {
+	loadPage(w, r, "agents")
+}
+
 func importdb(w http.ResponseWriter, r *http.Request) {
 	if config.MountLoc != "" {
 		loadPage(w, r, "importchooser")
@@ -78,18 +84,24 @@ func prepImportAction(w http.ResponseWriter, r *http.Request) {
 		public   = r.PostFormValue("public")
 	)
 
+	params := map[string]string{"dumpfile": dumpfile, "public": public}
+
 	dbID, err := doPrepImport(getUser(r), agent, dumpfile, dbname, dbuser, dbpass, public)
 	if err != nil {
 		session.AddFlash(fmt.Sprintf("Failed preparing import: %v", err), "fail")
+
+		audit(r, getUser(r), "import", data.Row{AgentName: agent, DBName: dbname}, params, err)
 		return
 	}
 
-	go doImport(int(dbID), dumpfile)
+	audit(r, getUser(r), "import", data.Row{ID: dbID, AgentName: agent, DBName: dbname}, params, nil)
+
+	go doImport(int(dbID), dumpfile, srv.RequestID(r))
 
 	session.AddFlash("Started the import process...", "msg")
 }
 
-func doImport(dbID int, dumpfile string) {
+func doImport(dbID int, dumpfile, requestID string) {
 	dbe, err := db.FetchByID(dbID)
 	if err != nil {
 		logger.Error("Failed getting entry by ID: %v", err)
@@ -129,7 +141,7 @@ func doImport(dbID int, dumpfile string) {
 		return
 	}
 
-	_, err = agent.ImportDatabase(int(dbID), dbe.DBName, dbe.DBUser, dbe.DBPass, url)
+	_, err = agent.WithRequestID(requestID).ImportDatabase(int(dbID), dbe.DBName, dbe.DBUser, password(dbe), url)
 	if err != nil {
 		dbe.Status = status.ImportFailed
 		dbe.Message = "Server error: " + err.Error()
@@ -148,6 +160,11 @@ func doPrepImport(creator, agentName, dumpfile, dbname, dbuser, dbpass, public s
 		return 0, fmt.Errorf("agent went offline")
 	}
 
+	err := checkPassword(dbpass)
+	if err != nil {
+		return 0, err
+	}
+
 	ensureValues(&dbname, &dbuser, &dbpass, agent.DBVendor)
 
 	entry := data.Row{
@@ -169,7 +186,7 @@ func doPrepImport(creator, agentName, dumpfile, dbname, dbuser, dbpass, public s
 		entry.Public = vis.Public
 	}
 
-	err := db.Insert(&entry)
+	err = db.Insert(&entry)
 	if err != nil {
 		return 0, fmt.Errorf("database persist: %v", err)
 	}
@@ -200,7 +217,7 @@ func copyFile(dump string) (string, error) {
 
 	}
 
-	url := fmt.Sprintf("http://%s:%s/dumps/%s", config.ServerHost, config.ServerPort, filename)
+	url := inet.WithScheme(fmt.Sprintf("%s:%s/dumps/%s", config.ServerHost, config.ServerPort, filename))
 
 	return url, nil
 }
@@ -225,6 +242,12 @@ func importAction(w http.ResponseWriter, r *http.Request) {
 	}
 	defer session.Save(r, w)
 
+	err = checkPassword(dbpass)
+	if err != nil {
+		session.AddFlash(fmt.Sprintf("Failed importing database: %v", err), "fail")
+		return
+	}
+
 	var filename string
 	for _, uploadFile := range r.MultipartForm.File {
 		filename = uploadFile[0].Filename
@@ -263,9 +286,11 @@ func importAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	agent = agent.WithRequestID(srv.RequestID(r))
+
 	ensureValues(&dbname, &dbuser, &dbpass, agent.DBVendor)
 
-	url := fmt.Sprintf("http://%s:%s/dumps/%s", config.ServerHost, config.ServerPort, filename)
+	url := inet.WithScheme(fmt.Sprintf("%s:%s/dumps/%s", config.ServerHost, config.ServerPort, filename))
 	entry := data.Row{
 		DBName:     dbname,
 		DBUser:     dbuser,
@@ -286,11 +311,15 @@ func importAction(w http.ResponseWriter, r *http.Request) {
 		entry.Public = vis.Public
 	}
 
+	params := map[string]string{"upload": filename, "public": public}
+
 	err = db.Insert(&entry)
 	if err != nil {
 		logger.Error("persist: %v", err)
 		session.AddFlash(fmt.Sprintf("failed persisting database locally: %v", err), "fail")
 		os.Remove(fmt.Sprintf("%s/web/dumps/%s", workdir, filename))
+
+		audit(r, entry.Creator, "import", entry, params, err)
 		return
 	}
 
@@ -300,9 +329,13 @@ func importAction(w http.ResponseWriter, r *http.Request) {
 
 		db.Delete(entry)
 		os.Remove(fmt.Sprintf("%s/web/dumps/%s", workdir, filename))
+
+		audit(r, entry.Creator, "import", entry, params, err)
 		return
 	}
 
+	audit(r, entry.Creator, "import", entry, params, nil)
+
 	session.AddFlash(resp, "msg")
 }
 
@@ -325,12 +358,20 @@ func createAction(w http.ResponseWriter, r *http.Request) {
 	}
 	defer session.Save(r, w)
 
+	err = checkPassword(dbpass)
+	if err != nil {
+		session.AddFlash(fmt.Sprintf("Failed creating database: %v", err), "fail")
+		return
+	}
+
 	agent, ok := registry.Get(agentName)
 	if !ok {
 		session.AddFlash(fmt.Sprintf("Failed creating database, agent %s went offline", agentName), "fail")
 		return
 	}
 
+	agent = agent.WithRequestID(srv.RequestID(r))
+
 	ensureValues(&dbname, &dbuser, &dbpass, agent.DBVendor)
 
 	entry := data.Row{
@@ -352,11 +393,15 @@ func createAction(w http.ResponseWriter, r *http.Request) {
 		entry.Public = vis.Public
 	}
 
+	params := map[string]string{"public": public}
+
 	err = db.Insert(&entry)
 	if err != nil {
 		logger.Error("persist: %v", err)
 
 		session.AddFlash(err.Error(), "fail")
+
+		audit(r, entry.Creator, "create", entry, params, err)
 		return
 	}
 
@@ -364,9 +409,13 @@ func createAction(w http.ResponseWriter, r *http.Request) {
 	resp, err := agent.CreateDatabase(ID, dbname, dbuser, dbpass)
 	if err != nil {
 		session.AddFlash(err.Error(), "fail")
+
+		audit(r, entry.Creator, "create", entry, params, err)
 		return
 	}
 
+	audit(r, entry.Creator, "create", entry, params, nil)
+
 	session.Values["id"] = entry.ID
 	session.AddFlash(resp, "success")
 }
@@ -377,7 +426,11 @@ func register(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error("json decode: %v", err)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.ErrorJSONResponse(err))
+		inet.ErrorJSONResponse(err).Send(w)
+		return
+	}
+
+	if !verifyAgent(w, r, req.ShortName) {
 		return
 	}
 
@@ -397,6 +450,7 @@ func register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	registry.Store(ddnc)
+	setAgentUp(ddnc.ShortName, true)
 
 	logger.Info("Registered: %v", req.AgentName)
 
@@ -413,11 +467,16 @@ func unregister(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(&agent)
 	if err != nil {
-		logger.Error("json encode: %v", err)
+		inet.ErrorJSONResponse(err).Send(w)
+		return
+	}
+
+	if !verifyAgent(w, r, agent.ShortName) {
 		return
 	}
 
 	registry.Remove(agent.ShortName)
+	agentUp.DeleteLabelValues(agent.ShortName)
 
 	logger.Info("Unregistered: %s", agent.Identifier)
 }
@@ -428,11 +487,49 @@ func heartbeat(w http.ResponseWriter, r *http.Request) {
 }
 
 func alive(w http.ResponseWriter, r *http.Request) {
-	if registry.Exists(mux.Vars(r)["shortname"]) {
-		inet.WriteHeader(w, http.StatusOK)
-	} else {
-		inet.WriteHeader(w, http.StatusNotFound)
+	shortname := mux.Vars(r)["shortname"]
+
+	if !verifyAgent(w, r, shortname) {
+		return
+	}
+
+	if !registry.Exists(shortname) {
+		inet.SendFailure(w, http.StatusNotFound, errs.AgentNotFound, shortname)
+		return
 	}
+
+	inet.WriteHeader(w, http.StatusOK)
+}
+
+// aliveCapacity works the same way as alive, but also stores the capacity
+// the agent sent along.
+func aliveCapacity(w http.ResponseWriter, r *http.Request) {
+	shortname := mux.Vars(r)["shortname"]
+
+	if !verifyAgent(w, r, shortname) {
+		return
+	}
+
+	var c model.Capacity
+
+	err := json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		logger.Error("capacity of %s: decoding failed: %v", shortname, err)
+
+		inet.SendFailure(w, http.StatusBadRequest, errs.JSONDecodeFailed, err.Error())
+		return
+	}
+
+	// checkAgents updates the agents at the same time
+	agent, ok := registry.Update(shortname, func(agent *model.Agent) { agent.Capacity = &c })
+	if !ok {
+		inet.SendFailure(w, http.StatusNotFound, errs.AgentNotFound, shortname)
+		return
+	}
+
+	checkCapacity(agent)
+
+	inet.WriteHeader(w, http.StatusOK)
 }
 
 func login(w http.ResponseWriter, r *http.Request) {
@@ -479,15 +576,21 @@ func extend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	params := map[string]string{"amount": "30", "unit": "days"}
+
 	dbe.ExpiryDate = time.Now().AddDate(0, 0, 30)
 	dbe.Status = status.Success
 
 	err = db.Update(&dbe)
 	if err != nil {
 		http.Error(w, "Failed updating entry: "+err.Error(), http.StatusInternalServerError)
+
+		audit(r, getUser(r), "extend", dbe, params, err)
 		return
 	}
 
+	audit(r, getUser(r), "extend", dbe, params, nil)
+
 	session, err := store.Get(r, "user-session")
 	if err != nil {
 		http.Error(w, "Failed getting session: "+err.Error(), http.StatusInternalServerError)
@@ -532,6 +635,8 @@ func drop(w http.ResponseWriter, r *http.Request) {
 	if dbe.Creator != user {
 		logger.Error("User %q tried to drop database of user %q.", user, dbe.Creator)
 		session.AddFlash("Failed dropping database: You can only drop databases you created.", "fail")
+
+		audit(r, user, "drop", dbe, nil, errDenied)
 		return
 	}
 
@@ -539,15 +644,21 @@ func drop(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		logger.Error("Agent %q is offline, can't drop database with id '%d'", dbe.AgentName, ID)
 		session.AddFlash("Unable to drop database: Agent is down.", "fail")
+
+		audit(r, user, "drop", dbe, nil, fmt.Errorf("agent %s not found", dbe.AgentName))
 		return
 	}
 
+	agent = agent.WithRequestID(srv.RequestID(r))
+
 	dbe.Status = status.DropInProgress
 
 	db.Update(&dbe)
 
 	go dropAsync(agent, ID, dbe.DBName, dbe.DBUser)
 
+	audit(r, user, "drop", dbe, nil, nil)
+
 	session.AddFlash("Started to drop the database.", "msg")
 }
 
@@ -605,6 +716,8 @@ func exportAction(w http.ResponseWriter, r *http.Request) {
 	if dbe.Creator != user {
 		logger.Error("User %q tried to export database of user %q.", user, dbe.Creator)
 		session.AddFlash("Failed exporting database: You can only export databases you created.", "fail")
+
+		audit(r, user, "export", dbe, nil, errDenied)
 		return
 	}
 
@@ -612,19 +725,27 @@ func exportAction(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		logger.Error("Agent %q is offline, can't export database with id '%d'", dbe.AgentName, ID)
 		session.AddFlash("Unable to export database: Agent is down.", "fail")
+
+		audit(r, user, "export", dbe, nil, fmt.Errorf("agent %s not found", dbe.AgentName))
 		return
 	}
 
+	agent = agent.WithRequestID(srv.RequestID(r))
+
 	dbe.Status = status.ExportInProgress
 
 	db.Update(&dbe)
 
-	resp, err := agent.ExportDatabase(ID, dbe.DBName, dbe.DBUser, dbe.DBPass)
+	resp, err := agent.ExportDatabase(ID, dbe.DBName, dbe.DBUser, password(dbe))
 	if err != nil {
 		session.AddFlash(err.Error(), "fail")
+
+		audit(r, user, "export", dbe, nil, err)
 		return
 	}
 
+	audit(r, user, "export", dbe, nil, nil)
+
 	session.AddFlash(resp, "msg")
 }
 
@@ -703,6 +824,8 @@ func recreate(w http.ResponseWriter, r *http.Request) {
 	if dbe.Creator != user {
 		logger.Error("User %q tried to get recreate the database created by %q.", user, dbe.Creator)
 		session.AddFlash("Failed recreating databasee: You can only recreate database you created.", "fail")
+
+		audit(r, user, "recreate", dbe, nil, errDenied)
 		return
 	}
 
@@ -710,15 +833,23 @@ func recreate(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		logger.Error("Agent %q is offline, can't recreate database with id '%d'", dbe.AgentName, ID)
 		session.AddFlash("Unable to recreate database: Agent is down.", "fail")
+
+		audit(r, user, "recreate", dbe, nil, fmt.Errorf("agent %s not found", dbe.AgentName))
 		return
 	}
 
-	resp, err := agent.ExportDatabase(ID, dbe.DBName, dbe.DBUser, dbe.DBPass)
+	agent = agent.WithRequestID(srv.RequestID(r))
+
+	resp, err := agent.ExportDatabase(ID, dbe.DBName, dbe.DBUser, password(dbe))
 	if err != nil {
 		session.AddFlash(err.Error(), "fail")
+
+		audit(r, user, "recreate", dbe, nil, err)
 		return
 	}
 
+	audit(r, user, "recreate", dbe, nil, nil)
+
 	session.AddFlash(resp, "msg")
 }
 
@@ -735,7 +866,7 @@ func recreateAsync(agent model.Agent, dbe data.Row) {
 		return
 	}
 
-	_, err = agent.CreateDatabase(dbe.ID, dbe.DBName, dbe.DBUser, dbe.DBPass)
+	_, err = agent.CreateDatabase(dbe.ID, dbe.DBName, dbe.DBUser, password(dbe))
 	if err != nil {
 		dbe.Status = status.CreateDatabaseFailed
 		dbe.Message = err.Error()
@@ -761,18 +892,35 @@ func upd8(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error("json decode: %v", err)
 
-		inet.SendResponse(w, http.StatusBadRequest, inet.ErrorJSONResponse(err))
+		inet.ErrorJSONResponse(err).Send(w)
 		return
 	}
 
+	// The agent sends the ID of the request that started the job along
+	jobLog := logger.With("request_id", srv.RequestID(r), "id", msg.ID)
+
 	dbe, err := db.FetchByID(msg.ID)
 	if err != nil {
-		logger.Error("FetchById: %v", err)
+		jobLog.Error("FetchById: %v", err)
+
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+		return
+	}
+
+	if !verifyAgent(w, r, dbe.AgentName) {
 		return
 	}
 
+	jobLog.With("agent", dbe.AgentName, "status", msg.StatusID).Debug("status update: %s", msg.Message)
+
 	dbe.Status = msg.StatusID
 
+	// Agents may report how far along they are with the import or export. The
+	// name of the exported dump is kept, so that it can be downloaded later.
+	if dbe.Status == status.ImportInProgress || dbe.Status == status.ExportInProgress || strings.HasPrefix(msg.Message, model.ExportCompleted) {
+		dbe.Message = msg.Message
+	}
+
 	db.Update(&dbe)
 
 	// Delete the dumpfile once import is started or if an error has occurred.
@@ -794,7 +942,7 @@ func upd8(w http.ResponseWriter, r *http.Request) {
 
 		err = sendUserNotifications(dbe.Creator, fmt.Sprintf("Importing %s failed!", dbe.DBName))
 		if err != nil {
-			logger.Error("failed notifying user: %v", err)
+			jobLog.Error("failed notifying user: %v", err)
 		}
 
 		// Update dbentry as well
@@ -803,7 +951,7 @@ func upd8(w http.ResponseWriter, r *http.Request) {
 
 		err = db.Update(&dbe)
 		if err != nil {
-			logger.Error("Update: %v", err)
+			jobLog.Error("Update: %v", err)
 		}
 	}
 
@@ -814,21 +962,23 @@ func upd8(w http.ResponseWriter, r *http.Request) {
 		)
 
 		if msg.Message == "Completed" {
+			dbpass := password(dbe)
+
 			switch dbe.DBVendor {
 			case "mysql":
-				jdbc62x = liferay.MysqlJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbe.DBPass)
-				jdbcDXP = liferay.MysqlJDBCDXP(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbe.DBPass)
+				jdbc62x = liferay.MysqlJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbpass)
+				jdbcDXP = liferay.MysqlJDBCDXP(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbpass)
 			case "mariadb":
-				jdbc62x = liferay.MariaDBJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbe.DBPass)
+				jdbc62x = liferay.MariaDBJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbpass)
 				jdbcDXP = jdbc62x
 			case "postgres":
-				jdbc62x = liferay.PostgreJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbe.DBPass)
+				jdbc62x = liferay.PostgreJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbpass)
 				jdbcDXP = jdbc62x
 			case "oracle":
-				jdbc62x = liferay.OracleJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBSID, dbe.DBUser, dbe.DBPass)
+				jdbc62x = liferay.OracleJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBSID, dbe.DBUser, dbpass)
 				jdbcDXP = jdbc62x
 			case "mssql":
-				jdbc62x = liferay.MSSQLJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbe.DBPass)
+				jdbc62x = liferay.MSSQLJDBC(dbe.DBAddress, dbe.DBPort, dbe.DBName, dbe.DBUser, dbpass)
 				jdbcDXP = jdbc62x
 			}
 
@@ -858,13 +1008,13 @@ func upd8(w http.ResponseWriter, r *http.Request) {
 
 			err = sendUserNotifications(dbe.Creator, fmt.Sprintf("Finished importing %s", dbe.DBName))
 			if err != nil {
-				logger.Error("failed notifying user: %v", err)
+				jobLog.Error("failed notifying user: %v", err)
 			}
 		}
 
-		if strings.HasPrefix(msg.Message, "Export completed:") {
+		if strings.HasPrefix(msg.Message, model.ExportCompleted) {
 			agent, _ := registry.Get(dbe.AgentName)
-			exportDumpFileName := strings.TrimPrefix(msg.Message, "Export completed:")
+			exportDumpFileName := strings.TrimPrefix(msg.Message, model.ExportCompleted)
 
 			mail.Send(dbe.Creator, fmt.Sprintf("[Cloud DB] Exporting %q succeeded", dbe.DBName), fmt.Sprintf(`<h3>Export database successful</h3>
 		
@@ -875,18 +1025,13 @@ func upd8(w http.ResponseWriter, r *http.Request) {
 
 			err = sendUserNotifications(dbe.Creator, fmt.Sprintf("Finished exporting %s", dbe.DBName))
 			if err != nil {
-				logger.Error("failed notifying user: %v", err)
+				jobLog.Error("failed notifying user: %v", err)
 			}
 		}
 	}
 }
 
 func ensureValues(dbname, dbuser, dbpass *string, vendor string) {
-	if vendor == "mssql" {
-		*dbuser = "clouddb"
-		*dbpass = "password"
-	}
-
 	if *dbuser == "" {
 		*dbuser = sutils.RandName()
 	}
@@ -4,12 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
-	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/djavorszky/ddn/common/inet"
@@ -17,6 +15,8 @@ import (
 	"github.com/djavorszky/ddn/server/brwsr"
 	"github.com/djavorszky/ddn/server/database"
 	"github.com/djavorszky/ddn/server/database/mysql"
+	"github.com/djavorszky/ddn/server/database/postgres"
+	"github.com/djavorszky/ddn/server/database/secret"
 	"github.com/djavorszky/ddn/server/database/sqlite"
 	"github.com/djavorszky/ddn/server/mail"
 	"github.com/djavorszky/sutils"
@@ -54,27 +54,51 @@ func main() {
 
 	var err error
 	filename := flag.String("p", "server.conf", "Specify the configuration file's name")
+	genkey := flag.Bool("genkey", false, "Print a new key to encrypt the database passwords with, and exit.")
 	logname := flag.String("l", "std", "Specify the log's filename. By default, logs to the terminal.")
+	logformat := flag.String("f", "text", "Specify the log's format: text, json or logfmt.")
+	logMaxSize := flag.Int64("log-max-size", 0, "Rotate the log file when it grows above this many megabytes. 0 disables it.")
+	logDaily := flag.Bool("log-daily", false, "Rotate the log file at the start of every day.")
+	logCompress := flag.Bool("log-compress", false, "Compress rotated log files with gzip.")
+	logKeep := flag.Int("log-keep", 0, "Number of rotated log files to keep. 0 keeps all of them.")
 
 	flag.Parse()
 
-	if *logname != "std" {
-		if _, err = os.Stat(*logname); err == nil {
-			rotated := fmt.Sprintf("%s.%s", *logname, time.Now().Format("2006-01-02_03:04"))
+	if *genkey {
+		key, err := secret.GenerateKey()
+		if err != nil {
+			logger.Fatal("%v", err)
+		}
 
-			logger.Debug("Rotated logfile to %s", rotated)
+		fmt.Println(key)
+		return
+	}
 
-			os.Rename(*logname, rotated)
-		}
+	format, err := logger.ParseFormat(*logformat)
+	if err != nil {
+		logger.Fatal("%v", err)
+	}
+	logger.SetFormat(format)
 
-		logOut, err := os.OpenFile(*logname, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if *logname != "std" {
+		logOut, err := logger.OpenRotatingFile(*logname, logger.RotateOptions{
+			MaxSize:    *logMaxSize * 1024 * 1024,
+			Daily:      *logDaily,
+			Compress:   *logCompress,
+			MaxBackups: *logKeep,
+		})
 		if err != nil {
-			fmt.Printf("error opening file %s, will continue logging to stderr: %s", *logname, err.Error())
-			logOut = os.Stderr
+			logger.Warn("error opening file %s, will continue logging to stderr: %v", *logname, err)
+		} else {
+			defer logOut.Close()
+
+			log.SetOutput(logOut)
 		}
-		defer logOut.Close()
+	}
 
-		log.SetOutput(logOut)
+	if flag.Arg(0) == "migrate" {
+		runMigrate(*filename, flag.Args()[1:])
+		return
 	}
 
 	loadProperties(*filename)
@@ -85,6 +109,11 @@ func main() {
 
 	config.Print()
 
+	_, err = config.apiV1Sunset()
+	if err != nil {
+		logger.Fatal("api-v1-sunset: %v", err)
+	}
+
 	if config.MountLoc != "" {
 		err = brwsr.Mount(config.MountLoc)
 		if err != nil {
@@ -96,19 +125,14 @@ func main() {
 		}
 	}
 
-	switch config.DBProvider {
-	case "mysql":
-		db = &mysql.DB{
-			Address:  config.DBAddress,
-			Port:     config.DBPort,
-			User:     config.DBUser,
-			Pass:     config.DBPass,
-			Database: config.DBName,
-		}
-	case "sqlite":
-		db = &sqlite.DB{DBLocation: config.DBAddress}
-	default:
-		logger.Fatal("Unknown database provider: %s", config.DBProvider)
+	keyring, err = loadKeyring(config.DBPassKeys)
+	if err != nil {
+		logger.Fatal("dbpass-keys: %v", err)
+	}
+
+	db, err = newBackend(config, keyring)
+	if err != nil {
+		logger.Fatal("%v", err)
 	}
 
 	err = db.ConnectAndPrepare()
@@ -119,6 +143,15 @@ func main() {
 
 	logger.Info("Database connection established")
 
+	migrated, err := migratePasswords(keyring)
+	if err != nil {
+		logger.Fatal("Failed to encrypt the database passwords: %v", err)
+	}
+
+	if migrated > 0 {
+		logger.Info("Encrypted the passwords of %d databases with the current key", migrated)
+	}
+
 	if config.SMTPAddr != "" {
 		if config.SMTPUser != "" {
 			err = mail.Init(config.SMTPAddr, config.SMTPPort, config.SMTPUser, config.SMTPPass, config.EmailSender)
@@ -142,17 +175,31 @@ func main() {
 		}
 	}
 
+	err = inet.UseTLS(config.TLS())
+	if err != nil {
+		logger.Fatal("tls: %v", err)
+	}
+
+	// Start announcing the server to agents that have no server-address
+	if !config.DiscoveryDisabled {
+		go announce()
+	}
+
 	// Start maintenance goroutine
 	go maintain()
 
 	// Start agent checker goroutine
 	go checkAgents()
 
+	// Start goroutine comparing the agents' databases with ours
+	go reconcileAgents()
+
 	logger.Info("Starting to listen on port %s", config.ServerPort)
 
 	port := fmt.Sprintf(":%s", config.ServerPort)
 
-	logger.Error("%v", http.ListenAndServe(port, Router()))
+	// Browsers don't have client certificates, so they are only checked on the agents' endpoints
+	logger.Error("%v", inet.ListenAndServe(port, Router(), config.TLS(), false))
 
 	if len(config.AdminEmail) != 0 {
 		for _, addr := range config.AdminEmail {
@@ -180,3 +227,32 @@ func loadProperties(filename string) {
 	}
 
 }
+
+// newBackend returns the database configured in c, without connecting to it.
+func newBackend(c Config, keys *secret.Keyring) (database.BackendConnection, error) {
+	switch c.DBProvider {
+	case "mysql":
+		return &mysql.DB{
+			Address:  c.DBAddress,
+			Port:     c.DBPort,
+			User:     c.DBUser,
+			Pass:     c.DBPass,
+			Database: c.DBName,
+			Keys:     keys,
+		}, nil
+	case "postgres":
+		return &postgres.DB{
+			Address:  c.DBAddress,
+			Port:     c.DBPort,
+			User:     c.DBUser,
+			Pass:     c.DBPass,
+			Database: c.DBName,
+			SSLMode:  c.DBSSLMode,
+			Keys:     keys,
+		}, nil
+	case "sqlite":
+		return &sqlite.DB{DBLocation: c.DBAddress, Keys: keys}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown database provider: %s", c.DBProvider)
+}
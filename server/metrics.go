@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/djavorszky/ddn/common/logger"
+	"github.com/djavorszky/ddn/common/status"
+	"github.com/djavorszky/ddn/server/database/data"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	agentUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ddn",
+		Subsystem: "server",
+		Name:      "agent_up",
+		Help:      "Whether the agent answers its heartbeat (1) or not (0).",
+	}, []string{"agent"})
+
+	jobsInProgress = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "ddn",
+		Subsystem: "server",
+		Name:      "jobs_in_progress",
+		Help:      "Number of databases being downloaded, imported or exported by the agents.",
+	}, countInProgress)
+
+	deprecatedCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ddn",
+		Subsystem: "server",
+		Name:      "deprecated_calls_total",
+		Help:      "Number of calls to the deprecated v1 API, by route.",
+	}, []string{"route"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ddn",
+		Subsystem: "server",
+		Name:      "rate_limited_total",
+		Help:      "Number of requests rejected for going over the rate limits, by route group.",
+	}, []string{"group"})
+)
+
+func init() {
+	prometheus.MustRegister(agentUp, jobsInProgress, deprecatedCalls, rateLimited)
+}
+
+// setAgentUp records whether the agent is up.
+func setAgentUp(shortName string, up bool) {
+	var v float64
+	if up {
+		v = 1
+	}
+
+	agentUp.WithLabelValues(shortName).Set(v)
+}
+
+// countInProgress returns the number of rows with something in progress. It
+// is called whenever the metrics are scraped, so the rows are only counted.
+func countInProgress() float64 {
+	if db == nil {
+		return 0
+	}
+
+	count, err := db.CountRows(data.RowFilter{Status: inProgressStatuses()})
+	if err != nil {
+		logger.Error("metrics: counting databases failed: %v", err)
+		return 0
+	}
+
+	return float64(count)
+}
+
+// inProgressStatuses returns the status codes meaning that something is in progress.
+func inProgressStatuses() []int {
+	var codes []int
+	for code := range status.Labels {
+		if (data.Row{Status: code}).InProgress() {
+			codes = append(codes, code)
+		}
+	}
+
+	sort.Ints(codes)
+
+	return codes
+}
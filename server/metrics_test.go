@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/djavorszky/ddn/common/status"
+	"github.com/djavorszky/ddn/server/database/data"
+)
+
+func TestCountInProgress(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	for i, code := range []int{status.Started, status.ImportInProgress, status.Success, status.ImportFailed} {
+		row := data.Row{DBName: string('a' + rune(i)), AgentName: "mysql-57", Status: code}
+
+		err := db.Insert(&row)
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	if got := countInProgress(); got != 2 {
+		t.Errorf("countInProgress() = %v, want 2", got)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/djavorszky/ddn/common/logger"
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/server/database"
+	"github.com/djavorszky/ddn/server/database/data"
+	"github.com/djavorszky/ddn/server/database/dbutil"
+	webpush "github.com/sherclockholmes/webpush-go"
+)
+
+// migrateReport holds how much was copied from one backend to the other.
+type migrateReport struct {
+	Rows          int
+	Subscriptions int
+	Audit         int
+}
+
+// runMigrate copies everything from the database configured in the server's
+// configuration file to the one configured in the file given with -to, e.g.
+//
+//	ddns -p server.conf migrate -to mysql.conf
+//
+// Only the db-* properties of the target's file are used.
+func runMigrate(filename string, args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	target := flags.String("to", "", "Specify the configuration file of the database to copy to.")
+	force := flags.Bool("force", false, "Copy even if the target already has data. Rows with the same ID or name are overwritten.")
+
+	flags.Parse(args)
+
+	if *target == "" {
+		fmt.Fprintln(os.Stderr, "Usage: ddns [-p server.conf] migrate -to target.conf [-force]")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var fromConf, toConf Config
+
+	if _, err := toml.DecodeFile(filename, &fromConf); err != nil {
+		logger.Fatal("couldn't read configuration file: %v", err)
+	}
+
+	if _, err := toml.DecodeFile(*target, &toConf); err != nil {
+		logger.Fatal("couldn't read configuration file of the target: %v", err)
+	}
+
+	keys, err := loadKeyring(fromConf.DBPassKeys)
+	if err != nil {
+		logger.Fatal("dbpass-keys: %v", err)
+	}
+
+	from, err := newBackend(fromConf, keys)
+	if err != nil {
+		logger.Fatal("source: %v", err)
+	}
+
+	to, err := newBackend(toConf, keys)
+	if err != nil {
+		logger.Fatal("target: %v", err)
+	}
+
+	// Connecting brings the tables of both up to the latest version
+	err = from.ConnectAndPrepare()
+	if err != nil {
+		logger.Fatal("Failed to connect to the source database: %v", err)
+	}
+	defer from.Close()
+
+	err = to.ConnectAndPrepare()
+	if err != nil {
+		logger.Fatal("Failed to connect to the target database: %v", err)
+	}
+	defer to.Close()
+
+	logger.Info("Copying from %s to %s", fromConf.DBProvider, toConf.DBProvider)
+
+	report, err := migrateBackend(from, to, *force)
+	if err != nil {
+		logger.Error("Migration failed: %v", err)
+		return
+	}
+
+	logger.Info("Copied and verified %d databases, %d push subscriptions and %d audit entries", report.Rows, report.Subscriptions, report.Audit)
+}
+
+// migrateBackend copies the rows, the push subscriptions and the audit log
+// from one backend to the other, and verifies that the target holds the same
+// data afterwards. Rows keep their IDs. Unless forced, it refuses to copy to
+// a target that already has data; if forced, the audit entries the target
+// already has, e.g. from an earlier run, are not copied again. The version of
+// the tables is not copied: both backends are brought up to date by their own
+// migrations when connecting.
+func migrateBackend(from, to database.BackendConnection, force bool) (migrateReport, error) {
+	var report migrateReport
+
+	rows, err := from.FetchAll()
+	if err != nil {
+		return report, fmt.Errorf("listing databases failed: %v", err)
+	}
+
+	subscriptions, err := from.FetchAllPushSubscriptions()
+	if err != nil {
+		return report, fmt.Errorf("listing push subscriptions failed: %v", err)
+	}
+
+	audit, err := from.FetchAudit(data.AuditFilter{})
+	if err != nil {
+		return report, fmt.Errorf("listing the audit log failed: %v", err)
+	}
+
+	// The entries of the target's audit log, which is empty unless forced
+	existing := make(auditIndex)
+
+	if !force {
+		err = checkEmpty(to)
+		if err != nil {
+			return report, err
+		}
+	} else {
+		targetAudit, err := to.FetchAudit(data.AuditFilter{})
+		if err != nil {
+			return report, fmt.Errorf("listing the audit log of the target failed: %v", err)
+		}
+
+		existing = newAuditIndex(targetAudit)
+	}
+
+	// Rows and audit entries are listed newest first, they are copied oldest first
+	for i := len(rows) - 1; i >= 0; i-- {
+		row := rows[i]
+
+		if force {
+			err = clearConflicts(to, row)
+			if err != nil {
+				return report, err
+			}
+		}
+
+		err = to.Restore(row)
+		if err != nil {
+			return report, fmt.Errorf("copying database %d failed: %v", row.ID, err)
+		}
+
+		report.Rows++
+	}
+
+	for subscriber, subs := range subscriptions {
+		for _, s := range subs {
+			sub := &model.PushSubscription{Endpoint: s.Endpoint, Keys: s.Keys}
+
+			if force {
+				to.DeletePushSubscription(sub, subscriber)
+			}
+
+			err = to.InsertPushSubscription(sub, subscriber)
+			if err != nil {
+				return report, fmt.Errorf("copying push subscription of %s failed: %v", subscriber, err)
+			}
+
+			report.Subscriptions++
+		}
+	}
+
+	for i := len(audit) - 1; i >= 0; i-- {
+		entry := audit[i]
+
+		if existing.take(entry) {
+			continue
+		}
+
+		err = to.InsertAudit(&entry)
+		if err != nil {
+			return report, fmt.Errorf("copying audit entry %d failed: %v", audit[i].ID, err)
+		}
+
+		report.Audit++
+	}
+
+	err = verifyMigration(to, rows, subscriptions, audit)
+	if err != nil {
+		return report, fmt.Errorf("verification failed: %v", err)
+	}
+
+	return report, nil
+}
+
+// checkEmpty returns an error if the backend has any data.
+func checkEmpty(to database.BackendConnection) error {
+	rows, err := to.FetchAll()
+	if err != nil {
+		return fmt.Errorf("listing databases of the target failed: %v", err)
+	}
+
+	subscriptions, err := to.FetchAllPushSubscriptions()
+	if err != nil {
+		return fmt.Errorf("listing push subscriptions of the target failed: %v", err)
+	}
+
+	audit, err := to.FetchAudit(data.AuditFilter{})
+	if err != nil {
+		return fmt.Errorf("listing the audit log of the target failed: %v", err)
+	}
+
+	if len(rows) > 0 || len(subscriptions) > 0 || len(audit) > 0 {
+		return fmt.Errorf("target is not empty: it has %d databases, push subscriptions of %d users and %d audit entries; use -force to copy anyway", len(rows), len(subscriptions), len(audit))
+	}
+
+	return nil
+}
+
+// clearConflicts removes the rows of the target that have the same ID, or the
+// same name on the same agent as the row about to be copied.
+func clearConflicts(to database.BackendConnection, row data.Row) error {
+	existing, err := to.FetchByID(row.ID)
+	if err == nil && existing.ID != 0 {
+		err = to.Delete(existing)
+		if err != nil {
+			return fmt.Errorf("removing database %d from the target failed: %v", existing.ID, err)
+		}
+	}
+
+	existing, err = to.FetchByDBNameAgent(row.DBName, row.AgentName)
+	if err == nil && existing.ID != 0 {
+		err = to.Delete(existing)
+		if err != nil {
+			return fmt.Errorf("removing database %d from the target failed: %v", existing.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// verifyMigration reads back everything that was copied, and returns an error
+// describing the first difference.
+func verifyMigration(to database.BackendConnection, rows []data.Row, subscriptions map[string][]webpush.Subscription, audit []data.AuditEntry) error {
+	for _, row := range rows {
+		copied, err := to.FetchByID(row.ID)
+		if err != nil {
+			return fmt.Errorf("reading database %d failed: %v", row.ID, err)
+		}
+
+		err = dbutil.CompareRows(row, copied)
+		if err != nil {
+			return fmt.Errorf("database %d: %v", row.ID, err)
+		}
+	}
+
+	copiedSubs, err := to.FetchAllPushSubscriptions()
+	if err != nil {
+		return fmt.Errorf("listing push subscriptions failed: %v", err)
+	}
+
+	for subscriber, subs := range subscriptions {
+		copied := make(map[string]bool)
+		for _, s := range copiedSubs[subscriber] {
+			copied[s.Endpoint+"|"+s.Keys.P256dh+"|"+s.Keys.Auth] = true
+		}
+
+		for _, s := range subs {
+			if !copied[s.Endpoint+"|"+s.Keys.P256dh+"|"+s.Keys.Auth] {
+				return fmt.Errorf("push subscription of %s to %q is missing", subscriber, s.Endpoint)
+			}
+		}
+	}
+
+	if len(audit) == 0 {
+		return nil
+	}
+
+	copiedAudit, err := to.FetchAudit(data.AuditFilter{})
+	if err != nil {
+		return fmt.Errorf("listing the audit log failed: %v", err)
+	}
+
+	copied := newAuditIndex(copiedAudit)
+
+	for _, entry := range audit {
+		if !copied.take(entry) {
+			return fmt.Errorf("audit entry %d is missing: %+v", entry.ID, entry)
+		}
+	}
+
+	return nil
+}
+
+// auditIndex finds audit entries by everything but their IDs and times, and
+// holds the times of the entries found that way.
+type auditIndex map[data.AuditEntry][]time.Time
+
+func newAuditIndex(entries []data.AuditEntry) auditIndex {
+	idx := make(auditIndex)
+
+	for _, entry := range entries {
+		key := auditKey(entry)
+		idx[key] = append(idx[key], entry.Time)
+	}
+
+	return idx
+}
+
+// take removes the entry from the index and returns true if it's in there.
+// The times of the entries only have to be within a second, as backends store
+// them with different precision.
+func (idx auditIndex) take(entry data.AuditEntry) bool {
+	key := auditKey(entry)
+
+	for i, t := range idx[key] {
+		delta := entry.Time.Sub(t)
+		if delta < -1*time.Second || delta > 1*time.Second {
+			continue
+		}
+
+		idx[key] = append(idx[key][:i], idx[key][i+1:]...)
+		return true
+	}
+
+	return false
+}
+
+func auditKey(entry data.AuditEntry) data.AuditEntry {
+	entry.ID, entry.Time = 0, time.Time{}
+	return entry
+}
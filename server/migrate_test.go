@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/server/database/data"
+	"github.com/djavorszky/ddn/server/database/sqlite"
+	webpush "github.com/sherclockholmes/webpush-go"
+)
+
+func openTestBackend(t *testing.T, dir, name string) *sqlite.DB {
+	backend := &sqlite.DB{DBLocation: filepath.Join(dir, name)}
+
+	err := backend.ConnectAndPrepare()
+	if err != nil {
+		t.Fatalf("ConnectAndPrepare failed: %v", err)
+	}
+
+	return backend
+}
+
+func TestMigrateBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddn-migrate")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	from := openTestBackend(t, dir, "from.db")
+	defer from.Close()
+
+	for _, name := range []string{"first", "second", "third"} {
+		row := data.Row{
+			DBName:     name,
+			DBUser:     name,
+			DBPass:     "enc:k1:" + name,
+			CreateDate: time.Now(),
+			ExpiryDate: time.Now().AddDate(0, 1, 0),
+			Creator:    "alice@example.com",
+			AgentName:  "mysql-57",
+			DBVendor:   "mysql",
+			Status:     100,
+			Comment:    "comment of " + name,
+		}
+
+		if err := from.Insert(&row); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+
+		// Leave a gap in the IDs, they have to be kept anyway
+		if name == "second" {
+			from.Delete(row)
+		}
+	}
+
+	from.InsertPushSubscription(&model.PushSubscription{Endpoint: "endpoint", Keys: webpush.Keys{P256dh: "key", Auth: "auth"}}, "alice@example.com")
+	from.InsertAudit(&data.AuditEntry{Time: time.Now(), Actor: "alice@example.com", Action: "create", DatabaseID: 1, Target: "mysql-57/first", Params: "{}", Outcome: data.AuditSuccess})
+	from.InsertAudit(&data.AuditEntry{Time: time.Now(), Actor: "alice@example.com", Action: "drop", DatabaseID: 2, Target: "mysql-57/second", Params: "{}", Outcome: data.AuditSuccess})
+
+	to := openTestBackend(t, dir, "to.db")
+	defer to.Close()
+
+	report, err := migrateBackend(from, to, false)
+	if err != nil {
+		t.Fatalf("migrateBackend failed: %v", err)
+	}
+
+	if report != (migrateReport{Rows: 2, Subscriptions: 1, Audit: 2}) {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	third, _ := to.FetchByID(3)
+	if third.DBName != "third" || third.DBPass != "enc:k1:third" {
+		t.Errorf("expected the third database to keep its ID and password, got %+v", third)
+	}
+
+	audit, _ := to.FetchAudit(data.AuditFilter{})
+	if len(audit) != 2 || audit[0].Action != "drop" || audit[1].Action != "create" {
+		t.Errorf("expected the audit log in the same order, got %+v", audit)
+	}
+
+	// The target has data now
+	_, err = migrateBackend(from, to, false)
+	if err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Errorf("expected copying to a non-empty target to fail, got %v", err)
+	}
+
+	// Forcing it overwrites the rows that are in the way
+	changed, _ := to.FetchByID(1)
+	changed.Comment = "changed on the target"
+	to.Update(&changed)
+
+	report, err = migrateBackend(from, to, true)
+	if err != nil {
+		t.Fatalf("forced migrateBackend failed: %v", err)
+	}
+
+	if report.Rows != 2 {
+		t.Errorf("expected 2 rows copied, got %d", report.Rows)
+	}
+
+	first, _ := to.FetchByID(1)
+	if first.Comment != "comment of first" {
+		t.Errorf("expected the row to be overwritten, got comment %q", first.Comment)
+	}
+
+	all, _ := to.FetchAll()
+	if len(all) != 2 {
+		t.Errorf("expected 2 rows on the target, got %d", len(all))
+	}
+
+	// Entries already on the target are not copied again, only new ones
+	from.InsertAudit(&data.AuditEntry{Time: time.Now(), Actor: "bob@example.com", Action: "create", DatabaseID: 3, Target: "mysql-57/third", Params: "{}", Outcome: data.AuditSuccess})
+
+	report, err = migrateBackend(from, to, true)
+	if err != nil {
+		t.Fatalf("forced migrateBackend failed: %v", err)
+	}
+
+	audit, _ = to.FetchAudit(data.AuditFilter{})
+	if report.Audit != 1 || len(audit) != 3 || audit[0].Actor != "bob@example.com" {
+		t.Errorf("expected only the new audit entry to be copied, got %d copied and %+v", report.Audit, audit)
+	}
+}
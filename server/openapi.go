@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn/common/inet"
+	"github.com/djavorszky/ddn/common/logger"
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/server/brwsr"
+	"github.com/djavorszky/ddn/server/database/data"
+)
+
+// apiParam describes a query parameter of an API call.
+type apiParam struct {
+	Name        string
+	Type        string // string, integer or boolean
+	Description string
+}
+
+// apiOperation describes one of the v2Routes in the OpenAPI document. The path
+// parameters are taken from the pattern of the route, the schemas of the payload
+// and the returned data from the types of Request and Response.
+type apiOperation struct {
+	Summary string
+	Query   []apiParam
+
+	// Request is a value of the type sent as JSON payload, nil if there's none.
+	// Response is a value of the type returned in "data" on success.
+	Request  interface{}
+	Response interface{}
+
+	// Upload is the name of the field holding the file if the payload is a
+	// multipart form.
+	Upload string
+
+	// Status is the status of a successful call, 200 if left empty.
+	Status int
+
+	// Paged is true if the call returns a cursor to the next page in "next",
+	// Unwrapped if Response is returned as it is, not within "data".
+	Paged     bool
+	Unwrapped bool
+
+	// Admin is true if only the users in admin-emails may call it, Anonymous
+	// if the Authorization header isn't needed at all.
+	Admin     bool
+	Anonymous bool
+}
+
+// apiOperations describes the routes of the v2 API, keyed by the method and the
+// pattern of the route, e.g. "GET /api/databases/{id:[0-9]+}".
+var apiOperations = map[string]apiOperation{
+	"GET /api/openapi.json": {
+		Summary:   "Returns this document",
+		Response:  map[string]interface{}{},
+		Unwrapped: true,
+		Anonymous: true,
+	},
+	"GET /api/agents": {
+		Summary:  "Lists all agents",
+		Response: []model.Agent{},
+	},
+	"GET /api/agents/active": {
+		Summary:  "Lists the agents that are up",
+		Response: []model.Agent{},
+	},
+	"GET /api/agents/{agent:[a-zA-Z0-9-_]+}": {
+		Summary:  "Returns an agent by its short name",
+		Response: model.Agent{},
+	},
+	"GET /api/databases": {
+		Summary: "Lists the databases visible to the user",
+		Query: []apiParam{
+			{"vendor", "string", "Only databases of this vendor"},
+			{"agent", "string", "Only databases on this agent"},
+			{"creator", "string", "Only databases created by this user"},
+			{"name", "string", "Only databases whose name contains this"},
+			{"status", "string", "Comma separated list of status codes"},
+			{"created_from", "string", "Created at or after, in RFC3339 format"},
+			{"created_to", "string", "Created before, in RFC3339 format"},
+			{"expires_from", "string", "Expiring at or after, in RFC3339 format"},
+			{"expires_to", "string", "Expiring before, in RFC3339 format"},
+			{"sort", "string", "Field to sort by, descending if prefixed with -; -id by default"},
+			{"limit", "integer", "Number of databases per page, all of them if missing"},
+			{"cursor", "string", "The next field of the previous page"},
+		},
+		Response: []data.Row{},
+		Paged:    true,
+	},
+	"GET /api/databases/{id:[0-9]+}": {
+		Summary:  "Returns a database by its id",
+		Response: data.Row{},
+	},
+	"GET /api/databases/{agent:[a-zA-Z][a-zA-Z0-9-_]+}/{dbname:[a-zA-Z0-9_]+}": {
+		Summary:  "Returns a database by its agent and name",
+		Response: data.Row{},
+	},
+	"DELETE /api/databases/{id:[0-9]+}": {
+		Summary:  "Starts dropping a database",
+		Response: "",
+	},
+	"POST /api/databases/create": {
+		Summary:  "Creates an empty database",
+		Request:  model.ClientRequest{},
+		Response: databaseResult{},
+	},
+	"POST /api/databases/import": {
+		Summary:  "Starts importing a dump into a new database",
+		Request:  model.ClientRequest{},
+		Response: databaseResult{},
+		Status:   http.StatusAccepted,
+	},
+	"POST /api/dumps": {
+		Summary:  "Uploads a dump, and returns the dumpfile_location to import it from",
+		Upload:   "dumpfile",
+		Response: "",
+		Status:   http.StatusCreated,
+	},
+	"PUT /api/databases/{id:[0-9]+}/recreate": {
+		Summary:  "Drops and creates a database again",
+		Response: data.Row{},
+	},
+	"PUT /api/databases/{id:[0-9]+}/export": {
+		Summary:  "Starts exporting a database",
+		Response: "",
+	},
+	"GET /api/browse": {
+		Summary:  "Lists the root of the mounted folder",
+		Response: brwsr.FileList{},
+	},
+	"GET /api/browse/{loc:[0-9a-zA-Z-_./ ]+}": {
+		Summary:  "Lists a folder within the mounted folder",
+		Response: brwsr.FileList{},
+	},
+	"PUT /api/databases/{id:[0-9]+}/visibility/{visibility:public|private}": {
+		Summary:  "Changes the visibility of a database",
+		Response: "",
+	},
+	"PUT /api/databases/{id:[0-9]+}/expiry/extend/{amount:[0-9]+}/{unit:days|months|years}": {
+		Summary:  "Extends the expiry of a database, and returns the new one",
+		Response: time.Time{},
+	},
+	"GET /api/databases/{id:[0-9]+}/accessinfo": {
+		Summary:  "Returns the connection details of a database by its id",
+		Response: dbAccess{},
+	},
+	"GET /api/databases/{agent:[a-zA-Z][a-zA-Z0-9-_]+}/{dbname:[a-zA-Z0-9-_]+}/accessinfo": {
+		Summary:  "Returns the connection details of a database by its agent and name",
+		Response: dbAccess{},
+	},
+	"GET /api/admin/reconcile": {
+		Summary:  "Returns the latest reconciliation report",
+		Response: reconcileReport{},
+		Admin:    true,
+	},
+	"POST /api/admin/reconcile": {
+		Summary: "Runs the reconciliation right away",
+		Query: []apiParam{
+			{"adopt", "boolean", "Add the untracked databases as entries owned by the first admin"},
+			{"cleanup", "boolean", "Remove the orphaned entries"},
+		},
+		Response: reconcileReport{},
+		Admin:    true,
+	},
+	"GET /api/admin/audit": {
+		Summary: "Returns the audit log, newest first",
+		Query: []apiParam{
+			{"actor", "string", "Only the actions of this user"},
+			{"action", "string", "Only this action, e.g. drop"},
+			{"outcome", "string", "One of success, failure or denied"},
+			{"database", "integer", "Only the actions on the database with this id"},
+			{"from", "string", "Done at or after, in RFC3339 format"},
+			{"to", "string", "Done before, in RFC3339 format"},
+			{"limit", "integer", "Number of entries, 500 by default, 0 for all"},
+			{"format", "string", "json or csv"},
+		},
+		Response: []data.AuditEntry{},
+		Admin:    true,
+	},
+	"PUT /api/loglevel/{level:[a-zA-Z]+}": {
+		Summary:  "Changes the loglevel of the server",
+		Response: "",
+	},
+}
+
+// specRoutes are the routes getAPISpec describes. They are set in init, as
+// apiRoutes can't refer to getAPISpec otherwise.
+var specRoutes Routes
+
+func init() {
+	specRoutes = v2Routes()
+}
+
+// getAPISpec serves the OpenAPI document of the v2 API.
+func getAPISpec(w http.ResponseWriter, r *http.Request) {
+	doc := openAPIDocument(specRoutes, apiOperations)
+
+	inet.WriteHeader(w, http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(doc)
+	if err != nil {
+		logger.Error("failed writing the OpenAPI document: %v", err)
+	}
+}
+
+// openAPIDocument builds an OpenAPI 3 document of the routes. Routes that
+// have no operation described are left out.
+func openAPIDocument(routes Routes, operations map[string]apiOperation) map[string]interface{} {
+	schemas := make(map[string]interface{})
+
+	// The fields added by Failure.Marshal are part of it as well
+	schemas["Failure"] = schemaOf(reflect.TypeOf(struct {
+		inet.Failure
+		Success bool     `json:"success"`
+		Error   []string `json:"error"`
+	}{}), schemas)
+
+	paths := make(map[string]interface{})
+	for _, route := range routes {
+		op, ok := operations[route.Method+" "+route.Pattern]
+		if !ok {
+			continue
+		}
+
+		path, params := openAPIPath(route.Pattern)
+
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+
+		item[strings.ToLower(route.Method)] = openAPIOperation(op, params, schemas)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "ddn",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"email": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        "Authorization",
+					"description": "The email address of the user",
+				},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"email": []string{}},
+		},
+	}
+}
+
+// openAPIOperation describes a single call, adding the schemas it refers to.
+func openAPIOperation(op apiOperation, params []interface{}, schemas map[string]interface{}) map[string]interface{} {
+	for _, p := range op.Query {
+		params = append(params, map[string]interface{}{
+			"name":        p.Name,
+			"in":          "query",
+			"description": p.Description,
+			"schema":      map[string]interface{}{"type": p.Type},
+		})
+	}
+
+	success := schemaOf(reflect.TypeOf(op.Response), schemas)
+	if !op.Unwrapped {
+		properties := map[string]interface{}{
+			"success": map[string]interface{}{"type": "boolean"},
+			"data":    success,
+		}
+
+		if op.Paged {
+			properties["next"] = map[string]interface{}{
+				"type":        "string",
+				"description": "Cursor of the next page, missing on the last one",
+			}
+		}
+
+		success = map[string]interface{}{"type": "object", "properties": properties}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	result := map[string]interface{}{
+		"summary":    op.Summary,
+		"parameters": params,
+		"responses": map[string]interface{}{
+			strconv.Itoa(status): jsonContent("Success", success),
+			"default": jsonContent("Failure", map[string]interface{}{
+				"$ref": "#/components/schemas/Failure",
+			}),
+		},
+	}
+
+	if op.Request != nil {
+		body := jsonContent("", schemaOf(reflect.TypeOf(op.Request), schemas))
+		delete(body, "description")
+		body["required"] = true
+
+		result["requestBody"] = body
+	}
+
+	if op.Upload != "" {
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"multipart/form-data": map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							op.Upload: map[string]interface{}{"type": "string", "format": "binary"},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	if op.Admin {
+		result["description"] = "Only available to users listed in admin-emails."
+	}
+
+	if op.Anonymous {
+		result["security"] = []interface{}{}
+	}
+
+	return result
+}
+
+// jsonContent returns a response or request body of JSON with the schema.
+func jsonContent(description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// openAPIPath converts the pattern of a route to an OpenAPI path, and returns
+// the parameters found in it, e.g. /api/databases/{id:[0-9]+} becomes
+// /api/databases/{id} with an integer id parameter.
+func openAPIPath(pattern string) (string, []interface{}) {
+	var (
+		path   = pattern
+		params = make([]interface{}, 0)
+	)
+
+	for {
+		start := strings.Index(path, "{")
+		end := strings.Index(path, "}")
+		if start == -1 || end < start {
+			break
+		}
+
+		name, regex := path[start+1:end], ""
+		if i := strings.Index(name, ":"); i != -1 {
+			name, regex = name[:i], name[i+1:]
+		}
+
+		schema := map[string]interface{}{"type": "string"}
+		switch {
+		case regex == "[0-9]+":
+			schema["type"] = "integer"
+		case regex != "":
+			schema["pattern"] = "^(" + regex + ")$"
+		}
+
+		params = append(params, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   schema,
+		})
+
+		path = path[:start] + "\x00" + name + "\x01" + path[end+1:]
+	}
+
+	path = strings.NewReplacer("\x00", "{", "\x01", "}").Replace(path)
+
+	return path, params
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the JSON schema of the type, the way encoding/json would
+// marshal it. Named structs are added to schemas and referred to.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+
+		schema := func() map[string]interface{} {
+			properties := make(map[string]interface{})
+			addProperties(t, properties, schemas)
+
+			return map[string]interface{}{"type": "object", "properties": properties}
+		}
+
+		if t.Name() == "" {
+			return schema()
+		}
+
+		if _, ok := schemas[t.Name()]; !ok {
+			// Added before the properties so that recursive types end
+			schemas[t.Name()] = nil
+			schemas[t.Name()] = schema()
+		}
+
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	// Interfaces can hold anything
+	return map[string]interface{}{}
+}
+
+// addProperties adds the exported fields of the struct to the properties, using
+// their names in JSON. The fields of embedded structs are added as if they were
+// the struct's own.
+func addProperties(t reflect.Type, properties, schemas map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.TrimSpace(strings.Split(tag, ",")[0])
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addProperties(field.Type, properties, schemas)
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = schemaOf(field.Type, schemas)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAPIOperations(t *testing.T) {
+	described := make(map[string]bool)
+
+	for _, route := range v2Routes() {
+		key := route.Method + " " + route.Pattern
+
+		op, ok := apiOperations[key]
+		if !ok {
+			t.Errorf("route %q (%s) is not described in apiOperations", key, route.Name)
+			continue
+		}
+
+		if op.Summary == "" {
+			t.Errorf("route %q has no summary", key)
+		}
+
+		described[key] = true
+	}
+
+	for key := range apiOperations {
+		if !described[key] {
+			t.Errorf("%q is described in apiOperations, but there's no such route", key)
+		}
+	}
+}
+
+func TestGetAPISpec(t *testing.T) {
+	w := httptest.NewRecorder()
+	getAPISpec(w, httptest.NewRequest("GET", "/api/openapi.json", nil))
+
+	var doc struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+
+	body := w.Body.String()
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatalf("couldn't decode document: %v", err)
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("expected an OpenAPI 3 document, got %q", doc.OpenAPI)
+	}
+
+	ops := 0
+	for _, item := range doc.Paths {
+		ops += len(item)
+	}
+
+	if ops != len(v2Routes()) {
+		t.Errorf("expected %d operations, got %d", len(v2Routes()), ops)
+	}
+
+	if _, ok := doc.Paths["/api/databases/{id}/expiry/extend/{amount}/{unit}"]["put"]; !ok {
+		t.Errorf("expected the path parameters to be converted, got paths %v", reflect.ValueOf(doc.Paths).MapKeys())
+	}
+
+	if _, ok := doc.Paths["/api/databases/create"]["post"]["requestBody"]; !ok {
+		t.Errorf("expected creating a database to have a request body")
+	}
+
+	// Every referred schema has to be in the document
+	for _, ref := range strings.Split(body, `"$ref":"#/components/schemas/`)[1:] {
+		name := ref[:strings.Index(ref, `"`)]
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("schema %q is referred to, but missing", name)
+		}
+	}
+
+	tests := []struct {
+		schema     string
+		properties []string
+	}{
+		{"Row", []string{"id", "dbname", "expirydate", "status_label", "public"}},
+		{"ClientRequest", []string{"agent_identifier", "vendor", "database_name", "dumpfile_location"}},
+		{"databaseResult", []string{"id", "dbname", "agent_info"}},
+		{"dbAccess", []string{"jdbc_url", "user", "password", "database"}},
+		{"Failure", []string{"success", "error", "http_status", "status", "message", "details"}},
+	}
+
+	for _, tt := range tests {
+		schema, ok := doc.Components.Schemas[tt.schema]
+		if !ok {
+			t.Errorf("expected schema %q", tt.schema)
+			continue
+		}
+
+		for _, prop := range tt.properties {
+			if _, ok := schema.Properties[prop]; !ok {
+				t.Errorf("expected %q to have property %q, got %v", tt.schema, prop, schema.Properties)
+			}
+		}
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	path, params := openAPIPath("/api/databases/{id:[0-9]+}/visibility/{visibility:public|private}")
+
+	if path != "/api/databases/{id}/visibility/{visibility}" {
+		t.Errorf("unexpected path %q", path)
+	}
+
+	want := []interface{}{
+		map[string]interface{}{
+			"name":     "id",
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "integer"},
+		},
+		map[string]interface{}{
+			"name":     "visibility",
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string", "pattern": "^(public|private)$"},
+		},
+	}
+
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("expected %v, got %v", want, params)
+	}
+}
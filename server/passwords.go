@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/djavorszky/ddn/common/logger"
+	"github.com/djavorszky/ddn/server/database/data"
+	"github.com/djavorszky/ddn/server/database/secret"
+)
+
+// keyring encrypts and decrypts the passwords of the databases. It is nil if
+// no keys are configured, in which case passwords are stored in plaintext.
+var keyring *secret.Keyring
+
+// loadKeyring returns the keyring built from the "dbpass-keys" configuration,
+// or nil if there are no keys. Each key is in the form of "id:base64-key". The
+// first one is used to encrypt the passwords, the rest are only used to
+// decrypt the ones that were encrypted before the key was rotated.
+func loadKeyring(entries []string) (*secret.Keyring, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	var current string
+
+	keys := make(map[string]string, len(entries))
+	for i, entry := range entries {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("key #%d is not in the form of id:key", i+1)
+		}
+
+		if _, ok := keys[parts[0]]; ok {
+			return nil, fmt.Errorf("key id %q is used more than once", parts[0])
+		}
+
+		if i == 0 {
+			current = parts[0]
+		}
+
+		keys[parts[0]] = parts[1]
+	}
+
+	return secret.NewKeyring(keys, current)
+}
+
+// migratePasswords encrypts the passwords that are stored in plaintext or with
+// an older key with the current one, and returns the number of rows updated.
+func migratePasswords(keys *secret.Keyring) (int, error) {
+	if keys == nil {
+		return 0, nil
+	}
+
+	rows, err := db.FetchAll()
+	if err != nil {
+		return 0, fmt.Errorf("listing databases failed: %v", err)
+	}
+
+	var migrated int
+	for _, row := range rows {
+		if !keys.NeedsRotation(row.DBPass) {
+			continue
+		}
+
+		row.DBPass, err = keys.Rotate(row.DBPass)
+		if err != nil {
+			logger.Error("migrating password of database %d failed: %v", row.ID, err)
+			continue
+		}
+
+		err = db.Update(&row)
+		if err != nil {
+			return migrated, fmt.Errorf("updating database %d failed: %v", row.ID, err)
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// checkPassword returns an error if the password chosen by a user can't be
+// stored, as it would be mistaken for an encrypted one.
+func checkPassword(pass string) error {
+	if secret.IsEncrypted(pass) {
+		return fmt.Errorf("password must not start with %q", "enc:")
+	}
+
+	return nil
+}
+
+// password returns the plaintext password of the row. It should only be used
+// when sending it to the agent, or to a user who has access to the database.
+func password(row data.Row) string {
+	pass, err := keyring.Decrypt(row.DBPass)
+	if err != nil {
+		logger.Error("decrypting password of database %d failed: %v", row.ID, err)
+		return ""
+	}
+
+	return pass
+}
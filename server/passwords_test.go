@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/djavorszky/ddn/server/database/data"
+	"github.com/djavorszky/ddn/server/database/secret"
+)
+
+func TestLoadKeyring(t *testing.T) {
+	keys, err := loadKeyring(nil)
+	if err != nil || keys != nil {
+		t.Errorf("expected no keyring without keys, got %v, %v", keys, err)
+	}
+
+	keys, err = loadKeyring([]string{
+		"new:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=",
+		"old:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+	})
+	if err != nil {
+		t.Fatalf("loadKeyring failed: %v", err)
+	}
+
+	old, _ := loadKeyring([]string{"old:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="})
+	enc, _ := old.Encrypt("s3cr3t")
+
+	if !keys.NeedsRotation(enc) {
+		t.Errorf("expected the first key to be the current one")
+	}
+
+	defer func(old *secret.Keyring) { keyring = old }(keyring)
+	keyring = keys
+
+	if pass := password(data.Row{DBPass: enc}); pass != "s3cr3t" {
+		t.Errorf("password() = %q", pass)
+	}
+
+	for _, entries := range [][]string{
+		{"nocolon"},
+		{"a:MDEyMzQ1Njc4OWFiY2RlZg==", "a:MDEyMzQ1Njc4OWFiY2RlZg=="},
+		{"a:notbase64"},
+	} {
+		if _, err := loadKeyring(entries); err == nil {
+			t.Errorf("expected %q to fail", entries)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	if err := checkPassword("s3cr3t"); err != nil {
+		t.Errorf("expected a plain password to be accepted, got %v", err)
+	}
+
+	if err := checkPassword("enc:k1:s3cr3t"); err == nil {
+		t.Errorf("expected a password looking encrypted to be rejected")
+	}
+
+	// Rows sent to users don't carry the password, encrypted or not
+	b, _ := json.Marshal(data.Row{DBPass: "enc:k1:s3cr3t"})
+	if strings.Contains(string(b), "s3cr3t") {
+		t.Errorf("expected the password to be left out of %s", b)
+	}
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/djavorszky/ddn/common/inet"
 	"github.com/djavorszky/ddn/common/logger"
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/common/srv"
 	"github.com/djavorszky/ddn/common/status"
 	"github.com/djavorszky/ddn/server/mail"
 	"github.com/djavorszky/ddn/server/registry"
@@ -39,7 +41,7 @@ func maintain() {
 					continue
 				}
 
-				_, err = agent.DropDatabase(registry.ID(), dbe.DBName, dbe.DBUser)
+				_, err = agent.WithRequestID(srv.NewRequestID()).DropDatabase(registry.ID(), dbe.DBName, dbe.DBUser)
 				if err != nil {
 					dbe.Status = status.DropDatabaseFailed
 					dbe.Message = err.Error()
@@ -116,23 +118,27 @@ func checkAgents() {
 		for _, agent := range registry.List() {
 			addr := fmt.Sprintf("%s:%s/heartbeat", agent.Address, agent.AgentPort)
 
-			if !inet.AddrExists(addr) && agent.Up {
-				agent.Up = false
+			// The check may take a while, during which the agent can report its
+			// capacity or register again, so only Up is updated afterwards
+			alive := inet.AddrExists(addr)
 
-				registry.Store(agent)
-
-				for _, addr := range config.AdminEmail {
-					mail.Send(addr, "[Cloud DB] Agent disappeared without trace",
-						fmt.Sprintf("Agent %q at %q no longer exists.", agent.ShortName, agent.Address))
-				}
+			var disappeared bool
 
+			agent, ok := registry.Update(agent.ShortName, func(agent *model.Agent) {
+				disappeared = agent.Up && !alive
+				agent.Up = alive
+			})
+			if !ok {
 				continue
 			}
 
-			if !agent.Up && inet.AddrExists(addr) {
-				agent.Up = true
+			setAgentUp(agent.ShortName, agent.Up)
 
-				registry.Store(agent)
+			if disappeared {
+				for _, addr := range config.AdminEmail {
+					mail.Send(addr, "[Cloud DB] Agent disappeared without trace",
+						fmt.Sprintf("Agent %q at %q no longer exists.", agent.ShortName, agent.Address))
+				}
 			}
 		}
 	}
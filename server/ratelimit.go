@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djavorszky/ddn/common/errs"
+	"github.com/djavorszky/ddn/common/inet"
+	"github.com/djavorszky/ddn/common/logger"
+)
+
+// rate is the rate of a token bucket: a token is added every Every, up to
+// Burst of them. The zero value doesn't limit anything.
+type rate struct {
+	Every time.Duration
+	Burst int
+}
+
+// limits are applied to every route of a group.
+type limits struct {
+	// PerUser limits the requests of each user, as named in the Authorization
+	// header or logged in to the web interface, and PerIP the ones from each
+	// IP, users or not.
+	PerUser rate
+	PerIP   rate
+
+	// MaxBody is the size of the largest body accepted in bytes, 0 if there's
+	// no limit. The bodies of the upload routes are not limited.
+	MaxBody int64
+}
+
+// bucket holds the tokens of a single user or IP.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter keeps a token bucket for each key it is asked about.
+type limiter struct {
+	rate rate
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter(r rate) *limiter {
+	return &limiter{rate: r, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the bucket of key. If there's none left, it returns
+// false, and how long it takes until there is one.
+func (l *limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l.rate.Every <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.tokensAt(b, now)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.rate.Every))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+// tokensAt returns the tokens the bucket has at now.
+func (l *limiter) tokensAt(b *bucket, now time.Time) float64 {
+	added := float64(now.Sub(b.last)) / float64(l.rate.Every)
+
+	return math.Min(float64(l.rate.Burst), b.tokens+added)
+}
+
+// sweep removes the buckets that are full again every minute, so that the
+// ones of users and IPs that stopped calling don't pile up.
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if l.tokensAt(b, now) >= float64(l.rate.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.swept = now
+}
+
+// limited returns a middleware applying the limits of the group to handlers,
+// all of which share the same buckets. Requests over the rate are rejected with
+// 429 Too Many Requests and a Retry-After header, the ones with too large a body
+// with 413 Request Entity Too Large, unless the handler is an upload.
+func limited(group string, l limits) func(inner http.Handler, upload bool) http.Handler {
+	users, ips := newLimiter(l.PerUser), newLimiter(l.PerIP)
+
+	return func(inner http.Handler, upload bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now()
+
+			ok, wait := ips.allow(remoteIP(r), now)
+			if ok {
+				if user := limitedUser(r); user != "" {
+					ok, wait = users.allow(user, now)
+				}
+			}
+
+			if !ok {
+				rateLimited.WithLabelValues(group).Inc()
+
+				logger.With("group", group, "caller", caller(r)).Debug("rate limited %s %s", r.Method, r.URL.Path)
+
+				// Retry-After is in whole seconds, rounded up so that the token is there by then
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				inet.SendFailure(w, http.StatusTooManyRequests, errs.TooManyRequests, group)
+				return
+			}
+
+			if l.MaxBody > 0 && r.Body != nil && !upload {
+				if r.ContentLength > l.MaxBody {
+					inet.SendFailure(w, http.StatusRequestEntityTooLarge, errs.RequestTooLarge, strconv.FormatInt(l.MaxBody, 10))
+					return
+				}
+
+				// Bodies of unknown length fail to decode once they go over the limit
+				r.Body = http.MaxBytesReader(w, r.Body, l.MaxBody)
+			}
+
+			inner.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitedUser returns the user whose bucket the request takes a token from:
+// the one in the Authorization header, or the one logged in to the web
+// interface. Returns an empty string for anonymous requests.
+func limitedUser(r *http.Request) string {
+	if user, err := getAPIUser(r); err == nil {
+		return user
+	}
+
+	if cookie, err := r.Cookie("user"); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// remoteIP returns the IP the request came from. Behind a reverse proxy, it's
+// the one in the header configured as real-ip-header, the last one if the proxy
+// appends to a list.
+func remoteIP(r *http.Request) string {
+	if config.RealIPHeader != "" {
+		if header := r.Header.Get(config.RealIPHeader); header != "" {
+			ips := strings.Split(header, ",")
+
+			return strings.TrimSpace(ips[len(ips)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(rate{Every: time.Second, Burst: 2})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("alice", now); !ok {
+			t.Fatalf("expected call %d to be allowed", i+1)
+		}
+	}
+
+	ok, wait := l.allow("alice", now)
+	if ok || wait != time.Second {
+		t.Errorf("expected the third call to wait a second, got %t and %v", ok, wait)
+	}
+
+	if ok, _ := l.allow("bob", now); !ok {
+		t.Errorf("expected bob to have a bucket of their own")
+	}
+
+	ok, wait = l.allow("alice", now.Add(500*time.Millisecond))
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("expected half a token after half a second, got %t and %v", ok, wait)
+	}
+
+	if ok, _ := l.allow("alice", now.Add(time.Second)); !ok {
+		t.Errorf("expected a token after a second")
+	}
+
+	// Full buckets are removed after a minute
+	l.allow("bob", now.Add(2*time.Minute))
+
+	if _, ok := l.buckets["alice"]; ok {
+		t.Errorf("expected the full bucket of alice to be swept")
+	}
+
+	// The zero rate doesn't limit
+	l = newLimiter(rate{})
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.allow("alice", now); !ok {
+			t.Fatalf("expected the zero rate to allow every call")
+		}
+	}
+}
+
+func TestLimited(t *testing.T) {
+	var read string
+
+	limit := limited("test", limits{
+		PerUser: rate{Every: time.Hour, Burst: 1},
+		PerIP:   rate{Every: time.Hour, Burst: 4},
+		MaxBody: 8,
+	})
+
+	handler := limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		read = string(b)
+	}), false)
+
+	// Both routes share the same buckets
+	other := limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), false)
+
+	call := func(h http.Handler, user, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Content-Type", contentType)
+		if user != "" {
+			req.Header.Set("Authorization", user)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := call(handler, "alice@example.com", "application/json", "{}")
+	if w.Code != http.StatusOK || read != "{}" {
+		t.Fatalf("expected the first call to be served, got %d: %s", w.Code, w.Body)
+	}
+
+	w = call(other, "alice@example.com", "application/json", "{}")
+	checkFailure(t, "user limited", w.Result(), http.StatusTooManyRequests)
+
+	if w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("expected to retry after 3600 seconds, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// Others from the same IP are only limited by the IP's bucket, which the
+	// rejected call used a token of as well
+	w = call(handler, "bob@example.com", "application/json", `{"a":1}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected bob to be served, got %d: %s", w.Code, w.Body)
+	}
+
+	w = call(handler, "", "application/json", "{}")
+	if w.Code != http.StatusOK {
+		t.Errorf("expected a call without a user to be served, got %d: %s", w.Code, w.Body)
+	}
+
+	w = call(handler, "carol@example.com", "application/json", "{}")
+	checkFailure(t, "ip limited", w.Result(), http.StatusTooManyRequests)
+}
+
+func TestLimitedBody(t *testing.T) {
+	var read string
+
+	limit := limited("test", limits{MaxBody: 8})
+	reader := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		read = string(b)
+	})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		unknownLen  bool
+		upload      bool
+		status      int
+	}{
+		{"small body", "application/json", `{"a":1}`, false, false, http.StatusOK},
+		{"large body", "application/json", `{"a":"long"}`, false, false, http.StatusRequestEntityTooLarge},
+		{"large body of unknown length", "application/json", `{"a":"long"}`, true, false, http.StatusBadRequest},
+		{"large multipart body", "multipart/form-data; boundary=x", `{"a":"long"}`, false, false, http.StatusRequestEntityTooLarge},
+		{"upload", "multipart/form-data; boundary=x", `{"a":"long"}`, false, true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		read = ""
+		handler := limit(reader, tt.upload)
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		if tt.unknownLen {
+			req.ContentLength = -1
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if tt.status == http.StatusRequestEntityTooLarge {
+			checkFailure(t, tt.name, w.Result(), tt.status)
+			continue
+		}
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, w.Code, w.Body)
+		}
+
+		if tt.status == http.StatusOK && read != tt.body {
+			t.Errorf("%s: expected the handler to read %q, got %q", tt.name, tt.body, read)
+		}
+	}
+}
+
+func TestJobRoutesLimited(t *testing.T) {
+	groups := make(map[string]string)
+	for _, group := range routeGroups {
+		for _, route := range group.routes {
+			groups[route.Method+" "+route.Pattern] = group.name
+		}
+	}
+
+	// The routes starting work on the agents, whichever way they're called
+	for _, route := range []string{
+		"POST /api/databases/create",
+		"POST /api/databases/import",
+		"PUT /api/databases/{id:[0-9]+}/recreate",
+		"POST /api/create",
+		"POST /create",
+		"POST /import",
+		"POST /prepimport",
+		"GET /recreate/{id:[0-9]+}",
+	} {
+		if groups[route] != "jobs" {
+			t.Errorf("expected %s to be in the jobs group, got %q", route, groups[route])
+		}
+	}
+}
+
+func TestUploadRoutesOnly(t *testing.T) {
+	names := make(map[string]bool)
+	for _, group := range routeGroups {
+		for _, route := range group.routes {
+			names[route.Name] = true
+		}
+	}
+
+	for name := range uploadRoutes {
+		if !names[name] {
+			t.Errorf("upload route %q is not routed", name)
+		}
+	}
+
+	// Claiming to upload doesn't lift the limit of the routes reading JSON
+	body := `{"agent_identifier":"` + strings.Repeat("a", 128<<10) + `"}`
+
+	for _, path := range []string{"/api/databases/create", "/api/databases/import", "/api/create"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+		req.Header.Set("Authorization", "alice@example.com")
+
+		w := httptest.NewRecorder()
+		Router().ServeHTTP(w, req)
+
+		checkFailure(t, path, w.Result(), http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	defer func(old Config) { config = old }(config)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.9, 192.0.2.1")
+
+	if ip := remoteIP(req); ip != "10.0.0.1" {
+		t.Errorf("expected the header to be ignored unless configured, got %q", ip)
+	}
+
+	config.RealIPHeader = "X-Forwarded-For"
+
+	if ip := remoteIP(req); ip != "192.0.2.1" {
+		t.Errorf("expected the IP appended by the proxy, got %q", ip)
+	}
+
+	req.Header.Del("X-Forwarded-For")
+
+	if ip := remoteIP(req); ip != "10.0.0.1" {
+		t.Errorf("expected the remote address without the header, got %q", ip)
+	}
+}
+
+func TestLimitedUser(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if user := limitedUser(req); user != "" {
+		t.Errorf("expected an anonymous request, got %q", user)
+	}
+
+	req.AddCookie(&http.Cookie{Name: "user", Value: "alice@example.com"})
+	if user := limitedUser(req); user != "alice@example.com" {
+		t.Errorf("expected the user of the web interface, got %q", user)
+	}
+
+	req.Header.Set("Authorization", "bob@example.com")
+	if user := limitedUser(req); user != "bob@example.com" {
+		t.Errorf("expected the user of the Authorization header, got %q", user)
+	}
+}
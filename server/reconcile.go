@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djavorszky/ddn/common/logger"
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/common/status"
+	vis "github.com/djavorszky/ddn/common/visibility"
+	"github.com/djavorszky/ddn/server/database/data"
+	"github.com/djavorszky/ddn/server/registry"
+)
+
+// defaultReconcileInterval is used if no interval is configured.
+const defaultReconcileInterval = 60 * time.Minute
+
+// reconcileReport holds the differences found between the rows stored
+// on the server and the databases existing on the agents.
+type reconcileReport struct {
+	Started  time.Time           `json:"started"`
+	Finished time.Time           `json:"finished"`
+	Agents   []agentReconcileLog `json:"agents"`
+}
+
+// agentReconcileLog holds the differences found for a single agent.
+type agentReconcileLog struct {
+	Agent string `json:"agent"`
+	Error string `json:"error,omitempty"`
+
+	// Orphaned rows are the ones whose database no longer exists on the agent
+	Orphaned []data.Row `json:"orphaned"`
+
+	// Untracked databases exist on the agent, but have no row on the server
+	Untracked []string `json:"untracked"`
+
+	// Removed and Adopted are filled if the differences were resolved
+	Removed []int    `json:"removed,omitempty"`
+	Adopted []string `json:"adopted,omitempty"`
+}
+
+var (
+	lastReconcile  *reconcileReport
+	reconcileMutex sync.Mutex
+)
+
+// reconcileAgents periodically compares the databases of each agent with the
+// rows on the server. Orphaned rows are removed if reconcile-cleanup is enabled,
+// untracked databases adopted if reconcile-adopt is.
+//
+// reconcileAgents should always be ran in a goroutine.
+func reconcileAgents() {
+	interval := time.Duration(config.ReconcileInterval) * time.Minute
+	if interval <= 0 {
+		interval = defaultReconcileInterval
+	}
+
+	ticker := time.NewTicker(interval)
+
+	for range ticker.C {
+		report := reconcile(config.ReconcileAdopt, config.ReconcileCleanup)
+
+		for _, a := range report.Agents {
+			if len(a.Orphaned) == 0 && len(a.Untracked) == 0 {
+				continue
+			}
+
+			logger.Warn("reconcile %s: %d orphaned rows, %d untracked databases", a.Agent, len(a.Orphaned), len(a.Untracked))
+		}
+	}
+}
+
+// reconcile compares the rows on the server with the databases of every agent
+// that is up, and resolves the differences if asked to. The report is kept as
+// the latest one.
+func reconcile(adopt, cleanup bool) reconcileReport {
+	reconcileMutex.Lock()
+	defer reconcileMutex.Unlock()
+
+	report := reconcileReport{Started: time.Now(), Agents: make([]agentReconcileLog, 0)}
+
+	rows, err := db.FetchAll()
+	if err != nil {
+		logger.Error("reconcile: failed listing databases: %v", err)
+
+		report.Agents = append(report.Agents, agentReconcileLog{Error: fmt.Sprintf("listing rows failed: %v", err)})
+		report.Finished = time.Now()
+
+		return report
+	}
+
+	byAgent := make(map[string][]data.Row)
+	for _, row := range rows {
+		byAgent[row.AgentName] = append(byAgent[row.AgentName], row)
+	}
+
+	for _, agent := range registry.List() {
+		if !agent.Up {
+			continue
+		}
+
+		report.Agents = append(report.Agents, reconcileAgent(agent, byAgent[agent.ShortName], adopt, cleanup))
+	}
+
+	report.Finished = time.Now()
+	lastReconcile = &report
+
+	return report
+}
+
+// reconcileAgent compares the rows of a single agent with its databases. The
+// rows are fetched again once the databases are listed, so that databases and
+// rows that were being created or recreated meanwhile are not reported.
+func reconcileAgent(agent model.Agent, rows []data.Row, adopt, cleanup bool) agentReconcileLog {
+	result := agentReconcileLog{Agent: agent.ShortName}
+
+	dbs, err := agent.ListDatabases()
+	if err != nil {
+		logger.Error("reconcile: %s: %v", agent.ShortName, err)
+
+		result.Error = err.Error()
+		return result
+	}
+
+	after, err := db.FetchRows(data.RowFilter{Agent: agent.ShortName})
+	if err != nil {
+		logger.Error("reconcile: %s: failed listing rows: %v", agent.ShortName, err)
+
+		result.Error = fmt.Sprintf("listing rows failed: %v", err)
+		return result
+	}
+
+	result.Orphaned, result.Untracked = compareSnapshots(agent.DBVendor, rows, after, dbs)
+
+	if cleanup {
+		for _, row := range result.Orphaned {
+			err = db.Delete(row)
+			if err != nil {
+				logger.Error("reconcile: removing row %d failed: %v", row.ID, err)
+				continue
+			}
+
+			result.Removed = append(result.Removed, row.ID)
+		}
+	}
+
+	if adopt {
+		for _, name := range result.Untracked {
+			row := adoptedRow(agent, name)
+
+			err = db.Insert(&row)
+			if err != nil {
+				logger.Error("reconcile: adopting %q failed: %v", name, err)
+				continue
+			}
+
+			result.Adopted = append(result.Adopted, name)
+		}
+	}
+
+	return result
+}
+
+// compareDatabases returns the rows that don't have a database on the agent, and the
+// databases that don't have rows. Rows with imports in progress or that failed are not
+// expected to have a database, but databases belonging to them are not untracked either.
+func compareDatabases(vendor string, rows []data.Row, dbs []string) ([]data.Row, []string) {
+	existing := make(map[string]bool)
+	for _, name := range dbs {
+		existing[strings.ToLower(name)] = true
+	}
+
+	tracked := make(map[string]bool)
+
+	orphaned := make([]data.Row, 0)
+	for _, row := range rows {
+		name := reconcileName(vendor, row)
+		tracked[name] = true
+
+		if row.InProgress() || row.IsErr() || row.Status == status.DropInProgress {
+			continue
+		}
+
+		if !existing[name] {
+			orphaned = append(orphaned, row)
+		}
+	}
+
+	untracked := make([]string, 0)
+	for _, name := range dbs {
+		if !tracked[strings.ToLower(name)] {
+			untracked = append(untracked, name)
+		}
+	}
+
+	return orphaned, untracked
+}
+
+// compareSnapshots compares the databases with the rows fetched both before and
+// after they were listed. Only the rows orphaned, and the databases untracked,
+// according to both are returned.
+func compareSnapshots(vendor string, before, after []data.Row, dbs []string) ([]data.Row, []string) {
+	orphanedBefore, untrackedBefore := compareDatabases(vendor, before, dbs)
+	orphanedAfter, untrackedAfter := compareDatabases(vendor, after, dbs)
+
+	stillOrphaned := make(map[int]bool)
+	for _, row := range orphanedAfter {
+		stillOrphaned[row.ID] = true
+	}
+
+	orphaned := make([]data.Row, 0)
+	for _, row := range orphanedBefore {
+		if stillOrphaned[row.ID] {
+			orphaned = append(orphaned, row)
+		}
+	}
+
+	stillUntracked := make(map[string]bool)
+	for _, name := range untrackedAfter {
+		stillUntracked[name] = true
+	}
+
+	untracked := make([]string, 0)
+	for _, name := range untrackedBefore {
+		if stillUntracked[name] {
+			untracked = append(untracked, name)
+		}
+	}
+
+	return orphaned, untracked
+}
+
+// reconcileName returns the name the agent lists the row's database as. Oracle
+// agents list schemas, which are named after the user.
+func reconcileName(vendor string, row data.Row) string {
+	if vendor == "oracle" {
+		return strings.ToLower(row.DBUser)
+	}
+
+	return strings.ToLower(row.DBName)
+}
+
+// adoptedRow returns a row for a database found on the agent without one. It is
+// owned by the first admin, and expires the same way newly created databases do.
+func adoptedRow(agent model.Agent, name string) data.Row {
+	var creator string
+	if len(config.AdminEmail) > 0 {
+		creator = config.AdminEmail[0]
+	}
+
+	return data.Row{
+		DBVendor:   agent.DBVendor,
+		DBName:     name,
+		DBUser:     name,
+		DBSID:      agent.DBSID,
+		CreateDate: time.Now(),
+		ExpiryDate: time.Now().AddDate(0, 1, 0),
+		Creator:    creator,
+		AgentName:  agent.ShortName,
+		DBAddress:  agent.DBAddr,
+		DBPort:     agent.DBPort,
+		Status:     status.Success,
+		Message:    "Adopted: database was found on the agent without being tracked",
+		Public:     vis.Private,
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/djavorszky/ddn/common/status"
+	"github.com/djavorszky/ddn/server/database/data"
+)
+
+func TestCompareDatabases(t *testing.T) {
+	rows := []data.Row{
+		{ID: 1, DBName: "present", DBUser: "u1", Status: status.Success},
+		{ID: 2, DBName: "Dropped", DBUser: "u2", Status: status.Success},
+		{ID: 3, DBName: "importing", DBUser: "u3", Status: status.ImportInProgress},
+		{ID: 4, DBName: "failed", DBUser: "u4", Status: status.ImportFailed},
+		{ID: 5, DBName: "expiring", DBUser: "u5", Status: status.RemovalScheduled},
+	}
+
+	orphaned, untracked := compareDatabases("mysql", rows, []string{"PRESENT", "importing", "handmade"})
+
+	var ids []int
+	for _, row := range orphaned {
+		ids = append(ids, row.ID)
+	}
+
+	if !reflect.DeepEqual(ids, []int{2, 5}) {
+		t.Errorf("orphaned = %v, want [2 5]", ids)
+	}
+
+	if !reflect.DeepEqual(untracked, []string{"handmade"}) {
+		t.Errorf("untracked = %v, want [handmade]", untracked)
+	}
+}
+
+func TestCompareDatabasesOracle(t *testing.T) {
+	rows := []data.Row{
+		{ID: 1, DBName: "whatever", DBUser: "schema_one", Status: status.Success},
+	}
+
+	orphaned, untracked := compareDatabases("oracle", rows, []string{"SCHEMA_ONE"})
+
+	if len(orphaned) != 0 || len(untracked) != 0 {
+		t.Errorf("expected no differences, got orphaned: %v, untracked: %v", orphaned, untracked)
+	}
+}
+
+func TestCompareSnapshots(t *testing.T) {
+	before := []data.Row{
+		{ID: 1, DBName: "dropped", Status: status.Success},
+		{ID: 2, DBName: "recreating", Status: status.Success},
+	}
+
+	// While the databases were listed, "creating" got a row and started being
+	// created, and "recreating" was dropped to be created again
+	after := []data.Row{
+		{ID: 1, DBName: "dropped", Status: status.Success},
+		{ID: 2, DBName: "recreating", Status: status.DropInProgress},
+		{ID: 3, DBName: "creating", Status: status.Started},
+	}
+
+	orphaned, untracked := compareSnapshots("mysql", before, after, []string{"creating", "handmade"})
+
+	if len(orphaned) != 1 || orphaned[0].ID != 1 {
+		t.Errorf("orphaned = %v, want only row 1", orphaned)
+	}
+
+	if !reflect.DeepEqual(untracked, []string{"handmade"}) {
+		t.Errorf("untracked = %v, want [handmade]", untracked)
+	}
+}
@@ -27,6 +27,24 @@ func Store(agent model.Agent) {
 	rw.Unlock()
 }
 
+// Update calls update with the agent registered as shortName and stores the
+// result, without other changes to the agent getting in between. Returns the
+// updated agent, or false if there's no agent with that name.
+func Update(shortName string, update func(agent *model.Agent)) (model.Agent, bool) {
+	rw.Lock()
+	defer rw.Unlock()
+
+	agent, ok := registry[shortName]
+	if !ok {
+		return agent, false
+	}
+
+	update(&agent)
+	registry[shortName] = agent
+
+	return agent, true
+}
+
 // Get returns the agent associated with the shortName, or
 // an error if no agent are registered with that name
 func Get(shortName string) (model.Agent, bool) {
@@ -99,6 +99,46 @@ func TestStore(t *testing.T) {
 	}
 }
 
+func TestUpdate(t *testing.T) {
+	setup()
+	defer teardown()
+
+	_, ok := Update(missing, func(agent *model.Agent) {
+		t.Errorf("Update(%q) called update", missing)
+	})
+	if ok || Exists(missing) {
+		t.Errorf("Update(%q) returned true or stored the agent", missing)
+	}
+
+	c, ok := Update(name1, func(agent *model.Agent) { agent.Up = true })
+	if !ok || !c.Up || !registry[name1].Up || registry[name1].LongName != long1 {
+		t.Errorf("Update(%q) did not store the updated agent: %v", name1, registry[name1])
+	}
+
+	// Concurrent updates of different fields don't undo each other
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			for j := 0; j < 100; j++ {
+				Update(name2, func(agent *model.Agent) {
+					if i == 0 {
+						agent.Up = true
+					} else {
+						agent.Capacity = &model.Capacity{}
+					}
+				})
+			}
+			done <- true
+		}(i)
+	}
+	<-done
+	<-done
+
+	if c := registry[name2]; !c.Up || c.Capacity == nil {
+		t.Errorf("concurrent updates were lost: %v", c)
+	}
+}
+
 func TestList(t *testing.T) {
 	setup()
 	defer teardown()
@@ -8,23 +8,33 @@ import (
 	"github.com/djavorszky/ddn/common/srv"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Router creates a new router that registers all routes.
 func Router() http.Handler {
 
 	router := mux.NewRouter().StrictSlash(true)
-	for _, route := range routes {
-		var handler http.Handler
+	for _, group := range routeGroups {
+		limit := limited(group.name, group.limits)
 
-		handler = route.HandlerFunc
-		handler = srv.Logger(handler, route.Name)
+		for _, route := range group.routes {
+			var handler http.Handler
 
-		router.
-			Methods(route.Method).
-			Path(route.Pattern).
-			Name(route.Name).
-			Handler(handler)
+			handler = route.HandlerFunc
+			if successor, ok := v1Successors[route.Name]; ok {
+				handler = deprecated(route.Name, successor, route.HandlerFunc)
+			}
+
+			handler = limit(handler, uploadRoutes[route.Name])
+			handler = srv.Logger(handler, route.Name)
+
+			router.
+				Methods(route.Method).
+				Path(route.Pattern).
+				Name(route.Name).
+				Handler(handler)
+		}
 	}
 
 	// Add static serving of files in dumps directory.
@@ -41,6 +51,8 @@ func Router() http.Handler {
 
 	attachProfiler(router)
 
+	router.Handle("/metrics", promhttp.Handler())
+
 	originsOk := handlers.AllowedOrigins([]string{"*"})
 	headersOk := handlers.AllowedHeaders([]string{"Authorization"})
 	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "DELETE"})
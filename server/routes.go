@@ -1,6 +1,9 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 type route struct {
 	Name        string
@@ -12,7 +15,45 @@ type route struct {
 // Routes contains all available routes
 type Routes []route
 
-var routes = Routes{
+// routeGroup is a list of routes sharing the same limits.
+type routeGroup struct {
+	name   string
+	routes Routes
+	limits limits
+}
+
+// routeGroups contains the routes served by the router, grouped by the limits
+// applied to them. Each group has its own buckets, so e.g. the imports started
+// by a user don't use up the tokens of their other calls.
+var routeGroups = []routeGroup{
+	// The agents are trusted to call on their own schedule
+	{"agents", agentRoutes, limits{MaxBody: 1 << 20}},
+	{"web", routes, limits{
+		PerIP:   rate{Every: 100 * time.Millisecond, Burst: 50},
+		MaxBody: 1 << 20,
+	}},
+	{"api", apiRoutes, limits{
+		PerUser: rate{Every: 100 * time.Millisecond, Burst: 20},
+		PerIP:   rate{Every: 50 * time.Millisecond, Burst: 50},
+		MaxBody: 1 << 20,
+	}},
+	// Every route starting work on the agents, through whichever API or the web
+	// interface, shares the same buckets
+	{"jobs", append(append(Routes{}, jobRoutes...), webJobRoutes...), limits{
+		PerUser: rate{Every: 6 * time.Second, Burst: 10},
+		PerIP:   rate{Every: 3 * time.Second, Burst: 20},
+		MaxBody: 64 << 10,
+	}},
+}
+
+// uploadRoutes are the routes receiving dumps, whose bodies are not limited.
+var uploadRoutes = map[string]bool{
+	"api/dumps": true,
+	"import":    true,
+}
+
+// agentRoutes contains the endpoints the agents call.
+var agentRoutes = Routes{
 	route{
 		"register",
 		http.MethodPost,
@@ -37,24 +78,29 @@ var routes = Routes{
 		"/alive/{shortname:[a-zA-Z0-9-_]+}",
 		alive,
 	},
+	route{
+		"capacity",
+		http.MethodPost,
+		"/alive/{shortname:[a-zA-Z0-9-_]+}",
+		aliveCapacity,
+	},
 	route{
 		"upd8",
 		http.MethodPost,
 		"/upd8",
 		upd8,
 	},
+}
+
+// routes contains the web interface and the v1 API, except for the routes in
+// webJobRoutes. The v1 routes listed in v1Successors are deprecated.
+var routes = Routes{
 	route{
 		"index",
 		http.MethodGet,
 		"/",
 		index,
 	},
-	route{
-		"create",
-		http.MethodPost,
-		"/create",
-		createAction,
-	},
 	route{
 		"createdb",
 		http.MethodGet,
@@ -62,16 +108,10 @@ var routes = Routes{
 		createdb,
 	},
 	route{
-		"import",
-		http.MethodPost,
-		"/import",
-		importAction,
-	},
-	route{
-		"prepimport",
-		http.MethodPost,
-		"/prepimport",
-		prepImportAction,
+		"agents",
+		http.MethodGet,
+		"/agents",
+		agents,
 	},
 	route{
 		"importdb",
@@ -121,42 +161,18 @@ var routes = Routes{
 		"/extend/{id:[0-9]+}",
 		extend,
 	},
-	route{
-		"drop",
-		http.MethodGet,
-		"/drop/{id:[0-9]+}",
-		drop,
-	},
-	route{
-		"export",
-		http.MethodGet,
-		"/export/{id:[0-9]+}",
-		exportAction,
-	},
 	route{
 		"portalext",
 		http.MethodGet,
 		"/portalext/{id:[0-9]+}",
 		portalext,
 	},
-	route{
-		"recreate",
-		http.MethodGet,
-		"/recreate/{id:[0-9]+}",
-		recreate,
-	},
 	route{
 		"api",
 		http.MethodGet,
 		"/api",
 		apiPage,
 	},
-	route{
-		"api/create",
-		http.MethodPost,
-		"/api/create",
-		apiCreate,
-	},
 	route{
 		"api/list",
 		http.MethodGet,
@@ -209,12 +225,23 @@ var routes = Routes{
 		apiRemoveSubscription,
 	},
 	route{
-		"/api/dbaccess/",
+		"api/dbaccess",
 		http.MethodGet,
 		"/api/dbaccess/{requester:[a-zA-Z0-9-_.@]+}/{agent:[a-zA-Z0-9-_]+}/{dbname:[a-zA-Z0-9-_]+}",
 		apiDBAccess,
 	},
-	// v2 APIs
+}
+
+// apiRoutes and jobRoutes contain the routes of the v2 API, the latter the ones
+// starting work on the agents. Each of them has to be described in
+// apiOperations, as the OpenAPI document is generated from the two.
+var apiRoutes = Routes{
+	route{
+		"api/openapi",
+		http.MethodGet,
+		"/api/openapi.json",
+		getAPISpec,
+	},
 	route{
 		"/api/agents",
 		http.MethodGet,
@@ -251,6 +278,69 @@ var routes = Routes{
 		"/api/databases/{agent:[a-zA-Z][a-zA-Z0-9-_]+}/{dbname:[a-zA-Z0-9_]+}",
 		getAPIDatabaseByAgentDBName,
 	},
+	route{
+		"api/browse",
+		http.MethodGet,
+		"/api/browse",
+		browseAPI,
+	},
+	route{
+		"api/browse/loc",
+		http.MethodGet,
+		"/api/browse/{loc:[0-9a-zA-Z-_./ ]+}",
+		browseAPI,
+	},
+	route{
+		"api/databases/visibility",
+		http.MethodPut,
+		"/api/databases/{id:[0-9]+}/visibility/{visibility:public|private}",
+		apiSetVisibility,
+	},
+	route{
+		"api/databases/expiry",
+		http.MethodPut,
+		"/api/databases/{id:[0-9]+}/expiry/extend/{amount:[0-9]+}/{unit:days|months|years}",
+		apiExtendExpiry,
+	},
+	route{
+		"/api/databases/id/accessinfo/",
+		http.MethodGet,
+		"/api/databases/{id:[0-9]+}/accessinfo",
+		apiAccessInfoByID,
+	},
+	route{
+		"/api/dbaccess/",
+		http.MethodGet,
+		"/api/databases/{agent:[a-zA-Z][a-zA-Z0-9-_]+}/{dbname:[a-zA-Z0-9-_]+}/accessinfo",
+		apiAccessInfoByAgentDB,
+	},
+	route{
+		"api/admin/reconcile",
+		http.MethodGet,
+		"/api/admin/reconcile",
+		getAPIReconcile,
+	},
+	route{
+		"api/admin/reconcile",
+		http.MethodPost,
+		"/api/admin/reconcile",
+		runAPIReconcile,
+	},
+	route{
+		"api/admin/audit",
+		http.MethodGet,
+		"/api/admin/audit",
+		getAPIAudit,
+	},
+	route{
+		"api/loglevel",
+		http.MethodPut,
+		"/api/loglevel/{level:[a-zA-Z]+}",
+		apiSetLogLevel,
+	},
+}
+
+var jobRoutes = Routes{
 	route{
 		"api/databases/id",
 		http.MethodDelete,
@@ -269,6 +359,12 @@ var routes = Routes{
 		"/api/databases/import",
 		importAPIDB,
 	},
+	route{
+		"api/dumps",
+		http.MethodPost,
+		"/api/dumps",
+		uploadAPIDump,
+	},
 	route{
 		"api/databases/id/recreate",
 		http.MethodPut,
@@ -281,46 +377,56 @@ var routes = Routes{
 		"/api/databases/{id:[0-9]+}/export",
 		exportAPIDB,
 	},
+}
+
+// webJobRoutes contains the routes of the web interface and of the v1 API that
+// start work on the agents, limited along with jobRoutes.
+var webJobRoutes = Routes{
 	route{
-		"api/browse",
-		http.MethodGet,
-		"/api/browse",
-		browseAPI,
+		"create",
+		http.MethodPost,
+		"/create",
+		createAction,
 	},
 	route{
-		"api/browse/loc",
-		http.MethodGet,
-		"/api/browse/{loc:[0-9a-zA-Z-_./ ]+}",
-		browseAPI,
+		"import",
+		http.MethodPost,
+		"/import",
+		importAction,
 	},
 	route{
-		"api/databases/visibility",
-		http.MethodPut,
-		"/api/databases/{id:[0-9]+}/visibility/{visibility:public|private}",
-		apiSetVisibility,
+		"prepimport",
+		http.MethodPost,
+		"/prepimport",
+		prepImportAction,
 	},
 	route{
-		"api/databases/expiry",
-		http.MethodPut,
-		"/api/databases/{id:[0-9]+}/expiry/extend/{amount:[0-9]+}/{unit:days|months|years}",
-		apiExtendExpiry,
+		"drop",
+		http.MethodGet,
+		"/drop/{id:[0-9]+}",
+		drop,
 	},
 	route{
-		"/api/databases/id/accessinfo/",
+		"export",
 		http.MethodGet,
-		"/api/databases/{id:[0-9]+}/accessinfo",
-		apiAccessInfoByID,
+		"/export/{id:[0-9]+}",
+		exportAction,
 	},
 	route{
-		"/api/dbaccess/",
+		"recreate",
 		http.MethodGet,
-		"/api/databases/{agent:[a-zA-Z][a-zA-Z0-9-_]+}/{dbname:[a-zA-Z0-9-_]+}/accessinfo",
-		apiAccessInfoByAgentDB,
+		"/recreate/{id:[0-9]+}",
+		recreate,
 	},
 	route{
-		"api/loglevel",
-		http.MethodPut,
-		"/api/loglevel/{level:[a-zA-Z]+}",
-		apiSetLogLevel,
+		"api/create",
+		http.MethodPost,
+		"/api/create",
+		apiCreate,
 	},
 }
+
+// v2Routes returns the routes of the v2 API.
+func v2Routes() Routes {
+	return append(append(Routes{}, apiRoutes...), jobRoutes...)
+}
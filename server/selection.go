@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/server/registry"
+)
+
+var (
+	constraintPattern = regexp.MustCompile(`^\s*([a-zA-Z]+)\s*(?:(>=|<=|==|!=|=|>|<)\s*([0-9]+(?:\.[0-9]+)*))?\s*$`)
+	versionPattern    = regexp.MustCompile(`[0-9]+(?:\.[0-9]+)*`)
+)
+
+// agentConstraint is a vendor with an optional version constraint that the agent
+// chosen for a request has to satisfy, e.g. mysql>=5.7.
+type agentConstraint struct {
+	vendor  string
+	op      string
+	version []int
+}
+
+// parseAgentConstraint parses constraints in the form of vendor[op version], where
+// op is one of >=, <=, ==, =, !=, > and <.
+func parseAgentConstraint(s string) (agentConstraint, error) {
+	m := constraintPattern.FindStringSubmatch(s)
+	if m == nil {
+		return agentConstraint{}, fmt.Errorf("invalid vendor constraint %q", s)
+	}
+
+	c := agentConstraint{vendor: strings.ToLower(m[1]), op: m[2]}
+	if c.op != "" {
+		c.version = parseVersion(m[3])
+	}
+
+	return c, nil
+}
+
+// matches returns whether the agent is of the vendor and version of the constraint.
+// Versions are only compared as far as the constraint goes, so 5.7.21 is both
+// ==5.7 and <=5.7.
+func (c agentConstraint) matches(agent model.Agent) bool {
+	if !strings.EqualFold(agent.DBVendor, c.vendor) {
+		return false
+	}
+
+	if c.op == "" {
+		return true
+	}
+
+	version := agentVersion(agent)
+	if version == nil {
+		return false
+	}
+
+	cmp := compareVersions(version, c.version)
+
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// agentVersion returns the version of the agent's database server. The one it
+// reported with its capacity is preferred to the configured one.
+func agentVersion(agent model.Agent) []int {
+	if agent.Capacity != nil {
+		if v := versionPattern.FindString(agent.Capacity.ServerVersion); v != "" {
+			return parseVersion(v)
+		}
+	}
+
+	return parseVersion(versionPattern.FindString(agent.LongName))
+}
+
+func parseVersion(s string) []int {
+	if s == "" {
+		return nil
+	}
+
+	var version []int
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+
+		version = append(version, n)
+	}
+
+	return version
+}
+
+// compareVersions compares the version to the constraint's, as many parts as the
+// constraint has. Missing parts of the version count as 0.
+func compareVersions(version, constraint []int) int {
+	for i, want := range constraint {
+		var got int
+		if i < len(version) {
+			got = version[i]
+		}
+
+		switch {
+		case got < want:
+			return -1
+		case got > want:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// selectAgent chooses the agent that is up, satisfies the constraint and has the
+// most room left. Agents that crossed a capacity threshold are only chosen if
+// there are no others, and agents that haven't reported their capacity yet come
+// after the ones that did.
+func selectAgent(c agentConstraint) (model.Agent, bool) {
+	var candidates []model.Agent
+	for _, agent := range registry.List() {
+		if agent.Up && c.matches(agent) {
+			candidates = append(candidates, agent)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return model.Agent{}, false
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return agentLoad(candidates[i]).less(agentLoad(candidates[j]))
+	})
+
+	return candidates[0], true
+}
+
+// load is used to rank the agents when choosing one.
+type load struct {
+	full        bool
+	unknown     bool
+	diskUsed    float64
+	connections int
+}
+
+func agentLoad(agent model.Agent) load {
+	if agent.Capacity == nil {
+		return load{unknown: true}
+	}
+
+	l := load{
+		full:        len(capacityWarnings(*agent.Capacity)) != 0,
+		connections: agent.Capacity.Connections,
+	}
+
+	for _, disk := range agent.Capacity.Disks {
+		if used := disk.UsedPercent(); used > l.diskUsed {
+			l.diskUsed = used
+		}
+	}
+
+	return l
+}
+
+func (l load) less(o load) bool {
+	switch {
+	case l.full != o.full:
+		return !l.full
+	case l.unknown != o.unknown:
+		return !l.unknown
+	case l.diskUsed != o.diskUsed:
+		return l.diskUsed < o.diskUsed
+	default:
+		return l.connections < o.connections
+	}
+}
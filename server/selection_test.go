@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/server/registry"
+)
+
+func TestAgentConstraint(t *testing.T) {
+	mysql57 := model.Agent{DBVendor: "mysql", LongName: "mysql 5.7.21"}
+	mysql80 := model.Agent{DBVendor: "mysql", LongName: "mysql 8", Capacity: &model.Capacity{ServerVersion: "8.0.12"}}
+
+	tests := []struct {
+		constraint string
+		agent      model.Agent
+		want       bool
+	}{
+		{"mysql", mysql57, true},
+		{"MySQL", mysql57, true},
+		{"postgres", mysql57, false},
+		{"mysql>=5.7", mysql57, true},
+		{"mysql >= 5.7", mysql80, true},
+		{"mysql>5.7", mysql57, false},
+		{"mysql==5.7", mysql57, true},
+		{"mysql=5.7.20", mysql57, false},
+		{"mysql<8", mysql57, true},
+		{"mysql<8", mysql80, false},
+		{"mysql!=8.0", mysql80, false},
+		{"mysql<=8.0.12", mysql80, true},
+	}
+
+	for _, tt := range tests {
+		c, err := parseAgentConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("%q: parse failed: %v", tt.constraint, err)
+			continue
+		}
+
+		if got := c.matches(tt.agent); got != tt.want {
+			t.Errorf("%q matches %s = %t, want %t", tt.constraint, tt.agent.LongName, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"", ">=5.7", "mysql>=", "mysql~5.7", "mysql>=5.x"} {
+		if _, err := parseAgentConstraint(invalid); err == nil {
+			t.Errorf("%q: expected parse to fail", invalid)
+		}
+	}
+}
+
+func TestSelectAgent(t *testing.T) {
+	defer func(old Config) { config = old }(config)
+	config = Config{}
+
+	disk := func(free uint64) *model.Capacity {
+		return &model.Capacity{Disks: []model.DiskUsage{{Path: "/dumps", Total: 100, Free: free}}}
+	}
+
+	agents := []model.Agent{
+		{ShortName: "sel-down", DBVendor: "mariadb", LongName: "mariadb 10.2", Capacity: disk(90)},
+		{ShortName: "sel-full", DBVendor: "mariadb", LongName: "mariadb 10.2", Up: true, Capacity: disk(5)},
+		{ShortName: "sel-unknown", DBVendor: "mariadb", LongName: "mariadb 10.2", Up: true},
+		{ShortName: "sel-busy", DBVendor: "mariadb", LongName: "mariadb 10.2", Up: true, Capacity: disk(60)},
+		{ShortName: "sel-free", DBVendor: "mariadb", LongName: "mariadb 10.1", Up: true, Capacity: disk(70)},
+	}
+
+	for _, agent := range agents {
+		registry.Store(agent)
+		defer registry.Remove(agent.ShortName)
+	}
+
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{"mariadb", "sel-free"},
+		{"mariadb>=10.2", "sel-busy"},
+		{"mysql", ""},
+	}
+
+	for _, tt := range tests {
+		c, _ := parseAgentConstraint(tt.constraint)
+
+		agent, ok := selectAgent(c)
+		if agent.ShortName != tt.want || ok != (tt.want != "") {
+			t.Errorf("%q: chose %q (%t), want %q", tt.constraint, agent.ShortName, ok, tt.want)
+		}
+	}
+}
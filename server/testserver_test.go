@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/djavorszky/ddn/common/inet"
+	"github.com/djavorszky/ddn/common/model"
+	"github.com/djavorszky/ddn/common/status"
+	"github.com/djavorszky/ddn/server/registry"
+)
+
+// fakeAgent accepts every action, and remembers the endpoints called.
+type fakeAgent struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.calls = append(f.calls, strings.TrimPrefix(r.URL.Path, "/"))
+	f.mu.Unlock()
+
+	inet.SendResponse(w, http.StatusOK, inet.Message{Status: status.Success, Message: "ok"})
+}
+
+func (f *fakeAgent) called(endpoint string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, call := range f.calls {
+		if call == endpoint {
+			return true
+		}
+	}
+
+	return false
+}
+
+// testServer is the router of the server running against a new SQLite
+// backend, with a fake agent registered as "mysql-57".
+type testServer struct {
+	*httptest.Server
+
+	agent   *fakeAgent
+	cleanup []func()
+}
+
+// newTestServer starts a test server. It replaces the backend of the server
+// until the test server is closed.
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{agent: &fakeAgent{}}
+
+	dir, err := ioutil.TempDir("", "ddn-test")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	s.onClose(func() { os.RemoveAll(dir) })
+
+	backend := openTestBackend(t, dir, "ddn.db")
+	s.onClose(func() { backend.Close() })
+
+	old := db
+	db = backend
+	s.onClose(func() { db = old })
+
+	agentSrv := httptest.NewServer(s.agent)
+	s.onClose(agentSrv.Close)
+
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(agentSrv.URL, "http://"))
+
+	registry.Store(model.Agent{
+		ShortName: "mysql-57",
+		DBVendor:  "mysql",
+		DBAddr:    "localhost",
+		DBPort:    "3306",
+		Address:   host,
+		AgentPort: port,
+		Up:        true,
+	})
+	s.onClose(func() { registry.Remove("mysql-57") })
+
+	s.Server = httptest.NewServer(Router())
+
+	return s
+}
+
+func (s *testServer) onClose(f func()) {
+	s.cleanup = append(s.cleanup, f)
+}
+
+// Close stops the server and the fake agent, and restores the backend.
+func (s *testServer) Close() {
+	if s.Server != nil {
+		s.Server.Close()
+	}
+
+	for i := len(s.cleanup) - 1; i >= 0; i-- {
+		s.cleanup[i]()
+	}
+}
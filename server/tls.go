@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/djavorszky/ddn/common/errs"
+	"github.com/djavorszky/ddn/common/inet"
+	"github.com/djavorszky/ddn/common/logger"
+)
+
+// verifyAgent checks that the request was sent by the agent it claims to be
+// from, if mutual TLS is configured: the agent has to present a certificate
+// with its shortname as the common name or as one of the DNS names. If not,
+// the request is rejected and false is returned.
+func verifyAgent(w http.ResponseWriter, r *http.Request, shortname string) bool {
+	if !config.TLS().Mutual() {
+		return true
+	}
+
+	names := inet.PeerNames(r)
+	for _, name := range names {
+		if strings.EqualFold(name, shortname) {
+			return true
+		}
+	}
+
+	logger.Warn("Rejected request from %s to %s: certificate %v does not belong to agent %q", r.RemoteAddr, r.URL.Path, names, shortname)
+
+	inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied, shortname)
+	return false
+}
@@ -103,21 +103,23 @@ func loadPage(w http.ResponseWriter, r *http.Request, pages ...string) {
 				logger.Error("database query: %v", err)
 				session.AddFlash("Failed querying database", "fail")
 			} else {
+				dbpass := password(entry)
+
 				switch entry.DBVendor {
 				case "mysql":
-					page.Ext62 = liferay.MysqlJDBC(entry.DBAddress, entry.DBPort, entry.DBName, entry.DBUser, entry.DBPass)
-					page.ExtDXP = liferay.MysqlJDBCDXP(entry.DBAddress, entry.DBPort, entry.DBName, entry.DBUser, entry.DBPass)
+					page.Ext62 = liferay.MysqlJDBC(entry.DBAddress, entry.DBPort, entry.DBName, entry.DBUser, dbpass)
+					page.ExtDXP = liferay.MysqlJDBCDXP(entry.DBAddress, entry.DBPort, entry.DBName, entry.DBUser, dbpass)
 				case "mariadb":
-					page.Ext62 = liferay.MariaDBJDBC(entry.DBAddress, entry.DBPort, entry.DBName, entry.DBUser, entry.DBPass)
+					page.Ext62 = liferay.MariaDBJDBC(entry.DBAddress, entry.DBPort, entry.DBName, entry.DBUser, dbpass)
 					page.ExtDXP = page.Ext62
 				case "postgres":
-					page.Ext62 = liferay.PostgreJDBC(entry.DBAddress, entry.DBPort, entry.DBName, entry.DBUser, entry.DBPass)
+					page.Ext62 = liferay.PostgreJDBC(entry.DBAddress, entry.DBPort, entry.DBName, entry.DBUser, dbpass)
 					page.ExtDXP = page.Ext62
 				case "oracle":
-					page.Ext62 = liferay.OracleJDBC(entry.DBAddress, entry.DBPort, entry.DBSID, entry.DBUser, entry.DBPass)
+					page.Ext62 = liferay.OracleJDBC(entry.DBAddress, entry.DBPort, entry.DBSID, entry.DBUser, dbpass)
 					page.ExtDXP = page.Ext62
 				case "mssql":
-					page.Ext62 = liferay.MSSQLJDBC(entry.DBAddress, entry.DBPort, entry.DBName, entry.DBUser, entry.DBPass)
+					page.Ext62 = liferay.MSSQLJDBC(entry.DBAddress, entry.DBPort, entry.DBName, entry.DBUser, dbpass)
 					page.ExtDXP = page.Ext62
 				}
 			}
@@ -238,6 +240,7 @@ func getPages() map[string]string {
 	pages["/"] = "Home"
 	pages["/createdb"] = "Create database"
 	pages["/importdb"] = "Import database"
+	pages["/agents"] = "Agents"
 
 	return pages
 }